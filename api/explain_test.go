@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// traceSteps 返回响应中跟踪记录的顶层步骤，格式为kind:name:outcome
+func traceSteps(t *testing.T, response map[string]interface{}) ([]string, []interface{}) {
+	t.Helper()
+	tr, ok := response["trace"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("no trace in response %v", response)
+	}
+	raw, _ := tr["steps"].([]interface{})
+	var steps []string
+	for _, s := range raw {
+		step := s.(map[string]interface{})
+		steps = append(steps, step["kind"].(string)+":"+step["name"].(string)+":"+step["outcome"].(string))
+	}
+	return steps, raw
+}
+
+func TestExplainTrace(t *testing.T) {
+	mp := &DefaultMessageProcessor{}
+	mp.SetMaxLength(10)
+	h := NewHandler(mp)
+
+	tests := []struct {
+		name    string
+		message string
+		code    int
+		steps   string
+		rules   string
+	}{
+		{"processed", "hello", http.StatusOK,
+			"route:priority:selected route:processor:selected route:guard:closed validate:default:pass process:default:pass",
+			"rule:not_empty:pass rule:max_length:pass"},
+		{"rejected", "much too long", http.StatusBadRequest,
+			"route:priority:selected route:processor:selected route:guard:closed validate:default:fail",
+			"rule:not_empty:pass rule:max_length:fail"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, response := postMessage(t, h, "explain=true", "application/x-www-form-urlencoded", "message="+tt.message)
+			if code != tt.code {
+				t.Fatalf("status %d, want %d: %v", code, tt.code, response)
+			}
+			steps, raw := traceSteps(t, response)
+			if got := strings.Join(steps, " "); got != tt.steps {
+				t.Errorf("steps %q, want %q", got, tt.steps)
+			}
+
+			// 校验规则嵌套在校验步骤中
+			validate := raw[3].(map[string]interface{})
+			var rules []string
+			for _, s := range validate["steps"].([]interface{}) {
+				rule := s.(map[string]interface{})
+				rules = append(rules, rule["kind"].(string)+":"+rule["name"].(string)+":"+rule["outcome"].(string))
+			}
+			if got := strings.Join(rules, " "); got != tt.rules {
+				t.Errorf("rules %q, want %q", got, tt.rules)
+			}
+		})
+	}
+}
+
+func TestExplainOptional(t *testing.T) {
+	h := NewHandler(&DefaultMessageProcessor{})
+
+	if _, response := postMessage(t, h, "explain=false", "application/x-www-form-urlencoded", "message=hello"); response["trace"] != nil {
+		t.Errorf("trace without explain: %v", response)
+	}
+	if code, _ := postMessage(t, h, "explain=maybe", "application/x-www-form-urlencoded", "message=hello"); code != http.StatusBadRequest {
+		t.Errorf("invalid explain accepted: %d", code)
+	}
+	code, response := postMessage(t, h, "explain=true&delay=1m", "application/x-www-form-urlencoded", "message=hello")
+	if code != http.StatusBadRequest || !strings.Contains(response["error"].(string), "scheduled") {
+		t.Errorf("explain for scheduled message: %d %v", code, response)
+	}
+}
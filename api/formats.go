@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/example/message_processor/format"
+)
+
+// 请求体格式适配
+// 默认从message表单字段读取消息；请求体为JSON、CSV、XML，或通过input_format参数
+// 指定格式时，整个请求体解码为记录列表，只有一条记录时以JSON对象、多条时以JSON数组的形式交给处理器。
+// 此时processor等控制参数需要放在URL查询参数中。
+// output_format参数将处理结果（JSON对象或对象数组）编码为指定格式，
+// 不是结构化数据的处理结果编码为只有result字段的一条记录
+
+// maxPayloadSize 结构化请求体的大小上限
+const maxPayloadSize = 1 << 20
+
+// readMessage 读取请求中的消息内容
+func (h *Handler) readMessage(w http.ResponseWriter, r *http.Request) (string, error) {
+	codec, err := inputCodec(r)
+	if err != nil {
+		return "", err
+	}
+	if codec == nil {
+		return r.FormValue("message"), nil
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPayloadSize))
+	if err != nil {
+		return "", fmt.Errorf("failed to read request body: %w", err)
+	}
+	// 请求体已作为消息读取，后续的表单解析只读取查询参数
+	r.Body = http.NoBody
+
+	records, err := codec.Decode(data)
+	if err != nil {
+		return "", err
+	}
+	if len(records) == 1 {
+		// 单条记录作为JSON对象，聚合、重组等按事件处理的处理器可以直接使用
+		encoded, err := json.Marshal(records[0])
+		if err != nil {
+			return "", err
+		}
+		return string(encoded), nil
+	}
+	encoded, err := format.JSON.Encode(records)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// inputCodec 根据input_format参数或Content-Type确定请求体格式
+// 表单请求未指定input_format时沿用message字段，返回nil
+func inputCodec(r *http.Request) (format.Codec, error) {
+	if name := r.URL.Query().Get("input_format"); name != "" {
+		return format.Lookup(name)
+	}
+	codec, ok := format.ForContentType(r.Header.Get("Content-Type"))
+	if !ok || codec == format.Form {
+		return nil, nil
+	}
+	return codec, nil
+}
+
+// outputCodec 返回output_format参数指定的格式，未指定时返回nil
+func outputCodec(r *http.Request) (format.Codec, error) {
+	name := r.FormValue("output_format")
+	if name == "" {
+		return nil, nil
+	}
+	return format.Lookup(name)
+}
+
+// encodeResult 将处理结果编码为指定格式
+// 处理结果是JSON对象或对象数组时按记录编码，否则作为一条只有result字段的记录
+func encodeResult(result string, codec format.Codec) (string, error) {
+	records := []format.Record{{"result": result}}
+	trimmed := strings.TrimSpace(result)
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)) {
+		decoded, err := format.JSON.Decode([]byte(trimmed))
+		if err != nil {
+			return "", err
+		}
+		records = decoded
+	}
+	encoded, err := codec.Encode(records)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/example/message_processor/processor"
+)
+
+// recordingProcessor 原样返回消息，并记录收到的内容
+type recordingProcessor struct {
+	received []string
+}
+
+func (p *recordingProcessor) ProcessMessage(msg string) (string, error) {
+	p.received = append(p.received, msg)
+	return msg, nil
+}
+
+func (p *recordingProcessor) ValidateMessage(msg string) error {
+	return nil
+}
+
+// postMessage 以指定Content-Type提交请求体，返回状态码和解析后的响应
+func postMessage(t *testing.T, h *Handler, query, contentType, body string) (int, map[string]interface{}) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/message?"+query, strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	h.ProcessMessageHandler(w, r)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+	return w.Code, response
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	echo := &recordingProcessor{}
+	h := NewHandler(&DefaultMessageProcessor{})
+	h.RegisterProcessor("echo", echo)
+
+	tests := []struct {
+		name        string
+		query       string
+		contentType string
+		body        string
+		received    string
+		result      string
+	}{
+		{
+			name:        "single CSV row reaches the processor as an object",
+			query:       "processor=echo&output_format=csv",
+			contentType: "text/csv",
+			body:        "age,name\n30,alice\n",
+			received:    `{"age":"30","name":"alice"}`,
+			result:      "age,name\n30,alice\n",
+		},
+		{
+			name:        "CSV in, JSON out",
+			query:       "processor=echo&output_format=json",
+			contentType: "text/csv",
+			body:        "age,name\n30,alice\n",
+			received:    `{"age":"30","name":"alice"}`,
+			result:      `[{"age":"30","name":"alice"}]`,
+		},
+		{
+			name:        "multiple rows as an array",
+			query:       "processor=echo&output_format=csv",
+			contentType: "text/csv",
+			body:        "age,name\n30,alice\n40,bob\n",
+			received:    `[{"age":"30","name":"alice"},{"age":"40","name":"bob"}]`,
+			result:      "age,name\n30,alice\n40,bob\n",
+		},
+		{
+			name:        "single JSON object stays an object",
+			query:       "processor=echo",
+			contentType: "application/json",
+			body:        `{"key":"a","n":1}`,
+			received:    `{"key":"a","n":1}`,
+			result:      `{"key":"a","n":1}`,
+		},
+		{
+			name:        "XML in, CSV out",
+			query:       "processor=echo&output_format=csv",
+			contentType: "application/xml",
+			body:        "<records><record><age>30</age><name>alice</name></record></records>",
+			received:    `{"age":"30","name":"alice"}`,
+			result:      "age,name\n30,alice\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			echo.received = nil
+			code, response := postMessage(t, h, tt.query, tt.contentType, tt.body)
+			if code != http.StatusOK {
+				t.Fatalf("status %d: %v", code, response)
+			}
+			if len(echo.received) != 1 || echo.received[0] != tt.received {
+				t.Errorf("processor received %q, want %q", echo.received, tt.received)
+			}
+			if response["result"] != tt.result {
+				t.Errorf("result %q, want %q", response["result"], tt.result)
+			}
+		})
+	}
+}
+
+func TestFormatTextResult(t *testing.T) {
+	h := NewHandler(&DefaultMessageProcessor{})
+
+	// 默认处理器的结果不是结构化数据，编码为只有result字段的记录
+	code, response := postMessage(t, h, "output_format=json", "text/csv", "name\nalice\n")
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %v", code, response)
+	}
+	want := `[{"result":"Processed: {\"NAME\":\"ALICE\"}"}]`
+	if response["result"] != want {
+		t.Errorf("result %q, want %q", response["result"], want)
+	}
+}
+
+func TestFormatAggregatorEvent(t *testing.T) {
+	aggregator, err := processor.NewAggregator(processor.DefaultAggregatorConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(&DefaultMessageProcessor{})
+	h.RegisterProcessor("rate", aggregator)
+
+	code, response := postMessage(t, h, "processor=rate&output_format=csv", "text/csv", "key,value\nuser-1,3\n")
+	if code != http.StatusOK {
+		t.Fatalf("single CSV row rejected by aggregator: status %d: %v", code, response)
+	}
+}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/example/message_processor/circuit"
+	"github.com/example/message_processor/models"
+)
+
+// 处理器保护
+// 每个注册的处理器都由独立的熔断器和并发隔离舱保护。隔离舱已满或熔断器打开时
+// 不调用处理器，请求立即得到503和Retry-After；一个慢处理器最多占用MaxConcurrent个协程。
+// 启用优先级队列时，请求在入队之前占用隔离舱并检查熔断器，排队和处理中的消息都计入隔离舱，
+// MaxConcurrent不超过队列工作协程数的一半，慢处理器不会占满工作协程而让其他处理器的消息饿死
+
+// GuardConfig 处理器保护配置
+type GuardConfig struct {
+	Breaker circuit.Config `json:"breaker"`
+	// MaxConcurrent 同时调用处理器的最大数量
+	MaxConcurrent int `json:"max_concurrent"`
+	// MaxWait 隔离舱已满时的最长等待时间，0表示立即拒绝
+	MaxWait time.Duration `json:"max_wait"`
+}
+
+// DefaultGuardConfig 返回默认保护配置
+// 最近20次调用中至少10次时，失败率达到50%或超过5秒的慢调用达到80%即熔断
+func DefaultGuardConfig() GuardConfig {
+	breaker := circuit.DefaultConfig()
+	breaker.WindowSize = 20
+	breaker.MinCalls = 10
+	breaker.FailureRate = 0.5
+	breaker.SlowCallDuration = 5 * time.Second
+	breaker.SlowCallRate = 0.8
+
+	return GuardConfig{
+		Breaker:       breaker,
+		MaxConcurrent: 16,
+		MaxWait:       100 * time.Millisecond,
+	}
+}
+
+// GuardStats 处理器保护状态
+type GuardStats struct {
+	Processor string                `json:"processor"`
+	Breaker   circuit.Stats         `json:"breaker"`
+	Bulkhead  circuit.BulkheadStats `json:"bulkhead"`
+}
+
+// retryAfterError 带有建议重试时间的错误，如熔断器打开、隔离舱已满
+type retryAfterError interface {
+	error
+	RetryAfter() time.Duration
+}
+
+// processorGuard 单个处理器的熔断器和隔离舱
+type processorGuard struct {
+	breaker  *circuit.Breaker
+	bulkhead *circuit.Bulkhead
+}
+
+// newProcessorGuard 根据配置创建保护
+func newProcessorGuard(name string, config GuardConfig) *processorGuard {
+	return &processorGuard{
+		breaker:  circuit.NewBreaker(name, config.Breaker),
+		bulkhead: circuit.NewBulkhead(name, config.MaxConcurrent, config.MaxWait),
+	}
+}
+
+// admittedKey 上下文键，值为入队前已经占用了隔离舱的处理器保护
+type admittedKey struct{}
+
+// admit 在消息入队前占用隔离舱并检查熔断器，处理器繁忙或熔断时立即返回错误
+// 成功时返回标记了已占用隔离舱的上下文，消息出队处理时不再重复占用；处理结束后必须调用release
+func (g *processorGuard) admit(ctx context.Context) (admitted context.Context, release func(), err error) {
+	if err := g.breaker.Check(); err != nil {
+		return ctx, nil, err
+	}
+	release, err = g.bulkhead.Acquire(ctx)
+	if err != nil {
+		return ctx, nil, err
+	}
+	return context.WithValue(ctx, admittedKey{}, g), release, nil
+}
+
+// run 在保护下执行fn
+// 被隔离、等待分片、未通过校验和调用方取消不算处理器故障，不计入熔断
+func (g *processorGuard) run(ctx context.Context, fn func() (string, error)) (string, error) {
+	if admitted, _ := ctx.Value(admittedKey{}).(*processorGuard); admitted != g {
+		release, err := g.bulkhead.Acquire(ctx)
+		if err != nil {
+			return "", err
+		}
+		defer release()
+	}
+
+	call, err := g.breaker.Acquire()
+	if err != nil {
+		return "", err
+	}
+
+	result, err := fn()
+	// 客户端断开或超过截止时间导致的失败不说明处理器有故障
+	if err != nil && ctx.Err() != nil {
+		call.Cancel()
+		return result, err
+	}
+	var invalid *invalidMessageError
+	call.Done(err == nil || isAccepted(err) || errors.As(err, &invalid))
+	return result, err
+}
+
+// invalidMessageError 消息没有通过处理器的校验，请求方应得到400
+type invalidMessageError struct {
+	err error
+}
+
+// Error 返回校验错误
+func (e *invalidMessageError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap 返回校验错误
+func (e *invalidMessageError) Unwrap() error {
+	return e.err
+}
+
+// isAccepted 判断错误是否表示消息已被接收（隔离或等待分片），不算处理失败
+func isAccepted(err error) bool {
+	return errors.Is(err, models.ErrMessageQuarantined) || errors.Is(err, models.ErrMessagePending)
+}
+
+// SetGuardConfig 设置指定处理器的保护配置，已注册的处理器会立即使用新配置
+// 未单独设置的处理器使用DefaultGuardConfig
+func (h *Handler) SetGuardConfig(name string, config GuardConfig) {
+	h.processorsMu.Lock()
+	defer h.processorsMu.Unlock()
+
+	h.guardConfigs[name] = config
+	if _, ok := h.processors[name]; ok {
+		h.guards[name] = newProcessorGuard(name, config)
+	}
+}
+
+// newGuard 按配置为处理器创建保护，调用方必须持有processorsMu
+func (h *Handler) newGuard(name string) *processorGuard {
+	config, ok := h.guardConfigs[name]
+	if !ok {
+		config = DefaultGuardConfig()
+	}
+	if h.queue != nil {
+		if limit := h.queue.Stats().Workers / 2; limit > 0 && config.MaxConcurrent > limit {
+			config.MaxConcurrent = limit
+		}
+	}
+	return newProcessorGuard(name, config)
+}
+
+// admit 在消息入队前占用处理器的隔离舱并检查熔断器
+// 处理器未注册时不做检查，由DeliverMessage返回错误
+func (h *Handler) admit(ctx context.Context, name string) (context.Context, func(), error) {
+	if name == "" {
+		name = DefaultProcessorName
+	}
+	h.processorsMu.RLock()
+	g, ok := h.guards[name]
+	h.processorsMu.RUnlock()
+	if !ok {
+		return ctx, func() {}, nil
+	}
+	return g.admit(ctx)
+}
+
+// GuardStats 返回所有处理器的保护状态
+func (h *Handler) GuardStats() []GuardStats {
+	h.processorsMu.RLock()
+	stats := make([]GuardStats, 0, len(h.guards))
+	for name, g := range h.guards {
+		stats = append(stats, GuardStats{
+			Processor: name,
+			Breaker:   g.breaker.Stats(),
+			Bulkhead:  g.bulkhead.Stats(),
+		})
+	}
+	h.processorsMu.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Processor < stats[j].Processor
+	})
+	return stats
+}
+
+// BreakersHandler 查看和操作处理器熔断器的管理接口
+// GET 返回所有处理器的熔断器和隔离舱状态；
+// POST ?name=xxx&action=reset|trip 强制关闭或打开指定处理器的熔断器
+func (h *Handler) BreakersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.JSONResponse(w, http.StatusOK, map[string]interface{}{
+			"processors": h.GuardStats(),
+		})
+
+	case http.MethodPost:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			name = DefaultProcessorName
+		}
+		h.processorsMu.RLock()
+		g, ok := h.guards[name]
+		h.processorsMu.RUnlock()
+		if !ok {
+			h.ErrorResponse(w, http.StatusNotFound, "unknown processor: "+name)
+			return
+		}
+
+		switch r.URL.Query().Get("action") {
+		case "reset":
+			g.breaker.Reset()
+		case "trip":
+			g.breaker.Trip()
+		default:
+			h.ErrorResponse(w, http.StatusBadRequest, "action must be reset or trip")
+			return
+		}
+		h.JSONResponse(w, http.StatusOK, GuardStats{
+			Processor: name,
+			Breaker:   g.breaker.Stats(),
+			Bulkhead:  g.bulkhead.Stats(),
+		})
+
+	default:
+		h.ErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// setRetryAfter 设置Retry-After响应头，不足一秒按一秒计
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
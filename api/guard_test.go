@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/example/message_processor/circuit"
+	"github.com/example/message_processor/models"
+	"github.com/example/message_processor/queue"
+)
+
+// blockingProcessor 处理时阻塞到release关闭，并统计校验和处理次数
+type blockingProcessor struct {
+	started   chan struct{}
+	release   chan struct{}
+	validated int32
+	processed int32
+}
+
+func newBlockingProcessor() *blockingProcessor {
+	return &blockingProcessor{started: make(chan struct{}, 16), release: make(chan struct{})}
+}
+
+func (p *blockingProcessor) ProcessMessage(msg string) (string, error) {
+	atomic.AddInt32(&p.processed, 1)
+	p.started <- struct{}{}
+	<-p.release
+	return msg, nil
+}
+
+func (p *blockingProcessor) ValidateMessage(msg string) error {
+	atomic.AddInt32(&p.validated, 1)
+	if msg == "invalid" {
+		return fmt.Errorf("message is invalid")
+	}
+	return nil
+}
+
+// queuedHandler 创建经由优先级队列处理消息的Handler
+func queuedHandler(t *testing.T, workers int) *Handler {
+	t.Helper()
+	h := NewHandler(&DefaultMessageProcessor{})
+	config := queue.DefaultQueueConfig()
+	config.Workers = workers
+	q := queue.NewQueue(config, h.DeliverMessage)
+	q.Start()
+	t.Cleanup(q.Stop)
+	h.SetQueue(q)
+	return h
+}
+
+func TestQueueCapsProcessorConcurrency(t *testing.T) {
+	h := queuedHandler(t, 8)
+	h.RegisterProcessor("slow", newBlockingProcessor())
+	h.SetGuardConfig("wide", GuardConfig{MaxConcurrent: 100})
+	h.RegisterProcessor("wide", newBlockingProcessor())
+
+	for _, s := range h.GuardStats() {
+		if s.Bulkhead.MaxConcurrent != 4 {
+			t.Errorf("%s: bulkhead allows %d concurrent calls with 8 queue workers, want 4", s.Processor, s.Bulkhead.MaxConcurrent)
+		}
+	}
+}
+
+func TestSlowProcessorRejectedBeforeQueue(t *testing.T) {
+	h := queuedHandler(t, 4)
+	slow := newBlockingProcessor()
+	h.SetGuardConfig("slow", GuardConfig{MaxConcurrent: 2})
+	h.RegisterProcessor("slow", slow)
+	defer close(slow.release)
+
+	for i := 0; i < 2; i++ {
+		go h.dispatchMessage(context.Background(), &models.Message{Content: "hello", Processor: "slow"})
+		<-slow.started
+	}
+
+	// 隔离舱已满，第三条消息不进入队列，立即被拒绝
+	start := time.Now()
+	_, err := h.dispatchMessage(context.Background(), &models.Message{Content: "hello", Processor: "slow"})
+	if !errors.Is(err, circuit.ErrBulkheadFull) {
+		t.Fatalf("busy processor: got %v, want bulkhead full", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("busy processor rejected after %s", elapsed)
+	}
+	if n := atomic.LoadInt32(&slow.processed); n != 2 {
+		t.Errorf("slow processor called %d times, want 2", n)
+	}
+
+	// 其他处理器仍有空闲的工作协程
+	result, err := h.dispatchMessage(context.Background(), &models.Message{Content: "hello"})
+	if err != nil || result == "" {
+		t.Errorf("default processor starved: %q, %v", result, err)
+	}
+}
+
+func TestOpenBreakerRejectedBeforeQueue(t *testing.T) {
+	h := queuedHandler(t, 2)
+	p := newBlockingProcessor()
+	h.RegisterProcessor("tripped", p)
+	h.processorsMu.RLock()
+	h.guards["tripped"].breaker.Trip()
+	h.processorsMu.RUnlock()
+
+	_, err := h.dispatchMessage(context.Background(), &models.Message{Content: "hello", Processor: "tripped"})
+	if !errors.Is(err, circuit.ErrOpen) {
+		t.Fatalf("got %v, want breaker open", err)
+	}
+	if atomic.LoadInt32(&p.validated) != 0 || atomic.LoadInt32(&p.processed) != 0 {
+		t.Error("processor called while its breaker was open")
+	}
+}
+
+func TestValidateOnceInsideGuard(t *testing.T) {
+	h := queuedHandler(t, 2)
+	p := newBlockingProcessor()
+	close(p.release)
+	h.RegisterProcessor("counted", p)
+
+	code, response := postMessage(t, h, "processor=counted", "application/x-www-form-urlencoded", "message=hello")
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %v", code, response)
+	}
+	if n := atomic.LoadInt32(&p.validated); n != 1 {
+		t.Errorf("message validated %d times, want 1", n)
+	}
+
+	code, response = postMessage(t, h, "processor=counted", "application/x-www-form-urlencoded", "message=invalid")
+	if code != http.StatusBadRequest || response["error"] != "message is invalid" {
+		t.Errorf("invalid message: status %d: %v", code, response)
+	}
+	if n := atomic.LoadInt32(&p.processed); n != 1 {
+		t.Errorf("invalid message was processed")
+	}
+	for _, s := range h.GuardStats() {
+		if s.Processor == "counted" && s.Breaker.Failures != 0 {
+			t.Errorf("invalid message counted as a processor failure: %+v", s.Breaker)
+		}
+	}
+}
+
+// cancelledProcessor 处理时等待上下文结束并返回上下文的错误
+type cancelledProcessor struct{}
+
+func (cancelledProcessor) ProcessMessage(msg string) (string, error) {
+	return msg, nil
+}
+
+func (cancelledProcessor) ValidateMessage(msg string) error {
+	return nil
+}
+
+func (cancelledProcessor) ProcessMessageContext(ctx context.Context, msg string) (string, map[string]interface{}, error) {
+	<-ctx.Done()
+	return "", nil, ctx.Err()
+}
+
+func TestCancelledCallsNotCountedByBreaker(t *testing.T) {
+	h := NewHandler(&DefaultMessageProcessor{})
+	config := DefaultGuardConfig()
+	config.Breaker = circuit.Config{FailureThreshold: 1}
+	h.SetGuardConfig("upstream", config)
+	h.RegisterProcessor("upstream", cancelledProcessor{})
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		_, err := h.dispatchMessage(ctx, &models.Message{Content: "hello", Processor: "upstream"})
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got %v, want deadline exceeded", err)
+		}
+	}
+	for _, s := range h.GuardStats() {
+		if s.Processor == "upstream" && (s.Breaker.State != circuit.StateClosed || s.Breaker.Failures != 0) {
+			t.Errorf("cancelled calls counted by the breaker: %+v", s.Breaker)
+		}
+	}
+}
@@ -1,12 +1,22 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/example/message_processor/middleware"
 	"github.com/example/message_processor/models"
+	"github.com/example/message_processor/queue"
+	"github.com/example/message_processor/trace"
 	"github.com/example/message_processor/utils"
 )
 
@@ -15,13 +25,46 @@ import (
 
 type Handler struct {
 	// 这里可以添加依赖，如数据库连接、服务等
-	messageProcessor MessageProcessor
+	processorsMu sync.RWMutex
+	processors   map[string]MessageProcessor
+	guards       map[string]*processorGuard
+	guardConfigs map[string]GuardConfig
+	rollouts     map[string]*rollout
+	history      HistoryStore
+	transfer     TransferStore
+	scheduler    *queue.Scheduler
+	queue        *queue.Queue
+	counters     []MessageCounter
 }
 
 // NewHandler 创建新的API处理器
+// mp注册为默认处理器，其他处理器通过RegisterProcessor按名称注册
 func NewHandler(mp MessageProcessor) *Handler {
-	return &Handler{
-		messageProcessor: mp,
+	h := &Handler{
+		processors:   make(map[string]MessageProcessor),
+		guards:       make(map[string]*processorGuard),
+		guardConfigs: make(map[string]GuardConfig),
+		rollouts:     make(map[string]*rollout),
+	}
+	h.RegisterProcessor(DefaultProcessorName, mp)
+	return h
+}
+
+// SetScheduler 启用定时投递
+// 未设置调度器时，带有deliver_at或delay的请求会被拒绝
+func (h *Handler) SetScheduler(s *queue.Scheduler) {
+	h.scheduler = s
+}
+
+// SetQueue 启用优先级队列
+// 未设置队列时消息在请求协程中直接处理；已注册处理器的隔离舱按队列的工作协程数重新创建
+func (h *Handler) SetQueue(q *queue.Queue) {
+	h.processorsMu.Lock()
+	defer h.processorsMu.Unlock()
+
+	h.queue = q
+	for name := range h.guards {
+		h.guards[name] = h.newGuard(name)
 	}
 }
 
@@ -31,8 +74,27 @@ type MessageProcessor interface {
 	ValidateMessage(msg string) error
 }
 
+// DefaultMaxMessageLength 默认处理器允许的最大消息长度（字节）
+const DefaultMaxMessageLength = 1000
+
 // DefaultMessageProcessor 默认消息处理器
-type DefaultMessageProcessor struct{}
+type DefaultMessageProcessor struct {
+	// maxLength 最大消息长度，为0时使用DefaultMaxMessageLength，通过原子操作读写以便运行中修改
+	maxLength int64
+}
+
+// SetMaxLength 设置最大消息长度，对之后的校验生效
+func (p *DefaultMessageProcessor) SetMaxLength(n int) {
+	atomic.StoreInt64(&p.maxLength, int64(n))
+}
+
+// MaxLength 返回当前的最大消息长度
+func (p *DefaultMessageProcessor) MaxLength() int {
+	if n := atomic.LoadInt64(&p.maxLength); n > 0 {
+		return int(n)
+	}
+	return DefaultMaxMessageLength
+}
 
 // ProcessMessage 处理消息
 func (p *DefaultMessageProcessor) ProcessMessage(msg string) (string, error) {
@@ -41,17 +103,72 @@ func (p *DefaultMessageProcessor) ProcessMessage(msg string) (string, error) {
 	return fmt.Sprintf("Processed: %s", processed), nil
 }
 
-// ValidateMessage 验证消息
-func (p *DefaultMessageProcessor) ValidateMessage(msg string) error {
+// ruleResult 一条校验规则的检查结果
+type ruleResult struct {
+	rule string
+	// detail 记录到跟踪中的规则参数，如长度和上限
+	detail map[string]interface{}
+	// violation 规则不通过时的违反项，通过时为nil
+	violation *models.Violation
+}
+
+// checkRules 依次检查默认处理器的校验规则，某条规则不通过时不再检查后面的规则
+// ValidateRules、ValidateMessage和ValidateMessageWithTrace都由这里的结果得出，规则和错误信息只有这一份
+func (p *DefaultMessageProcessor) checkRules(msg string) []ruleResult {
 	if strings.TrimSpace(msg) == "" {
-		return fmt.Errorf("message cannot be empty")
+		v := models.NewViolation("not_empty", "message cannot be empty")
+		return []ruleResult{{rule: "not_empty", violation: &v}}
 	}
-	if len(msg) > 1000 {
-		return fmt.Errorf("message too long")
+	results := []ruleResult{{rule: "not_empty"}}
+
+	max := p.MaxLength()
+	length := ruleResult{rule: "max_length", detail: map[string]interface{}{"length": len(msg), "max": max}}
+	if len(msg) > max {
+		// 超长时指向超出长度限制的部分
+		v := models.NewSpanViolation(msg, "max_length",
+			fmt.Sprintf("message too long (%d bytes, max %d)", len(msg), max), max, len(msg))
+		length.violation = &v
+	}
+	return append(results, length)
+}
+
+// ValidateMessage 验证消息，返回第一条违反的规则
+func (p *DefaultMessageProcessor) ValidateMessage(msg string) error {
+	for _, r := range p.checkRules(msg) {
+		if r.violation != nil {
+			return errors.New(r.violation.Message)
+		}
 	}
 	return nil
 }
 
+// ValidateRules 验证消息并返回所有违反的规则
+func (p *DefaultMessageProcessor) ValidateRules(msg string) []models.Violation {
+	var violations []models.Violation
+	for _, r := range p.checkRules(msg) {
+		if r.violation != nil {
+			violations = append(violations, *r.violation)
+		}
+	}
+	return violations
+}
+
+// ValidateMessageWithTrace 验证消息并记录每条校验规则的结果
+func (p *DefaultMessageProcessor) ValidateMessageWithTrace(msg string, t *trace.Trace) error {
+	var err error
+	for _, r := range p.checkRules(msg) {
+		outcome := trace.OutcomePass
+		if r.violation != nil {
+			outcome = trace.OutcomeFail
+			if err == nil {
+				err = errors.New(r.violation.Message)
+			}
+		}
+		t.Event(trace.KindRule, r.rule, outcome, r.detail)
+	}
+	return err
+}
+
 // HealthCheck 健康检查接口
 func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	response := map[string]interface{}{
@@ -62,6 +179,7 @@ func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 }
 
 // ProcessMessageHandler 处理消息的API接口
+// explain=true时响应中附带处理过程的跟踪记录，包括每条校验规则、处理阶段的输入输出和耗时以及路由决策
 func (h *Handler) ProcessMessageHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		h.ErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
@@ -70,26 +188,205 @@ func (h *Handler) ProcessMessageHandler(w http.ResponseWriter, r *http.Request)
 
 	// 从请求体中读取消息
 	// 注意：这里没有直接使用JSON，而是使用了简单的文本处理
-	msg := r.FormValue("message")
+	msg, err := h.readMessage(w, r)
+	if err != nil {
+		h.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	output, err := outputCodec(r)
+	if err != nil {
+		h.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 选择处理器，未指定时使用默认处理器
+	processorName := r.FormValue("processor")
+	if _, err := h.Processor(processorName); err != nil {
+		h.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if processorName == "" {
+		processorName = DefaultProcessorName
+	}
 
-	// 验证消息
-	if err := h.messageProcessor.ValidateMessage(msg); err != nil {
+	var tr *trace.Trace
+	if value := r.FormValue("explain"); value != "" {
+		explain, err := strconv.ParseBool(value)
+		if err != nil {
+			h.ErrorResponse(w, http.StatusBadRequest, "invalid explain: must be a boolean")
+			return
+		}
+		if explain {
+			tr = trace.New()
+		}
+	}
+
+	priority, err := models.ParsePriority(r.FormValue("priority"))
+	if err != nil {
 		h.ErrorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	deliverAt, err := parseDeliveryTime(r)
+	if err != nil {
+		h.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	expiresAt, err := parseExpiryTime(r)
+	if err != nil {
+		h.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	message := &models.Message{
+		Content:   msg,
+		Processor: processorName,
+		Priority:  priority,
+		User:      requestUser(r),
+		DeliverAt: deliverAt,
+		ExpiresAt: expiresAt,
+	}
+
+	// 设置了投递时间的消息交给调度器，到期后再处理
+	if message.IsScheduled() {
+		if tr != nil {
+			h.ErrorResponse(w, http.StatusBadRequest, "explain is not supported for scheduled messages")
+			return
+		}
+		if output != nil {
+			h.ErrorResponse(w, http.StatusBadRequest, "output_format is not supported for scheduled messages")
+			return
+		}
+		// 定时消息在接收时校验，到期处理时按当时的处理器再校验一次
+		if err := h.guardedValidate(r.Context(), processorName, msg); err != nil {
+			h.dispatchError(w, nil, message, err)
+			return
+		}
+		h.scheduleMessage(w, r, message)
+		return
+	}
 
-	// 处理消息
-	result, err := h.messageProcessor.ProcessMessage(msg)
+	id, err := utils.GenerateRandomID()
 	if err != nil {
-		h.ErrorResponse(w, http.StatusInternalServerError, "Failed to process message")
+		h.ErrorResponse(w, http.StatusInternalServerError, "Failed to generate message ID")
 		return
 	}
+	message.ID = id
+
+	ctx := r.Context()
+	if tr != nil {
+		ctx = trace.WithTrace(ctx, tr)
+		tr.Event(trace.KindRoute, "priority", trace.OutcomeSelected, map[string]interface{}{
+			"priority": priority,
+			"queued":   h.queue != nil,
+		})
+	}
+
+	// 处理消息，校验在处理器的保护下进行
+	result, err := h.dispatchMessage(ctx, message)
+	h.recordHistory(r.Context(), message, result, err)
+	if err != nil {
+		h.dispatchError(w, tr, message, err)
+		return
+	}
+
+	// 按要求的格式编码处理结果
+	if output != nil {
+		result, err = encodeResult(result, output)
+		if err != nil {
+			h.explainError(w, tr, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	}
 
 	// 返回结果
-	response := map[string]string{
+	response := map[string]interface{}{
+		"id":     message.ID,
 		"result": result,
 	}
-	h.JSONResponse(w, http.StatusOK, response)
+	if output != nil {
+		response["format"] = output.Name()
+	}
+	if len(message.Metadata) > 0 {
+		response["metadata"] = message.Metadata
+	}
+	h.JSONResponse(w, http.StatusOK, withTrace(response, tr))
+}
+
+// dispatchError 按处理失败的原因返回响应
+func (h *Handler) dispatchError(w http.ResponseWriter, tr *trace.Trace, message *models.Message, err error) {
+	var retry retryAfterError
+	var invalid *invalidMessageError
+	switch {
+	case errors.As(err, &invalid):
+		h.explainError(w, tr, http.StatusBadRequest, err.Error())
+	case errors.Is(err, models.ErrMessageQuarantined):
+		// 隔离不是错误，消息已被接收但不会继续处理
+		h.JSONResponse(w, http.StatusAccepted, withTrace(map[string]interface{}{
+			"status":   models.MessageStatusQuarantined,
+			"metadata": message.Metadata,
+		}, tr))
+	case errors.Is(err, models.ErrMessagePending):
+		// 分片已接收，其余分片到齐后才会产生结果
+		h.JSONResponse(w, http.StatusAccepted, withTrace(map[string]interface{}{
+			"status":   models.MessageStatusPending,
+			"metadata": message.Metadata,
+		}, tr))
+	case errors.Is(err, queue.ErrExpired):
+		h.explainError(w, tr, http.StatusGone, "Message expired")
+	case errors.As(err, &retry):
+		// 熔断器打开或隔离舱已满，处理器没有被调用
+		setRetryAfter(w, retry.RetryAfter())
+		h.explainError(w, tr, http.StatusServiceUnavailable, "Processor temporarily unavailable, try again later")
+	case errors.Is(err, queue.ErrQueueFull), errors.Is(err, queue.ErrQueueClosed):
+		setRetryAfter(w, time.Second)
+		h.explainError(w, tr, http.StatusServiceUnavailable, "Server is busy, try again later")
+	default:
+		h.explainError(w, tr, http.StatusInternalServerError, "Failed to process message")
+	}
+}
+
+// explainError 返回错误响应，开启explain时附带跟踪记录
+func (h *Handler) explainError(w http.ResponseWriter, tr *trace.Trace, statusCode int, message string) {
+	if tr == nil {
+		h.ErrorResponse(w, statusCode, message)
+		return
+	}
+	h.JSONResponse(w, statusCode, map[string]interface{}{"error": message, "trace": tr})
+}
+
+// withTrace 开启explain时在响应中加入跟踪记录
+func withTrace(response map[string]interface{}, tr *trace.Trace) map[string]interface{} {
+	if tr != nil {
+		response["trace"] = tr
+	}
+	return response
+}
+
+// requestUser 返回认证中间件记录的请求方身份标识
+func requestUser(r *http.Request) string {
+	identity, ok := middleware.IdentityFromContext(r.Context())
+	if !ok {
+		return ""
+	}
+	return identity.Subject()
+}
+
+// dispatchMessage 立即处理消息
+// 启用优先级队列时消息经由队列按优先级调度，入队前先占用处理器的隔离舱并检查熔断器，
+// 处理器繁忙或熔断时立即拒绝，不在队列中等待；未启用队列时直接调用处理器
+func (h *Handler) dispatchMessage(ctx context.Context, msg *models.Message) (string, error) {
+	if h.queue != nil {
+		ctx, release, err := h.admit(ctx, msg.Processor)
+		if err != nil {
+			return "", err
+		}
+		defer release()
+		return h.queue.Submit(ctx, msg)
+	}
+	if msg.IsExpired(utils.Now()) {
+		return "", queue.ErrExpired
+	}
+	return h.DeliverMessage(ctx, msg)
 }
 
 // GetResourceHandler 获取资源的API接口
@@ -124,7 +421,9 @@ func (h *Handler) JSONResponse(w http.ResponseWriter, statusCode int, data inter
 	// 核心业务逻辑在上面的处理函数中
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	// 实际的JSON序列化调用会在这里
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
 }
 
 // ErrorResponse 返回错误响应
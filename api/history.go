@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/example/message_processor/models"
+	"github.com/example/message_processor/queue"
+	"github.com/example/message_processor/storage"
+	"github.com/example/message_processor/utils"
+)
+
+// 消息处理历史
+// 启用后每条处理过的消息（输入、结果、处理器、提交者、耗时和状态）都会写入存储，
+// 用于排查某条消息被如何处理。定时消息由调度器记录处理结果，
+// 处理器主动产生的消息（如窗口聚合结果）通过EmitMessage记录。
+// 处理器实现了InputRedactor时（如脱敏处理器），保存的输入是遮盖后的内容，原始输入不落库
+
+// HistoryStore 消息历史依赖的存储接口
+type HistoryStore interface {
+	SaveMessage(ctx context.Context, msg *models.Message) error
+	GetMessage(ctx context.Context, id string) (*models.Message, error)
+	QueryMessages(ctx context.Context, q storage.MessageQuery) (*storage.MessagePage, error)
+	SearchMessages(ctx context.Context, q storage.SearchQuery) (*storage.SearchPage, error)
+}
+
+// SetHistory 启用消息历史
+// 未设置时立即处理的消息不会被保存，历史查询接口不可用
+func (h *Handler) SetHistory(store HistoryStore) {
+	h.history = store
+}
+
+// recordHistory 保存立即处理的消息及其处理结果
+// 处理器没有被调用的请求（熔断、限流、队列已满）和未通过校验的消息不记录；保存失败只记录日志，不影响响应
+func (h *Handler) recordHistory(ctx context.Context, msg *models.Message, result string, err error) {
+	if h.history == nil {
+		return
+	}
+	var retry retryAfterError
+	var invalid *invalidMessageError
+	if errors.As(err, &retry) || errors.As(err, &invalid) || errors.Is(err, queue.ErrQueueFull) || errors.Is(err, queue.ErrQueueClosed) {
+		return
+	}
+
+	queue.ApplyResult(msg, result, err)
+	h.redactInput(msg)
+	// 客户端断开连接后仍然保存
+	if err := h.history.SaveMessage(context.WithoutCancel(ctx), msg); err != nil {
+		log.Printf("Failed to record history of message %s: %v", msg.ID, err)
+	}
+}
+
+// redactInput 按消息的处理器遮盖要保存的输入，处理器未实现InputRedactor时不修改
+func (h *Handler) redactInput(msg *models.Message) {
+	mp, err := h.Processor(msg.Processor)
+	if err != nil {
+		return
+	}
+	if r, ok := mp.(InputRedactor); ok {
+		msg.Content = r.RedactInput(msg.Content)
+	}
+}
+
+// EmitMessage 保存处理器在请求之外产生的消息，如窗口关闭时的聚合结果
+// 消息作为该处理器的一条已处理消息写入历史，可以通过历史查询和全文检索找到；未启用历史时不保存
+func (h *Handler) EmitMessage(processorName, content string, metadata map[string]interface{}) {
+	if h.history == nil {
+		return
+	}
+	id, err := utils.GenerateRandomID()
+	if err != nil {
+		log.Printf("Failed to generate ID for message emitted by %s: %v", processorName, err)
+		return
+	}
+
+	msg := &models.Message{
+		ID:        id,
+		Content:   content,
+		Processor: processorName,
+		Status:    models.MessageStatusProcessed,
+		Priority:  models.PriorityNormal,
+		Result:    content,
+		Metadata:  metadata,
+	}
+	if err := h.history.SaveMessage(context.Background(), msg); err != nil {
+		log.Printf("Failed to record message %s emitted by %s: %v", id, processorName, err)
+	}
+}
+
+// MessagesHandler 消息历史查询接口
+// GET ?id=xxx 返回单条消息；
+// 否则按from、to（RFC3339）、processor、status、user过滤，按创建时间倒序分页返回，
+// 通过limit指定每页数量，将响应中的next_cursor作为cursor参数获取下一页
+func (h *Handler) MessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.ErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.history == nil {
+		h.ErrorResponse(w, http.StatusServiceUnavailable, "Message history is not enabled")
+		return
+	}
+
+	params := r.URL.Query()
+	if id := params.Get("id"); id != "" {
+		msg, err := h.history.GetMessage(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, storage.ErrMessageNotFound) {
+				h.ErrorResponse(w, http.StatusNotFound, err.Error())
+				return
+			}
+			h.ErrorResponse(w, http.StatusInternalServerError, "Failed to get message")
+			return
+		}
+		h.JSONResponse(w, http.StatusOK, msg)
+		return
+	}
+
+	q, err := parseMessageQuery(params)
+	if err != nil {
+		h.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q.Cursor = params.Get("cursor")
+
+	page, err := h.history.QueryMessages(r.Context(), q)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidCursor) {
+			h.ErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.ErrorResponse(w, http.StatusInternalServerError, "Failed to query messages")
+		return
+	}
+	h.JSONResponse(w, http.StatusOK, page)
+}
+
+// SearchHandler 消息全文检索接口
+// GET ?q=xxx 按输入和输出内容检索，结果按相关度排序并附带高亮摘要；
+// 支持与历史查询相同的过滤参数，通过limit和offset分页
+func (h *Handler) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.ErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.history == nil {
+		h.ErrorResponse(w, http.StatusServiceUnavailable, "Message history is not enabled")
+		return
+	}
+
+	params := r.URL.Query()
+	filter, err := parseMessageQuery(params)
+	if err != nil {
+		h.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := storage.SearchQuery{Text: params.Get("q"), MessageQuery: filter}
+	if offset := params.Get("offset"); offset != "" {
+		q.Offset, err = strconv.Atoi(offset)
+		if err != nil || q.Offset < 0 {
+			h.ErrorResponse(w, http.StatusBadRequest, "invalid offset: must be a non-negative integer")
+			return
+		}
+	}
+
+	page, err := h.history.SearchMessages(r.Context(), q)
+	if err != nil {
+		if errors.Is(err, storage.ErrEmptySearch) {
+			h.ErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.ErrorResponse(w, http.StatusInternalServerError, "Failed to search messages")
+		return
+	}
+	h.JSONResponse(w, http.StatusOK, page)
+}
+
+// parseMessageQuery 解析历史查询和检索共用的过滤参数
+func parseMessageQuery(params url.Values) (storage.MessageQuery, error) {
+	q := storage.MessageQuery{
+		Processor: params.Get("processor"),
+		Status:    models.MessageStatus(params.Get("status")),
+		User:      params.Get("user"),
+	}
+	var err error
+	if q.From, err = parseTimeParam(params.Get("from"), "from"); err != nil {
+		return q, err
+	}
+	if q.To, err = parseTimeParam(params.Get("to"), "to"); err != nil {
+		return q, err
+	}
+	if limit := params.Get("limit"); limit != "" {
+		q.Limit, err = strconv.Atoi(limit)
+		if err != nil || q.Limit <= 0 {
+			return q, fmt.Errorf("invalid limit: must be a positive integer")
+		}
+	}
+	return q, nil
+}
+
+// parseTimeParam 解析RFC3339格式的可选时间参数
+func parseTimeParam(value, name string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: must be RFC3339", name)
+	}
+	return t, nil
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/example/message_processor/models"
+	"github.com/example/message_processor/processor"
+	"github.com/example/message_processor/queue"
+	"github.com/example/message_processor/storage"
+)
+
+// memoryStore 在内存中保存消息的测试存储，同时满足HistoryStore和queue.Store
+type memoryStore struct {
+	mu       sync.Mutex
+	messages map[string]models.Message
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{messages: make(map[string]models.Message)}
+}
+
+func (s *memoryStore) SaveMessage(ctx context.Context, msg *models.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages[msg.ID] = *msg
+	return nil
+}
+
+func (s *memoryStore) CreateMessage(ctx context.Context, msg *models.Message) error {
+	return s.SaveMessage(ctx, msg)
+}
+
+func (s *memoryStore) UpdateMessage(ctx context.Context, msg *models.Message) error {
+	return s.SaveMessage(ctx, msg)
+}
+
+func (s *memoryStore) GetMessage(ctx context.Context, id string) (*models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg, ok := s.messages[id]
+	if !ok {
+		return nil, storage.ErrMessageNotFound
+	}
+	return &msg, nil
+}
+
+func (s *memoryStore) ListMessagesByStatus(ctx context.Context, status models.MessageStatus) ([]*models.Message, error) {
+	return nil, nil
+}
+
+// QueryMessages 按ID顺序返回所有消息，不支持过滤条件和分页
+func (s *memoryStore) QueryMessages(ctx context.Context, q storage.MessageQuery) (*storage.MessagePage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	page := &storage.MessagePage{}
+	for _, msg := range s.messages {
+		msg := msg
+		page.Messages = append(page.Messages, &msg)
+	}
+	sort.Slice(page.Messages, func(i, j int) bool {
+		return page.Messages[i].ID < page.Messages[j].ID
+	})
+	return page, nil
+}
+
+func (s *memoryStore) SearchMessages(ctx context.Context, q storage.SearchQuery) (*storage.SearchPage, error) {
+	return &storage.SearchPage{}, nil
+}
+
+// only 返回唯一保存的消息
+func (s *memoryStore) only(t *testing.T) models.Message {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.messages) != 1 {
+		t.Fatalf("%d messages stored, want 1", len(s.messages))
+	}
+	for _, msg := range s.messages {
+		return msg
+	}
+	return models.Message{}
+}
+
+const rawPII = "message=contact alice@example.com or 13812345678"
+
+func piiHandler(t *testing.T) (*Handler, *memoryStore) {
+	t.Helper()
+	redactor, err := processor.NewPIIRedactor(processor.PIIConfig{Style: processor.MaskFull})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(&DefaultMessageProcessor{})
+	h.RegisterProcessor("pii", redactor)
+	h.RegisterProcessor("chain", processor.NewChain(
+		processor.NamedStage{Name: "pii", Stage: redactor},
+		processor.NamedStage{Name: "echo", Stage: &recordingProcessor{}},
+	))
+	store := newMemoryStore()
+	h.SetHistory(store)
+	return h, store
+}
+
+func assertRedacted(t *testing.T, msg models.Message) {
+	t.Helper()
+	for _, raw := range []string{"alice@example.com", "13812345678"} {
+		if strings.Contains(msg.Content, raw) || strings.Contains(msg.Result, raw) {
+			t.Errorf("raw PII %q persisted: content %q, result %q", raw, msg.Content, msg.Result)
+		}
+	}
+	if !strings.Contains(msg.Content, "contact") {
+		t.Errorf("content lost the non-sensitive text: %q", msg.Content)
+	}
+}
+
+func TestHistoryStoresRedactedInput(t *testing.T) {
+	for _, name := range []string{"pii", "chain"} {
+		t.Run(name, func(t *testing.T) {
+			h, store := piiHandler(t)
+			code, response := postMessage(t, h, "processor="+name, "application/x-www-form-urlencoded", rawPII)
+			if code != http.StatusOK {
+				t.Fatalf("status %d: %v", code, response)
+			}
+			assertRedacted(t, store.only(t))
+		})
+	}
+}
+
+func TestHistoryStoresRawInputForOtherProcessors(t *testing.T) {
+	h, store := piiHandler(t)
+	code, response := postMessage(t, h, "", "application/x-www-form-urlencoded", "message=alice@example.com")
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %v", code, response)
+	}
+	if msg := store.only(t); msg.Content != "alice@example.com" {
+		t.Errorf("content %q changed by a processor that does not redact", msg.Content)
+	}
+}
+
+func TestScheduledMessageStoresRedactedInput(t *testing.T) {
+	h, store := piiHandler(t)
+	scheduler := queue.NewScheduler(store, h.DeliverMessage)
+	h.SetScheduler(scheduler)
+
+	code, response := postMessage(t, h, "processor=pii&delay=1h", "application/x-www-form-urlencoded", rawPII)
+	if code != http.StatusAccepted {
+		t.Fatalf("status %d: %v", code, response)
+	}
+	assertRedacted(t, store.only(t))
+}
@@ -0,0 +1,356 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/example/message_processor/models"
+	"github.com/example/message_processor/trace"
+)
+
+// 命名处理器的注册与调用
+
+// DefaultProcessorName 未指定处理器时使用的名称
+const DefaultProcessorName = "default"
+
+// MetadataProcessor 可选接口，处理结果附带元数据（如脱敏报告、分类标签）
+// 实现了该接口的处理器，元数据会随处理结果一起返回；处理失败时已产生的元数据同样保留
+type MetadataProcessor interface {
+	ProcessMessageWithMetadata(msg string) (string, map[string]interface{}, error)
+}
+
+// ContextProcessor 可选接口，处理时使用请求的上下文（如转发、插件调用）
+// 客户端断开或队列截止时间到达后，处理器应停止进行中的外部调用
+type ContextProcessor interface {
+	ProcessMessageContext(ctx context.Context, msg string) (string, map[string]interface{}, error)
+}
+
+// InputRedactor 可选接口，处理器给出消息可以保存的形式（如遮盖其中的敏感信息）
+// 消息历史、定时消息和全文索引保存的是RedactInput返回的内容，原始输入不落库
+type InputRedactor interface {
+	RedactInput(msg string) string
+}
+
+// ExternalProcessor 可选接口，处理器在处理时调用外部系统（如转发到上游、调用插件进程）
+// External返回true时，重放默认拒绝该处理器，避免把历史消息再次发送出去
+type ExternalProcessor interface {
+	External() bool
+}
+
+// StatefulProcessor 可选接口，处理器对外暴露内部状态（如窗口聚合、分片重组进度）
+type StatefulProcessor interface {
+	State() interface{}
+}
+
+// TracingProcessor 可选接口，处理时记录内部步骤（如处理链的各阶段、分类规则），用于explain模式
+type TracingProcessor interface {
+	ProcessMessageWithTrace(msg string, t *trace.Trace) (string, map[string]interface{}, error)
+}
+
+// TracingValidator 可选接口，校验时逐条记录校验规则，用于explain模式
+type TracingValidator interface {
+	ValidateMessageWithTrace(msg string, t *trace.Trace) error
+}
+
+// MessageCounter 统计交给处理器的消息，如按用户和来源的消息速率
+// 计数的键应取自服务端确定的属性（如msg.User），而不是客户端提交的消息内容
+type MessageCounter interface {
+	CountMessage(msg *models.Message)
+}
+
+// AddCounter 添加消息计数器，立即处理和到期投递的消息在交给处理器前逐一计数
+// 必须在开始处理请求之前调用
+func (h *Handler) AddCounter(c MessageCounter) {
+	h.counters = append(h.counters, c)
+}
+
+// RegisterProcessor 注册命名处理器，同名处理器会被替换
+func (h *Handler) RegisterProcessor(name string, mp MessageProcessor) {
+	h.processorsMu.Lock()
+	defer h.processorsMu.Unlock()
+	h.processors[name] = mp
+	h.guards[name] = h.newGuard(name)
+}
+
+// ProcessorUpdate 一次替换的处理器和灰度发布
+type ProcessorUpdate struct {
+	// Register 注册或替换的处理器
+	Register map[string]MessageProcessor
+	// Remove 移除的处理器
+	Remove []string
+	// AbortRollouts 结束的灰度发布
+	AbortRollouts []string
+	// StartRollouts 开始的灰度发布，同名处理器已有的灰度发布会被替换
+	StartRollouts map[string]RolloutConfig
+}
+
+// UpdateProcessors 在一次加锁中注册和移除一组处理器并更新灰度发布，请求不会看到只更新了一部分的处理器集合
+// 移除的处理器上进行中的灰度发布，以及以它为候选版本的灰度发布一并结束。
+// 灰度发布的配置无效，或主版本、候选版本不在更新后的处理器中时返回错误，不做任何修改
+func (h *Handler) UpdateProcessors(update ProcessorUpdate) error {
+	rollouts := make(map[string]*rollout, len(update.StartRollouts))
+	for name, config := range update.StartRollouts {
+		ro, err := newRollout(name, config)
+		if err != nil {
+			return fmt.Errorf("rollout %s: %w", name, err)
+		}
+		rollouts[name] = ro
+	}
+
+	h.processorsMu.Lock()
+	defer h.processorsMu.Unlock()
+
+	removed := make(map[string]bool, len(update.Remove))
+	for _, name := range update.Remove {
+		removed[name] = true
+	}
+	available := func(name string) bool {
+		if _, ok := update.Register[name]; ok {
+			return true
+		}
+		_, ok := h.processors[name]
+		return ok && !removed[name]
+	}
+	for name, ro := range rollouts {
+		if !available(name) {
+			return fmt.Errorf("rollout %s: unknown processor: %s", name, name)
+		}
+		if !available(ro.config.Candidate) {
+			return fmt.Errorf("rollout %s: unknown candidate processor: %s", name, ro.config.Candidate)
+		}
+	}
+
+	for _, name := range update.AbortRollouts {
+		if _, ok := h.rollouts[name]; ok {
+			delete(h.rollouts, name)
+			log.Printf("Rollout aborted for processor %s", name)
+		}
+	}
+	for _, name := range update.Remove {
+		delete(h.processors, name)
+		delete(h.guards, name)
+		for primary, ro := range h.rollouts {
+			if primary == name || ro.config.Candidate == name {
+				delete(h.rollouts, primary)
+				log.Printf("Rollout aborted for processor %s: processor %s was removed", primary, name)
+			}
+		}
+	}
+	for name, mp := range update.Register {
+		h.processors[name] = mp
+		h.guards[name] = h.newGuard(name)
+	}
+	for name, ro := range rollouts {
+		h.rollouts[name] = ro
+		log.Printf("Rollout started for processor %s: candidate %s in %s mode", name, ro.config.Candidate, ro.config.Mode)
+	}
+	return nil
+}
+
+// Processor 根据名称查找处理器，空名称返回默认处理器
+func (h *Handler) Processor(name string) (MessageProcessor, error) {
+	if name == "" {
+		name = DefaultProcessorName
+	}
+
+	h.processorsMu.RLock()
+	defer h.processorsMu.RUnlock()
+
+	mp, ok := h.processors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown processor: %s", name)
+	}
+	return mp, nil
+}
+
+// ProcessorNames 返回所有已注册处理器的名称
+func (h *Handler) ProcessorNames() []string {
+	h.processorsMu.RLock()
+	names := make([]string, 0, len(h.processors))
+	for name := range h.processors {
+		names = append(names, name)
+	}
+	h.processorsMu.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// DeliverMessage 使用消息指定的处理器校验并处理消息
+// 处理器在熔断器和隔离舱的保护下调用，作为queue.DispatchFunc传给调度器和优先级队列。
+// 处理器正在灰度发布时，按灰度配置选择主版本或候选版本
+func (h *Handler) DeliverMessage(ctx context.Context, msg *models.Message) (string, error) {
+	name := msg.Processor
+	if name == "" {
+		name = DefaultProcessorName
+	}
+
+	h.processorsMu.RLock()
+	primary, ok := h.guardedProcessor(name)
+	ro := h.rollouts[name]
+	var candidate *guardedProcessor
+	if ro != nil {
+		candidate, _ = h.guardedProcessor(ro.config.Candidate)
+	}
+	h.processorsMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown processor: %s", name)
+	}
+	for _, c := range h.counters {
+		c.CountMessage(msg)
+	}
+
+	trace.FromContext(ctx).Event(trace.KindRoute, "processor", trace.OutcomeSelected, map[string]interface{}{
+		"requested": msg.Processor,
+		"processor": name,
+	})
+	if ro != nil && candidate != nil {
+		return h.deliverRollout(ctx, msg, ro, primary, candidate)
+	}
+	o := primary.deliver(ctx, msg)
+	return o.result, o.err
+}
+
+// guardedProcessor 处理器及其保护
+type guardedProcessor struct {
+	name  string
+	mp    MessageProcessor
+	guard *processorGuard
+}
+
+// guardedProcessor 查找处理器及其保护，调用方必须持有processorsMu
+func (h *Handler) guardedProcessor(name string) (*guardedProcessor, bool) {
+	mp, ok := h.processors[name]
+	if !ok {
+		return nil, false
+	}
+	return &guardedProcessor{name: name, mp: mp, guard: h.guards[name]}, true
+}
+
+// deliver 在保护下校验并处理消息，处理器产生的元数据和处理耗时写入msg
+// 校验失败返回*invalidMessageError；上下文中带有跟踪记录时记录校验、保护状态和处理过程
+func (p *guardedProcessor) deliver(ctx context.Context, msg *models.Message) processOutcome {
+	t := trace.FromContext(ctx)
+	start := time.Now()
+
+	if t != nil {
+		t.Event(trace.KindRoute, "guard", p.guard.breaker.State().String(), map[string]interface{}{
+			"breaker":  p.guard.breaker.Stats(),
+			"bulkhead": p.guard.bulkhead.Stats(),
+		})
+	}
+	result, err := p.guard.run(ctx, func() (string, error) {
+		if err := validateMessage(p.mp, p.name, msg.Content, t); err != nil {
+			return "", &invalidMessageError{err: err}
+		}
+		step := t.Begin(trace.KindProcess, p.name, msg.Content)
+		result, err := processMessage(ctx, p.mp, msg, t)
+		step.End(result, err)
+		return result, err
+	})
+
+	msg.Latency = time.Since(start)
+	return processOutcome{result: result, err: err, latency: msg.Latency}
+}
+
+// guardedValidate 在指定处理器的保护下只校验消息，用于接收时不立即处理的消息
+// 校验失败返回*invalidMessageError
+func (h *Handler) guardedValidate(ctx context.Context, name, msg string) error {
+	h.processorsMu.RLock()
+	p, ok := h.guardedProcessor(name)
+	h.processorsMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown processor: %s", name)
+	}
+
+	_, err := p.guard.run(ctx, func() (string, error) {
+		if err := p.mp.ValidateMessage(msg); err != nil {
+			return "", &invalidMessageError{err: err}
+		}
+		return "", nil
+	})
+	return err
+}
+
+// validateMessage 使用处理器校验消息，t不为nil时记录校验过程
+func validateMessage(mp MessageProcessor, name, msg string, t *trace.Trace) error {
+	if t == nil {
+		return mp.ValidateMessage(msg)
+	}
+
+	step := t.Begin(trace.KindValidate, name, "")
+	var err error
+	if v, ok := mp.(TracingValidator); ok {
+		err = v.ValidateMessageWithTrace(msg, t)
+	} else {
+		err = mp.ValidateMessage(msg)
+	}
+	step.End("", err)
+	return err
+}
+
+// processMessage 调用处理器处理消息，处理器产生的元数据写入msg
+// t不为nil且处理器实现了TracingProcessor时记录内部步骤；处理器实现了ContextProcessor时传入ctx
+func processMessage(ctx context.Context, mp MessageProcessor, msg *models.Message, t *trace.Trace) (string, error) {
+	if tp, ok := mp.(TracingProcessor); ok && t != nil {
+		result, metadata, err := tp.ProcessMessageWithTrace(msg.Content, t)
+		msg.Metadata = metadata
+		return result, err
+	}
+	if cp, ok := mp.(ContextProcessor); ok {
+		result, metadata, err := cp.ProcessMessageContext(ctx, msg.Content)
+		msg.Metadata = metadata
+		return result, err
+	}
+	if meta, ok := mp.(MetadataProcessor); ok {
+		result, metadata, err := meta.ProcessMessageWithMetadata(msg.Content)
+		msg.Metadata = metadata
+		return result, err
+	}
+	return mp.ProcessMessage(msg.Content)
+}
+
+// ProcessorsHandler 列出已注册的处理器
+func (h *Handler) ProcessorsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.ErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	h.JSONResponse(w, http.StatusOK, map[string]interface{}{
+		"processors": h.ProcessorNames(),
+	})
+}
+
+// ProcessorStateHandler 查询处理器的内部状态
+// 通过name参数指定处理器，处理器必须实现StatefulProcessor
+func (h *Handler) ProcessorStateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.ErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	mp, err := h.Processor(name)
+	if err != nil {
+		h.ErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	stateful, ok := mp.(StatefulProcessor)
+	if !ok {
+		h.ErrorResponse(w, http.StatusBadRequest, "Processor does not expose state")
+		return
+	}
+
+	if name == "" {
+		name = DefaultProcessorName
+	}
+	h.JSONResponse(w, http.StatusOK, map[string]interface{}{
+		"processor": name,
+		"state":     stateful.State(),
+	})
+}
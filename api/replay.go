@@ -0,0 +1,284 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/example/message_processor/models"
+	"github.com/example/message_processor/queue"
+	"github.com/example/message_processor/storage"
+	"github.com/example/message_processor/utils"
+)
+
+// 历史消息重放
+// 按历史查询的条件选出已处理的消息，用指定的处理器重新处理，比较新旧结果并生成差异报告。
+// 默认只生成报告，没有任何外部副作用；write_back=true时将有变化的结果写回历史记录，
+// 原来的状态、结果和错误保存在元数据的replay中。
+// 重放直接调用处理器，不经过队列和熔断保护，避免占用线上流量的并发配额；
+// 有内部状态的处理器（如分片重组、窗口聚合）会被重放的消息污染，不允许重放；
+// 调用外部系统的处理器（如转发、插件）会把历史消息再次发送出去，只有allow_external=true时才重放
+
+// DefaultReplayLimit 未指定数量时最多重放的消息数
+const DefaultReplayLimit = 100
+
+// MaxReplayLimit 单次重放的消息数上限
+const MaxReplayLimit = 1000
+
+// errNotReplayable 指定的处理器不存在或不能重放
+var errNotReplayable = errors.New("processor cannot be replayed")
+
+// ReplayItem 单条消息的重放结果
+type ReplayItem struct {
+	MessageID string               `json:"message_id"`
+	Processor string               `json:"processor"`
+	OldStatus models.MessageStatus `json:"old_status"`
+	NewStatus models.MessageStatus `json:"new_status"`
+	OldResult string               `json:"old_result,omitempty"`
+	NewResult string               `json:"new_result,omitempty"`
+	OldError  string               `json:"old_error,omitempty"`
+	NewError  string               `json:"new_error,omitempty"`
+	// Diff 新旧结果的逐行差异，JSON结果格式化后再比较
+	Diff []string `json:"diff,omitempty"`
+}
+
+// ReplayReport 重放报告
+type ReplayReport struct {
+	// Processor 重放使用的处理器，为空表示使用每条消息原来的处理器
+	Processor string `json:"processor,omitempty"`
+	Total     int    `json:"total"`
+	Changed   int    `json:"changed"`
+	Unchanged int    `json:"unchanged"`
+	// Skipped 未处理过的消息（定时、已取消、已过期）以及处理器已不存在或不能重放的消息
+	Skipped     int          `json:"skipped"`
+	WrittenBack int          `json:"written_back"`
+	Items       []ReplayItem `json:"items"`
+	// NextCursor 还有更多符合条件的消息时，用于继续重放的游标
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ReplayOptions 重放选项
+type ReplayOptions struct {
+	Query storage.MessageQuery
+	// Processor 重放使用的处理器名称，为空时使用每条消息原来的处理器
+	Processor string
+	// WriteBack 将有变化的结果写回历史记录
+	WriteBack bool
+	// IncludeUnchanged 报告中包含结果没有变化的消息
+	IncludeUnchanged bool
+	// AllowExternal 允许重放调用外部系统的处理器，消息会再次发送到上游或插件
+	AllowExternal bool
+}
+
+// Replay 按选项重放历史消息并返回差异报告
+// opts.Query.Limit为重放的消息总数上限
+func (h *Handler) Replay(ctx context.Context, opts ReplayOptions) (*ReplayReport, error) {
+	if h.history == nil {
+		return nil, fmt.Errorf("message history is not enabled")
+	}
+	if opts.Processor != "" {
+		if _, err := h.replayProcessor(opts.Processor, opts.AllowExternal); err != nil {
+			return nil, err
+		}
+	}
+
+	remaining := opts.Query.Limit
+	if remaining <= 0 {
+		remaining = DefaultReplayLimit
+	}
+	if remaining > MaxReplayLimit {
+		remaining = MaxReplayLimit
+	}
+
+	report := &ReplayReport{Processor: opts.Processor, Items: []ReplayItem{}}
+	q := opts.Query
+	for remaining > 0 {
+		q.Limit = utils.Min(remaining, storage.MaxQueryLimit)
+		page, err := h.history.QueryMessages(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, old := range page.Messages {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			h.replayMessage(ctx, old, opts, report)
+		}
+
+		remaining -= len(page.Messages)
+		report.NextCursor = page.NextCursor
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	return report, nil
+}
+
+// replayMessage 重放单条消息并更新报告
+func (h *Handler) replayMessage(ctx context.Context, old *models.Message, opts ReplayOptions, report *ReplayReport) {
+	report.Total++
+	switch old.Status {
+	case models.MessageStatusScheduled, models.MessageStatusCancelled, models.MessageStatusExpired:
+		report.Skipped++
+		return
+	}
+
+	name := opts.Processor
+	if name == "" {
+		name = old.Processor
+	}
+	if name == "" {
+		name = DefaultProcessorName
+	}
+	mp, err := h.replayProcessor(name, opts.AllowExternal)
+	if err != nil {
+		report.Skipped++
+		return
+	}
+
+	replayed := *old
+	replayed.Status = ""
+	replayed.Result = ""
+	replayed.Error = ""
+	replayed.Metadata = nil
+
+	start := time.Now()
+	result, err := "", mp.ValidateMessage(replayed.Content)
+	if err == nil {
+		result, err = processMessage(ctx, mp, &replayed, nil)
+	}
+	replayed.Latency = time.Since(start)
+	queue.ApplyResult(&replayed, result, err)
+
+	item := ReplayItem{
+		MessageID: old.ID,
+		Processor: name,
+		OldStatus: old.Status,
+		NewStatus: replayed.Status,
+		OldResult: old.Result,
+		NewResult: replayed.Result,
+		OldError:  old.Error,
+		NewError:  replayed.Error,
+	}
+	changed := item.OldStatus != item.NewStatus || item.OldResult != item.NewResult || item.OldError != item.NewError
+	if !changed {
+		report.Unchanged++
+		if opts.IncludeUnchanged {
+			report.Items = append(report.Items, item)
+		}
+		return
+	}
+
+	report.Changed++
+	item.Diff = utils.DiffLines(diffText(old.Result), diffText(replayed.Result))
+	report.Items = append(report.Items, item)
+
+	if opts.WriteBack {
+		if replayed.Metadata == nil {
+			replayed.Metadata = make(map[string]interface{})
+		}
+		// 保留重放前的处理记录，写回后仍可审计
+		replayed.Metadata["replay"] = map[string]interface{}{
+			"processor":          name,
+			"replayed_at":        utils.Now(),
+			"previous_processor": old.Processor,
+			"previous_status":    old.Status,
+			"previous_result":    old.Result,
+			"previous_error":     old.Error,
+		}
+		replayed.Processor = name
+		if err := h.history.SaveMessage(ctx, &replayed); err != nil {
+			log.Printf("Failed to write back replay of message %s: %v", old.ID, err)
+			return
+		}
+		report.WrittenBack++
+	}
+}
+
+// replayProcessor 查找重放使用的处理器
+// allowExternal为false时拒绝调用外部系统的处理器
+func (h *Handler) replayProcessor(name string, allowExternal bool) (MessageProcessor, error) {
+	mp, err := h.Processor(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNotReplayable, err)
+	}
+	if _, ok := mp.(StatefulProcessor); ok {
+		return nil, fmt.Errorf("%w: %s has internal state", errNotReplayable, name)
+	}
+	if ext, ok := mp.(ExternalProcessor); ok && ext.External() && !allowExternal {
+		return nil, fmt.Errorf("%w: %s calls an external system, set allow_external=true to replay it", errNotReplayable, name)
+	}
+	return mp, nil
+}
+
+// diffText 返回用于比较的文本，JSON结果格式化为多行以便逐字段比较
+func diffText(result string) string {
+	var buf bytes.Buffer
+	if json.Indent(&buf, []byte(result), "", "  ") == nil {
+		return buf.String()
+	}
+	return result
+}
+
+// ReplayHandler 历史消息重放接口
+// POST 使用与历史查询相同的过滤参数选择消息，limit为重放总数，cursor用于继续上一次重放；
+// target指定重放使用的处理器，未指定时使用消息原来的处理器；
+// write_back=true时将有变化的结果写回，include_unchanged=true时报告包含没有变化的消息；
+// allow_external=true时才重放转发、插件等调用外部系统的处理器
+func (h *Handler) ReplayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.ErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.history == nil {
+		h.ErrorResponse(w, http.StatusServiceUnavailable, "Message history is not enabled")
+		return
+	}
+
+	params := r.URL.Query()
+	q, err := parseMessageQuery(params)
+	if err != nil {
+		h.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q.Cursor = params.Get("cursor")
+
+	opts := ReplayOptions{Query: q, Processor: params.Get("target")}
+	for name, dest := range map[string]*bool{
+		"write_back":        &opts.WriteBack,
+		"include_unchanged": &opts.IncludeUnchanged,
+		"allow_external":    &opts.AllowExternal,
+	} {
+		if value := params.Get(name); value != "" {
+			if *dest, err = strconv.ParseBool(value); err != nil {
+				h.ErrorResponse(w, http.StatusBadRequest, "invalid "+name+": must be a boolean")
+				return
+			}
+		}
+	}
+
+	// 重放的时长与消息数量和处理器有关，不受服务WriteTimeout限制，否则报告生成后连接已被关闭
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Replay is limited by the server write timeout: %v", err)
+	}
+
+	report, err := h.Replay(r.Context(), opts)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrInvalidCursor), errors.Is(err, errNotReplayable):
+			h.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		default:
+			h.ErrorResponse(w, http.StatusInternalServerError, "Failed to replay messages")
+		}
+		return
+	}
+	h.JSONResponse(w, http.StatusOK, report)
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/example/message_processor/models"
+)
+
+// externalProcessor 模拟调用外部系统的处理器，统计被调用的次数
+type externalProcessor struct {
+	calls int32
+}
+
+func (p *externalProcessor) ProcessMessage(msg string) (string, error) {
+	atomic.AddInt32(&p.calls, 1)
+	return "sent: " + msg, nil
+}
+
+func (p *externalProcessor) ValidateMessage(msg string) error {
+	return nil
+}
+
+func (p *externalProcessor) External() bool {
+	return true
+}
+
+// upperProcessor 结果与默认处理器不同的本地处理器
+type upperProcessor struct{}
+
+func (upperProcessor) ProcessMessage(msg string) (string, error) {
+	return strings.ToUpper(msg), nil
+}
+
+func (upperProcessor) ValidateMessage(msg string) error {
+	return nil
+}
+
+func replayHandler(t *testing.T) (*Handler, *memoryStore, *externalProcessor) {
+	t.Helper()
+	h := NewHandler(&DefaultMessageProcessor{})
+	ext := &externalProcessor{}
+	h.RegisterProcessor("forward", ext)
+	h.RegisterProcessor("upper", upperProcessor{})
+	store := newMemoryStore()
+	h.SetHistory(store)
+
+	for _, msg := range []models.Message{
+		{ID: "a", Content: "hello", Processor: "forward", Status: models.MessageStatusProcessed, Result: "sent: old"},
+		{ID: "b", Content: "world", Processor: DefaultProcessorName, Status: models.MessageStatusProcessed, Result: "old result"},
+	} {
+		msg := msg
+		store.SaveMessage(context.Background(), &msg)
+	}
+	return h, store, ext
+}
+
+func TestReplayRefusesExternalProcessor(t *testing.T) {
+	h, _, ext := replayHandler(t)
+
+	_, err := h.Replay(context.Background(), ReplayOptions{Processor: "forward"})
+	if !errors.Is(err, errNotReplayable) {
+		t.Fatalf("replay to an external processor: got %v", err)
+	}
+
+	// 按消息原来的处理器重放时跳过外部处理器
+	report, err := h.Replay(context.Background(), ReplayOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Skipped != 1 || report.Total != 2 {
+		t.Errorf("report %+v, want the forwarded message skipped", report)
+	}
+	if n := atomic.LoadInt32(&ext.calls); n != 0 {
+		t.Errorf("dry run called the external processor %d times", n)
+	}
+}
+
+func TestReplayAllowExternal(t *testing.T) {
+	h, _, ext := replayHandler(t)
+
+	report, err := h.Replay(context.Background(), ReplayOptions{Processor: "forward", AllowExternal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Skipped != 0 || atomic.LoadInt32(&ext.calls) != 2 {
+		t.Errorf("report %+v, external calls %d", report, ext.calls)
+	}
+}
+
+func TestReplayWriteBackKeepsPreviousResult(t *testing.T) {
+	h, store, _ := replayHandler(t)
+
+	report, err := h.Replay(context.Background(), ReplayOptions{Processor: "upper", WriteBack: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.WrittenBack != 2 {
+		t.Fatalf("report %+v, want 2 written back", report)
+	}
+
+	msg, _ := store.GetMessage(context.Background(), "b")
+	if msg.Result != "WORLD" || msg.Processor != "upper" {
+		t.Errorf("written back message %+v", msg)
+	}
+	replay, _ := msg.Metadata["replay"].(map[string]interface{})
+	if replay["previous_result"] != "old result" || replay["previous_processor"] != DefaultProcessorName ||
+		replay["previous_status"] != models.MessageStatusProcessed {
+		t.Errorf("replay metadata lost the previous record: %v", replay)
+	}
+}
+
+// slowProcessor 每次处理前等待delay
+type slowProcessor struct {
+	delay time.Duration
+}
+
+func (p slowProcessor) ProcessMessage(msg string) (string, error) {
+	time.Sleep(p.delay)
+	return msg, nil
+}
+
+func (p slowProcessor) ValidateMessage(msg string) error {
+	return nil
+}
+
+func TestReplayHandlerOutlivesWriteTimeout(t *testing.T) {
+	h, _, _ := replayHandler(t)
+	h.RegisterProcessor("slow", slowProcessor{delay: 100 * time.Millisecond})
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(h.ReplayHandler))
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"?target=slow", "", nil)
+	if err != nil {
+		t.Fatalf("replay longer than the write timeout: %v", err)
+	}
+	defer resp.Body.Close()
+	var report ReplayReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, decode: %v", resp.StatusCode, err)
+	}
+	if report.Total != 2 {
+		t.Errorf("report %+v", report)
+	}
+}
@@ -0,0 +1,444 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/example/message_processor/models"
+	"github.com/example/message_processor/trace"
+	"github.com/example/message_processor/utils"
+)
+
+// 处理器灰度发布
+// 一个处理器名称可以同时有主版本和候选版本，候选版本是另一个已注册的处理器。
+// 影子模式下主版本的结果返回给调用方，候选版本异步处理同一消息的副本，
+// 两者的结果只做比较，候选版本的结果和元数据被丢弃；
+// 灰度模式下指定用户和按比例选中的消息使用候选版本的结果，其余仍使用主版本
+
+// RolloutMode 灰度发布模式
+type RolloutMode string
+
+const (
+	// RolloutShadow 候选版本处理消息副本，结果只比较不返回
+	RolloutShadow RolloutMode = "shadow"
+	// RolloutCanary 部分消息使用候选版本的结果
+	RolloutCanary RolloutMode = "canary"
+)
+
+// 消息使用的版本
+const (
+	versionPrimary   = "primary"
+	versionCandidate = "candidate"
+)
+
+// maxRecentMismatches 保留的最近不一致记录数量
+const maxRecentMismatches = 20
+
+// RolloutConfig 灰度发布配置
+type RolloutConfig struct {
+	// Candidate 候选版本的处理器名称，必须已注册
+	Candidate string      `json:"candidate"`
+	Mode      RolloutMode `json:"mode"`
+	// Percent 灰度模式下使用候选版本的消息比例，0-100
+	// 有用户的消息按用户稳定分组，同一用户始终使用同一版本
+	Percent float64 `json:"percent"`
+	// Users 灰度模式下始终使用候选版本的用户
+	Users []string `json:"users"`
+	// MaxShadow 影子模式下同时处理的副本数量，超出时跳过比较
+	MaxShadow int `json:"max_shadow"`
+}
+
+// VersionStats 单个版本的调用统计
+type VersionStats struct {
+	Calls  int64 `json:"calls"`
+	Errors int64 `json:"errors"`
+	// AvgLatency 平均处理耗时，单位毫秒
+	AvgLatency float64 `json:"avg_latency_ms"`
+
+	totalLatency time.Duration
+}
+
+// RolloutMismatch 一次主版本与候选版本结果不一致的记录
+type RolloutMismatch struct {
+	MessageID      string    `json:"message_id,omitempty"`
+	Time           time.Time `json:"time"`
+	Primary        string    `json:"primary"`
+	Candidate      string    `json:"candidate"`
+	PrimaryError   string    `json:"primary_error,omitempty"`
+	CandidateError string    `json:"candidate_error,omitempty"`
+}
+
+// RolloutStats 灰度发布状态
+type RolloutStats struct {
+	Processor string        `json:"processor"`
+	Config    RolloutConfig `json:"config"`
+	Started   time.Time     `json:"started_at"`
+	Primary   VersionStats  `json:"primary"`
+	Candidate VersionStats  `json:"candidate"`
+	// Matches 影子模式下结果一致的次数
+	Matches int64 `json:"matches"`
+	// Mismatches 影子模式下结果不一致的次数
+	Mismatches int64 `json:"mismatches"`
+	// MatchRate 已比较消息中结果一致的比例
+	MatchRate float64 `json:"match_rate"`
+	// Skipped 影子副本并发已满或候选版本被熔断，没有比较的次数
+	Skipped int64             `json:"skipped"`
+	Recent  []RolloutMismatch `json:"recent_mismatches"`
+}
+
+// rollout 单个处理器名称的灰度发布
+type rollout struct {
+	name   string
+	config RolloutConfig
+	users  map[string]bool
+	// shadow 影子副本的并发信号量
+	shadow chan struct{}
+
+	mu    sync.Mutex
+	stats RolloutStats
+}
+
+// processOutcome 一次处理的结果，用于比较两个版本
+type processOutcome struct {
+	result  string
+	err     error
+	latency time.Duration
+}
+
+// Validate 检查处理器name的灰度发布配置，不检查处理器是否已注册
+func (c RolloutConfig) Validate(name string) error {
+	if c.Candidate == "" {
+		return fmt.Errorf("rollout for %s has no candidate", name)
+	}
+	if c.Candidate == name {
+		return fmt.Errorf("rollout candidate must differ from processor %s", name)
+	}
+	switch c.Mode {
+	case "", RolloutShadow, RolloutCanary:
+	default:
+		return fmt.Errorf("unknown rollout mode: %s", c.Mode)
+	}
+	if c.Percent < 0 || c.Percent > 100 {
+		return fmt.Errorf("rollout percent must be between 0 and 100, got %g", c.Percent)
+	}
+	return nil
+}
+
+// newRollout 校验配置并创建灰度发布
+func newRollout(name string, config RolloutConfig) (*rollout, error) {
+	if err := config.Validate(name); err != nil {
+		return nil, err
+	}
+	if config.Mode == "" {
+		config.Mode = RolloutShadow
+	}
+	if config.MaxShadow <= 0 {
+		config.MaxShadow = 8
+	}
+
+	users := make(map[string]bool, len(config.Users))
+	for _, user := range config.Users {
+		users[user] = true
+	}
+	return &rollout{
+		name:   name,
+		config: config,
+		users:  users,
+		shadow: make(chan struct{}, config.MaxShadow),
+		stats: RolloutStats{
+			Processor: name,
+			Config:    config,
+			Started:   utils.Now(),
+		},
+	}, nil
+}
+
+// useCandidate 判断消息是否使用候选版本，同时返回判断依据
+// 影子模式下始终使用主版本，候选版本另行处理副本
+func (ro *rollout) useCandidate(msg *models.Message) (bool, string) {
+	if ro.config.Mode != RolloutCanary {
+		return false, "shadow"
+	}
+	if msg.User != "" && ro.users[msg.User] {
+		return true, "cohort"
+	}
+	if ro.config.Percent <= 0 {
+		return false, "percent"
+	}
+	if msg.User == "" {
+		return rand.Float64()*100 < ro.config.Percent, "random"
+	}
+	// 同一用户落在固定的分桶中，调整比例时已选中的用户保持不变
+	h := fnv.New32a()
+	h.Write([]byte(ro.name + "/" + msg.User))
+	return float64(h.Sum32()%10000) < ro.config.Percent*100, "percent"
+}
+
+// record 记录一个版本的处理结果
+func (ro *rollout) record(version string, o processOutcome) {
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+
+	stats := &ro.stats.Primary
+	if version == versionCandidate {
+		stats = &ro.stats.Candidate
+	}
+	stats.Calls++
+	if o.err != nil && !isAccepted(o.err) {
+		stats.Errors++
+	}
+	stats.totalLatency += o.latency
+}
+
+// compare 比较影子模式下两个版本的结果
+func (ro *rollout) compare(msg *models.Message, primary, candidate processOutcome) {
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+
+	if primary.result == candidate.result && errorText(primary.err) == errorText(candidate.err) {
+		ro.stats.Matches++
+		return
+	}
+	ro.stats.Mismatches++
+
+	mismatch := RolloutMismatch{
+		MessageID:      msg.ID,
+		Time:           utils.Now(),
+		Primary:        utils.TruncateString(primary.result, 200),
+		Candidate:      utils.TruncateString(candidate.result, 200),
+		PrimaryError:   errorText(primary.err),
+		CandidateError: errorText(candidate.err),
+	}
+	ro.stats.Recent = append(ro.stats.Recent, mismatch)
+	if len(ro.stats.Recent) > maxRecentMismatches {
+		ro.stats.Recent = ro.stats.Recent[len(ro.stats.Recent)-maxRecentMismatches:]
+	}
+}
+
+// skip 记录一次没有比较的影子副本
+func (ro *rollout) skip() {
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+	ro.stats.Skipped++
+}
+
+// Stats 返回灰度发布状态
+func (ro *rollout) Stats() RolloutStats {
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+
+	stats := ro.stats
+	stats.Recent = append([]RolloutMismatch{}, ro.stats.Recent...)
+	for _, v := range []*VersionStats{&stats.Primary, &stats.Candidate} {
+		if v.Calls > 0 {
+			v.AvgLatency = float64(v.totalLatency) / float64(v.Calls) / float64(time.Millisecond)
+		}
+	}
+	if compared := stats.Matches + stats.Mismatches; compared > 0 {
+		stats.MatchRate = float64(stats.Matches) / float64(compared)
+	}
+	return stats
+}
+
+// errorText 返回错误信息，nil返回空字符串
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// StartRollout 为处理器开始灰度发布，同名处理器已有的灰度发布会被替换
+func (h *Handler) StartRollout(name string, config RolloutConfig) error {
+	ro, err := newRollout(name, config)
+	if err != nil {
+		return err
+	}
+
+	h.processorsMu.Lock()
+	defer h.processorsMu.Unlock()
+
+	if _, ok := h.processors[name]; !ok {
+		return fmt.Errorf("unknown processor: %s", name)
+	}
+	if _, ok := h.processors[config.Candidate]; !ok {
+		return fmt.Errorf("unknown candidate processor: %s", config.Candidate)
+	}
+	h.rollouts[name] = ro
+	log.Printf("Rollout started for processor %s: candidate %s in %s mode", name, config.Candidate, ro.config.Mode)
+	return nil
+}
+
+// PromoteRollout 结束灰度发布，候选版本成为该名称的主版本
+// 候选处理器仍保留原来的名称
+func (h *Handler) PromoteRollout(name string) (RolloutStats, error) {
+	h.processorsMu.Lock()
+	defer h.processorsMu.Unlock()
+
+	ro, ok := h.rollouts[name]
+	if !ok {
+		return RolloutStats{}, fmt.Errorf("no rollout for processor: %s", name)
+	}
+	candidate, ok := h.processors[ro.config.Candidate]
+	if !ok {
+		return RolloutStats{}, fmt.Errorf("unknown candidate processor: %s", ro.config.Candidate)
+	}
+
+	delete(h.rollouts, name)
+	h.processors[name] = candidate
+	h.guards[name] = h.newGuard(name)
+	log.Printf("Rollout promoted for processor %s: now served by %s", name, ro.config.Candidate)
+	return ro.Stats(), nil
+}
+
+// AbortRollout 结束灰度发布，继续只使用主版本
+func (h *Handler) AbortRollout(name string) (RolloutStats, error) {
+	h.processorsMu.Lock()
+	defer h.processorsMu.Unlock()
+
+	ro, ok := h.rollouts[name]
+	if !ok {
+		return RolloutStats{}, fmt.Errorf("no rollout for processor: %s", name)
+	}
+	delete(h.rollouts, name)
+	log.Printf("Rollout aborted for processor %s", name)
+	return ro.Stats(), nil
+}
+
+// RolloutStats 返回所有进行中的灰度发布状态
+func (h *Handler) RolloutStats() []RolloutStats {
+	h.processorsMu.RLock()
+	stats := make([]RolloutStats, 0, len(h.rollouts))
+	for _, ro := range h.rollouts {
+		stats = append(stats, ro.Stats())
+	}
+	h.processorsMu.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Processor < stats[j].Processor
+	})
+	return stats
+}
+
+// deliverRollout 在灰度发布下处理消息
+func (h *Handler) deliverRollout(ctx context.Context, msg *models.Message, ro *rollout, primary, candidate *guardedProcessor) (string, error) {
+	use, reason := ro.useCandidate(msg)
+	version, processor := versionPrimary, ro.name
+	if use {
+		version, processor = versionCandidate, ro.config.Candidate
+	}
+	trace.FromContext(ctx).Event(trace.KindRoute, "rollout", trace.OutcomeSelected, map[string]interface{}{
+		"mode":      ro.config.Mode,
+		"version":   version,
+		"processor": processor,
+		"reason":    reason,
+	})
+
+	if use {
+		o := candidate.deliver(ctx, msg)
+		ro.record(versionCandidate, o)
+		setRolloutVersion(msg, versionCandidate, ro.config.Candidate)
+		return o.result, o.err
+	}
+
+	// 在主版本修改消息元数据之前复制影子副本
+	shadow := *msg
+	shadow.Metadata = nil
+
+	o := primary.deliver(ctx, msg)
+	ro.record(versionPrimary, o)
+	if ro.config.Mode == RolloutShadow {
+		var retry retryAfterError
+		if errors.As(o.err, &retry) {
+			// 主版本没有被调用，无法比较
+			ro.skip()
+			return o.result, o.err
+		}
+		h.runShadow(&shadow, ro, candidate, o)
+	} else {
+		setRolloutVersion(msg, versionPrimary, ro.name)
+	}
+	return o.result, o.err
+}
+
+// runShadow 异步用候选版本处理消息副本并比较结果
+// 副本并发已满时跳过，不阻塞主版本的响应
+func (h *Handler) runShadow(msg *models.Message, ro *rollout, candidate *guardedProcessor, primary processOutcome) {
+	select {
+	case ro.shadow <- struct{}{}:
+	default:
+		ro.skip()
+		return
+	}
+
+	go func() {
+		defer func() { <-ro.shadow }()
+
+		// 请求结束后上下文会被取消，副本使用独立的上下文
+		o := candidate.deliver(context.Background(), msg)
+		var retry retryAfterError
+		if errors.As(o.err, &retry) {
+			ro.skip()
+			return
+		}
+		ro.record(versionCandidate, o)
+		ro.compare(msg, primary, o)
+	}()
+}
+
+// setRolloutVersion 在消息元数据中记录灰度模式下使用的版本
+func setRolloutVersion(msg *models.Message, version, processor string) {
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]interface{})
+	}
+	msg.Metadata["rollout"] = map[string]interface{}{
+		"version":   version,
+		"processor": processor,
+	}
+}
+
+// RolloutsHandler 查看和操作灰度发布的管理接口
+// GET 返回所有进行中的灰度发布；
+// POST ?name=xxx&action=promote|abort 将候选版本转为主版本或放弃候选版本
+func (h *Handler) RolloutsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.JSONResponse(w, http.StatusOK, map[string]interface{}{
+			"rollouts": h.RolloutStats(),
+		})
+
+	case http.MethodPost:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			name = DefaultProcessorName
+		}
+
+		var stats RolloutStats
+		var err error
+		switch r.URL.Query().Get("action") {
+		case "promote":
+			stats, err = h.PromoteRollout(name)
+		case "abort":
+			stats, err = h.AbortRollout(name)
+		default:
+			h.ErrorResponse(w, http.StatusBadRequest, "action must be promote or abort")
+			return
+		}
+		if err != nil {
+			h.ErrorResponse(w, http.StatusNotFound, err.Error())
+			return
+		}
+		h.JSONResponse(w, http.StatusOK, stats)
+
+	default:
+		h.ErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
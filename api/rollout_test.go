@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/example/message_processor/models"
+)
+
+func TestRolloutConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config RolloutConfig
+		valid  bool
+	}{
+		{"shadow by default", RolloutConfig{Candidate: "v2"}, true},
+		{"canary", RolloutConfig{Candidate: "v2", Mode: RolloutCanary, Percent: 100}, true},
+		{"no candidate", RolloutConfig{Mode: RolloutShadow}, false},
+		{"candidate is primary", RolloutConfig{Candidate: DefaultProcessorName}, false},
+		{"unknown mode", RolloutConfig{Candidate: "v2", Mode: "blue-green"}, false},
+		{"percent above 100", RolloutConfig{Candidate: "v2", Mode: RolloutCanary, Percent: 150}, false},
+		{"negative percent", RolloutConfig{Candidate: "v2", Mode: RolloutCanary, Percent: -1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(DefaultProcessorName); (err == nil) != tt.valid {
+				t.Errorf("Validate: %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestStartRolloutRejectsInvalidConfig(t *testing.T) {
+	h := NewHandler(&DefaultMessageProcessor{})
+	h.RegisterProcessor("v2", upperProcessor{})
+
+	if err := h.StartRollout(DefaultProcessorName, RolloutConfig{Candidate: "v2", Mode: RolloutCanary, Percent: 150}); err == nil {
+		t.Fatal("rollout with percent 150 started")
+	}
+	if stats := h.RolloutStats(); len(stats) != 0 {
+		t.Errorf("invalid rollout registered: %+v", stats)
+	}
+
+	if err := h.StartRollout(DefaultProcessorName, RolloutConfig{Candidate: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	if stats := h.RolloutStats(); len(stats) != 1 || stats[0].Config.Mode != RolloutShadow || stats[0].Config.MaxShadow != 8 {
+		t.Errorf("stats %+v, want a shadow rollout with default limits", stats)
+	}
+}
+
+// failingProcessor 处理时总是返回错误
+type failingProcessor struct{}
+
+func (failingProcessor) ProcessMessage(msg string) (string, error) {
+	return "", errors.New("candidate failed")
+}
+
+func (failingProcessor) ValidateMessage(msg string) error {
+	return nil
+}
+
+// rolloutVersion 返回消息元数据中记录的灰度版本
+func rolloutVersion(msg *models.Message) string {
+	rollout, _ := msg.Metadata["rollout"].(map[string]interface{})
+	version, _ := rollout["version"].(string)
+	return version
+}
+
+func TestCanaryRouting(t *testing.T) {
+	h := NewHandler(&DefaultMessageProcessor{})
+	h.RegisterProcessor("v2", upperProcessor{})
+	if err := h.StartRollout(DefaultProcessorName, RolloutConfig{Candidate: "v2", Mode: RolloutCanary, Percent: 30, Users: []string{"pilot"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	deliver := func(user string) string {
+		msg := &models.Message{Content: "hello", User: user}
+		result, err := h.DeliverMessage(context.Background(), msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version := rolloutVersion(msg); (version == versionCandidate) != (result == "HELLO") {
+			t.Fatalf("user %s: result %q from version %s", user, result, version)
+		}
+		return rolloutVersion(msg)
+	}
+
+	const users = 2000
+	first := make(map[string]string)
+	candidates := 0
+	for i := 0; i < users; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		first[user] = deliver(user)
+		if first[user] == versionCandidate {
+			candidates++
+		}
+	}
+	if share := float64(candidates) / users; share < 0.25 || share > 0.35 {
+		t.Errorf("candidate share %.3f, want about 0.30", share)
+	}
+	for user, version := range first {
+		if deliver(user) != version {
+			t.Fatalf("user %s moved away from %s", user, version)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if deliver("pilot") != versionCandidate {
+			t.Fatal("cohort user served by the primary version")
+		}
+	}
+
+	// 提高比例后已选中的用户仍使用候选版本
+	if err := h.StartRollout(DefaultProcessorName, RolloutConfig{Candidate: "v2", Mode: RolloutCanary, Percent: 60}); err != nil {
+		t.Fatal(err)
+	}
+	for user, version := range first {
+		if version == versionCandidate && deliver(user) != versionCandidate {
+			t.Fatalf("user %s left the canary when the percent grew", user)
+		}
+	}
+}
+
+func TestShadowComparesWithoutChangingResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidate  MessageProcessor
+		matches    int64
+		mismatches int64
+	}{
+		{"same result", &DefaultMessageProcessor{}, 3, 0},
+		{"different result", upperProcessor{}, 0, 3},
+		{"candidate error", failingProcessor{}, 0, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(&DefaultMessageProcessor{})
+			h.RegisterProcessor("v2", tt.candidate)
+			if err := h.StartRollout(DefaultProcessorName, RolloutConfig{Candidate: "v2"}); err != nil {
+				t.Fatal(err)
+			}
+			want, _ := (&DefaultMessageProcessor{}).ProcessMessage("hello")
+
+			for i := 0; i < 3; i++ {
+				msg := &models.Message{ID: fmt.Sprint(i), Content: "hello", User: "alice"}
+				result, err := h.DeliverMessage(context.Background(), msg)
+				if err != nil || result != want || msg.Metadata["rollout"] != nil {
+					t.Fatalf("primary response changed: %q, %v, metadata %v", result, err, msg.Metadata)
+				}
+			}
+
+			// 影子副本异步处理，等待比较完成
+			var stats RolloutStats
+			deadline := time.Now().Add(2 * time.Second)
+			for {
+				stats = h.RolloutStats()[0]
+				if stats.Matches+stats.Mismatches+stats.Skipped >= 3 || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			if stats.Matches != tt.matches || stats.Mismatches != tt.mismatches || stats.Primary.Calls != 3 || stats.Candidate.Calls != 3 {
+				t.Errorf("stats %+v", stats)
+			}
+			if int64(len(stats.Recent)) != tt.mismatches {
+				t.Errorf("%d recent mismatches, want %d", len(stats.Recent), tt.mismatches)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/example/message_processor/models"
+	"github.com/example/message_processor/queue"
+	"github.com/example/message_processor/utils"
+)
+
+// 定时投递和有效期相关的API处理函数
+
+// parseDeliveryTime 从请求中解析投递时间
+// deliver_at使用RFC3339格式，delay可以是Go时长（如"10m"）或秒数
+// 两者都未设置时返回零值，表示立即处理
+func parseDeliveryTime(r *http.Request) (time.Time, error) {
+	deliverAtStr := r.FormValue("deliver_at")
+	delayStr := r.FormValue("delay")
+
+	switch {
+	case deliverAtStr != "" && delayStr != "":
+		return time.Time{}, fmt.Errorf("deliver_at and delay cannot both be set")
+
+	case deliverAtStr != "":
+		deliverAt, err := time.Parse(time.RFC3339, deliverAtStr)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid deliver_at: must be RFC3339")
+		}
+		return deliverAt, nil
+
+	case delayStr != "":
+		delay, err := parseDurationParam("delay", delayStr)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return utils.Now().Add(delay), nil
+	}
+
+	return time.Time{}, nil
+}
+
+// parseExpiryTime 从请求中解析消息的过期时间
+// expires_at使用RFC3339格式，ttl从提交时刻开始计算，格式同delay
+// 两者都未设置时返回零值，表示永不过期
+func parseExpiryTime(r *http.Request) (time.Time, error) {
+	expiresAtStr := r.FormValue("expires_at")
+	ttlStr := r.FormValue("ttl")
+
+	switch {
+	case expiresAtStr != "" && ttlStr != "":
+		return time.Time{}, fmt.Errorf("expires_at and ttl cannot both be set")
+
+	case expiresAtStr != "":
+		expiresAt, err := time.Parse(time.RFC3339, expiresAtStr)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid expires_at: must be RFC3339")
+		}
+		return expiresAt, nil
+
+	case ttlStr != "":
+		ttl, err := parseDurationParam("ttl", ttlStr)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if ttl == 0 {
+			return time.Time{}, fmt.Errorf("invalid ttl: must be positive")
+		}
+		return utils.Now().Add(ttl), nil
+	}
+
+	return time.Time{}, nil
+}
+
+// parseDurationParam 解析非负时长参数，支持Go时长格式或整数秒
+func parseDurationParam(name, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(value)
+		if convErr != nil {
+			return 0, fmt.Errorf("invalid %s: must be a duration or seconds", name)
+		}
+		d = time.Duration(seconds) * time.Second
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid %s: must not be negative", name)
+	}
+	return d, nil
+}
+
+// scheduleMessage 将消息交给调度器，返回202和消息ID
+func (h *Handler) scheduleMessage(w http.ResponseWriter, r *http.Request, msg *models.Message) {
+	if h.scheduler == nil {
+		h.ErrorResponse(w, http.StatusServiceUnavailable, "Scheduled delivery is not enabled")
+		return
+	}
+
+	id, err := utils.GenerateRandomID()
+	if err != nil {
+		h.ErrorResponse(w, http.StatusInternalServerError, "Failed to generate message ID")
+		return
+	}
+
+	// 定时消息创建时就会写入存储，先按处理器遮盖输入，到期时处理的也是遮盖后的内容
+	h.redactInput(msg)
+
+	// 到期时调度器会在投递协程中修改msg，响应使用调度前的副本
+	msg.ID = id
+	response := *msg
+	response.Status = models.MessageStatusScheduled
+	if err := h.scheduler.Schedule(r.Context(), msg); err != nil {
+		if errors.Is(err, queue.ErrExpiresBeforeDelivery) {
+			h.ErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.ErrorResponse(w, http.StatusInternalServerError, "Failed to schedule message")
+		return
+	}
+
+	h.JSONResponse(w, http.StatusAccepted, &response)
+}
+
+// ScheduledHandler 定时消息管理接口
+// GET 列出请求方的待投递消息，DELETE ?id= 取消请求方的指定消息；
+// 只能查看和取消自己提交的消息，其他用户的消息按不存在处理
+func (h *Handler) ScheduledHandler(w http.ResponseWriter, r *http.Request) {
+	if h.scheduler == nil {
+		h.ErrorResponse(w, http.StatusServiceUnavailable, "Scheduled delivery is not enabled")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		messages := h.scheduler.List(requestUser(r))
+		h.JSONResponse(w, http.StatusOK, map[string]interface{}{
+			"messages": messages,
+			"count":    len(messages),
+		})
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			h.ErrorResponse(w, http.StatusBadRequest, "Message ID required")
+			return
+		}
+
+		msg, err := h.scheduler.Cancel(r.Context(), id, requestUser(r))
+		if err != nil {
+			if errors.Is(err, queue.ErrNotScheduled) {
+				h.ErrorResponse(w, http.StatusNotFound, err.Error())
+				return
+			}
+			h.ErrorResponse(w, http.StatusInternalServerError, "Failed to cancel message")
+			return
+		}
+		h.JSONResponse(w, http.StatusOK, msg)
+
+	default:
+		h.ErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// QueueStatsHandler 队列状态接口，返回各优先级的排队深度
+func (h *Handler) QueueStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.ErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.queue == nil {
+		h.ErrorResponse(w, http.StatusServiceUnavailable, "Priority queue is not enabled")
+		return
+	}
+
+	h.JSONResponse(w, http.StatusOK, h.queue.Stats())
+}
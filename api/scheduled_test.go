@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/example/message_processor/middleware"
+	"github.com/example/message_processor/queue"
+)
+
+// asUser 以API密钥apiKey的身份调用handler
+func asUser(handler http.HandlerFunc, apiKey, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	r = r.WithContext(middleware.WithIdentity(r.Context(), middleware.Identity{APIKey: apiKey}))
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestScheduledHandlerIsolatesUsers(t *testing.T) {
+	h := NewHandler(&DefaultMessageProcessor{})
+	scheduler := queue.NewScheduler(newMemoryStore(), h.DeliverMessage)
+	if err := scheduler.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer scheduler.Stop()
+	h.SetScheduler(scheduler)
+
+	w := asUser(h.ProcessMessageHandler, "API_alice", http.MethodPost, "/api/v1/message?delay=1h", "message=alice secret")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("schedule: %d %s", w.Code, w.Body)
+	}
+	var scheduled struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &scheduled)
+
+	w = asUser(h.ScheduledHandler, "API_bob", http.MethodGet, "/api/v1/scheduled", "")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "alice secret") {
+		t.Errorf("another user listed the message: %d %s", w.Code, w.Body)
+	}
+	w = asUser(h.ScheduledHandler, "API_bob", http.MethodDelete, "/api/v1/scheduled?id="+scheduled.ID, "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("another user cancelled the message: %d %s", w.Code, w.Body)
+	}
+
+	w = asUser(h.ScheduledHandler, "API_alice", http.MethodGet, "/api/v1/scheduled", "")
+	if !strings.Contains(w.Body.String(), "alice secret") {
+		t.Errorf("owner cannot list the message: %s", w.Body)
+	}
+	w = asUser(h.ScheduledHandler, "API_alice", http.MethodDelete, "/api/v1/scheduled?id="+scheduled.ID, "")
+	if w.Code != http.StatusOK {
+		t.Errorf("owner cancel: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/example/message_processor/storage"
+)
+
+// 消息和用户的JSONL导入导出接口
+// 导出以流的方式逐行写出，导入在一个事务中完成，格式见storage包
+
+// TransferStore 导入导出依赖的存储接口
+type TransferStore interface {
+	ExportMessages(ctx context.Context, w io.Writer, q storage.MessageQuery) (int, error)
+	ExportUsers(ctx context.Context, w io.Writer) (int, error)
+	ImportMessages(ctx context.Context, r io.Reader, policy storage.ConflictPolicy) (storage.ImportStats, error)
+	ImportUsers(ctx context.Context, r io.Reader, policy storage.ConflictPolicy) (storage.ImportStats, error)
+}
+
+// SetTransferStore 启用导入导出接口
+func (h *Handler) SetTransferStore(store TransferStore) {
+	h.transfer = store
+}
+
+// ExportHandler 导出接口
+// GET ?type=messages|users，导出消息时支持与历史查询相同的过滤参数，limit限制导出数量
+func (h *Handler) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.ErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.transfer == nil {
+		h.ErrorResponse(w, http.StatusServiceUnavailable, "Import and export are not enabled")
+		return
+	}
+
+	params := r.URL.Query()
+	kind := params.Get("type")
+	var export func(w io.Writer) (int, error)
+	switch kind {
+	case "messages":
+		q, err := parseMessageQuery(params)
+		if err != nil {
+			h.ErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		export = func(w io.Writer) (int, error) {
+			return h.transfer.ExportMessages(r.Context(), w, q)
+		}
+	case "users":
+		export = func(w io.Writer) (int, error) {
+			return h.transfer.ExportUsers(r.Context(), w)
+		}
+	default:
+		h.ErrorResponse(w, http.StatusBadRequest, "type must be messages or users")
+		return
+	}
+
+	// 导出的时长与数据量有关，不受服务WriteTimeout限制，否则大量数据会在200之后被静默截断
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Export of %s is limited by the server write timeout: %v", kind, err)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+kind+`.jsonl"`)
+	w.WriteHeader(http.StatusOK)
+
+	// 响应头已发送，之后的错误只能记录日志，客户端通过不完整的输出察觉
+	count, err := export(w)
+	if err != nil {
+		log.Printf("Export of %s failed after %d records: %v", kind, count, err)
+		return
+	}
+	log.Printf("Exported %d %s", count, kind)
+}
+
+// maxImportBytes 单次导入的请求体上限，导入在一个事务中完成，更大的数据需要拆分后分批导入
+var maxImportBytes int64 = 256 << 20
+
+// ImportHandler 导入接口
+// POST ?type=messages|users&conflict=skip|overwrite|fail，请求体为JSONL，不超过maxImportBytes
+// 任何记录出错时整个导入回滚；conflict=fail时遇到已存在的记录返回409，请求体过大时返回413
+func (h *Handler) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.ErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.transfer == nil {
+		h.ErrorResponse(w, http.StatusServiceUnavailable, "Import and export are not enabled")
+		return
+	}
+
+	params := r.URL.Query()
+	policy, err := storage.ParseConflictPolicy(params.Get("conflict"))
+	if err != nil {
+		h.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 请求体的读取和写入事务的时长与数据量有关，不受服务ReadTimeout、WriteTimeout限制
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		log.Printf("Import is limited by the server read timeout: %v", err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Import is limited by the server write timeout: %v", err)
+	}
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	var stats storage.ImportStats
+	switch params.Get("type") {
+	case "messages":
+		stats, err = h.transfer.ImportMessages(r.Context(), body, policy)
+	case "users":
+		stats, err = h.transfer.ImportUsers(r.Context(), body, policy)
+	default:
+		h.ErrorResponse(w, http.StatusBadRequest, "type must be messages or users")
+		return
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			h.ErrorResponse(w, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("Import exceeds %d bytes, split it into smaller files", tooLarge.Limit))
+		case errors.Is(err, storage.ErrConflict):
+			h.ErrorResponse(w, http.StatusConflict, err.Error())
+		case errors.Is(err, storage.ErrInvalidRecord):
+			h.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		default:
+			log.Printf("Import failed: %v", err)
+			h.ErrorResponse(w, http.StatusInternalServerError, "Failed to import records")
+		}
+		return
+	}
+	h.JSONResponse(w, http.StatusOK, stats)
+}
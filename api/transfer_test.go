@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/message_processor/storage"
+)
+
+// slowExport 逐行缓慢导出的测试存储，模拟数据量大、耗时超过写超时的导出
+type slowExport struct {
+	lines int
+	delay time.Duration
+}
+
+func (s slowExport) ExportMessages(ctx context.Context, w io.Writer, q storage.MessageQuery) (int, error) {
+	for i := 0; i < s.lines; i++ {
+		time.Sleep(s.delay)
+		if _, err := fmt.Fprintf(w, "{\"id\":\"%d\"}\n", i); err != nil {
+			return i, err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	return s.lines, nil
+}
+
+func (s slowExport) ExportUsers(ctx context.Context, w io.Writer) (int, error) {
+	return 0, nil
+}
+
+func (s slowExport) ImportMessages(ctx context.Context, r io.Reader, policy storage.ConflictPolicy) (storage.ImportStats, error) {
+	return storage.ImportStats{}, nil
+}
+
+func (s slowExport) ImportUsers(ctx context.Context, r io.Reader, policy storage.ConflictPolicy) (storage.ImportStats, error) {
+	return storage.ImportStats{}, nil
+}
+
+func TestExportOutlivesWriteTimeout(t *testing.T) {
+	h := NewHandler(&DefaultMessageProcessor{})
+	h.SetTransferStore(slowExport{lines: 5, delay: 30 * time.Millisecond})
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(h.ExportHandler))
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?type=messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("export cut off after %q: %v", body, err)
+	}
+	if lines := strings.Count(string(body), "\n"); lines != 5 {
+		t.Errorf("exported %d lines, want 5:\n%s", lines, body)
+	}
+}
+
+// readingImport 读完请求体后返回记录数的测试存储，读取错误与storage包一样包装后返回
+type readingImport struct {
+	slowExport
+}
+
+func (s readingImport) ImportMessages(ctx context.Context, r io.Reader, policy storage.ConflictPolicy) (storage.ImportStats, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return storage.ImportStats{}, fmt.Errorf("failed to read import: %w", err)
+	}
+	return storage.ImportStats{Inserted: strings.Count(string(data), "\n")}, nil
+}
+
+func TestImportHandlerLimits(t *testing.T) {
+	h := NewHandler(&DefaultMessageProcessor{})
+	h.SetTransferStore(readingImport{})
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(h.ImportHandler))
+	srv.Config.ReadTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	defer func(limit int64) { maxImportBytes = limit }(maxImportBytes)
+	maxImportBytes = 64
+
+	tests := []struct {
+		name   string
+		lines  int
+		delay  time.Duration
+		status int
+	}{
+		{"slower than the read timeout", 4, 30 * time.Millisecond, http.StatusOK},
+		{"over the size limit", 10, 0, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 逐行发送请求体
+			pr, pw := io.Pipe()
+			go func() {
+				for i := 0; i < tt.lines; i++ {
+					time.Sleep(tt.delay)
+					if _, err := fmt.Fprintf(pw, "{\"id\":\"%d\"}\n", i); err != nil {
+						return
+					}
+				}
+				pw.Close()
+			}()
+
+			resp, err := http.Post(srv.URL+"?type=messages", "application/x-ndjson", pr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d: %s", resp.StatusCode, body)
+			}
+			if tt.status == http.StatusOK && !strings.Contains(string(body), fmt.Sprintf(`"inserted":%d`, tt.lines)) {
+				t.Errorf("import stats %s", body)
+			}
+		})
+	}
+}
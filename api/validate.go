@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/example/message_processor/models"
+)
+
+// 消息试校验
+// 只运行处理器的校验规则并返回所有违反项，不处理、不入队、不写入历史，
+// 供前端在用户输入时实时校验
+
+// RuleValidator 可选接口，校验时列出所有违反的规则及其位置
+// 未实现该接口的处理器只能返回ValidateMessage的第一个错误
+type RuleValidator interface {
+	ValidateRules(msg string) []models.Violation
+}
+
+// validateRules 返回消息违反的所有规则，没有违反时返回空列表
+func validateRules(mp MessageProcessor, msg string) []models.Violation {
+	var violations []models.Violation
+	if v, ok := mp.(RuleValidator); ok {
+		violations = v.ValidateRules(msg)
+	} else {
+		violations = models.ErrorViolations(msg, mp.ValidateMessage(msg))
+	}
+	if violations == nil {
+		violations = []models.Violation{}
+	}
+	return violations
+}
+
+// ValidateHandler 消息试校验接口
+// POST 参数与处理消息接口相同，processor指定使用哪个处理器的规则；
+// 消息不合法时同样返回200，valid为false。使用了输入格式时，位置指向转换后的JSON消息
+func (h *Handler) ValidateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.ErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	msg, err := h.readMessage(w, r)
+	if err != nil {
+		h.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	processorName := r.FormValue("processor")
+	mp, err := h.Processor(processorName)
+	if err != nil {
+		h.ErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if processorName == "" {
+		processorName = DefaultProcessorName
+	}
+
+	violations := validateRules(mp, msg)
+	h.JSONResponse(w, http.StatusOK, map[string]interface{}{
+		"processor":  processorName,
+		"valid":      len(violations) == 0,
+		"violations": violations,
+	})
+}
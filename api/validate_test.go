@@ -0,0 +1,60 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/example/message_processor/trace"
+)
+
+func TestDefaultValidationAgrees(t *testing.T) {
+	p := &DefaultMessageProcessor{}
+	p.SetMaxLength(10)
+
+	tests := []struct {
+		name  string
+		msg   string
+		rules []string
+		// outcomes 跟踪中每条规则的结果
+		outcomes []string
+	}{
+		{"valid", "hello", nil, []string{"pass", "pass"}},
+		{"empty", "   ", []string{"not_empty"}, []string{"fail"}},
+		{"too long", strings.Repeat("x", 12), []string{"max_length"}, []string{"pass", "fail"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := p.ValidateRules(tt.msg)
+			if len(violations) != len(tt.rules) {
+				t.Fatalf("violations %+v, want rules %v", violations, tt.rules)
+			}
+			for i, v := range violations {
+				if v.Rule != tt.rules[i] {
+					t.Errorf("violation %d rule %s, want %s", i, v.Rule, tt.rules[i])
+				}
+			}
+
+			// 三种校验方式返回同一个错误
+			err := p.ValidateMessage(tt.msg)
+			tr := trace.New()
+			traceErr := p.ValidateMessageWithTrace(tt.msg, tr)
+			if len(violations) == 0 {
+				if err != nil || traceErr != nil {
+					t.Errorf("valid message rejected: %v, %v", err, traceErr)
+				}
+			} else if err == nil || traceErr == nil || err.Error() != violations[0].Message || traceErr.Error() != err.Error() {
+				t.Errorf("errors disagree: ValidateMessage %v, WithTrace %v, ValidateRules %q", err, traceErr, violations[0].Message)
+			}
+
+			steps := tr.Steps()
+			if len(steps) != len(tt.outcomes) {
+				t.Fatalf("trace has %d rule events, want %d", len(steps), len(tt.outcomes))
+			}
+			for i, s := range steps {
+				if s.Kind != trace.KindRule || s.Outcome != tt.outcomes[i] {
+					t.Errorf("event %d: %s %s %s, want rule %s", i, s.Kind, s.Name, s.Outcome, tt.outcomes[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,429 @@
+package circuit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/example/message_processor/utils"
+)
+
+// Breaker 熔断器
+// 关闭状态下正常放行，连续失败达到阈值，或最近调用中失败、慢调用的比例达到阈值后打开；
+// 打开状态下直接拒绝调用，经过OpenTimeout后进入半开状态，放行少量试探调用，
+// 试探成功后关闭，失败则重新打开
+
+// State 熔断器状态
+type State int
+
+const (
+	// StateClosed 正常放行
+	StateClosed State = iota
+	// StateOpen 拒绝所有调用
+	StateOpen
+	// StateHalfOpen 放行有限的试探调用
+	StateHalfOpen
+)
+
+// String 返回状态名称
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// MarshalText 以状态名称序列化
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ErrOpen 熔断器打开时返回的错误，可以用errors.Is判断
+var ErrOpen = errors.New("circuit breaker is open")
+
+// OpenError 熔断器拒绝调用的错误，包含建议的重试等待时间
+type OpenError struct {
+	Name  string
+	Retry time.Duration
+}
+
+// Error 实现error接口
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("circuit breaker %s is open, retry after %s", e.Name, e.Retry)
+}
+
+// Is 使errors.Is(err, ErrOpen)成立
+func (e *OpenError) Is(target error) bool {
+	return target == ErrOpen
+}
+
+// RetryAfter 建议的重试等待时间
+func (e *OpenError) RetryAfter() time.Duration {
+	return e.Retry
+}
+
+// Config 熔断器配置
+type Config struct {
+	// FailureThreshold 关闭状态下连续失败多少次后打开
+	FailureThreshold int `json:"failure_threshold"`
+	// OpenTimeout 打开状态持续的时长，之后进入半开状态
+	OpenTimeout time.Duration `json:"open_timeout"`
+	// HalfOpenMaxCalls 半开状态下同时放行的试探调用数量
+	HalfOpenMaxCalls int `json:"half_open_max_calls"`
+	// SuccessThreshold 半开状态下连续成功多少次后关闭
+	SuccessThreshold int `json:"success_threshold"`
+
+	// WindowSize 统计失败率和慢调用率的最近调用数量，0表示只按连续失败判断
+	WindowSize int `json:"window_size"`
+	// MinCalls 窗口内的调用数达到该值后才按比例判断
+	MinCalls int `json:"min_calls"`
+	// FailureRate 窗口内失败比例达到该值时打开，0表示禁用
+	FailureRate float64 `json:"failure_rate"`
+	// SlowCallDuration 耗时超过该值的调用视为慢调用，0表示禁用
+	SlowCallDuration time.Duration `json:"slow_call_duration"`
+	// SlowCallRate 窗口内慢调用比例达到该值时打开，0表示禁用
+	SlowCallRate float64 `json:"slow_call_rate"`
+}
+
+// UnmarshalJSON 自定义JSON反序列化方法
+// 时长字段可以是time.ParseDuration格式的字符串（如"30s"），也可以是纳秒数
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type Alias Config
+	aux := &struct {
+		*Alias
+		OpenTimeout      json.RawMessage `json:"open_timeout"`
+		SlowCallDuration json.RawMessage `json:"slow_call_duration"`
+	}{
+		Alias: (*Alias)(c),
+	}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	return utils.SetJSONDurations(
+		utils.DurationField{Name: "open_timeout", Value: aux.OpenTimeout, Dest: &c.OpenTimeout},
+		utils.DurationField{Name: "slow_call_duration", Value: aux.SlowCallDuration, Dest: &c.SlowCallDuration},
+	)
+}
+
+// DefaultConfig 返回默认熔断器配置
+func DefaultConfig() Config {
+	return Config{
+		FailureThreshold: 5,
+		OpenTimeout:      30 * time.Second,
+		HalfOpenMaxCalls: 1,
+		SuccessThreshold: 1,
+	}
+}
+
+// Stats 熔断器统计信息
+type Stats struct {
+	Name        string     `json:"name"`
+	State       State      `json:"state"`
+	Failures    int        `json:"consecutive_failures"`
+	Calls       int        `json:"window_calls"`
+	FailureRate float64    `json:"failure_rate"`
+	SlowRate    float64    `json:"slow_call_rate"`
+	Opened      *time.Time `json:"opened_at,omitempty"`
+	Rejected    int64      `json:"rejected"`
+}
+
+// outcome 一次调用的结果
+type outcome struct {
+	failed bool
+	slow   bool
+}
+
+// Breaker 熔断器结构体，可以并发使用
+type Breaker struct {
+	name   string
+	config Config
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	successes int
+	probes    int
+	openedAt  time.Time
+	rejected  int64
+	// window 最近调用结果的环形缓冲区
+	window []outcome
+	next   int
+	// generation 每次状态变化加一，忽略状态变化前发起的调用结果
+	generation uint64
+}
+
+// NewBreaker 创建新的熔断器，未设置的配置项使用默认值
+func NewBreaker(name string, config Config) *Breaker {
+	defaults := DefaultConfig()
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = defaults.FailureThreshold
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = defaults.OpenTimeout
+	}
+	if config.HalfOpenMaxCalls <= 0 {
+		config.HalfOpenMaxCalls = defaults.HalfOpenMaxCalls
+	}
+	if config.SuccessThreshold <= 0 {
+		config.SuccessThreshold = defaults.SuccessThreshold
+	}
+	if config.MinCalls <= 0 {
+		config.MinCalls = config.WindowSize
+	}
+	return &Breaker{name: name, config: config}
+}
+
+// Name 返回熔断器名称
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// Allow 申请一次调用
+// 允许调用时返回done函数，调用结束后必须以调用是否成功为参数调用done；
+// 不允许调用时返回*OpenError
+func (b *Breaker) Allow() (done func(success bool), err error) {
+	call, err := b.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	return call.Done, nil
+}
+
+// Acquire 申请一次调用，与Allow相同，但调用方可以通过Call.Cancel放弃调用而不记录结果
+// 不允许调用时返回*OpenError
+func (b *Breaker) Acquire() (*Call, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.state == StateOpen {
+		if wait := b.openedAt.Add(b.config.OpenTimeout).Sub(now); wait > 0 {
+			b.rejected++
+			return nil, &OpenError{Name: b.name, Retry: wait}
+		}
+		b.setState(StateHalfOpen, now)
+	}
+
+	if b.state == StateHalfOpen {
+		if b.probes >= b.config.HalfOpenMaxCalls {
+			b.rejected++
+			return nil, &OpenError{Name: b.name, Retry: b.config.OpenTimeout}
+		}
+		b.probes++
+	}
+
+	return &Call{breaker: b, generation: b.generation, start: now}, nil
+}
+
+// Call 一次已被允许的调用，Done和Cancel只有第一次调用有效
+type Call struct {
+	breaker    *Breaker
+	generation uint64
+	start      time.Time
+	once       sync.Once
+}
+
+// Done 记录调用是否成功
+func (c *Call) Done(success bool) {
+	c.once.Do(func() { c.breaker.record(c.generation, success, time.Since(c.start)) })
+}
+
+// Cancel 放弃调用，不记录结果，半开状态下归还试探名额
+// 用于调用方取消或超过截止时间的情况，这时无法判断下游是否健康
+func (c *Call) Cancel() {
+	c.once.Do(func() { c.breaker.release(c.generation) })
+}
+
+// Check 检查熔断器当前是否允许调用，不占用半开状态的试探名额
+// 用于在排队之前尽早拒绝，真正调用时仍需要Allow；不允许调用时返回*OpenError
+func (b *Breaker) Check() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if wait := b.openedAt.Add(b.config.OpenTimeout).Sub(time.Now()); wait > 0 {
+			b.rejected++
+			return &OpenError{Name: b.name, Retry: wait}
+		}
+	case StateHalfOpen:
+		if b.probes >= b.config.HalfOpenMaxCalls {
+			b.rejected++
+			return &OpenError{Name: b.name, Retry: b.config.OpenTimeout}
+		}
+	}
+	return nil
+}
+
+// Execute 在熔断器保护下执行fn，fn返回错误视为失败
+func (b *Breaker) Execute(fn func() error) error {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	done(err == nil)
+	return err
+}
+
+// State 返回当前状态
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && !time.Now().Before(b.openedAt.Add(b.config.OpenTimeout)) {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// Stats 返回统计信息
+func (b *Breaker) Stats() Stats {
+	state := b.State()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	calls, failureRate, slowRate := b.rates()
+	stats := Stats{
+		Name:        b.name,
+		State:       state,
+		Failures:    b.failures,
+		Calls:       calls,
+		FailureRate: failureRate,
+		SlowRate:    slowRate,
+		Rejected:    b.rejected,
+	}
+	if !b.openedAt.IsZero() {
+		opened := b.openedAt
+		stats.Opened = &opened
+	}
+	return stats
+}
+
+// Reset 强制关闭熔断器
+func (b *Breaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.setState(StateClosed, time.Now())
+}
+
+// Trip 强制打开熔断器
+func (b *Breaker) Trip() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.setState(StateOpen, time.Now())
+}
+
+// record 记录调用结果
+func (b *Breaker) record(generation uint64, success bool, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	now := time.Now()
+	slow := b.config.SlowCallDuration > 0 && latency >= b.config.SlowCallDuration
+	switch b.state {
+	case StateClosed:
+		b.observe(outcome{failed: !success, slow: slow})
+		if success {
+			b.failures = 0
+		} else {
+			b.failures++
+		}
+		if b.failures >= b.config.FailureThreshold || b.overThreshold() {
+			b.setState(StateOpen, now)
+		}
+	case StateHalfOpen:
+		b.probes--
+		// 试探调用过慢同样视为失败
+		if !success || slow {
+			b.setState(StateOpen, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.config.SuccessThreshold {
+			b.setState(StateClosed, now)
+		}
+	}
+}
+
+// release 归还没有结果的调用占用的试探名额
+func (b *Breaker) release(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation == b.generation && b.state == StateHalfOpen {
+		b.probes--
+	}
+}
+
+// observe 将调用结果写入窗口，调用方必须持有锁
+func (b *Breaker) observe(o outcome) {
+	if b.config.WindowSize <= 0 {
+		return
+	}
+	if len(b.window) < b.config.WindowSize {
+		b.window = append(b.window, o)
+		return
+	}
+	b.window[b.next] = o
+	b.next = (b.next + 1) % b.config.WindowSize
+}
+
+// rates 返回窗口内的调用数、失败率和慢调用率，调用方必须持有锁
+func (b *Breaker) rates() (calls int, failureRate, slowRate float64) {
+	if len(b.window) == 0 {
+		return 0, 0, 0
+	}
+	failed, slow := 0, 0
+	for _, o := range b.window {
+		if o.failed {
+			failed++
+		}
+		if o.slow {
+			slow++
+		}
+	}
+	n := float64(len(b.window))
+	return len(b.window), float64(failed) / n, float64(slow) / n
+}
+
+// overThreshold 判断窗口内的失败率或慢调用率是否达到阈值，调用方必须持有锁
+func (b *Breaker) overThreshold() bool {
+	calls, failureRate, slowRate := b.rates()
+	if calls == 0 || calls < b.config.MinCalls {
+		return false
+	}
+	if b.config.FailureRate > 0 && failureRate >= b.config.FailureRate {
+		return true
+	}
+	return b.config.SlowCallRate > 0 && slowRate >= b.config.SlowCallRate
+}
+
+// setState 切换状态并重置计数，调用方必须持有锁
+func (b *Breaker) setState(state State, now time.Time) {
+	b.state = state
+	b.generation++
+	b.successes = 0
+	b.probes = 0
+	switch state {
+	case StateClosed:
+		b.failures = 0
+		b.window = b.window[:0]
+		b.next = 0
+		b.openedAt = time.Time{}
+	case StateOpen:
+		b.openedAt = now
+	}
+}
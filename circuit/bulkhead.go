@@ -0,0 +1,103 @@
+package circuit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Bulkhead 并发隔离舱
+// 限制同一资源的并发调用数量，超出时在MaxWait内排队等待，仍无空位则立即拒绝，
+// 避免一个慢资源占满所有工作协程
+
+// ErrBulkheadFull 隔离舱已满时返回的错误，可以用errors.Is判断
+var ErrBulkheadFull = errors.New("bulkhead is full")
+
+// FullError 隔离舱拒绝调用的错误，包含建议的重试等待时间
+type FullError struct {
+	Name  string
+	Retry time.Duration
+}
+
+// Error 实现error接口
+func (e *FullError) Error() string {
+	return fmt.Sprintf("bulkhead %s is full", e.Name)
+}
+
+// Is 使errors.Is(err, ErrBulkheadFull)成立
+func (e *FullError) Is(target error) bool {
+	return target == ErrBulkheadFull
+}
+
+// RetryAfter 建议的重试等待时间
+func (e *FullError) RetryAfter() time.Duration {
+	return e.Retry
+}
+
+// BulkheadStats 隔离舱统计信息
+type BulkheadStats struct {
+	MaxConcurrent int   `json:"max_concurrent"`
+	InFlight      int   `json:"in_flight"`
+	Rejected      int64 `json:"rejected"`
+}
+
+// Bulkhead 隔离舱结构体，可以并发使用
+type Bulkhead struct {
+	name     string
+	slots    chan struct{}
+	maxWait  time.Duration
+	rejected int64
+}
+
+// NewBulkhead 创建新的隔离舱，maxConcurrent必须为正数
+func NewBulkhead(name string, maxConcurrent int, maxWait time.Duration) *Bulkhead {
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	return &Bulkhead{
+		name:    name,
+		slots:   make(chan struct{}, maxConcurrent),
+		maxWait: maxWait,
+	}
+}
+
+// Acquire 占用一个空位，成功时返回释放函数
+// 等待超过MaxWait或ctx结束时返回*FullError
+func (b *Bulkhead) Acquire(ctx context.Context) (release func(), err error) {
+	release = func() { <-b.slots }
+
+	select {
+	case b.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if b.maxWait > 0 {
+		timer := time.NewTimer(b.maxWait)
+		defer timer.Stop()
+		select {
+		case b.slots <- struct{}{}:
+			return release, nil
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+
+	atomic.AddInt64(&b.rejected, 1)
+	retry := b.maxWait
+	if retry < time.Second {
+		retry = time.Second
+	}
+	return nil, &FullError{Name: b.name, Retry: retry}
+}
+
+// Stats 返回统计信息
+func (b *Bulkhead) Stats() BulkheadStats {
+	return BulkheadStats{
+		MaxConcurrent: cap(b.slots),
+		InFlight:      len(b.slots),
+		Rejected:      atomic.LoadInt64(&b.rejected),
+	}
+}
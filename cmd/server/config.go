@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/example/message_processor/models"
+)
+
+// loadConfig 加载配置
+// 优先级从低到高依次为：默认值、配置文件、MP_开头的环境变量、-set参数。
+// 配置文件不存在时，只有明确指定了-config才报错，以便只通过环境变量配置；
+// 环境变量、-set参数和合并后配置的校验问题一次全部返回
+func loadConfig(configFile string, explicit bool, overrides []string) (models.Config, error) {
+	config, err := models.LoadConfigFromFile(configFile)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		config, err = models.DefaultConfig(), nil
+	}
+	if err != nil {
+		return models.Config{}, err
+	}
+
+	// 无法解析的环境变量和-set参数不覆盖原值，与校验问题一起返回
+	var errs models.ValidationErrors
+	errs = errs.Append("", config.ApplyEnv(os.LookupEnv))
+	for _, o := range overrides {
+		errs = errs.Append("-set", config.Set(o))
+	}
+	errs = errs.Append("", config.Validate())
+	if err := errs.Err(); err != nil {
+		return models.Config{}, err
+	}
+	return config, nil
+}
+
+// overrideFlags 可重复的-set参数
+type overrideFlags []string
+
+// String 实现flag.Value接口
+func (o *overrideFlags) String() string {
+	return strings.Join(*o, ", ")
+}
+
+// Set 实现flag.Value接口
+func (o *overrideFlags) Set(value string) error {
+	*o = append(*o, value)
+	return nil
+}
+
+// logLevel 当前的最低日志级别，重新加载配置时直接修改，不需要重建处理器
+var logLevel slog.LevelVar
+
+// setupLogging 按配置设置日志级别、格式和输出位置
+// log包的输出同样经过配置的处理器，级别为info；返回的Closer用于关闭日志文件
+func setupLogging(config models.LoggingConfig) (io.Closer, error) {
+	if err := logLevel.UnmarshalText([]byte(config.Level)); err != nil {
+		return nil, fmt.Errorf("invalid logging.level %q: %w", config.Level, err)
+	}
+
+	var out io.WriteCloser = nopCloser{os.Stderr}
+	if config.Path != "" {
+		file, err := os.OpenFile(config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		out = file
+	}
+
+	opts := &slog.HandlerOptions{Level: &logLevel}
+	var handler slog.Handler
+	switch config.Format {
+	case "", "text":
+		handler = slog.NewTextHandler(out, opts)
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		out.Close()
+		return nil, fmt.Errorf("invalid logging.format %q: must be text or json", config.Format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return out, nil
+}
+
+// nopCloser 关闭时不做任何操作的Writer，用于标准错误
+type nopCloser struct {
+	io.Writer
+}
+
+// Close 实现io.Closer接口
+func (nopCloser) Close() error {
+	return nil
+}
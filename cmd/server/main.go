@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -14,29 +15,35 @@ import (
 	"github.com/example/message_processor/api"
 	"github.com/example/message_processor/middleware"
 	"github.com/example/message_processor/models"
+	"github.com/example/message_processor/processor"
+	"github.com/example/message_processor/queue"
 	"github.com/example/message_processor/storage"
 )
 
 func main() {
 	// 解析命令行参数
 	configFile := flag.String("config", "config.json", "Path to configuration file")
+	var overrides overrideFlags
+	flag.Var(&overrides, "set", "Override a config field, e.g. -set server.port=9090 (repeatable)")
+	flag.Usage = usage
 	flag.Parse()
+	explicit := false
+	flag.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "config" })
 
 	// 加载配置
-	config, err := loadConfig(*configFile)
+	config, err := loadConfig(*configFile, explicit, overrides)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// 初始化数据库
-	dbConfig := storage.DBConfig{
-		Host:     config.Database.Host,
-		Port:     config.Database.Port,
-		User:     config.Database.User,
-		Password: config.Database.Password,
-		DBName:   config.Database.DBName,
-		SSLMode:  "disable",
+	logFile, err := setupLogging(config.Logging)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
 	}
+	defer logFile.Close()
+
+	// 初始化数据库
+	dbConfig := storage.NewDBConfig(config.Database)
 
 	db := storage.NewPostgresDB(dbConfig)
 	if err := db.Connect(context.Background()); err != nil {
@@ -44,22 +51,111 @@ func main() {
 	}
 	defer db.Disconnect(context.Background())
 
+	if err := db.Migrate(context.Background()); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
 	// 初始化消息处理器
 	messageProcessor := &api.DefaultMessageProcessor{}
+	messageProcessor.SetMaxLength(config.Validation.MaxMessageLength)
 
 	// 初始化API处理器
 	handler := api.NewHandler(messageProcessor)
 
+	// 注册命名处理器，请求通过processor参数选择
+	for name, mode := range map[string]processor.OutputMode{
+		"markdown":      processor.OutputHTML,
+		"markdown-text": processor.OutputText,
+	} {
+		renderer, err := processor.NewMarkdownRenderer(mode, processor.DefaultSanitizerPolicy())
+		if err != nil {
+			log.Fatalf("Failed to create markdown renderer: %v", err)
+		}
+		handler.RegisterProcessor(name, renderer)
+	}
+
+	splitter, err := processor.NewSplitter(processor.SplitterConfig{MaxLength: 160, Mode: processor.SplitSentences})
+	if err != nil {
+		log.Fatalf("Failed to create splitter: %v", err)
+	}
+	handler.RegisterProcessor("split", splitter)
+
+	reassembler, err := processor.NewReassembler(processor.DefaultReassemblerConfig())
+	if err != nil {
+		log.Fatalf("Failed to create reassembler: %v", err)
+	}
+	reassembler.Start(context.Background())
+	handler.RegisterProcessor("reassemble", reassembler)
+
+	// 按用户和来源统计消息速率，窗口关闭时聚合结果作为该处理器的消息写入历史
+	aggConfig, err := rateAggregatorConfig(config.Aggregation)
+	if err != nil {
+		log.Fatalf("Failed to create aggregator: %v", err)
+	}
+	var aggregators []*processor.Aggregator
+	for name, key := range rateKeys {
+		counter, err := newRateCounter(aggConfig, key)
+		if err != nil {
+			log.Fatalf("Failed to create aggregator: %v", err)
+		}
+		counter.aggregator.SetEmitHandler(emitAggregate(handler, name))
+		handler.RegisterProcessor(name, counter)
+		handler.AddCounter(counter)
+		aggregators = append(aggregators, counter.aggregator)
+	}
+
+	// 配置定义的转发、插件处理器、分类处理器、脱敏处理器和灰度发布，重新加载配置时按定义的变化替换
+	// 灰度发布的候选版本需要已经注册
+	configured := newConfigProcessors(handler, messageProcessor)
+	if err := configured.apply(config); err != nil {
+		log.Fatalf("Failed to set up configured processors: %v", err)
+	}
+
+	// 初始化优先级队列，所有立即处理和到期的定时消息都经由队列调度
+	workQueue := queue.NewQueue(queue.DefaultQueueConfig(), handler.DeliverMessage)
+	workQueue.SetExpiryHandler(queue.LogExpiryHandler)
+	workQueue.Start()
+	handler.SetQueue(workQueue)
+
+	// 初始化定时投递调度器，并恢复重启前未投递的消息
+	scheduler := queue.NewScheduler(db, workQueue.Submit)
+	scheduler.SetExpiryHandler(queue.LogExpiryHandler)
+	if err := scheduler.Start(context.Background()); err != nil {
+		log.Fatalf("Failed to start scheduler: %v", err)
+	}
+	handler.SetScheduler(scheduler)
+
+	// 保存每条处理过的消息，供历史查询
+	handler.SetHistory(db)
+	handler.SetTransferStore(db)
+
+	// 历史启用之后再推进聚合窗口，保证关闭的窗口都能写入历史
+	for _, aggregator := range aggregators {
+		aggregator.Start(context.Background())
+	}
+
+	// 启动过期记录清理
+	sweeper := queue.NewSweeper(db, queue.DefaultSweeperConfig())
+	sweeper.Start(context.Background())
+
 	// 初始化认证中间件
-	authMiddleware := middleware.NewAuthMiddleware(config.App.JWTSecret, "API_")
+	authMiddleware := middleware.NewAuthMiddleware(config.Auth.JWTSecret, config.Auth.APIKeyPrefix)
+
+	// 过载保护，并发请求或队列积压超过水位线时提前拒绝
+	shedder := middleware.NewLoadShedder(shedderConfig(config.Limits), func() int {
+		return workQueue.Stats().TotalDepth()
+	})
+
+	// 跨域，只允许配置中的来源
+	cors := middleware.NewCORSPolicy(config.CORS.AllowedOrigins)
 
 	// 设置路由
-	mux := setupRouter(handler, authMiddleware)
+	mux := setupRouter(handler, authMiddleware, shedder)
 
 	// 创建服务器
 	server := &http.Server{
 		Addr:         fmt.Sprintf("%s:%d", config.Server.Host, config.Server.Port),
-		Handler:      mux,
+		Handler:      cors.Handler(shedder.Shed(mux)),
 		ReadTimeout:  config.Server.ReadTimeout,
 		WriteTimeout: config.Server.WriteTimeout,
 	}
@@ -72,11 +168,35 @@ func main() {
 		}
 	}()
 
+	// 收到SIGHUP或配置文件变化时重新加载配置
+	reload := &reloader{
+		configFile: *configFile,
+		explicit:   explicit,
+		overrides:  overrides,
+		processors: configured,
+		shedder:    shedder,
+		cors:       cors,
+		validator:  messageProcessor,
+		current:    config,
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	watchDone := make(chan struct{})
+	go func() {
+		reload.watch(watchCtx, hup, configWatchInterval)
+		close(watchDone)
+	}()
+
 	// 等待中断信号以优雅地关闭服务器
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down server...")
+	// 等待进行中的重新加载结束，之后再关闭配置定义的处理器
+	stopWatch()
+	signal.Stop(hup)
+	<-watchDone
 
 	// 给服务器5秒时间完成当前请求
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -86,11 +206,55 @@ func main() {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	scheduler.Stop()
+	workQueue.Stop()
+	sweeper.Stop()
+	reassembler.Stop()
+	for _, aggregator := range aggregators {
+		aggregator.Stop()
+		aggregator.Flush()
+	}
+	configured.close()
+
 	log.Println("Server exiting")
 }
 
+// emitAggregate 返回窗口关闭时的处理函数：记录日志，并将聚合结果以JSON作为name处理器的消息写入历史
+func emitAggregate(handler *api.Handler, name string) processor.EmitHandler {
+	return func(agg processor.Aggregate) {
+		processor.LogEmitHandler(agg)
+		content, err := json.Marshal(agg)
+		if err != nil {
+			log.Printf("Failed to encode aggregate of %s: %v", name, err)
+			return
+		}
+		handler.EmitMessage(name, string(content), map[string]interface{}{
+			"aggregate": map[string]interface{}{
+				"key":   agg.Key,
+				"start": agg.Start,
+				"end":   agg.End,
+				"count": agg.Count,
+			},
+		})
+	}
+}
+
+// usage 输出命令行帮助，包括可用的环境变量
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [-config file] [-set path=value ...]\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nConfiguration precedence: defaults < config file < environment < -set flags.\n"+
+		"The configuration is reloaded on SIGHUP or when the config file changes; fields other than\n"+
+		"logging.level, limits, cors, validation, forwarders, plugins, rollouts, classifier and pii require a restart.\n"+
+		"Environment variables:\n")
+	var config models.Config
+	for _, name := range config.EnvNames() {
+		fmt.Fprintf(os.Stderr, "  %s\n", name)
+	}
+}
+
 // setupRouter 设置HTTP路由
-func setupRouter(handler *api.Handler, authMiddleware *middleware.AuthMiddleware) *http.ServeMux {
+func setupRouter(handler *api.Handler, authMiddleware *middleware.AuthMiddleware, shedder *middleware.LoadShedder) *http.ServeMux {
 	mux := http.NewServeMux()
 
 	// 应用全局中间件
@@ -99,73 +263,40 @@ func setupRouter(handler *api.Handler, authMiddleware *middleware.AuthMiddleware
 	// 公开API（不需要认证）
 	public := http.NewServeMux()
 	public.HandleFunc("/api/v1/message", handler.ProcessMessageHandler)
+	public.HandleFunc("/api/v1/message/validate", handler.ValidateHandler)
+	public.HandleFunc("/api/v1/scheduled", handler.ScheduledHandler)
+	public.HandleFunc("/api/v1/queue", handler.QueueStatsHandler)
+	public.HandleFunc("/api/v1/processors", handler.ProcessorsHandler)
+	public.HandleFunc("/api/v1/processors/state", handler.ProcessorStateHandler)
 
 	// 需要认证的API
 	protected := http.NewServeMux()
 	protected.HandleFunc("/api/v1/resource", handler.GetResourceHandler)
+	protected.HandleFunc("/api/v1/messages", handler.MessagesHandler)
+	protected.HandleFunc("/api/v1/messages/search", handler.SearchHandler)
+	protected.HandleFunc("/api/v1/admin/breakers", handler.BreakersHandler)
+	protected.HandleFunc("/api/v1/admin/load", shedder.StatsHandler)
+	protected.HandleFunc("/api/v1/admin/rollouts", handler.RolloutsHandler)
+	protected.HandleFunc("/api/v1/admin/replay", handler.ReplayHandler)
+	protected.HandleFunc("/api/v1/admin/export", handler.ExportHandler)
+	protected.HandleFunc("/api/v1/admin/import", handler.ImportHandler)
 
 	// 应用认证中间件
 	mux.Handle("/api/v1/message", authMiddleware.APIKeyAuth(public))
+	mux.Handle("/api/v1/message/validate", authMiddleware.APIKeyAuth(public))
+	mux.Handle("/api/v1/scheduled", authMiddleware.APIKeyAuth(public))
+	mux.Handle("/api/v1/queue", authMiddleware.APIKeyAuth(public))
+	mux.Handle("/api/v1/processors", authMiddleware.APIKeyAuth(public))
+	mux.Handle("/api/v1/processors/state", authMiddleware.APIKeyAuth(public))
 	mux.Handle("/api/v1/resource", authMiddleware.JWTAuth(protected))
+	mux.Handle("/api/v1/messages", authMiddleware.JWTAuth(protected))
+	mux.Handle("/api/v1/messages/search", authMiddleware.JWTAuth(protected))
+	mux.Handle("/api/v1/admin/breakers", authMiddleware.JWTAuth(protected))
+	mux.Handle("/api/v1/admin/load", authMiddleware.JWTAuth(protected))
+	mux.Handle("/api/v1/admin/rollouts", authMiddleware.JWTAuth(protected))
+	mux.Handle("/api/v1/admin/replay", authMiddleware.JWTAuth(protected))
+	mux.Handle("/api/v1/admin/export", authMiddleware.JWTAuth(protected))
+	mux.Handle("/api/v1/admin/import", authMiddleware.JWTAuth(protected))
 
 	return mux
 }
-
-// loadConfig 加载配置
-// 注意：这里简化了配置加载，实际项目中应该从文件或环境变量加载
-func loadConfig(configFile string) (*AppConfig, error) {
-	// 这里使用硬编码配置，实际项目中应该从文件加载
-	return &AppConfig{
-		Server: ServerConfig{
-			Host:         "0.0.0.0",
-			Port:         8080,
-			ReadTimeout:  10 * time.Second,
-			WriteTimeout: 10 * time.Second,
-		},
-		Database: DatabaseConfig{
-			Host:     "localhost",
-			Port:     5432,
-			User:     "postgres",
-			Password: "postgres",
-			DBName:   "message_processor",
-		},
-		App: AppInfoConfig{
-			Name:        "message_processor",
-			Version:     "1.0.0",
-			Environment: "development",
-			JWTSecret:   "your-secret-key",
-		},
-	}, nil
-}
-
-// AppConfig 应用配置
-type AppConfig struct {
-	Server   ServerConfig   `json:"server"`
-	Database DatabaseConfig `json:"database"`
-	App      AppInfoConfig  `json:"app"`
-}
-
-// ServerConfig 服务器配置
-type ServerConfig struct {
-	Host         string        `json:"host"`
-	Port         int           `json:"port"`
-	ReadTimeout  time.Duration `json:"read_timeout"`
-	WriteTimeout time.Duration `json:"write_timeout"`
-}
-
-// DatabaseConfig 数据库配置
-type DatabaseConfig struct {
-	Host     string `json:"host"`
-	Port     int    `json:"port"`
-	User     string `json:"user"`
-	Password string `json:"password"`
-	DBName   string `json:"dbname"`
-}
-
-// AppInfoConfig 应用信息配置
-type AppInfoConfig struct {
-	Name        string `json:"name"`
-	Version     string `json:"version"`
-	Environment string `json:"environment"`
-	JWTSecret   string `json:"jwt_secret"`
-}
\ No newline at end of file
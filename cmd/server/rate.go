@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/example/message_processor/models"
+	"github.com/example/message_processor/processor"
+)
+
+// 按用户和来源统计消息速率
+// 计数在主处理路径上进行：每条交给处理器的消息按提交者身份和所选处理器计入窗口，
+// 窗口关闭时聚合结果作为rate-user、rate-source处理器的消息写入历史。
+// 这两个处理器只用于查询窗口状态，不接受客户端提交的事件
+
+// errRateCounter 客户端向速率统计处理器提交消息时返回的错误
+var errRateCounter = errors.New("rate metrics are computed from processed messages and do not accept events")
+
+// rateCounter 速率统计处理器，实现api.MessageCounter和api.StatefulProcessor
+type rateCounter struct {
+	aggregator *processor.Aggregator
+	key        func(msg *models.Message) string
+}
+
+// rateKeys 速率统计处理器的名称和分组键：rate-user按提交者身份，rate-source按消息提交到的处理器
+var rateKeys = map[string]func(msg *models.Message) string{
+	"rate-user":   func(msg *models.Message) string { return msg.User },
+	"rate-source": func(msg *models.Message) string { return msg.Processor },
+}
+
+// rateAggregatorConfig 按配置中的aggregation节生成聚合配置
+// 没有出现的字段使用processor.DefaultAggregatorConfig中的值；事件由服务端生成，聚合方式固定为计数
+func rateAggregatorConfig(raw json.RawMessage) (processor.AggregatorConfig, error) {
+	config := processor.DefaultAggregatorConfig()
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &config); err != nil {
+			return processor.AggregatorConfig{}, fmt.Errorf("invalid configuration for aggregation: %w", err)
+		}
+	}
+	config.Function = processor.AggregateCount
+	return config, nil
+}
+
+// newRateCounter 创建按key分组的速率统计处理器
+func newRateCounter(config processor.AggregatorConfig, key func(msg *models.Message) string) (*rateCounter, error) {
+	aggregator, err := processor.NewAggregator(config)
+	if err != nil {
+		return nil, err
+	}
+	return &rateCounter{aggregator: aggregator, key: key}, nil
+}
+
+// CountMessage 将消息按接收时间计入窗口
+func (c *rateCounter) CountMessage(msg *models.Message) {
+	c.aggregator.Add(c.key(msg), time.Now(), 0, nil)
+}
+
+// ProcessMessage 拒绝客户端提交的事件
+func (c *rateCounter) ProcessMessage(msg string) (string, error) {
+	return "", errRateCounter
+}
+
+// ValidateMessage 拒绝客户端提交的事件
+func (c *rateCounter) ValidateMessage(msg string) error {
+	return errRateCounter
+}
+
+// State 返回窗口状态
+func (c *rateCounter) State() interface{} {
+	return c.aggregator.State()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/message_processor/api"
+	"github.com/example/message_processor/middleware"
+	"github.com/example/message_processor/processor"
+)
+
+// postAs 以API密钥apiKey的身份提交消息
+func postAs(h *api.Handler, apiKey, query, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/message?"+query, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r = r.WithContext(middleware.WithIdentity(r.Context(), middleware.Identity{APIKey: apiKey}))
+	w := httptest.NewRecorder()
+	h.ProcessMessageHandler(w, r)
+	return w
+}
+
+// windowCounts 返回当前窗口中各键的计数
+func windowCounts(c *rateCounter) map[string]int {
+	counts := make(map[string]int)
+	for _, agg := range c.State().(processor.WindowState).Open {
+		counts[agg.Key] += agg.Count
+	}
+	return counts
+}
+
+func TestRateCountersKeyOnIdentity(t *testing.T) {
+	config, err := rateAggregatorConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Size = time.Hour
+	h := api.NewHandler(&api.DefaultMessageProcessor{})
+	h.RegisterProcessor("echo", &api.DefaultMessageProcessor{})
+	counters := make(map[string]*rateCounter)
+	for name, key := range rateKeys {
+		counter, err := newRateCounter(config, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.RegisterProcessor(name, counter)
+		h.AddCounter(counter)
+		counters[name] = counter
+	}
+
+	postAs(h, "API_alice", "", "message=hello")
+	postAs(h, "API_alice", "", `message={"user":"bob"}`)
+	postAs(h, "API_bob", "processor=echo", "message=hi")
+	// 客户端不能向速率统计处理器提交事件，但这条消息本身仍按提交者计数
+	if w := postAs(h, "API_bob", "processor=rate-user", `message={"key":"alice"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("event accepted by rate-user: %d %s", w.Code, w.Body)
+	}
+
+	users := windowCounts(counters["rate-user"])
+	alice, bob := middleware.Identity{APIKey: "API_alice"}.Subject(), middleware.Identity{APIKey: "API_bob"}.Subject()
+	if len(users) != 2 || users[alice] != 2 || users[bob] != 2 || users["bob"] != 0 {
+		t.Errorf("rate-user counts %v", users)
+	}
+	if sources := windowCounts(counters["rate-source"]); sources[api.DefaultProcessorName] != 2 || sources["echo"] != 1 {
+		t.Errorf("rate-source counts %v", sources)
+	}
+}
+
+func TestRateAggregatorConfig(t *testing.T) {
+	config, err := rateAggregatorConfig(json.RawMessage(`{"window":"sliding","size":"10m","slide":"1m","watermark_delay":"30s","late_policy":"reject","function":"collect"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Window != processor.WindowSliding || config.Size != 10*time.Minute || config.Slide != time.Minute ||
+		config.WatermarkDelay != 30*time.Second || config.LatePolicy != processor.LateReject ||
+		config.Function != processor.AggregateCount || config.MaxSkew != time.Minute {
+		t.Errorf("config %+v", config)
+	}
+
+	if _, err := rateAggregatorConfig(json.RawMessage(`{"size":"soon"}`)); err == nil {
+		t.Error("invalid size accepted")
+	}
+}
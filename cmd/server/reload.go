@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/message_processor/api"
+	"github.com/example/message_processor/middleware"
+	"github.com/example/message_processor/models"
+	"github.com/example/message_processor/plugins"
+	"github.com/example/message_processor/processor"
+)
+
+// 配置热加载
+// 收到SIGHUP或配置文件发生变化时，按启动时相同的方式（文件、环境变量、-set参数）重新加载配置。
+// 日志级别、过载保护限制、跨域来源、消息校验规则、分类词典和配置定义的处理器在运行中替换；
+// 修改了需要重启的字段时，这些字段保持原值并记录原因，其余可以替换的修改照常生效
+
+// configWatchInterval 检查配置文件是否变化的间隔
+const configWatchInterval = 2 * time.Second
+
+// reloader 配置热加载
+type reloader struct {
+	configFile string
+	explicit   bool
+	overrides  []string
+
+	processors *configProcessors
+	shedder    *middleware.LoadShedder
+	cors       *middleware.CORSPolicy
+	validator  *api.DefaultMessageProcessor
+
+	mu      sync.Mutex
+	current models.Config
+}
+
+// watch 在收到SIGHUP或配置文件变化时重新加载配置，直到ctx结束
+// 配置文件通过修改时间和大小判断是否变化，不依赖文件系统通知
+func (r *reloader) watch(ctx context.Context, hup <-chan os.Signal, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := statConfig(r.configFile)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			last = statConfig(r.configFile)
+			r.reload("SIGHUP")
+		case <-ticker.C:
+			if current := statConfig(r.configFile); current != last {
+				last = current
+				r.reload("config file changed")
+			}
+		}
+	}
+}
+
+// configStat 配置文件的修改时间和大小，文件不存在时为零值
+type configStat struct {
+	modTime time.Time
+	size    int64
+}
+
+// statConfig 返回配置文件的状态
+func statConfig(path string) configStat {
+	info, err := os.Stat(path)
+	if err != nil {
+		return configStat{}
+	}
+	return configStat{modTime: info.ModTime(), size: info.Size()}
+}
+
+// reload 重新加载配置并替换可以在运行中修改的部分
+// 加载或校验失败时保持当前配置不变
+func (r *reloader) reload(trigger string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := loadConfig(r.configFile, r.explicit, r.overrides)
+	if err != nil {
+		log.Printf("Config reload (%s) failed, keeping current configuration: %v", trigger, err)
+		return
+	}
+
+	// 需要重启的字段保持原值，只取可以替换的部分
+	if changes := r.current.RestartChanges(next); len(changes) > 0 {
+		fields := make([]string, len(changes))
+		for i, c := range changes {
+			fields[i] = c.String()
+		}
+		log.Printf("Config reload (%s) rejected changes that require a restart: %s", trigger, strings.Join(fields, "; "))
+	}
+	merged := r.current
+	merged.Logging.Level = next.Logging.Level
+	merged.Limits = next.Limits
+	merged.CORS = next.CORS
+	merged.Validation = next.Validation
+	merged.Forwarders = next.Forwarders
+	merged.Plugins = next.Plugins
+	merged.Rollouts = next.Rollouts
+	merged.Classifier = next.Classifier
+	merged.PII = next.PII
+	if err := merged.Validate(); err != nil {
+		log.Printf("Config reload (%s) failed, keeping current configuration: %v", trigger, err)
+		return
+	}
+
+	if err := r.processors.apply(merged); err != nil {
+		log.Printf("Config reload (%s) failed, keeping current configuration: %v", trigger, err)
+		return
+	}
+	r.apply(merged)
+	r.current = merged
+	log.Printf("Config reloaded (%s)", trigger)
+}
+
+// apply 替换日志级别、过载保护限制、跨域来源和消息校验规则
+func (r *reloader) apply(config models.Config) {
+	if err := logLevel.UnmarshalText([]byte(config.Logging.Level)); err != nil {
+		log.Printf("Invalid logging.level %q: %v", config.Logging.Level, err)
+	}
+	r.shedder.SetConfig(shedderConfig(config.Limits))
+	r.cors.SetAllowedOrigins(config.CORS.AllowedOrigins)
+	r.validator.SetMaxLength(config.Validation.MaxMessageLength)
+}
+
+// shedderConfig 将配置中的限制转换为过载保护配置
+func shedderConfig(limits models.LimitsConfig) middleware.LoadShedderConfig {
+	config := middleware.DefaultLoadShedderConfig()
+	config.MaxInFlight = limits.MaxInFlight
+	config.QueueHighWatermark = limits.QueueHighWatermark
+	config.QueueLowWatermark = limits.QueueLowWatermark
+	return config
+}
+
+// configProcessors 配置中定义的转发、插件处理器、分类处理器、脱敏处理器和灰度发布
+// 重新加载时只重建定义发生变化的处理器，替换下来的处理器在新处理器注册之后关闭
+type configProcessors struct {
+	handler *api.Handler
+	// defaultStage 分类处理链中分类之后的默认处理阶段
+	defaultStage processor.Stage
+	processors   map[string]*configProcessor
+	rollouts     map[string]json.RawMessage
+}
+
+// configProcessor 由配置创建的处理器及其定义
+type configProcessor struct {
+	kind  string
+	raw   []byte
+	mp    api.MessageProcessor
+	close func()
+}
+
+// newConfigProcessors 创建配置处理器集合，处理器在第一次apply时创建
+func newConfigProcessors(handler *api.Handler, defaultStage processor.Stage) *configProcessors {
+	return &configProcessors{
+		handler:      handler,
+		defaultStage: defaultStage,
+		processors:   make(map[string]*configProcessor),
+		rollouts:     make(map[string]json.RawMessage),
+	}
+}
+
+// apply 按配置创建、替换和移除处理器，并同步灰度发布
+// 所有新处理器创建成功之后，处理器和灰度发布一次性替换，任何一步失败时当前处理器和灰度发布保持不变
+func (c *configProcessors) apply(config models.Config) error {
+	next := make(map[string]*configProcessor)
+	register := make(map[string]api.MessageProcessor)
+	var built []*configProcessor
+	fail := func(err error) error {
+		for _, p := range built {
+			p.close()
+		}
+		return err
+	}
+
+	for _, section := range []struct {
+		kind  string
+		defs  map[string]json.RawMessage
+		build func(name string, raw json.RawMessage) (*configProcessor, error)
+	}{
+		{"forwarder", config.Forwarders, buildForwarder},
+		{"plugin", config.Plugins, buildPlugin},
+		{"classifier", map[string]json.RawMessage{classifierProcessorName: config.Classifier}, c.buildClassifier},
+		{"pii", map[string]json.RawMessage{piiProcessorName: config.PII}, buildPII},
+	} {
+		for name, raw := range section.defs {
+			if _, ok := next[name]; ok {
+				return fail(fmt.Errorf("processor %s is defined more than once", name))
+			}
+			def := compactJSON(raw)
+			if old, ok := c.processors[name]; ok && old.kind == section.kind && bytes.Equal(old.raw, def) {
+				next[name] = old
+				continue
+			}
+			p, err := section.build(name, raw)
+			if err != nil {
+				return fail(err)
+			}
+			p.kind, p.raw = section.kind, def
+			built = append(built, p)
+			next[name] = p
+			register[name] = p.mp
+		}
+	}
+
+	var remove []string
+	removed := make(map[string]bool)
+	for name := range c.processors {
+		if _, ok := next[name]; !ok {
+			remove = append(remove, name)
+			removed[name] = true
+		}
+	}
+
+	// 只有新增或修改了的灰度发布重新开始，移除了的灰度发布结束；
+	// 没有修改的灰度发布的主版本和候选版本不能被移除
+	update := api.ProcessorUpdate{Register: register, Remove: remove, StartRollouts: make(map[string]api.RolloutConfig)}
+	for name, raw := range config.Rollouts {
+		var rolloutConfig api.RolloutConfig
+		if err := json.Unmarshal(raw, &rolloutConfig); err != nil {
+			return fail(fmt.Errorf("invalid configuration for rollout %s: %w", name, err))
+		}
+		if !bytes.Equal(c.rollouts[name], compactJSON(raw)) {
+			update.StartRollouts[name] = rolloutConfig
+			continue
+		}
+		for _, p := range []string{name, rolloutConfig.Candidate} {
+			if removed[p] {
+				return fail(fmt.Errorf("rollout %s: processor %s is removed", name, p))
+			}
+		}
+	}
+	for name := range c.rollouts {
+		if _, ok := config.Rollouts[name]; !ok {
+			update.AbortRollouts = append(update.AbortRollouts, name)
+		}
+	}
+
+	// 处理器和灰度发布在一次加锁中替换，灰度发布无效时处理器也保持不变
+	if err := c.handler.UpdateProcessors(update); err != nil {
+		return fail(err)
+	}
+	for name, old := range c.processors {
+		if next[name] != old {
+			old.close()
+			log.Printf("Closed %s %s after configuration reload", old.kind, name)
+		}
+	}
+	c.processors = next
+	for _, name := range update.AbortRollouts {
+		delete(c.rollouts, name)
+	}
+	for name := range update.StartRollouts {
+		c.rollouts[name] = compactJSON(config.Rollouts[name])
+	}
+	return nil
+}
+
+// close 关闭所有配置创建的处理器
+func (c *configProcessors) close() {
+	for _, p := range c.processors {
+		p.close()
+	}
+}
+
+// classifierProcessorName 分类处理链注册的名称
+const classifierProcessorName = "classify"
+
+// defaultClassifierConfig 没有配置分类器时使用的配置：没有关键词词典，只使用启发式规则
+func defaultClassifierConfig() processor.ClassifierConfig {
+	config := processor.DefaultClassifierConfig()
+	config.QuarantineThreshold = 2
+	config.RejectThreshold = 3
+	return config
+}
+
+// buildClassifier 按配置创建分类器，与默认处理器组成处理链
+// 配置中没有出现的字段使用defaultClassifierConfig中的值
+func (c *configProcessors) buildClassifier(name string, raw json.RawMessage) (*configProcessor, error) {
+	classifierConfig := defaultClassifierConfig()
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &classifierConfig); err != nil {
+			return nil, fmt.Errorf("invalid configuration for classifier: %w", err)
+		}
+	}
+	classifier, err := processor.NewClassifier(classifierConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create classifier: %w", err)
+	}
+	chain := processor.NewChain(
+		processor.NamedStage{Name: "classify", Stage: classifier},
+		processor.NamedStage{Name: "default", Stage: c.defaultStage},
+	)
+	return &configProcessor{mp: chain, close: func() {}}, nil
+}
+
+// piiProcessorName 脱敏处理器注册的名称
+const piiProcessorName = "pii"
+
+// buildPII 按配置创建脱敏处理器，没有配置时整体替换为类型占位符
+// PIIConfig不从JSON读取哈希密钥，单独从hash_key读取
+func buildPII(name string, raw json.RawMessage) (*configProcessor, error) {
+	var piiConfig struct {
+		processor.PIIConfig
+		HashKey string `json:"hash_key"`
+	}
+	piiConfig.Style = processor.MaskFull
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &piiConfig); err != nil {
+			return nil, fmt.Errorf("invalid configuration for pii: %w", err)
+		}
+	}
+	piiConfig.PIIConfig.HashKey = piiConfig.HashKey
+	redactor, err := processor.NewPIIRedactor(piiConfig.PIIConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PII redactor: %w", err)
+	}
+	return &configProcessor{mp: redactor, close: func() {}}, nil
+}
+
+// buildForwarder 按配置创建转发处理器
+func buildForwarder(name string, raw json.RawMessage) (*configProcessor, error) {
+	var forwarderConfig processor.ForwarderConfig
+	if err := json.Unmarshal(raw, &forwarderConfig); err != nil {
+		return nil, fmt.Errorf("invalid configuration for forwarder %s: %w", name, err)
+	}
+	forwarder, err := processor.NewForwarder(name, forwarderConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create forwarder %s: %w", name, err)
+	}
+	return &configProcessor{mp: forwarder, close: forwarder.Close}, nil
+}
+
+// buildPlugin 按配置创建并启动插件
+func buildPlugin(name string, raw json.RawMessage) (*configProcessor, error) {
+	var pluginConfig plugins.Config
+	if err := json.Unmarshal(raw, &pluginConfig); err != nil {
+		return nil, fmt.Errorf("invalid configuration for plugin %s: %w", name, err)
+	}
+	host, err := plugins.NewHost(name, pluginConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create plugin %s: %w", name, err)
+	}
+	// 启动失败时Start已经终止了启动的进程
+	if err := host.Start(context.Background()); err != nil {
+		return nil, err
+	}
+	return &configProcessor{mp: host, close: host.Stop}, nil
+}
+
+// compactJSON 去掉JSON中的空白，用于比较定义是否变化
+func compactJSON(raw json.RawMessage) []byte {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return raw
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/example/message_processor/api"
+	"github.com/example/message_processor/middleware"
+	"github.com/example/message_processor/models"
+)
+
+// processorConfig 只包含配置处理器和灰度发布定义的配置
+func processorConfig(t *testing.T, data string) models.Config {
+	t.Helper()
+	var config models.Config
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// rolloutNames 返回进行中的灰度发布的主版本名称
+func rolloutNames(h *api.Handler) string {
+	var names []string
+	for _, stats := range h.RolloutStats() {
+		names = append(names, stats.Processor+"->"+stats.Config.Candidate)
+	}
+	return strings.Join(names, " ")
+}
+
+// registered 返回处理器当前注册的实例
+func registered(t *testing.T, h *api.Handler, name string) api.MessageProcessor {
+	t.Helper()
+	mp, err := h.Processor(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mp
+}
+
+func TestConfigProcessorsApply(t *testing.T) {
+	h := api.NewHandler(&api.DefaultMessageProcessor{})
+	c := newConfigProcessors(h, &api.DefaultMessageProcessor{})
+
+	initial := `{"forwarders":{"fwd":{"url":"http://127.0.0.1:1"}},"pii":{"style":"full"},
+		"rollouts":{"pii":{"candidate":"fwd"}}}`
+	if err := c.apply(processorConfig(t, initial)); err != nil {
+		t.Fatal(err)
+	}
+	names := h.ProcessorNames()
+	sort.Strings(names)
+	if got := strings.Join(names, " "); got != "classify default fwd pii" {
+		t.Fatalf("processors %q", got)
+	}
+	if got := rolloutNames(h); got != "pii->fwd" {
+		t.Fatalf("rollouts %q", got)
+	}
+	fwd, pii := registered(t, h, "fwd"), registered(t, h, "pii")
+	started := h.RolloutStats()[0].Started
+
+	// 没有变化的定义不重建，灰度发布不重新开始；只重建修改了的处理器
+	reformatted := `{"forwarders":{"fwd":{ "url" : "http://127.0.0.1:1" }},"pii":{"style":"partial"},
+		"rollouts":{"pii":{"candidate": "fwd"}}}`
+	if err := c.apply(processorConfig(t, reformatted)); err != nil {
+		t.Fatal(err)
+	}
+	if registered(t, h, "fwd") != fwd || registered(t, h, "pii") == pii {
+		t.Error("only the changed pii processor should be rebuilt")
+	}
+	if stats := h.RolloutStats(); len(stats) != 1 || !stats[0].Started.Equal(started) {
+		t.Errorf("unchanged rollout restarted: %+v", stats)
+	}
+
+	// 移除的处理器和灰度发布一并结束
+	if err := c.apply(processorConfig(t, `{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Processor("fwd"); err == nil {
+		t.Error("removed forwarder still registered")
+	}
+	if got := rolloutNames(h); got != "" {
+		t.Errorf("removed rollout still running: %q", got)
+	}
+	if _, ok := c.rollouts["pii"]; ok {
+		t.Error("removed rollout still recorded")
+	}
+}
+
+func TestConfigProcessorsApplyFailureChangesNothing(t *testing.T) {
+	initial := `{"forwarders":{"fwd":{"url":"http://127.0.0.1:1"}},"rollouts":{"pii":{"candidate":"fwd"}}}`
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"unknown candidate", `{"forwarders":{"fwd":{"url":"http://127.0.0.1:1"},"extra":{"url":"http://127.0.0.1:2"}},
+			"pii":{"style":"partial"},"rollouts":{"pii":{"candidate":"missing"}}}`, "unknown candidate processor: missing"},
+		{"candidate of unchanged rollout removed", `{"pii":{"style":"partial"},"rollouts":{"pii":{"candidate":"fwd"}}}`,
+			"processor fwd is removed"},
+		{"invalid rollout", `{"pii":{"style":"partial"},"rollouts":{"pii":{"candidate":"fwd","mode":"blue-green"}}}`,
+			"unknown rollout mode"},
+		{"invalid processor", `{"forwarders":{"fwd":{"url":"http://127.0.0.1:1"}},"pii":{"style":"sparkles"}}`, "PII redactor"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := api.NewHandler(&api.DefaultMessageProcessor{})
+			c := newConfigProcessors(h, &api.DefaultMessageProcessor{})
+			if err := c.apply(processorConfig(t, initial)); err != nil {
+				t.Fatal(err)
+			}
+			fwd, pii := registered(t, h, "fwd"), registered(t, h, "pii")
+			processors := len(c.processors)
+
+			err := c.apply(processorConfig(t, tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("apply: %v, want %q", err, tt.err)
+			}
+			if registered(t, h, "fwd") != fwd || registered(t, h, "pii") != pii || len(c.processors) != processors {
+				t.Error("processors replaced by a failed apply")
+			}
+			if _, err := h.Processor("extra"); err == nil {
+				t.Error("processor registered by a failed apply")
+			}
+			if got := rolloutNames(h); got != "pii->fwd" {
+				t.Errorf("rollouts after a failed apply: %q", got)
+			}
+		})
+	}
+}
+
+func TestReloadKeepsConfigurationOnFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"auth":{"jwt_secret":"0123456789abcdef0123456789abcdef"},"validation":{"max_message_length":100},"forwarders":{"fwd":{"url":"http://127.0.0.1:1"}}}`)
+	current, err := loadConfig(path, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validator := &api.DefaultMessageProcessor{}
+	h := api.NewHandler(validator)
+	r := &reloader{
+		configFile: path,
+		explicit:   true,
+		processors: newConfigProcessors(h, validator),
+		shedder:    middleware.NewLoadShedder(shedderConfig(current.Limits), nil),
+		cors:       middleware.NewCORSPolicy(current.CORS.AllowedOrigins),
+		validator:  validator,
+		current:    current,
+	}
+	if err := r.processors.apply(current); err != nil {
+		t.Fatal(err)
+	}
+	r.apply(current)
+
+	// 灰度发布无效时，同一次加载中的其他修改也不生效
+	write(`{"auth":{"jwt_secret":"0123456789abcdef0123456789abcdef"},"validation":{"max_message_length":50},"rollouts":{"fwd":{"candidate":"missing"}}}`)
+	r.reload("test")
+	if validator.MaxLength() != 100 || r.current.Validation.MaxMessageLength != 100 || len(r.current.Rollouts) != 0 {
+		t.Errorf("failed reload applied: max length %d, current %+v", validator.MaxLength(), r.current.Validation)
+	}
+	if _, err := h.Processor("fwd"); err != nil {
+		t.Errorf("forwarder removed by a failed reload: %v", err)
+	}
+
+	write(`{"auth":{"jwt_secret":"0123456789abcdef0123456789abcdef"},"validation":{"max_message_length":50}}`)
+	r.reload("test")
+	if validator.MaxLength() != 50 || r.current.Validation.MaxMessageLength != 50 || len(r.current.Forwarders) != 0 {
+		t.Errorf("reload not applied: max length %d, current %+v", validator.MaxLength(), r.current.Validation)
+	}
+	if _, err := h.Processor("fwd"); err == nil {
+		t.Error("forwarder kept after reload removed it")
+	}
+}
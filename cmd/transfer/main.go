@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/example/message_processor/models"
+	"github.com/example/message_processor/storage"
+)
+
+// 消息和用户的JSONL导入导出命令
+//
+//	transfer [数据库参数] export messages|users [-out 文件] [过滤参数]
+//	transfer [数据库参数] import messages|users [-in 文件] [-conflict skip|overwrite|fail]
+//
+// 未指定文件时使用标准输入输出，格式与/api/v1/admin/export接口相同
+
+func main() {
+	log.SetFlags(0)
+
+	defaults := models.DefaultConfig().Database
+	configFile := flag.String("config", "", "Path to the server configuration file to read database settings from")
+	flag.String("host", defaults.Host, "Database host")
+	flag.Int("port", defaults.Port, "Database port")
+	flag.String("user", defaults.User, "Database user")
+	flag.String("password", "", "Database password (defaults to $PGPASSWORD)")
+	flag.String("dbname", defaults.DBName, "Database name")
+	flag.String("sslmode", defaults.SSLMode, "Database SSL mode")
+	flag.Usage = usage
+	flag.Parse()
+
+	dbConfig, err := databaseConfig(*configFile)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	command, kind := args[0], args[1]
+	if kind != "messages" && kind != "users" {
+		log.Fatalf("Unknown record type %q: must be messages or users", kind)
+	}
+
+	// 中断时取消导入导出，导入的事务会回滚
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	db := storage.NewPostgresDB(storage.NewDBConfig(dbConfig))
+	if err := db.Connect(ctx); err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Disconnect(context.Background())
+
+	switch command {
+	case "export":
+		err = runExport(ctx, db, kind, args[2:])
+	case "import":
+		err = runImport(ctx, db, kind, args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// databaseConfig 返回数据库配置
+// 与服务相同，优先级从低到高依次为：默认值、配置文件、MP_开头的环境变量、命令行参数；
+// 没有密码时使用$PGPASSWORD，在解析参数之后读取，避免出现在-help输出的默认值中
+func databaseConfig(configFile string) (models.DatabaseConfig, error) {
+	appConfig := models.DefaultConfig()
+	if configFile != "" {
+		var err error
+		if appConfig, err = models.LoadConfigFromFile(configFile); err != nil {
+			return models.DatabaseConfig{}, err
+		}
+	}
+	if err := appConfig.ApplyEnv(os.LookupEnv); err != nil {
+		return models.DatabaseConfig{}, err
+	}
+	config := appConfig.Database
+
+	flag.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch f.Name {
+		case "host":
+			config.Host = value
+		case "port":
+			config.Port = f.Value.(flag.Getter).Get().(int)
+		case "user":
+			config.User = value
+		case "password":
+			config.Password = value
+		case "dbname":
+			config.DBName = value
+		case "sslmode":
+			config.SSLMode = value
+		}
+	})
+	if config.Password == "" {
+		config.Password = os.Getenv("PGPASSWORD")
+	}
+	return config, nil
+}
+
+// runExport 执行导出命令
+func runExport(ctx context.Context, db *storage.PostgresDB, kind string, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	out := fs.String("out", "", "Output file (defaults to stdout)")
+	from := fs.String("from", "", "Only messages created at or after this time (RFC3339)")
+	to := fs.String("to", "", "Only messages created before this time (RFC3339)")
+	var q storage.MessageQuery
+	fs.StringVar(&q.Processor, "processor", "", "Only messages handled by this processor")
+	status := fs.String("status", "", "Only messages with this status")
+	fs.StringVar(&q.User, "submitter", "", "Only messages submitted by this user")
+	fs.IntVar(&q.Limit, "limit", 0, "Maximum number of messages (0 for all)")
+	fs.Parse(args)
+
+	q.Status = models.MessageStatus(*status)
+	var err error
+	if q.From, err = parseTimeFlag("from", *from); err != nil {
+		return err
+	}
+	if q.To, err = parseTimeFlag("to", *to); err != nil {
+		return err
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		w = file
+	}
+	buf := bufio.NewWriter(w)
+
+	var count int
+	if kind == "messages" {
+		count, err = db.ExportMessages(ctx, buf, q)
+	} else {
+		count, err = db.ExportUsers(ctx, buf)
+	}
+	if err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	log.Printf("Exported %d %s", count, kind)
+	return nil
+}
+
+// runImport 执行导入命令
+func runImport(ctx context.Context, db *storage.PostgresDB, kind string, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	in := fs.String("in", "", "Input file (defaults to stdin)")
+	conflict := fs.String("conflict", string(storage.ConflictSkip), "What to do with existing records: skip, overwrite or fail")
+	fs.Parse(args)
+
+	policy, err := storage.ParseConflictPolicy(*conflict)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if *in != "" {
+		file, err := os.Open(*in)
+		if err != nil {
+			return fmt.Errorf("failed to open input file: %w", err)
+		}
+		defer file.Close()
+		r = file
+	}
+	r = bufio.NewReader(r)
+
+	var stats storage.ImportStats
+	if kind == "messages" {
+		stats, err = db.ImportMessages(ctx, r, policy)
+	} else {
+		stats, err = db.ImportUsers(ctx, r, policy)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Imported %s: %d inserted, %d overwritten, %d skipped",
+		kind, stats.Inserted, stats.Overwritten, stats.Skipped)
+	return nil
+}
+
+// parseTimeFlag 解析RFC3339格式的可选时间参数
+func parseTimeFlag(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -%s: must be RFC3339", name)
+	}
+	return t, nil
+}
+
+// usage 输出命令用法
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  transfer [database flags] export messages|users [-out file] [filters]
+  transfer [database flags] import messages|users [-in file] [-conflict skip|overwrite|fail]
+
+Database flags:
+`)
+	flag.PrintDefaults()
+}
@@ -0,0 +1,106 @@
+package format
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CSV 以逗号分隔的CSV编解码器
+var CSV Codec = NewCSVCodec(',')
+
+// CSVCodec CSV编解码器
+// 第一行为表头，之后每一行解码为一条记录，字段值均为字符串。
+// 编码时表头为所有记录字段名的并集，嵌套结构编码为JSON
+type CSVCodec struct {
+	comma rune
+}
+
+// NewCSVCodec 创建使用指定分隔符的CSV编解码器
+func NewCSVCodec(comma rune) *CSVCodec {
+	return &CSVCodec{comma: comma}
+}
+
+// Name 格式名称
+func (c *CSVCodec) Name() string { return "csv" }
+
+// ContentType MIME类型
+func (c *CSVCodec) ContentType() string { return "text/csv" }
+
+// Decode 解码CSV，空行会被忽略
+func (c *CSVCodec) Decode(data []byte) ([]Record, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.Comma = c.comma
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("CSV is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+	seen := make(map[string]bool, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("CSV column %d has no name", i+1)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate CSV column: %s", name)
+		}
+		seen[name] = true
+		header[i] = name
+	}
+
+	var records []Record
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		record := make(Record, len(header))
+		for i, name := range header {
+			record[name] = row[i]
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Encode 编码为带表头的CSV
+func (c *CSVCodec) Encode(records []Record) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Comma = c.comma
+
+	keys := sortedKeys(records)
+	if err := w.Write(keys); err != nil {
+		return nil, err
+	}
+	row := make([]string, len(keys))
+	for _, record := range records {
+		for i, k := range keys {
+			s, err := scalarString(record[k])
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", k, err)
+			}
+			row[i] = s
+		}
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,73 @@
+package format
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+// Form application/x-www-form-urlencoded编解码器
+// 整个表单解码为一条记录，出现多次的字段解码为字符串数组
+var Form Codec = formCodec{}
+
+type formCodec struct{}
+
+// Name 格式名称
+func (formCodec) Name() string { return "form" }
+
+// ContentType MIME类型
+func (formCodec) ContentType() string { return "application/x-www-form-urlencoded" }
+
+// Decode 解码表单
+func (formCodec) Decode(data []byte) ([]Record, error) {
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid form data: %w", err)
+	}
+
+	record := make(Record, len(values))
+	for k, v := range values {
+		if len(v) == 1 {
+			record[k] = v[0]
+			continue
+		}
+		list := make([]interface{}, len(v))
+		for i, s := range v {
+			list[i] = s
+		}
+		record[k] = list
+	}
+	return []Record{record}, nil
+}
+
+// Encode 编码为表单，只能编码单条记录
+// 数组字段编码为重复的键，其他嵌套结构编码为JSON
+func (formCodec) Encode(records []Record) ([]byte, error) {
+	if len(records) > 1 {
+		return nil, fmt.Errorf("form encoding supports a single record, got %d", len(records))
+	}
+
+	values := url.Values{}
+	if len(records) == 1 {
+		keys := make([]string, 0, len(records[0]))
+		for k := range records[0] {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			items, ok := records[0][k].([]interface{})
+			if !ok {
+				items = []interface{}{records[0][k]}
+			}
+			for _, item := range items {
+				s, err := scalarString(item)
+				if err != nil {
+					return nil, fmt.Errorf("field %s: %w", k, err)
+				}
+				values.Add(k, s)
+			}
+		}
+	}
+	return []byte(values.Encode()), nil
+}
@@ -0,0 +1,161 @@
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"sort"
+	"strings"
+)
+
+// 消息格式适配
+// 不同格式的请求体（JSON、CSV、XML、表单）统一解码为记录列表，
+// 处理器以JSON数组的形式接收记录；处理结果可以再编码为任意一种格式
+
+// Record 一条结构化记录
+// 字段值为string、float64、bool、nil、[]interface{}或map[string]interface{}
+type Record map[string]interface{}
+
+// Codec 格式编解码器
+type Codec interface {
+	// Name 格式名称，用于input_format和output_format参数
+	Name() string
+	// ContentType 编码结果的MIME类型
+	ContentType() string
+	// Decode 将请求体解码为记录列表
+	Decode(data []byte) ([]Record, error)
+	// Encode 将记录列表编码为该格式
+	Encode(records []Record) ([]byte, error)
+}
+
+// codecs 已注册的编解码器
+var codecs = map[string]Codec{}
+
+// contentTypes MIME类型到编解码器的映射
+var contentTypes = map[string]Codec{}
+
+func init() {
+	Register(JSON, "application/json", "text/json")
+	Register(CSV, "text/csv", "application/csv")
+	Register(XML, "application/xml", "text/xml")
+	Register(Form, "application/x-www-form-urlencoded")
+}
+
+// Register 注册编解码器及其对应的MIME类型，同名编解码器会被替换
+// 只应在初始化阶段调用
+func Register(c Codec, mediaTypes ...string) {
+	codecs[c.Name()] = c
+	for _, mt := range mediaTypes {
+		contentTypes[mt] = c
+	}
+}
+
+// Lookup 根据名称查找编解码器
+func Lookup(name string) (Codec, error) {
+	c, ok := codecs[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown format: %s", name)
+	}
+	return c, nil
+}
+
+// ForContentType 根据Content-Type请求头查找编解码器
+func ForContentType(contentType string) (Codec, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	c, ok := contentTypes[mediaType]
+	return c, ok
+}
+
+// Names 返回所有已注册格式的名称
+func Names() []string {
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Convert 将一种格式的数据转换为另一种格式
+func Convert(data []byte, from, to Codec) ([]byte, error) {
+	records, err := from.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return to.Encode(records)
+}
+
+// JSON JSON编解码器，接受单个对象或对象数组，编码结果始终为数组
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+// Name 格式名称
+func (jsonCodec) Name() string { return "json" }
+
+// ContentType MIME类型
+func (jsonCodec) ContentType() string { return "application/json" }
+
+// Decode 解码JSON对象或对象数组
+func (jsonCodec) Decode(data []byte) ([]Record, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var record Record
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return []Record{record}, nil
+	}
+
+	var records []Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("invalid JSON: expected an object or an array of objects: %w", err)
+	}
+	return records, nil
+}
+
+// Encode 编码为JSON数组
+func (jsonCodec) Encode(records []Record) ([]byte, error) {
+	if records == nil {
+		records = []Record{}
+	}
+	return json.Marshal(records)
+}
+
+// scalarString 将字段值转换为字符串，嵌套结构编码为JSON
+func scalarString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool, float64, json.Number:
+		return fmt.Sprint(v), nil
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+}
+
+// sortedKeys 返回所有记录字段名的并集，按字母排序
+func sortedKeys(records []Record) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, r := range records {
+		for k := range r {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
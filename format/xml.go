@@ -0,0 +1,250 @@
+package format
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// XML XML编解码器
+// 元素解码为以子元素名为键的对象，重复的子元素解码为数组，属性以"@"为前缀，
+// 同时有文本和子元素或属性时文本存放在"#text"中，只有文本的元素解码为字符串。
+// 根元素为<records>时每个<record>子元素解码为一条记录，否则整个文档为一条记录
+var XML Codec = xmlCodec{}
+
+const (
+	xmlRecordsElement = "records"
+	xmlRecordElement  = "record"
+	xmlTextKey        = "#text"
+	xmlAttrPrefix     = "@"
+)
+
+type xmlCodec struct{}
+
+// xmlNode 解码过程中的元素
+type xmlNode struct {
+	name     string
+	attrs    []xml.Attr
+	children []*xmlNode
+	text     strings.Builder
+}
+
+// Name 格式名称
+func (xmlCodec) Name() string { return "xml" }
+
+// ContentType MIME类型
+func (xmlCodec) ContentType() string { return "application/xml" }
+
+// Decode 解码XML文档
+func (xmlCodec) Decode(data []byte) ([]Record, error) {
+	// encoding/xml不处理DTD和外部实体，DOCTYPE等指令直接忽略
+	d := xml.NewDecoder(bytes.NewReader(data))
+
+	var root *xmlNode
+	var stack []*xmlNode
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid XML: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			node := &xmlNode{name: t.Name.Local, attrs: t.Attr}
+			if len(stack) == 0 {
+				if root != nil {
+					return nil, fmt.Errorf("invalid XML: multiple root elements")
+				}
+				root = node
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("XML document is empty")
+	}
+
+	if root.name == xmlRecordsElement {
+		var records []Record
+		for _, child := range root.children {
+			if child.name != xmlRecordElement {
+				return nil, fmt.Errorf("unexpected element <%s> in <%s>", child.name, xmlRecordsElement)
+			}
+			records = append(records, toRecord(child.value()))
+		}
+		return records, nil
+	}
+	return []Record{toRecord(root.value())}, nil
+}
+
+// value 将元素转换为字符串或对象
+func (n *xmlNode) value() interface{} {
+	text := strings.TrimSpace(n.text.String())
+	if len(n.attrs) == 0 && len(n.children) == 0 {
+		return text
+	}
+
+	obj := make(map[string]interface{})
+	for _, a := range n.attrs {
+		obj[xmlAttrPrefix+a.Name.Local] = a.Value
+	}
+	for _, child := range n.children {
+		v := child.value()
+		switch existing := obj[child.name].(type) {
+		case nil:
+			obj[child.name] = v
+		case []interface{}:
+			obj[child.name] = append(existing, v)
+		default:
+			obj[child.name] = []interface{}{existing, v}
+		}
+	}
+	if text != "" {
+		obj[xmlTextKey] = text
+	}
+	return obj
+}
+
+// toRecord 将元素的值转换为记录
+func toRecord(v interface{}) Record {
+	if obj, ok := v.(map[string]interface{}); ok {
+		return Record(obj)
+	}
+	return Record{xmlTextKey: v}
+}
+
+// Encode 编码为<records><record>...</record></records>
+func (xmlCodec) Encode(records []Record) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	e := xml.NewEncoder(&buf)
+
+	start := xml.StartElement{Name: xml.Name{Local: xmlRecordsElement}}
+	if err := e.EncodeToken(start); err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if err := encodeXMLValue(e, xmlRecordElement, map[string]interface{}(record)); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.EncodeToken(start.End()); err != nil {
+		return nil, err
+	}
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeXMLValue 将值编码为名为name的元素，数组编码为重复的元素
+func encodeXMLValue(e *xml.Encoder, name string, v interface{}) error {
+	if list, ok := v.([]interface{}); ok {
+		for _, item := range list {
+			if err := encodeXMLValue(e, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	start := xml.StartElement{Name: xml.Name{Local: xmlName(name)}}
+	obj, isObj := v.(map[string]interface{})
+	if !isObj {
+		s, err := scalarString(v)
+		if err != nil {
+			return err
+		}
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		if err := e.EncodeToken(xml.CharData(s)); err != nil {
+			return err
+		}
+		return e.EncodeToken(start.End())
+	}
+
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var text string
+	var children []string
+	for _, k := range keys {
+		switch {
+		case k == xmlTextKey:
+			s, err := scalarString(obj[k])
+			if err != nil {
+				return err
+			}
+			text = s
+		case strings.HasPrefix(k, xmlAttrPrefix):
+			s, err := scalarString(obj[k])
+			if err != nil {
+				return err
+			}
+			start.Attr = append(start.Attr, xml.Attr{
+				Name:  xml.Name{Local: xmlName(strings.TrimPrefix(k, xmlAttrPrefix))},
+				Value: s,
+			})
+		default:
+			children = append(children, k)
+		}
+	}
+
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	if text != "" {
+		if err := e.EncodeToken(xml.CharData(text)); err != nil {
+			return err
+		}
+	}
+	for _, k := range children {
+		if err := encodeXMLValue(e, k, obj[k]); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// xmlName 将字段名转换为合法的XML元素名，非法字符替换为下划线
+func xmlName(s string) string {
+	if s == "" {
+		return "_"
+	}
+	var b strings.Builder
+	for i, r := range s {
+		valid := unicode.IsLetter(r) || r == '_' ||
+			(i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'))
+		if !valid {
+			if i == 0 && (unicode.IsDigit(r) || r == '-' || r == '.') {
+				b.WriteRune('_')
+				b.WriteRune(r)
+				continue
+			}
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -1,3 +1,11 @@
 module github.com/example/message_processor
 
-go 1.21
\ No newline at end of file
+go 1.21.0
+
+require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/goccy/go-yaml v1.19.2
+	github.com/golang-jwt/jwt/v4 v4.5.2
+	github.com/lib/pq v1.12.3
+	golang.org/x/net v0.33.0
+)
@@ -6,7 +6,6 @@ import (
 	"log"
 
 	"github.com/example/message_processor/models"
-	"github.com/example/message_processor/utils"
 )
 
 // 主程序入口
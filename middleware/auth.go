@@ -72,9 +72,10 @@ func (m *AuthMiddleware) JWTAuth(next http.Handler) http.Handler {
 
 		// 将用户信息存储到请求上下文
 		// 注意：这里没有使用JSON，而是直接操作请求上下文
-		ctx := r.Context()
-		// ctx = context.WithValue(ctx, "user_id", claims.UserID)
-		// ctx = context.WithValue(ctx, "username", claims.Username)
+		ctx := WithIdentity(r.Context(), Identity{
+			UserID:   claims.UserID,
+			Username: claims.Username,
+		})
 
 		// 继续处理请求
 		next.ServeHTTP(w, r.WithContext(ctx))
@@ -105,7 +106,8 @@ func (m *AuthMiddleware) APIKeyAuth(next http.Handler) http.Handler {
 		// 比如从数据库查询密钥是否有效
 		
 		// 继续处理请求
-		next.ServeHTTP(w, r)
+		ctx := WithIdentity(r.Context(), Identity{APIKey: apiKey})
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
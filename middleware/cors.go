@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// CORSPolicy 按允许的来源列表处理跨域请求
+// 来源在列表中时回显该来源，列表包含"*"时允许任意来源；不允许的来源不设置CORS头，
+// 浏览器会拒绝读取响应。预检请求（OPTIONS）直接返回，不进入后续处理。
+// 来源列表可以在运行中通过SetAllowedOrigins替换
+
+// CORSPolicy 跨域中间件结构体
+type CORSPolicy struct {
+	origins atomic.Value // map[string]bool
+}
+
+// NewCORSPolicy 创建新的跨域中间件，origins为空时不允许跨域
+func NewCORSPolicy(origins []string) *CORSPolicy {
+	p := &CORSPolicy{}
+	p.SetAllowedOrigins(origins)
+	return p
+}
+
+// SetAllowedOrigins 替换允许的来源，对之后的请求生效
+func (p *CORSPolicy) SetAllowedOrigins(origins []string) {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+	p.origins.Store(allowed)
+}
+
+// Handler 跨域中间件
+func (p *CORSPolicy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		allowed := p.origins.Load().(map[string]bool)
+		if !allowed[origin] && !allowed["*"] {
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+		// 处理预检请求
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+// Identity 请求方身份，由认证中间件写入请求上下文
+
+// identityKey 上下文中存放身份的键
+type identityKey struct{}
+
+// Identity 请求方身份
+type Identity struct {
+	UserID   int
+	Username string
+	// APIKey 使用API密钥认证时的密钥，不应写入日志或存储
+	APIKey string
+}
+
+// Subject 返回用于记录和分组的身份标识
+// JWT用户返回用户名（没有用户名时返回user:ID），API密钥返回密钥摘要key:xxxxxxxx
+func (i Identity) Subject() string {
+	switch {
+	case i.Username != "":
+		return i.Username
+	case i.UserID != 0:
+		return "user:" + strconv.Itoa(i.UserID)
+	case i.APIKey != "":
+		sum := sha256.Sum256([]byte(i.APIKey))
+		return "key:" + hex.EncodeToString(sum[:4])
+	default:
+		return ""
+	}
+}
+
+// WithIdentity 返回带有身份的上下文
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext 从上下文中取出身份
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoadShedder 过载保护中间件
+// 在请求进入处理逻辑之前检查正在处理的请求数和队列深度，超过水位线时立即拒绝：
+// 并发请求过多返回429，队列积压过多返回503，两者都带有Retry-After。
+// 队列积压使用高低两条水位线，超过高水位开始拒绝，降到低水位以下才恢复，避免频繁抖动。
+// 健康检查和管理接口不受限制。配置可以在运行中通过SetConfig替换
+
+// 拒绝原因
+const (
+	ShedReasonInFlight = "in_flight"
+	ShedReasonQueue    = "queue_depth"
+)
+
+// LoadShedderConfig 过载保护配置
+type LoadShedderConfig struct {
+	// MaxInFlight 同时处理的请求数上限，超过时返回429，0表示不限制
+	MaxInFlight int `json:"max_in_flight"`
+	// QueueHighWatermark 队列深度达到该值时开始返回503，0表示不检查队列
+	QueueHighWatermark int `json:"queue_high_watermark"`
+	// QueueLowWatermark 开始拒绝后，队列深度降到该值以下才恢复接收
+	QueueLowWatermark int `json:"queue_low_watermark"`
+	// RetryAfter 建议客户端的重试等待时间
+	RetryAfter time.Duration `json:"retry_after"`
+	// BypassPrefixes 不受限制的路径前缀
+	BypassPrefixes []string `json:"bypass_prefixes"`
+}
+
+// DefaultLoadShedderConfig 返回默认过载保护配置
+func DefaultLoadShedderConfig() LoadShedderConfig {
+	return LoadShedderConfig{
+		MaxInFlight:        512,
+		QueueHighWatermark: 2000,
+		QueueLowWatermark:  1000,
+		RetryAfter:         2 * time.Second,
+		BypassPrefixes:     []string{"/health", "/api/v1/admin/"},
+	}
+}
+
+// LoadStats 过载保护统计信息
+type LoadStats struct {
+	InFlight   int64            `json:"in_flight"`
+	QueueDepth int              `json:"queue_depth"`
+	Shedding   bool             `json:"shedding"`
+	Accepted   int64            `json:"accepted"`
+	Bypassed   int64            `json:"bypassed"`
+	Shed       map[string]int64 `json:"shed"`
+}
+
+// LoadShedder 过载保护结构体
+type LoadShedder struct {
+	config     atomic.Value // LoadShedderConfig
+	queueDepth func() int
+
+	inFlight int64
+	accepted int64
+	bypassed int64
+
+	mu       sync.Mutex
+	shedding bool
+	shed     map[string]int64
+}
+
+// NewLoadShedder 创建新的过载保护中间件
+// queueDepth返回当前排队的消息数量，为nil时不检查队列
+func NewLoadShedder(config LoadShedderConfig, queueDepth func() int) *LoadShedder {
+	s := &LoadShedder{
+		queueDepth: queueDepth,
+		shed:       make(map[string]int64),
+	}
+	s.SetConfig(config)
+	return s
+}
+
+// SetConfig 替换过载保护配置，对之后进入的请求生效
+// 正在处理的请求数和统计不受影响
+func (s *LoadShedder) SetConfig(config LoadShedderConfig) {
+	if config.QueueLowWatermark <= 0 || config.QueueLowWatermark > config.QueueHighWatermark {
+		config.QueueLowWatermark = config.QueueHighWatermark
+	}
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = time.Second
+	}
+	s.config.Store(config)
+}
+
+// Config 返回当前的过载保护配置
+func (s *LoadShedder) Config() LoadShedderConfig {
+	return s.config.Load().(LoadShedderConfig)
+}
+
+// Shed 过载保护中间件
+func (s *LoadShedder) Shed(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config := s.Config()
+		if bypass(config, r.URL.Path) {
+			atomic.AddInt64(&s.bypassed, 1)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if s.queueOverloaded(config) {
+			s.reject(w, config, ShedReasonQueue, http.StatusServiceUnavailable, "Server is overloaded, try again later")
+			return
+		}
+
+		inFlight := atomic.AddInt64(&s.inFlight, 1)
+		defer atomic.AddInt64(&s.inFlight, -1)
+		if config.MaxInFlight > 0 && inFlight > int64(config.MaxInFlight) {
+			s.reject(w, config, ShedReasonInFlight, http.StatusTooManyRequests, "Too many concurrent requests, try again later")
+			return
+		}
+
+		atomic.AddInt64(&s.accepted, 1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Stats 返回统计信息
+func (s *LoadShedder) Stats() LoadStats {
+	stats := LoadStats{
+		InFlight: atomic.LoadInt64(&s.inFlight),
+		Accepted: atomic.LoadInt64(&s.accepted),
+		Bypassed: atomic.LoadInt64(&s.bypassed),
+		Shed:     make(map[string]int64),
+	}
+	if s.queueDepth != nil {
+		stats.QueueDepth = s.queueDepth()
+	}
+
+	s.mu.Lock()
+	stats.Shedding = s.shedding
+	for reason, n := range s.shed {
+		stats.Shed[reason] = n
+	}
+	s.mu.Unlock()
+	return stats
+}
+
+// StatsHandler 返回过载保护统计信息的接口
+func (s *LoadShedder) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(s.Stats())
+}
+
+// bypass 判断路径是否不受限制
+func bypass(config LoadShedderConfig, path string) bool {
+	for _, prefix := range config.BypassPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// queueOverloaded 按高低水位线判断队列是否积压
+func (s *LoadShedder) queueOverloaded(config LoadShedderConfig) bool {
+	if s.queueDepth == nil || config.QueueHighWatermark <= 0 {
+		return false
+	}
+	depth := s.queueDepth()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case depth >= config.QueueHighWatermark:
+		s.shedding = true
+	case depth < config.QueueLowWatermark:
+		s.shedding = false
+	}
+	return s.shedding
+}
+
+// reject 拒绝请求并计数
+func (s *LoadShedder) reject(w http.ResponseWriter, config LoadShedderConfig, reason string, status int, message string) {
+	s.mu.Lock()
+	s.shed[reason]++
+	s.mu.Unlock()
+
+	seconds := int(math.Ceil(config.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeJSONError(w, status, message)
+}
+
+// writeJSONError 写入JSON格式的错误响应
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
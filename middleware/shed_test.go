@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// shedRequest 经过过载保护发送请求
+func shedRequest(handler http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+	return w
+}
+
+func TestLoadShedderQueueHysteresis(t *testing.T) {
+	var depth int64
+	s := NewLoadShedder(LoadShedderConfig{QueueHighWatermark: 10, QueueLowWatermark: 5, RetryAfter: 1500 * time.Millisecond,
+		BypassPrefixes: []string{"/health"}},
+		func() int { return int(atomic.LoadInt64(&depth)) })
+	handler := s.Shed(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// 深度依次变化时的预期状态：超过高水位开始拒绝，降到低水位以下才恢复
+	steps := []struct {
+		depth int64
+		code  int
+	}{
+		{0, http.StatusOK},
+		{9, http.StatusOK},
+		{10, http.StatusServiceUnavailable},
+		{7, http.StatusServiceUnavailable},
+		{5, http.StatusServiceUnavailable},
+		{4, http.StatusOK},
+		{9, http.StatusOK},
+		{12, http.StatusServiceUnavailable},
+		{0, http.StatusOK},
+	}
+	shed := 0
+	for i, step := range steps {
+		atomic.StoreInt64(&depth, step.depth)
+		w := shedRequest(handler, "/api/v1/message")
+		if w.Code != step.code {
+			t.Fatalf("step %d (depth %d): status %d, want %d", i, step.depth, w.Code, step.code)
+		}
+		if w.Code == http.StatusServiceUnavailable {
+			shed++
+			if w.Header().Get("Retry-After") != "2" {
+				t.Errorf("Retry-After %q, want 2", w.Header().Get("Retry-After"))
+			}
+		}
+	}
+	if stats := s.Stats(); stats.Shed[ShedReasonQueue] != int64(shed) || stats.Accepted != int64(len(steps)-shed) || stats.Shedding {
+		t.Errorf("stats %+v", stats)
+	}
+
+	// 管理接口不受限制
+	atomic.StoreInt64(&depth, 100)
+	if w := shedRequest(handler, "/health"); w.Code != http.StatusOK {
+		t.Errorf("health check shed: %d", w.Code)
+	}
+}
+
+func TestLoadShedderConfigWatermarks(t *testing.T) {
+	tests := []struct {
+		name      string
+		high, low int
+		wantLow   int
+	}{
+		{"low kept", 10, 5, 5},
+		{"missing low uses high", 10, 0, 10},
+		{"low above high uses high", 10, 20, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewLoadShedder(LoadShedderConfig{QueueHighWatermark: tt.high, QueueLowWatermark: tt.low}, nil)
+			if config := s.Config(); config.QueueLowWatermark != tt.wantLow || config.RetryAfter != time.Second {
+				t.Errorf("config %+v", config)
+			}
+		})
+	}
+}
+
+func TestLoadShedderInFlight(t *testing.T) {
+	s := NewLoadShedder(LoadShedderConfig{MaxInFlight: 2}, nil)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := s.Shed(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			shedRequest(handler, "/api/v1/message")
+		}()
+		<-started
+	}
+	if w := shedRequest(handler, "/api/v1/message"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("third concurrent request: %d, want 429", w.Code)
+	}
+	close(release)
+	wg.Wait()
+
+	// 配置在运行中替换后立即生效
+	s.SetConfig(LoadShedderConfig{MaxInFlight: 0})
+	go func() { <-started }()
+	if w := shedRequest(handler, "/api/v1/message"); w.Code != http.StatusOK {
+		t.Errorf("request after raising the limit: %d", w.Code)
+	}
+	if stats := s.Stats(); stats.Shed[ShedReasonInFlight] != 1 || stats.InFlight != 0 {
+		t.Errorf("stats %+v", stats)
+	}
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
 	"time"
 )
@@ -16,6 +17,23 @@ type Config struct {
 	Database DatabaseConfig `json:"database"`
 	Logging  LoggingConfig  `json:"logging"`
 	App      AppConfig      `json:"app"`
+	Auth     AuthConfig     `json:"auth"`
+	// Aggregation rate-user和rate-source聚合器的窗口、水位线和迟到处理，由使用方解析为processor.AggregatorConfig
+	Aggregation json.RawMessage `json:"aggregation,omitempty"`
+	// 以下各节以及Logging.Level、Forwarders、Plugins、Rollouts、Classifier、PII可以在运行中重新加载，其余字段需要重启
+	Limits     LimitsConfig     `json:"limits"`
+	CORS       CORSConfig       `json:"cors"`
+	Validation ValidationConfig `json:"validation"`
+	// Forwarders 按处理器名称配置的上游转发，由使用方解析为processor.ForwarderConfig
+	Forwarders map[string]json.RawMessage `json:"forwarders,omitempty"`
+	// Plugins 按处理器名称配置的外部进程插件，由使用方解析为plugins.Config
+	Plugins map[string]json.RawMessage `json:"plugins,omitempty"`
+	// Rollouts 按处理器名称配置的灰度发布，由使用方解析为api.RolloutConfig
+	Rollouts map[string]json.RawMessage `json:"rollouts,omitempty"`
+	// Classifier classify处理器的关键词词典和阈值，由使用方解析为processor.ClassifierConfig
+	Classifier json.RawMessage `json:"classifier,omitempty"`
+	// PII pii处理器的遮盖方式和启用的类型，由使用方解析为processor.PIIConfig，hash_key为哈希遮盖的密钥
+	PII json.RawMessage `json:"pii,omitempty"`
 }
 
 // ServerConfig 服务器配置
@@ -31,15 +49,25 @@ type DatabaseConfig struct {
 	Host     string `json:"host"`
 	Port     int    `json:"port"`
 	User     string `json:"user"`
-	Password string `json:"-"` // 密码不序列化
+	Password string `json:"password,omitempty"` // 可以从文件读取，Config序列化时不输出
 	DBName   string `json:"dbname"`
+	SSLMode  string `json:"sslmode"`
+	// MaxOpenConns 连接池的最大连接数
+	MaxOpenConns int `json:"max_open_conns"`
+	// MaxIdleConns 连接池保留的最大空闲连接数
+	MaxIdleConns int `json:"max_idle_conns"`
+	// ConnMaxLifetime 连接的最长使用时间，超过后关闭重建
+	ConnMaxLifetime time.Duration `json:"conn_max_lifetime"`
 }
 
 // LoggingConfig 日志配置
 type LoggingConfig struct {
-	Level  string `json:"level"`
+	// Level 最低输出级别：debug、info、warn、error
+	Level string `json:"level"`
+	// Format 输出格式：text或json
 	Format string `json:"format"`
-	Path   string `json:"path"`
+	// Path 日志文件路径，为空时输出到标准错误
+	Path string `json:"path"`
 }
 
 // AppConfig 应用配置
@@ -49,89 +77,202 @@ type AppConfig struct {
 	Env     string `json:"env"`
 }
 
+// AuthConfig 认证配置
+type AuthConfig struct {
+	// JWTSecret JWT签名密钥，Config序列化时不输出
+	JWTSecret string `json:"jwt_secret,omitempty"`
+	// APIKeyPrefix API密钥必须带有的前缀
+	APIKeyPrefix string `json:"api_key_prefix"`
+}
+
+// LimitsConfig 过载保护的限制，对应middleware.LoadShedderConfig
+type LimitsConfig struct {
+	// MaxInFlight 同时处理的请求数上限，0表示不限制
+	MaxInFlight int `json:"max_in_flight"`
+	// QueueHighWatermark 队列深度达到该值时开始拒绝请求，0表示不检查队列
+	QueueHighWatermark int `json:"queue_high_watermark"`
+	// QueueLowWatermark 开始拒绝后，队列深度降到该值以下才恢复接收
+	QueueLowWatermark int `json:"queue_low_watermark"`
+}
+
+// CORSConfig 跨域配置
+type CORSConfig struct {
+	// AllowedOrigins 允许跨域访问的来源，如https://example.com，"*"表示任意来源，为空时不允许跨域
+	AllowedOrigins []string `json:"allowed_origins"`
+}
+
+// ValidationConfig 默认处理器的消息校验规则
+type ValidationConfig struct {
+	// MaxMessageLength 消息的最大长度（字节）
+	MaxMessageLength int `json:"max_message_length"`
+}
+
+// DefaultConfig 返回默认配置，配置文件中没有出现的字段保持默认值
+// JWT签名密钥没有默认值，必须在配置中提供
+func DefaultConfig() Config {
+	return Config{
+		Server: ServerConfig{
+			Host:         "0.0.0.0",
+			Port:         8080,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Host:            "localhost",
+			Port:            5432,
+			User:            "postgres",
+			DBName:          "message_processor",
+			SSLMode:         "disable",
+			MaxOpenConns:    25,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 5 * time.Minute,
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		App: AppConfig{
+			Name:    "message_processor",
+			Version: "1.0.0",
+			Env:     "development",
+		},
+		Auth: AuthConfig{
+			APIKeyPrefix: "API_",
+		},
+		Limits: LimitsConfig{
+			MaxInFlight:        512,
+			QueueHighWatermark: 2000,
+			QueueLowWatermark:  1000,
+		},
+		Validation: ValidationConfig{
+			MaxMessageLength: 1000,
+		},
+	}
+}
+
+// serverJSON ServerConfig的JSON形式，时长使用time.ParseDuration格式的字符串
+type serverJSON struct {
+	ServerConfig
+	ReadTimeout  string `json:"read_timeout"`
+	WriteTimeout string `json:"write_timeout"`
+}
+
+// databaseJSON DatabaseConfig的JSON形式，时长使用time.ParseDuration格式的字符串
+type databaseJSON struct {
+	DatabaseConfig
+	ConnMaxLifetime string `json:"conn_max_lifetime"`
+}
+
 // MarshalJSON 自定义JSON序列化方法
+// 时长输出为字符串，数据库密码和JWT签名密钥不输出
 func (c Config) MarshalJSON() ([]byte, error) {
 	// 创建一个匿名结构体用于JSON序列化
 	type Alias Config
-	return json.Marshal(&struct {
+	aux := struct {
 		Alias
-		// 自定义字段格式
-		Server struct {
-			ServerConfig
-			ReadTimeout  string `json:"read_timeout"`
-			WriteTimeout string `json:"write_timeout"`
-		} `json:"server"`
+		Server   serverJSON   `json:"server"`
+		Database databaseJSON `json:"database"`
 	}{
-		Alias: (Alias)(c),
-		Server: struct {
-			ServerConfig
-			ReadTimeout  string `json:"read_timeout"`
-			WriteTimeout string `json:"write_timeout"`
-		}{
-			ServerConfig: c.Server,
-			ReadTimeout:  c.Server.ReadTimeout.String(),
-			WriteTimeout: c.Server.WriteTimeout.String(),
-		},
-	})
+		Alias:    (Alias)(c),
+		Server:   serverJSON{ServerConfig: c.Server},
+		Database: databaseJSON{DatabaseConfig: c.Database},
+	}
+	aux.Server.ReadTimeout = c.Server.ReadTimeout.String()
+	aux.Server.WriteTimeout = c.Server.WriteTimeout.String()
+	aux.Database.Password = ""
+	aux.Database.ConnMaxLifetime = c.Database.ConnMaxLifetime.String()
+	aux.Auth.JWTSecret = ""
+	return json.Marshal(&aux)
 }
 
 // UnmarshalJSON 自定义JSON反序列化方法
+// 只覆盖JSON中出现的字段，其余字段保持原值，时长字段为空字符串时同样保持原值；
+// 无法解析的时长全部收集到ValidationErrors中一起返回
 func (c *Config) UnmarshalJSON(data []byte) error {
 	// 创建一个匿名结构体用于JSON反序列化
 	type Alias Config
 	aux := &struct {
 		*Alias
-		Server struct {
-			ServerConfig
-			ReadTimeout  string `json:"read_timeout"`
-			WriteTimeout string `json:"write_timeout"`
-		} `json:"server"`
+		Server   serverJSON   `json:"server"`
+		Database databaseJSON `json:"database"`
 	}{
-		Alias: (*Alias)(c),
-	}
-
-	if err := json.Unmarshal(data, &aux); err != nil {
-		return err
+		Alias:    (*Alias)(c),
+		Server:   serverJSON{ServerConfig: c.Server},
+		Database: databaseJSON{DatabaseConfig: c.Database},
 	}
 
-	// 解析时间字符串
-	readTimeout, err := time.ParseDuration(aux.Server.ReadTimeout)
-	if err != nil {
+	if err := json.Unmarshal(data, aux); err != nil {
 		return err
 	}
-	c.Server.ReadTimeout = readTimeout
 
-	writeTimeout, err := time.ParseDuration(aux.Server.WriteTimeout)
-	if err != nil {
-		return err
+	c.Server = aux.Server.ServerConfig
+	c.Database = aux.Database.DatabaseConfig
+	var errs ValidationErrors
+	for _, d := range []struct {
+		field string
+		value string
+		dest  *time.Duration
+	}{
+		{"server.read_timeout", aux.Server.ReadTimeout, &c.Server.ReadTimeout},
+		{"server.write_timeout", aux.Server.WriteTimeout, &c.Server.WriteTimeout},
+		{"database.conn_max_lifetime", aux.Database.ConnMaxLifetime, &c.Database.ConnMaxLifetime},
+	} {
+		if d.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil {
+			errs = append(errs, &FieldError{Path: d.field, Err: err})
+			continue
+		}
+		*d.dest = duration
 	}
-	c.Server.WriteTimeout = writeTimeout
 
-	return nil
+	return errs.Err()
 }
 
-// LoadFromFile 从文件加载配置
+// LoadConfigFromFile 从文件加载配置，文件中没有出现的字段使用DefaultConfig中的默认值
+// 按扩展名选择格式：.json、.yaml/.yml或.toml；解析错误为*ConfigError，带有出错的行号和列号，
+// 多个字段同时出错时为ValidationErrors，其中每个问题都带有位置
 func LoadConfigFromFile(filePath string) (Config, error) {
-	file, err := os.Open(filePath)
+	format, err := ConfigFormatFromPath(filePath)
 	if err != nil {
 		return Config{}, err
 	}
-	defer file.Close()
-
-	var config Config
-	if err := json.NewDecoder(file).Decode(&config); err != nil {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
 		return Config{}, err
 	}
 
+	config, err := ParseConfig(data, format)
+	if err != nil {
+		var errs ValidationErrors
+		var configErr *ConfigError
+		switch {
+		case errors.As(err, &errs):
+			for _, fieldErr := range errs {
+				if errors.As(fieldErr, &configErr) {
+					configErr.File = filePath
+				}
+			}
+		case errors.As(err, &configErr):
+			configErr.File = filePath
+		}
+		return Config{}, err
+	}
 	return config, nil
 }
 
-// SaveToFile 将配置保存到文件
+// SaveConfigToFile 将配置保存到文件，按扩展名选择格式
 func (c Config) SaveConfigToFile(filePath string) error {
-	jsonData, err := c.MarshalJSON()
+	format, err := ConfigFormatFromPath(filePath)
+	if err != nil {
+		return err
+	}
+	data, err := c.Encode(format)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(filePath, jsonData, 0644)
-}
\ No newline at end of file
+	return os.WriteFile(filePath, data, 0644)
+}
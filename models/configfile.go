@@ -0,0 +1,314 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/parser"
+)
+
+// 配置文件格式
+// YAML和TOML先转换为JSON，再经过Config.UnmarshalJSON解析，时长等字段的处理与JSON完全一致。
+// 转换后的JSON中字段类型不对等错误，按字段路径回到原文件中定位行号和列号
+
+// ConfigFormat 配置文件格式
+type ConfigFormat string
+
+const (
+	// ConfigJSON JSON格式，扩展名.json
+	ConfigJSON ConfigFormat = "json"
+	// ConfigYAML YAML格式，扩展名.yaml或.yml
+	ConfigYAML ConfigFormat = "yaml"
+	// ConfigTOML TOML格式，扩展名.toml
+	ConfigTOML ConfigFormat = "toml"
+)
+
+// ConfigFormatFromPath 根据文件扩展名判断配置格式
+func ConfigFormatFromPath(filePath string) (ConfigFormat, error) {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".json":
+		return ConfigJSON, nil
+	case ".yaml", ".yml":
+		return ConfigYAML, nil
+	case ".toml":
+		return ConfigTOML, nil
+	default:
+		return "", fmt.Errorf("unsupported config file extension %q: must be .json, .yaml, .yml or .toml", filepath.Ext(filePath))
+	}
+}
+
+// FieldError 配置字段的值无效
+type FieldError struct {
+	// Path 字段路径，由各级字段名用点连接，如server.read_timeout
+	Path string
+	Err  error
+}
+
+// Error 实现error接口
+func (e *FieldError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+// Unwrap 返回原始错误
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigError 配置文件解析错误
+type ConfigError struct {
+	File string
+	// Line和Column从1开始，列按字符计；无法定位时为0
+	Line   int
+	Column int
+	// Field 出错的字段路径，语法错误时为空
+	Field string
+	Err   error
+}
+
+// Error 实现error接口，格式为"文件:行:列: 字段: 错误"
+func (e *ConfigError) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		b.WriteString(e.File)
+		b.WriteString(":")
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&b, "%d:%d:", e.Line, e.Column)
+	}
+	if b.Len() > 0 {
+		b.WriteString(" ")
+	}
+	if e.Field != "" {
+		b.WriteString(e.Field)
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+// Unwrap 返回原始错误
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ParseConfig 按格式解析配置，没有出现的字段使用DefaultConfig中的默认值
+func ParseConfig(data []byte, format ConfigFormat) (Config, error) {
+	jsonData, err := configToJSON(data, format)
+	if err != nil {
+		return Config{}, err
+	}
+
+	config := DefaultConfig()
+	if err := json.Unmarshal(jsonData, &config); err != nil {
+		return Config{}, configFieldError(data, format, err)
+	}
+	return config, nil
+}
+
+// Encode 按格式序列化配置，数据库密码和JWT签名密钥不输出
+func (c Config) Encode(format ConfigFormat) ([]byte, error) {
+	jsonData, err := c.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case ConfigJSON:
+		return jsonData, nil
+	case ConfigYAML:
+		return yaml.JSONToYAML(jsonData)
+	case ConfigTOML:
+		// 保留数字的原始形式，避免整数被写成浮点数
+		var v map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(jsonData))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported config format: %s", format)
+	}
+}
+
+// configToJSON 将配置文件内容转换为JSON，语法错误带有行号和列号
+func configToJSON(data []byte, format ConfigFormat) ([]byte, error) {
+	switch format {
+	case ConfigJSON:
+		var syntax *json.SyntaxError
+		if err := json.Unmarshal(data, new(json.RawMessage)); errors.As(err, &syntax) {
+			line, column := lineColumn(data, int(syntax.Offset)-1)
+			return nil, &ConfigError{Line: line, Column: column, Err: err}
+		} else if err != nil {
+			return nil, &ConfigError{Err: err}
+		}
+		return data, nil
+
+	case ConfigYAML:
+		jsonData, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			var yamlErr yaml.Error
+			if errors.As(err, &yamlErr) && yamlErr.GetToken() != nil {
+				pos := yamlErr.GetToken().Position
+				return nil, &ConfigError{Line: pos.Line, Column: pos.Column, Err: errors.New(yamlErr.GetMessage())}
+			}
+			return nil, &ConfigError{Err: err}
+		}
+		return jsonData, nil
+
+	case ConfigTOML:
+		var v map[string]interface{}
+		if _, err := toml.Decode(string(data), &v); err != nil {
+			var parseErr toml.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, &ConfigError{Line: parseErr.Position.Line, Column: parseErr.Position.Col, Err: errors.New(parseErr.Message)}
+			}
+			return nil, &ConfigError{Err: err}
+		}
+		return json.Marshal(v)
+
+	default:
+		return nil, fmt.Errorf("unsupported config format: %s", format)
+	}
+}
+
+// configFieldError 将解析转换后JSON时的错误转换为*ConfigError，并在原文件中定位出错的字段
+// 多个字段同时出错时返回ValidationErrors，每个问题的Err为带有位置的*ConfigError
+func configFieldError(data []byte, format ConfigFormat, err error) error {
+	var errs ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &errs) && len(errs) == 1:
+		return locateField(data, format, errs[0].Path, errs[0].Err.Error())
+	case errors.As(err, &errs):
+		located := make(ValidationErrors, len(errs))
+		for i, fieldErr := range errs {
+			configErr := locateField(data, format, fieldErr.Path, fieldErr.Err.Error())
+			configErr.Field = ""
+			located[i] = &FieldError{Path: fieldErr.Path, Err: configErr}
+		}
+		return located
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return locateField(data, format, typeErr.Field, fmt.Sprintf("cannot use %s as %s", typeErr.Value, typeErr.Type))
+	default:
+		return &ConfigError{Err: err}
+	}
+}
+
+// locateField 返回字段path的*ConfigError，带有字段在原文件中的位置
+func locateField(data []byte, format ConfigFormat, path, message string) *ConfigError {
+	configErr := &ConfigError{Field: path, Err: errors.New(message)}
+	switch format {
+	case ConfigJSON:
+		configErr.Line, configErr.Column = locateJSON(data, path)
+	case ConfigYAML:
+		configErr.Line, configErr.Column = locateYAML(data, path)
+	case ConfigTOML:
+		configErr.Line, configErr.Column = locateTOML(data, path)
+	}
+	return configErr
+}
+
+// lineColumn 将字节偏移转换为行号和列号
+func lineColumn(data []byte, offset int) (int, int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(data) {
+		offset = len(data)
+	}
+	before := data[:offset]
+	lineStart := bytes.LastIndexByte(before, '\n') + 1
+	return bytes.Count(before, []byte("\n")) + 1, utf8.RuneCount(before[lineStart:]) + 1
+}
+
+// locateJSON 返回JSON中字段键的位置，找不到时返回0
+func locateJSON(data []byte, path string) (int, int) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	prefix := ""
+	for {
+		// 进入一层对象，在其中查找目标字段或其上级字段
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+			return 0, 0
+		}
+		descended := false
+		for dec.More() {
+			start := int(dec.InputOffset())
+			tok, err := dec.Token()
+			if err != nil {
+				return 0, 0
+			}
+			key := prefix + fmt.Sprint(tok)
+			if key == path {
+				// InputOffset在键之前的逗号和空白处，跳到键的引号
+				return lineColumn(data, start+bytes.IndexByte(data[start:], '"'))
+			}
+			if strings.HasPrefix(path, key+".") {
+				prefix = key + "."
+				descended = true
+				break
+			}
+			if err := dec.Decode(new(json.RawMessage)); err != nil {
+				return 0, 0
+			}
+		}
+		if !descended {
+			return 0, 0
+		}
+	}
+}
+
+// locateYAML 返回YAML中字段值的位置，找不到时返回0
+func locateYAML(data []byte, path string) (int, int) {
+	file, err := parser.ParseBytes(data, 0)
+	if err != nil {
+		return 0, 0
+	}
+	p, err := yaml.PathString("$." + path)
+	if err != nil {
+		return 0, 0
+	}
+	node, err := p.FilterFile(file)
+	if err != nil || node == nil || node.GetToken() == nil {
+		return 0, 0
+	}
+	pos := node.GetToken().Position
+	return pos.Line, pos.Column
+}
+
+// locateTOML 返回TOML中字段键的位置，找不到时返回0
+// 只识别[表]下的"键 = 值"和顶层的点分键，足以覆盖配置文件的常见写法
+func locateTOML(data []byte, path string) (int, int) {
+	table := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			table = strings.TrimSpace(strings.Trim(trimmed, "[]"))
+			continue
+		}
+		key, _, ok := strings.Cut(trimmed, "=")
+		if !ok || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"'`)
+		if table != "" {
+			key = table + "." + key
+		}
+		if key == path {
+			indent := len(line) - len(strings.TrimLeft(line, " \t"))
+			return i + 1, utf8.RuneCountInString(line[:indent]) + 1
+		}
+	}
+	return 0, 0
+}
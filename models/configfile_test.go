@@ -0,0 +1,165 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// sampleConfigs 三种格式书写的同一份配置
+var sampleConfigs = map[ConfigFormat]string{
+	ConfigJSON: `{
+  "server": {"port": 9090, "read_timeout": "15s"},
+  "database": {"host": "db.internal", "conn_max_lifetime": "1h"},
+  "cors": {"allowed_origins": ["https://a.example.com"]},
+  "forwarders": {"hook": {"url": "http://example.com", "retries": 2}}
+}`,
+	ConfigYAML: `server:
+  port: 9090
+  read_timeout: 15s
+database:
+  host: db.internal
+  conn_max_lifetime: 1h
+cors:
+  allowed_origins:
+    - https://a.example.com
+forwarders:
+  hook:
+    url: http://example.com
+    retries: 2
+`,
+	ConfigTOML: `[server]
+port = 9090
+read_timeout = "15s"
+
+[database]
+host = "db.internal"
+conn_max_lifetime = "1h"
+
+[cors]
+allowed_origins = ["https://a.example.com"]
+
+[forwarders.hook]
+url = "http://example.com"
+retries = 2
+`,
+}
+
+func TestParseConfigFormats(t *testing.T) {
+	for format, data := range sampleConfigs {
+		t.Run(string(format), func(t *testing.T) {
+			config, err := ParseConfig([]byte(data), format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Server.Port != 9090 || config.Server.ReadTimeout != 15*time.Second ||
+				config.Database.Host != "db.internal" || config.Database.ConnMaxLifetime != time.Hour {
+				t.Errorf("server %+v, database %+v", config.Server, config.Database)
+			}
+			if !reflect.DeepEqual(config.CORS.AllowedOrigins, []string{"https://a.example.com"}) {
+				t.Errorf("allowed origins %q", config.CORS.AllowedOrigins)
+			}
+			var hook struct {
+				URL     string `json:"url"`
+				Retries int    `json:"retries"`
+			}
+			if err := json.Unmarshal(config.Forwarders["hook"], &hook); err != nil || hook.URL != "http://example.com" || hook.Retries != 2 {
+				t.Errorf("forwarder %s: %v", config.Forwarders["hook"], err)
+			}
+
+			// 没有出现的字段保持默认值
+			defaults := DefaultConfig()
+			if config.Server.Host != defaults.Server.Host || config.Server.WriteTimeout != defaults.Server.WriteTimeout ||
+				config.Database.Port != defaults.Database.Port || config.Validation != defaults.Validation {
+				t.Errorf("defaults lost: %+v", config)
+			}
+		})
+	}
+}
+
+func TestParseConfigErrorPosition(t *testing.T) {
+	tests := []struct {
+		name   string
+		format ConfigFormat
+		data   string
+		line   int
+		field  string
+	}{
+		{"json syntax", ConfigJSON, "{\n  \"server\": {\"port\": 80,}\n}", 2, ""},
+		{"json type", ConfigJSON, "{\n  \"server\": {\n    \"port\": \"eighty\"\n  }\n}", 3, "server.port"},
+		{"json duration", ConfigJSON, "{\n  \"database\": {\n    \"conn_max_lifetime\": \"1 hour\"\n  }\n}", 3, "database.conn_max_lifetime"},
+		{"yaml syntax", ConfigYAML, "server:\n  port: 80\n  host: \"x\n", 3, ""},
+		{"yaml duration", ConfigYAML, "server:\n  port: 80\n  write_timeout: soon\n", 3, "server.write_timeout"},
+		{"toml syntax", ConfigTOML, "[server]\nport = \n", 2, ""},
+		{"toml type", ConfigTOML, "[server]\nhost = \"x\"\nport = \"eighty\"\n", 3, "server.port"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseConfig([]byte(tt.data), tt.format)
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) {
+				t.Fatalf("error %v is not a *ConfigError", err)
+			}
+			if configErr.Line != tt.line || configErr.Column == 0 || configErr.Field != tt.field {
+				t.Errorf("%v: line %d column %d field %q, want line %d field %q",
+					err, configErr.Line, configErr.Column, configErr.Field, tt.line, tt.field)
+			}
+		})
+	}
+}
+
+func TestConfigFormatFromPath(t *testing.T) {
+	for path, want := range map[string]ConfigFormat{
+		"config.json":      ConfigJSON,
+		"/etc/mp/app.YAML": ConfigYAML,
+		"app.yml":          ConfigYAML,
+		"app.toml":         ConfigTOML,
+	} {
+		if format, err := ConfigFormatFromPath(path); err != nil || format != want {
+			t.Errorf("%s: %s, %v, want %s", path, format, err, want)
+		}
+	}
+	for _, path := range []string{"config", "config.ini", "config.json.bak"} {
+		if _, err := ConfigFormatFromPath(path); err == nil {
+			t.Errorf("%s accepted", path)
+		}
+	}
+}
+
+func TestConfigFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.Server.Port = 9191
+	config.Database.ConnMaxLifetime = 90 * time.Second
+	config.CORS.AllowedOrigins = []string{"*"}
+
+	for _, name := range []string{"config.json", "config.yaml", "config.toml"} {
+		path := filepath.Join(dir, name)
+		if err := config.SaveConfigToFile(path); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		loaded, err := LoadConfigFromFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(loaded, config) {
+			t.Errorf("%s: loaded %+v, want %+v", name, loaded, config)
+		}
+	}
+}
+
+func TestLoadConfigFromFileNamesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.yaml")
+	if err := os.WriteFile(path, []byte("server:\n  port: x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadConfigFromFile(path)
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || configErr.File != path || configErr.Line != 2 {
+		t.Errorf("error %v, want a *ConfigError at %s:2", err, path)
+	}
+}
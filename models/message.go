@@ -0,0 +1,171 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Message 消息模型
+// 描述一条提交给处理器的消息及其处理状态
+// 实现了json.Marshaler和json.Unmarshaler接口
+
+// MessageStatus 消息状态
+type MessageStatus string
+
+const (
+	// MessageStatusScheduled 等待定时投递
+	MessageStatusScheduled MessageStatus = "scheduled"
+	// MessageStatusProcessed 处理成功
+	MessageStatusProcessed MessageStatus = "processed"
+	// MessageStatusFailed 处理失败
+	MessageStatusFailed MessageStatus = "failed"
+	// MessageStatusCancelled 已取消
+	MessageStatusCancelled MessageStatus = "cancelled"
+	// MessageStatusExpired 超过有效期，未处理
+	MessageStatusExpired MessageStatus = "expired"
+	// MessageStatusQuarantined 被分类器隔离，等待人工审核
+	MessageStatusQuarantined MessageStatus = "quarantined"
+	// MessageStatusPending 分片已接收，等待其余分片到齐后重组
+	MessageStatusPending MessageStatus = "pending"
+)
+
+// ErrMessageQuarantined 处理器判定消息需要隔离，消息不会被继续处理
+var ErrMessageQuarantined = errors.New("message quarantined")
+
+// ErrMessagePending 处理器已接收消息但尚不能产生结果，如分片尚未到齐
+var ErrMessagePending = errors.New("message pending")
+
+// Priority 消息优先级
+type Priority string
+
+const (
+	// PriorityCritical 关键消息，如告警通知
+	PriorityCritical Priority = "critical"
+	// PriorityNormal 普通消息，未指定优先级时的默认值
+	PriorityNormal Priority = "normal"
+	// PriorityBulk 批量消息，如回填任务
+	PriorityBulk Priority = "bulk"
+)
+
+// Priorities 所有优先级，按从高到低排列
+var Priorities = []Priority{PriorityCritical, PriorityNormal, PriorityBulk}
+
+// ParsePriority 解析优先级字符串，空字符串返回PriorityNormal
+func ParsePriority(s string) (Priority, error) {
+	if s == "" {
+		return PriorityNormal, nil
+	}
+	for _, p := range Priorities {
+		if string(p) == s {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown priority: %s", s)
+}
+
+// Message 消息结构体
+type Message struct {
+	ID        string        `json:"id"`
+	Content   string        `json:"content"`
+	Processor string        `json:"processor,omitempty"`
+	User      string        `json:"user,omitempty"` // 提交消息的用户或API密钥摘要
+	Status    MessageStatus `json:"status"`
+	Priority  Priority      `json:"priority"`
+	Result    string        `json:"result,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	// Metadata 处理器附加的元数据，如脱敏报告
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	// Latency 处理器处理消息的耗时，JSON中以毫秒表示
+	Latency   time.Duration `json:"-"`
+	DeliverAt time.Time     `json:"deliver_at"`
+	ExpiresAt time.Time     `json:"expires_at"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// MarshalJSON 自定义JSON序列化方法
+// 零值时间序列化为空，便于区分未设置的时间字段；时间保留纳秒精度，导出后再导入不丢失精度
+func (m Message) MarshalJSON() ([]byte, error) {
+	type Alias Message
+	return json.Marshal(&struct {
+		Alias
+		Latency   float64 `json:"latency_ms,omitempty"`
+		DeliverAt string  `json:"deliver_at,omitempty"`
+		ExpiresAt string  `json:"expires_at,omitempty"`
+		CreatedAt string  `json:"created_at"`
+		UpdatedAt string  `json:"updated_at"`
+	}{
+		Alias:     (Alias)(m),
+		Latency:   float64(m.Latency) / float64(time.Millisecond),
+		DeliverAt: formatOptionalTime(m.DeliverAt),
+		ExpiresAt: formatOptionalTime(m.ExpiresAt),
+		CreatedAt: m.CreatedAt.Format(time.RFC3339Nano),
+		UpdatedAt: m.UpdatedAt.Format(time.RFC3339Nano),
+	})
+}
+
+// UnmarshalJSON 自定义JSON反序列化方法
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type Alias Message
+	aux := &struct {
+		*Alias
+		Latency   float64 `json:"latency_ms"`
+		DeliverAt string  `json:"deliver_at"`
+		ExpiresAt string  `json:"expires_at"`
+		CreatedAt string  `json:"created_at"`
+		UpdatedAt string  `json:"updated_at"`
+	}{
+		Alias: (*Alias)(m),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	m.Latency = time.Duration(aux.Latency * float64(time.Millisecond))
+
+	var err error
+	if m.DeliverAt, err = parseOptionalTime(aux.DeliverAt); err != nil {
+		return err
+	}
+	if m.ExpiresAt, err = parseOptionalTime(aux.ExpiresAt); err != nil {
+		return err
+	}
+	if m.CreatedAt, err = parseOptionalTime(aux.CreatedAt); err != nil {
+		return err
+	}
+	if m.UpdatedAt, err = parseOptionalTime(aux.UpdatedAt); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// IsScheduled 判断消息是否设置了投递时间
+func (m *Message) IsScheduled() bool {
+	return !m.DeliverAt.IsZero()
+}
+
+// IsExpired 判断消息在指定时间是否已过期
+// 未设置有效期的消息永不过期
+func (m *Message) IsExpired(now time.Time) bool {
+	return !m.ExpiresAt.IsZero() && !now.Before(m.ExpiresAt)
+}
+
+// formatOptionalTime 格式化可选时间，零值返回空字符串
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// parseOptionalTime 解析可选时间，空字符串返回零值
+func parseOptionalTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMessageJSONRoundTrip(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.UTC)
+	msg := Message{
+		ID:        "m1",
+		Content:   "hello",
+		Status:    MessageStatusProcessed,
+		Latency:   1500 * time.Microsecond,
+		ExpiresAt: created.Add(time.Hour + 5*time.Millisecond),
+		CreatedAt: created,
+		UpdatedAt: created.Add(time.Microsecond),
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Message
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if !decoded.CreatedAt.Equal(msg.CreatedAt) || !decoded.UpdatedAt.Equal(msg.UpdatedAt) || !decoded.ExpiresAt.Equal(msg.ExpiresAt) {
+		t.Errorf("timestamps lost precision: %s", data)
+	}
+	if !decoded.DeliverAt.IsZero() {
+		t.Errorf("unset deliver_at decoded as %s", decoded.DeliverAt)
+	}
+	if decoded.Latency != msg.Latency {
+		t.Errorf("latency %s, want %s", decoded.Latency, msg.Latency)
+	}
+}
+
+func TestMessageJSONAcceptsSecondPrecision(t *testing.T) {
+	var msg Message
+	if err := json.Unmarshal([]byte(`{"id":"m1","created_at":"2024-03-01T12:30:45Z","updated_at":"2024-03-01T12:30:45+08:00"}`), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.CreatedAt.Second() != 45 || msg.UpdatedAt.IsZero() {
+		t.Errorf("decoded %+v", msg)
+	}
+}
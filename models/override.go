@@ -0,0 +1,166 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 配置覆盖
+// 每个配置字段都可以通过环境变量或"路径=值"的形式覆盖。路径由各级JSON字段名用点连接，
+// 如server.port；环境变量名为MP_加上大写的路径，点换成下划线，如MP_SERVER_PORT、
+// MP_DATABASE_CONN_MAX_LIFETIME。时长使用time.ParseDuration格式（如30s），
+// 字符串列表以逗号分隔（如cors.allowed_origins），aggregation、forwarders、plugins、rollouts、classifier、pii整体以JSON对象覆盖。
+// 优先级从低到高依次为：默认值、配置文件、环境变量、命令行参数
+
+// EnvPrefix 配置环境变量的前缀
+const EnvPrefix = "MP_"
+
+// durationType time.Duration的反射类型
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// rawMessageType json.RawMessage的反射类型
+var rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+
+// configField 一个可覆盖的配置字段
+type configField struct {
+	path  string
+	value reflect.Value
+}
+
+// env 返回字段对应的环境变量名
+func (f configField) env() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(f.path, ".", "_"))
+}
+
+// ApplyEnv 用环境变量覆盖配置，lookup通常为os.LookupEnv
+// 无法解析的值不覆盖原值，全部收集到ValidationErrors中一起返回，每个问题包含环境变量名
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	var errs ValidationErrors
+	for _, f := range c.fields() {
+		value, ok := lookup(f.env())
+		if !ok {
+			continue
+		}
+		if err := setField(f.value, value); err != nil {
+			errs = append(errs, &FieldError{Path: f.path, Err: fmt.Errorf("environment variable %s: %w", f.env(), err)})
+		}
+	}
+	return errs.Err()
+}
+
+// Set 按"路径=值"覆盖一个配置字段，如server.port=9090
+func (c *Config) Set(assignment string) error {
+	path, value, ok := strings.Cut(assignment, "=")
+	if !ok {
+		return fmt.Errorf("invalid override %q: must be path=value", assignment)
+	}
+	path = strings.TrimSpace(path)
+	for _, f := range c.fields() {
+		if f.path == path {
+			if err := setField(f.value, value); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown config field: %s", path)
+}
+
+// EnvNames 返回所有配置字段对应的环境变量名
+func (c *Config) EnvNames() []string {
+	fields := c.fields()
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.env()
+	}
+	return names
+}
+
+// fields 返回所有可覆盖的字段，嵌套的结构体展开为各自的字段
+func (c *Config) fields() []configField {
+	var fields []configField
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			field := v.Field(i)
+			if field.Kind() == reflect.Struct {
+				walk(prefix+name+".", field)
+				continue
+			}
+			fields = append(fields, configField{path: prefix + name, value: field})
+		}
+	}
+	walk("", reflect.ValueOf(c).Elem())
+	return fields
+}
+
+// setField 将字符串解析为字段的类型并赋值
+func setField(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	if field.Type() == rawMessageType {
+		if !json.Valid([]byte(value)) {
+			return fmt.Errorf("invalid JSON value")
+		}
+		field.SetBytes([]byte(value))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported field type %s", field.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		m := reflect.New(field.Type())
+		if err := json.Unmarshal([]byte(value), m.Interface()); err != nil {
+			return fmt.Errorf("invalid JSON object: %v", err)
+		}
+		field.Set(m.Elem())
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envLookup 用map模拟os.LookupEnv
+func envLookup(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	config := DefaultConfig()
+	err := config.ApplyEnv(envLookup(map[string]string{
+		"MP_SERVER_PORT":                "9090",
+		"MP_DATABASE_CONN_MAX_LIFETIME": "90s",
+		"MP_LOGGING_LEVEL":              "debug",
+		"MP_CORS_ALLOWED_ORIGINS":       "https://a.example.com, ,https://b.example.com",
+		"MP_FORWARDERS":                 `{"hook":{"url":"http://example.com"}}`,
+		"MP_CLASSIFIER":                 `{"threshold":2}`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Server.Port != 9090 || config.Database.ConnMaxLifetime != 90*time.Second || config.Logging.Level != "debug" {
+		t.Errorf("scalar overrides not applied: %+v", config)
+	}
+	if want := []string{"https://a.example.com", "https://b.example.com"}; !reflect.DeepEqual(config.CORS.AllowedOrigins, want) {
+		t.Errorf("allowed origins %q, want %q", config.CORS.AllowedOrigins, want)
+	}
+	if string(config.Forwarders["hook"]) != `{"url":"http://example.com"}` {
+		t.Errorf("forwarders %s", config.Forwarders["hook"])
+	}
+	if string(config.Classifier) != `{"threshold":2}` {
+		t.Errorf("classifier %s", config.Classifier)
+	}
+	// 没有设置的变量不改变配置
+	if config.Server.Host != DefaultConfig().Server.Host {
+		t.Errorf("unset variable changed server.host to %q", config.Server.Host)
+	}
+}
+
+func TestApplyEnvErrorNamesVariable(t *testing.T) {
+	for name, value := range map[string]string{
+		"MP_SERVER_PORT":             "http",
+		"MP_SERVER_READ_TIMEOUT":     "10",
+		"MP_FORWARDERS":              `["hook"]`,
+		"MP_CLASSIFIER":              `{"threshold":`,
+		"MP_DATABASE_MAX_OPEN_CONNS": "1.5",
+	} {
+		config := DefaultConfig()
+		err := config.ApplyEnv(envLookup(map[string]string{name: value}))
+		if err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("%s=%s: error %v does not name the variable", name, value, err)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	config := DefaultConfig()
+	for _, assignment := range []string{
+		"server.port=9091",
+		" server.write_timeout =1m",
+		"app.env=production",
+		`rollouts={"classify":{"percent":10}}`,
+	} {
+		if err := config.Set(assignment); err != nil {
+			t.Fatalf("%s: %v", assignment, err)
+		}
+	}
+	if config.Server.Port != 9091 || config.Server.WriteTimeout != time.Minute || config.App.Env != "production" {
+		t.Errorf("overrides not applied: %+v", config)
+	}
+	if string(config.Rollouts["classify"]) != `{"percent":10}` {
+		t.Errorf("rollouts %s", config.Rollouts["classify"])
+	}
+
+	tests := []struct {
+		assignment string
+		want       string
+	}{
+		{"server.port", "must be path=value"},
+		{"server.missing=1", "unknown config field: server.missing"},
+		{"server=1", "unknown config field: server"},
+		{"server.port=eighty", "server.port: invalid integer"},
+		{"database.conn_max_lifetime=forever", "database.conn_max_lifetime: invalid duration"},
+	}
+	for _, tt := range tests {
+		if err := config.Set(tt.assignment); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.assignment, err, tt.want)
+		}
+	}
+	if config.Server.Port != 9091 {
+		t.Errorf("failed override changed server.port to %d", config.Server.Port)
+	}
+}
+
+func TestOverridePrecedence(t *testing.T) {
+	config, err := ParseConfig([]byte(`{"server":{"port":7000,"host":"file.example.com"}}`), ConfigJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.ApplyEnv(envLookup(map[string]string{"MP_SERVER_PORT": "8000"})); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Set("server.port=9000"); err != nil {
+		t.Fatal(err)
+	}
+	if config.Server.Port != 9000 || config.Server.Host != "file.example.com" {
+		t.Errorf("server %+v, want port from -set and host from the file", config.Server)
+	}
+}
+
+func TestEnvNames(t *testing.T) {
+	config := DefaultConfig()
+	names := make(map[string]bool)
+	for _, name := range config.EnvNames() {
+		names[name] = true
+	}
+	for _, want := range []string{"MP_SERVER_PORT", "MP_DATABASE_CONN_MAX_LIFETIME", "MP_CORS_ALLOWED_ORIGINS", "MP_AUTH_JWT_SECRET", "MP_FORWARDERS"} {
+		if !names[want] {
+			t.Errorf("missing %s in %v", want, config.EnvNames())
+		}
+	}
+	if names["MP_SERVER"] || names["MP_DATABASE"] {
+		t.Errorf("nested sections listed as variables: %v", config.EnvNames())
+	}
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+)
+
+// 配置重新加载
+// 日志级别、过载保护限制、跨域来源、消息校验规则、分类词典、脱敏规则和配置定义的处理器可以在运行中替换；
+// 其余字段在启动时就已经生效（监听地址、数据库连接池、签名密钥等），修改后需要重启
+
+// reloadableFields 可以重新加载的字段路径或路径前缀（以点结尾）
+var reloadableFields = []string{
+	"logging.level",
+	"limits.",
+	"cors.",
+	"validation.",
+	"forwarders",
+	"plugins",
+	"rollouts",
+	"classifier",
+	"pii",
+}
+
+// restartReasons 需要重启的各节配置及原因，按路径前缀匹配
+var restartReasons = []struct {
+	prefix string
+	reason string
+}{
+	{"server.", "the listener and its timeouts are set up when the server starts"},
+	{"database.", "the connection pool is opened when the server starts"},
+	{"logging.", "the log output is opened when the server starts"},
+	{"auth.", "tokens and API keys are checked with the settings loaded at startup"},
+	{"app.", "application identity is fixed for the lifetime of the process"},
+}
+
+// RestartChange 一个修改后需要重启才能生效的字段
+type RestartChange struct {
+	// Field 字段路径，如server.port
+	Field string
+	// Reason 不能在运行中修改的原因
+	Reason string
+}
+
+// String 返回"字段 (原因)"形式的说明
+func (c RestartChange) String() string {
+	return c.Field + " (" + c.Reason + ")"
+}
+
+// Reloadable 判断字段路径是否可以在运行中重新加载
+func Reloadable(path string) bool {
+	for _, f := range reloadableFields {
+		if path == f || strings.HasSuffix(f, ".") && strings.HasPrefix(path, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// RestartChanges 比较两份配置，返回值不同且需要重启才能生效的字段
+// 只返回字段路径，不包含字段的值，避免密码和密钥出现在日志中
+func (c Config) RestartChanges(next Config) []RestartChange {
+	current, updated := c.fields(), next.fields()
+	var changes []RestartChange
+	for i, f := range current {
+		if Reloadable(f.path) || reflect.DeepEqual(f.value.Interface(), updated[i].value.Interface()) {
+			continue
+		}
+		change := RestartChange{Field: f.path, Reason: "the field is only read at startup"}
+		for _, r := range restartReasons {
+			if strings.HasPrefix(f.path, r.prefix) {
+				change.Reason = r.reason
+				break
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
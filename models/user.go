@@ -31,8 +31,8 @@ func (u User) MarshalJSON() ([]byte, error) {
 		UpdatedAt string `json:"updated_at"`
 	}{
 		Alias:     (Alias)(u),
-		CreatedAt: u.CreatedAt.Format(time.RFC3339),
-		UpdatedAt: u.UpdatedAt.Format(time.RFC3339),
+		CreatedAt: u.CreatedAt.Format(time.RFC3339Nano),
+		UpdatedAt: u.UpdatedAt.Format(time.RFC3339Nano),
 	})
 }
 
@@ -54,13 +54,13 @@ func (u *User) UnmarshalJSON(data []byte) error {
 	}
 
 	// 解析时间字符串
-	createdAt, err := time.Parse(time.RFC3339, aux.CreatedAt)
+	createdAt, err := time.Parse(time.RFC3339Nano, aux.CreatedAt)
 	if err != nil {
 		return err
 	}
 	u.CreatedAt = createdAt
 
-	updatedAt, err := time.Parse(time.RFC3339, aux.UpdatedAt)
+	updatedAt, err := time.Parse(time.RFC3339Nano, aux.UpdatedAt)
 	if err != nil {
 		return err
 	}
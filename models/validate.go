@@ -0,0 +1,191 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 配置校验
+// 在默认值、配置文件、环境变量和命令行参数全部合并之后调用，一次返回所有问题
+
+// MinJWTSecretLength JWT签名密钥的最小长度（字节），HS256要求密钥不短于哈希输出
+const MinJWTSecretLength = 32
+
+// logLevels 支持的日志级别
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// logFormats 支持的日志格式
+var logFormats = []string{"text", "json"}
+
+// sslModes PostgreSQL支持的SSL模式
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// ValidationErrors 配置校验发现的所有问题
+type ValidationErrors []*FieldError
+
+// Error 实现error接口，每个问题一行
+func (e ValidationErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = "  " + err.Error()
+	}
+	return fmt.Sprintf("invalid configuration (%d problems):\n%s", len(e), strings.Join(lines, "\n"))
+}
+
+// Unwrap 返回各个问题，errors.Is和errors.As可以匹配其中任意一个
+func (e ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Append 将err加入问题列表并返回新的列表
+// ValidationErrors展开为各个问题，*FieldError原样加入，其他错误记在path下；err为nil时不变
+func (e ValidationErrors) Append(path string, err error) ValidationErrors {
+	var list ValidationErrors
+	var field *FieldError
+	switch {
+	case err == nil:
+		return e
+	case errors.As(err, &list):
+		return append(e, list...)
+	case errors.As(err, &field):
+		return append(e, field)
+	default:
+		return append(e, &FieldError{Path: path, Err: err})
+	}
+}
+
+// Err 没有问题时返回nil，否则返回e
+func (e ValidationErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate 校验配置，返回ValidationErrors，没有问题时返回nil
+func (c Config) Validate() error {
+	var errs ValidationErrors
+	add := func(path, format string, args ...interface{}) {
+		errs = append(errs, &FieldError{Path: path, Err: fmt.Errorf(format, args...)})
+	}
+
+	// 服务器
+	if strings.TrimSpace(c.Server.Host) == "" {
+		add("server.host", "must not be empty")
+	}
+	checkPort(add, "server.port", c.Server.Port)
+	checkPositive(add, "server.read_timeout", c.Server.ReadTimeout)
+	checkPositive(add, "server.write_timeout", c.Server.WriteTimeout)
+
+	// 数据库
+	if strings.TrimSpace(c.Database.Host) == "" {
+		add("database.host", "must not be empty")
+	}
+	checkPort(add, "database.port", c.Database.Port)
+	if c.Database.User == "" {
+		add("database.user", "must not be empty")
+	}
+	if c.Database.DBName == "" {
+		add("database.dbname", "must not be empty")
+	}
+	checkOneOf(add, "database.sslmode", c.Database.SSLMode, sslModes)
+	if c.Database.MaxOpenConns < 0 {
+		add("database.max_open_conns", "must not be negative, got %d", c.Database.MaxOpenConns)
+	}
+	if c.Database.MaxIdleConns < 0 {
+		add("database.max_idle_conns", "must not be negative, got %d", c.Database.MaxIdleConns)
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		add("database.max_idle_conns", "must not exceed database.max_open_conns (%d), got %d",
+			c.Database.MaxOpenConns, c.Database.MaxIdleConns)
+	}
+	if c.Database.ConnMaxLifetime < 0 {
+		add("database.conn_max_lifetime", "must not be negative, got %s", c.Database.ConnMaxLifetime)
+	}
+
+	// 日志
+	checkOneOf(add, "logging.level", strings.ToLower(c.Logging.Level), logLevels)
+	checkOneOf(add, "logging.format", c.Logging.Format, logFormats)
+
+	// 认证
+	switch {
+	case c.Auth.JWTSecret == "":
+		add("auth.jwt_secret", "is required")
+	case len(c.Auth.JWTSecret) < MinJWTSecretLength:
+		add("auth.jwt_secret", "must be at least %d bytes, got %d", MinJWTSecretLength, len(c.Auth.JWTSecret))
+	case strings.Count(c.Auth.JWTSecret, c.Auth.JWTSecret[:1]) == len(c.Auth.JWTSecret):
+		add("auth.jwt_secret", "must not repeat a single character")
+	}
+	if c.Auth.APIKeyPrefix == "" {
+		add("auth.api_key_prefix", "must not be empty")
+	}
+
+	// 过载保护
+	if c.Limits.MaxInFlight < 0 {
+		add("limits.max_in_flight", "must not be negative, got %d", c.Limits.MaxInFlight)
+	}
+	if c.Limits.QueueHighWatermark < 0 {
+		add("limits.queue_high_watermark", "must not be negative, got %d", c.Limits.QueueHighWatermark)
+	}
+	if c.Limits.QueueLowWatermark < 0 {
+		add("limits.queue_low_watermark", "must not be negative, got %d", c.Limits.QueueLowWatermark)
+	}
+	if c.Limits.QueueLowWatermark > c.Limits.QueueHighWatermark {
+		add("limits.queue_low_watermark", "must not exceed limits.queue_high_watermark (%d), got %d",
+			c.Limits.QueueHighWatermark, c.Limits.QueueLowWatermark)
+	}
+
+	// 跨域
+	for i, origin := range c.CORS.AllowedOrigins {
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			add(fmt.Sprintf("cors.allowed_origins[%d]", i), "must be * or start with http:// or https://, got %q", origin)
+		}
+	}
+
+	// 消息校验
+	if c.Validation.MaxMessageLength <= 0 {
+		add("validation.max_message_length", "must be positive, got %d", c.Validation.MaxMessageLength)
+	}
+
+	// 生产环境的附加要求
+	if c.App.Env == "production" {
+		if c.Database.SSLMode == "disable" || c.Database.SSLMode == "allow" {
+			add("database.sslmode", "must encrypt connections when app.env is production, got %q", c.Database.SSLMode)
+		}
+		if strings.EqualFold(c.Logging.Level, "debug") {
+			add("logging.level", "must not be debug when app.env is production")
+		}
+	}
+
+	return errs.Err()
+}
+
+// checkPort 检查端口范围
+func checkPort(add func(string, string, ...interface{}), path string, port int) {
+	if port < 1 || port > 65535 {
+		add(path, "must be between 1 and 65535, got %d", port)
+	}
+}
+
+// checkPositive 检查时长为正数
+func checkPositive(add func(string, string, ...interface{}), path string, d time.Duration) {
+	if d <= 0 {
+		add(path, "must be positive, got %s", d)
+	}
+}
+
+// checkOneOf 检查取值在允许的范围内
+func checkOneOf(add func(string, string, ...interface{}), path, value string, allowed []string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	add(path, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig 通过校验的配置
+func validConfig() Config {
+	config := DefaultConfig()
+	config.Auth.JWTSecret = "0123456789abcdef0123456789abcdef"
+	return config
+}
+
+// problemPaths 返回ValidationErrors中各个问题的字段路径
+func problemPaths(t *testing.T, err error) []string {
+	t.Helper()
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("error %v is not ValidationErrors", err)
+	}
+	paths := make([]string, len(errs))
+	for i, e := range errs {
+		paths[i] = e.Path
+	}
+	return paths
+}
+
+func TestValidateDefaults(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// 默认配置没有JWT签名密钥
+	if paths := problemPaths(t, DefaultConfig().Validate()); strings.Join(paths, ",") != "auth.jwt_secret" {
+		t.Errorf("problems %v", paths)
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	config := validConfig()
+	config.Server.Port = 0
+	config.Server.ReadTimeout = 0
+	config.Database.SSLMode = "sometimes"
+	config.Database.MaxOpenConns = 5
+	config.Database.MaxIdleConns = 10
+	config.Logging.Format = "xml"
+	config.Auth.JWTSecret = "short"
+	config.Limits.QueueLowWatermark = config.Limits.QueueHighWatermark + 1
+	config.CORS.AllowedOrigins = []string{"https://ok.example.com", "example.com"}
+	config.Validation.MaxMessageLength = 0
+
+	err := config.Validate()
+	want := []string{
+		"server.port", "server.read_timeout", "database.sslmode", "database.max_idle_conns",
+		"logging.format", "auth.jwt_secret", "limits.queue_low_watermark", "cors.allowed_origins[1]",
+		"validation.max_message_length",
+	}
+	if paths := problemPaths(t, err); strings.Join(paths, ",") != strings.Join(want, ",") {
+		t.Errorf("problems %v, want %v", paths, want)
+	}
+	if !strings.Contains(err.Error(), "(9 problems)") || !strings.Contains(err.Error(), "must not exceed database.max_open_conns (5), got 10") {
+		t.Errorf("message %s", err)
+	}
+}
+
+func TestValidateProduction(t *testing.T) {
+	config := validConfig()
+	config.App.Env = "production"
+	config.Logging.Level = "DEBUG"
+	if paths := problemPaths(t, config.Validate()); strings.Join(paths, ",") != "database.sslmode,logging.level" {
+		t.Errorf("problems %v", paths)
+	}
+
+	config.Database.SSLMode = "verify-full"
+	config.Logging.Level = "info"
+	if err := config.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUnmarshalCollectsDurationErrors(t *testing.T) {
+	config := DefaultConfig()
+	err := json.Unmarshal([]byte(`{"server":{"read_timeout":"soon","write_timeout":"5s"},"database":{"conn_max_lifetime":"1 hour"}}`), &config)
+	if paths := problemPaths(t, err); strings.Join(paths, ",") != "server.read_timeout,database.conn_max_lifetime" {
+		t.Errorf("problems %v", paths)
+	}
+	if config.Server.WriteTimeout != 5*time.Second || config.Server.ReadTimeout != DefaultConfig().Server.ReadTimeout {
+		t.Errorf("server %+v", config.Server)
+	}
+}
+
+func TestParseConfigLocatesEveryProblem(t *testing.T) {
+	data := "{\n  \"server\": {\n    \"read_timeout\": \"soon\",\n    \"write_timeout\": \"later\"\n  }\n}"
+	_, err := ParseConfig([]byte(data), ConfigJSON)
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("error %v, want two problems", err)
+	}
+	for i, line := range []int{3, 4} {
+		var configErr *ConfigError
+		if !errors.As(errs[i], &configErr) || configErr.Line != line {
+			t.Errorf("problem %v, want line %d", errs[i], line)
+		}
+	}
+}
+
+func TestApplyEnvCollectsErrors(t *testing.T) {
+	config := DefaultConfig()
+	err := config.ApplyEnv(envLookup(map[string]string{
+		"MP_SERVER_PORT":         "http",
+		"MP_SERVER_HOST":         "127.0.0.1",
+		"MP_SERVER_READ_TIMEOUT": "10",
+	}))
+	if paths := problemPaths(t, err); strings.Join(paths, ",") != "server.port,server.read_timeout" {
+		t.Errorf("problems %v", paths)
+	}
+	if config.Server.Host != "127.0.0.1" || config.Server.Port != DefaultConfig().Server.Port {
+		t.Errorf("server %+v: valid variables must apply and invalid ones keep the previous value", config.Server)
+	}
+}
+
+func TestValidationErrorsAppend(t *testing.T) {
+	var errs ValidationErrors
+	errs = errs.Append("", nil)
+	errs = errs.Append("", ValidationErrors{{Path: "a", Err: errors.New("x")}, {Path: "b", Err: errors.New("y")}})
+	errs = errs.Append("", &FieldError{Path: "c", Err: errors.New("z")})
+	errs = errs.Append("-set", errors.New("unknown config field: d"))
+	if paths := problemPaths(t, errs.Err()); strings.Join(paths, ",") != "a,b,c,-set" {
+		t.Errorf("problems %v", paths)
+	}
+	if ValidationErrors(nil).Err() != nil {
+		t.Error("empty list is not nil")
+	}
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// RuleValidate 不能列出具体规则的校验器的校验错误使用的规则ID
+const RuleValidate = "validate"
+
+// RuleJSONSyntax 消息不是合法JSON时使用的规则ID
+const RuleJSONSyntax = "json_syntax"
+
+// 校验规则的违反项
+// 用于试校验接口，一条消息可以有多个违反项，每项标明规则和在消息中的位置
+
+// Violation 一条违反的校验规则
+type Violation struct {
+	// Rule 规则ID，如not_empty、max_length、keywords:spam
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	// Span 违反规则的文本位置，规则针对整条消息时为nil
+	Span *Span `json:"span,omitempty"`
+}
+
+// Span 消息中的一段文本
+// Start和End是字节偏移（不含End），Line和Column是Start所在的行号和列号（从1开始，列按字符计）
+type Span struct {
+	Start  int `json:"start"`
+	End    int `json:"end"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// NewViolation 创建针对整条消息的违反项
+func NewViolation(rule, message string) Violation {
+	return Violation{Rule: rule, Message: message}
+}
+
+// NewSpanViolation 创建指向msg中[start, end)的违反项
+func NewSpanViolation(msg, rule, message string, start, end int) Violation {
+	return Violation{Rule: rule, Message: message, Span: NewSpan(msg, start, end)}
+}
+
+// NewSpan 根据字节偏移创建文本位置，偏移超出消息范围时截断到消息末尾
+func NewSpan(msg string, start, end int) *Span {
+	if start > len(msg) {
+		start = len(msg)
+	}
+	if end > len(msg) {
+		end = len(msg)
+	}
+	if end < start {
+		end = start
+	}
+
+	before := msg[:start]
+	line := strings.Count(before, "\n") + 1
+	lineStart := strings.LastIndexByte(before, '\n') + 1
+	return &Span{
+		Start:  start,
+		End:    end,
+		Line:   line,
+		Column: utf8.RuneCountInString(before[lineStart:]) + 1,
+	}
+}
+
+// ErrorViolations 将校验错误转换为违反项，没有错误时返回nil
+// JSON语法错误指向出错的位置，其他错误针对整条消息
+func ErrorViolations(msg string, err error) []Violation {
+	if err == nil {
+		return nil
+	}
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		// Offset是读取到的字节数，出错的字符是最后读取的一个
+		start := int(syntax.Offset) - 1
+		if start < 0 {
+			start = 0
+		}
+		return []Violation{NewSpanViolation(msg, RuleJSONSyntax, err.Error(), start, int(syntax.Offset))}
+	}
+	return []Violation{NewViolation(RuleValidate, err.Error())}
+}
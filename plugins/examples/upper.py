@@ -0,0 +1,60 @@
+#!/usr/bin/env python3
+"""示例插件：将消息转换为大写
+
+协议说明见 plugins/protocol.go。每一帧为4字节大端序长度加JSON正文，
+标准输出只能写协议帧，日志请写到标准错误。
+"""
+
+import json
+import struct
+import sys
+
+
+def read_frame(stream):
+    header = stream.read(4)
+    if len(header) < 4:
+        return None
+    (size,) = struct.unpack(">I", header)
+    return json.loads(stream.read(size))
+
+
+def write_frame(stream, obj):
+    body = json.dumps(obj).encode("utf-8")
+    stream.write(struct.pack(">I", len(body)) + body)
+    stream.flush()
+
+
+def handle(method, params):
+    if method == "handshake":
+        return {"name": "upper", "protocol_version": 1, "capabilities": ["validate"]}
+    if method == "health":
+        return {"status": "ok"}
+    if method == "validate":
+        if not params["message"].strip():
+            raise ValueError("message cannot be empty")
+        return {}
+    if method == "process":
+        message = params["message"]
+        return {"result": message.upper(), "metadata": {"length": len(message)}}
+    raise NotImplementedError("unknown method: " + method)
+
+
+def main():
+    stdin, stdout = sys.stdin.buffer, sys.stdout.buffer
+    while True:
+        request = read_frame(stdin)
+        if request is None:
+            return
+        response = {"id": request["id"]}
+        try:
+            response["result"] = handle(request["method"], request.get("params") or {})
+        except ValueError as e:
+            response["error"] = {"code": "invalid", "message": str(e)}
+        except Exception as e:
+            print("plugin error: %r" % e, file=sys.stderr)
+            response["error"] = {"code": "internal", "message": str(e)}
+        write_frame(stdout, response)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,619 @@
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/message_processor/models"
+	"github.com/example/message_processor/utils"
+)
+
+// Host 插件宿主
+// 为一个插件维护固定数量的插件进程，每个进程同一时间只处理一个调用。
+// 进程崩溃、调用超时或协议错误时终止该进程，下次使用前按退避时间重新启动；
+// 后台定期对空闲进程做健康检查，并拉起已退出的进程
+
+// ErrHostStopped 插件宿主已停止，不再接受调用
+var ErrHostStopped = errors.New("plugin host is stopped")
+
+// Config 插件配置
+type Config struct {
+	// Command 插件可执行文件
+	Command string `json:"command"`
+	// Args 命令行参数
+	Args []string `json:"args"`
+	// Env 附加的环境变量，格式为KEY=VALUE
+	Env []string `json:"env"`
+	// Dir 工作目录，为空时使用服务器的工作目录
+	Dir string `json:"dir"`
+
+	// PoolSize 插件进程数量
+	PoolSize int `json:"pool_size"`
+	// CallTimeout 单次调用的超时时间，超时的进程会被终止
+	CallTimeout time.Duration `json:"call_timeout"`
+	// StartTimeout 启动和握手的超时时间
+	StartTimeout time.Duration `json:"start_timeout"`
+	// HealthInterval 健康检查间隔，0表示不检查
+	HealthInterval time.Duration `json:"health_interval"`
+	// RestartBackoff 进程退出后第一次重启前的等待时间，连续重启时翻倍
+	RestartBackoff time.Duration `json:"restart_backoff"`
+	// MaxRestartBackoff 重启等待时间的上限
+	MaxRestartBackoff time.Duration `json:"max_restart_backoff"`
+}
+
+// UnmarshalJSON 自定义JSON反序列化方法
+// 时长字段可以是time.ParseDuration格式的字符串（如"5s"），也可以是纳秒数
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type Alias Config
+	aux := &struct {
+		*Alias
+		CallTimeout       json.RawMessage `json:"call_timeout"`
+		StartTimeout      json.RawMessage `json:"start_timeout"`
+		HealthInterval    json.RawMessage `json:"health_interval"`
+		RestartBackoff    json.RawMessage `json:"restart_backoff"`
+		MaxRestartBackoff json.RawMessage `json:"max_restart_backoff"`
+	}{
+		Alias: (*Alias)(c),
+	}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	return utils.SetJSONDurations(
+		utils.DurationField{Name: "call_timeout", Value: aux.CallTimeout, Dest: &c.CallTimeout},
+		utils.DurationField{Name: "start_timeout", Value: aux.StartTimeout, Dest: &c.StartTimeout},
+		utils.DurationField{Name: "health_interval", Value: aux.HealthInterval, Dest: &c.HealthInterval},
+		utils.DurationField{Name: "restart_backoff", Value: aux.RestartBackoff, Dest: &c.RestartBackoff},
+		utils.DurationField{Name: "max_restart_backoff", Value: aux.MaxRestartBackoff, Dest: &c.MaxRestartBackoff},
+	)
+}
+
+// DefaultConfig 返回默认插件配置，Command需要另行设置
+func DefaultConfig() Config {
+	return Config{
+		PoolSize:          2,
+		CallTimeout:       10 * time.Second,
+		StartTimeout:      10 * time.Second,
+		HealthInterval:    30 * time.Second,
+		RestartBackoff:    time.Second,
+		MaxRestartBackoff: time.Minute,
+	}
+}
+
+// WorkerStatus 插件进程状态
+type WorkerStatus struct {
+	Index     int       `json:"index"`
+	PID       int       `json:"pid,omitempty"`
+	Running   bool      `json:"running"`
+	Restarts  int       `json:"restarts"`
+	StartedAt time.Time `json:"started_at"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// HostState 插件宿主状态
+type HostState struct {
+	Name         string         `json:"name"`
+	Plugin       string         `json:"plugin,omitempty"`
+	Capabilities []string       `json:"capabilities"`
+	Workers      []WorkerStatus `json:"workers"`
+}
+
+// Host 插件宿主结构体，可以并发使用
+type Host struct {
+	name    string
+	config  Config
+	workers []*worker
+	idle    chan *worker
+
+	mu           sync.RWMutex
+	plugin       string
+	capabilities []string
+	// stopped Stop或启动失败后为true，之后的调用直接返回ErrHostStopped，不再启动进程
+	stopped bool
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewHost 创建新的插件宿主，未设置的配置项使用默认值
+func NewHost(name string, config Config) (*Host, error) {
+	if config.Command == "" {
+		return nil, fmt.Errorf("plugin %s has no command", name)
+	}
+
+	defaults := DefaultConfig()
+	if config.PoolSize <= 0 {
+		config.PoolSize = defaults.PoolSize
+	}
+	if config.CallTimeout <= 0 {
+		config.CallTimeout = defaults.CallTimeout
+	}
+	if config.StartTimeout <= 0 {
+		config.StartTimeout = defaults.StartTimeout
+	}
+	if config.RestartBackoff <= 0 {
+		config.RestartBackoff = defaults.RestartBackoff
+	}
+	if config.MaxRestartBackoff <= 0 {
+		config.MaxRestartBackoff = defaults.MaxRestartBackoff
+	}
+
+	h := &Host{
+		name:   name,
+		config: config,
+		idle:   make(chan *worker, config.PoolSize),
+	}
+	for i := 0; i < config.PoolSize; i++ {
+		w := &worker{index: i, host: h, backoff: config.RestartBackoff}
+		h.workers = append(h.workers, w)
+		h.idle <- w
+	}
+	return h, nil
+}
+
+// Start 启动所有插件进程并开始健康检查
+// 任何一个进程启动或握手失败时返回错误，已启动的进程会被终止
+func (h *Host) Start(ctx context.Context) error {
+	for _, w := range h.workers {
+		if err := w.start(); err != nil {
+			h.shutdown()
+			return fmt.Errorf("failed to start plugin %s: %w", h.name, err)
+		}
+	}
+
+	ctx, h.cancel = context.WithCancel(ctx)
+	if h.config.HealthInterval > 0 {
+		h.wg.Add(1)
+		go h.run(ctx)
+	}
+	return nil
+}
+
+// Stop 停止健康检查，等待进行中的调用结束后关闭所有插件进程
+// 停止后的调用返回ErrHostStopped
+func (h *Host) Stop() {
+	if h.cancel != nil {
+		h.cancel()
+	}
+	h.wg.Wait()
+	h.shutdown()
+}
+
+// External 插件在独立进程中处理消息，可能访问任意外部系统
+func (h *Host) External() bool {
+	return true
+}
+
+// ProcessMessage 调用插件处理消息
+func (h *Host) ProcessMessage(msg string) (string, error) {
+	result, _, err := h.ProcessMessageWithMetadata(msg)
+	return result, err
+}
+
+// ProcessMessageWithMetadata 调用插件处理消息，返回插件附带的元数据
+func (h *Host) ProcessMessageWithMetadata(msg string) (string, map[string]interface{}, error) {
+	return h.ProcessMessageContext(context.Background(), msg)
+}
+
+// ProcessMessageContext 在ctx下调用插件处理消息，单次调用仍受CallTimeout限制
+func (h *Host) ProcessMessageContext(ctx context.Context, msg string) (string, map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.config.CallTimeout)
+	defer cancel()
+
+	var result ProcessResult
+	if err := h.Call(ctx, MethodProcess, MessageParams{Message: msg}, &result); err != nil {
+		return "", nil, err
+	}
+	return result.Result, result.Metadata, nil
+}
+
+// ValidateMessage 验证消息
+// 插件声明了validate能力时交给插件验证，否则只检查消息非空
+func (h *Host) ValidateMessage(msg string) error {
+	if strings.TrimSpace(msg) == "" {
+		return fmt.Errorf("message cannot be empty")
+	}
+	if !h.hasCapability(CapabilityValidate) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.config.CallTimeout)
+	defer cancel()
+	return h.Call(ctx, MethodValidate, MessageParams{Message: msg}, nil)
+}
+
+// Call 使用一个空闲的插件进程执行调用，result为nil时忽略结果
+func (h *Host) Call(ctx context.Context, method string, params, result interface{}) error {
+	var w *worker
+	select {
+	case w = <-h.idle:
+	case <-ctx.Done():
+		return fmt.Errorf("plugin %s is busy: %w", h.name, ctx.Err())
+	}
+	defer func() { h.idle <- w }()
+
+	if h.isStopped() {
+		return fmt.Errorf("plugin %s: %w", h.name, ErrHostStopped)
+	}
+	if err := w.ensureRunning(); err != nil {
+		return err
+	}
+	return w.call(ctx, method, params, result)
+}
+
+// State 返回插件宿主状态
+func (h *Host) State() interface{} {
+	h.mu.RLock()
+	state := HostState{
+		Name:         h.name,
+		Plugin:       h.plugin,
+		Capabilities: append([]string{}, h.capabilities...),
+	}
+	h.mu.RUnlock()
+
+	for _, w := range h.workers {
+		state.Workers = append(state.Workers, w.status())
+	}
+	return state
+}
+
+// isStopped 判断宿主是否已停止
+func (h *Host) isStopped() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.stopped
+}
+
+// hasCapability 判断插件是否声明了指定能力
+func (h *Host) hasCapability(capability string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, c := range h.capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// run 健康检查循环
+func (h *Host) run(ctx context.Context) {
+	defer h.wg.Done()
+
+	ticker := time.NewTicker(h.config.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.checkIdle(ctx)
+		}
+	}
+}
+
+// checkIdle 对当前空闲的进程做健康检查，已退出的进程会被重新启动
+func (h *Host) checkIdle(ctx context.Context) {
+	for i := 0; i < len(h.workers); i++ {
+		var w *worker
+		select {
+		case w = <-h.idle:
+		default:
+			return
+		}
+
+		if err := w.ensureRunning(); err == nil {
+			callCtx, cancel := context.WithTimeout(ctx, h.config.CallTimeout)
+			if err := w.call(callCtx, MethodHealth, nil, nil); err != nil {
+				log.Printf("Plugin %s worker %d failed health check: %v", h.name, w.index, err)
+			}
+			cancel()
+		}
+		h.idle <- w
+	}
+}
+
+// shutdown 标记宿主已停止，取回所有进程并关闭
+// 进程放回空闲队列只是为了让并发的调用取到后返回ErrHostStopped，不会再被启动
+func (h *Host) shutdown() {
+	h.mu.Lock()
+	h.stopped = true
+	h.mu.Unlock()
+
+	taken := make([]*worker, 0, len(h.workers))
+	for range h.workers {
+		w := <-h.idle
+		w.stop()
+		taken = append(taken, w)
+	}
+	for _, w := range taken {
+		h.idle <- w
+	}
+}
+
+// worker 一个插件进程
+type worker struct {
+	index int
+	host  *Host
+
+	// 以下字段只在持有该worker时访问
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  io.ReadCloser
+	exited  chan struct{}
+	nextID  uint64
+	backoff time.Duration
+	retryAt time.Time
+
+	mu        sync.Mutex
+	pid       int
+	restarts  int
+	startedAt time.Time
+	lastError string
+}
+
+// alive 判断进程是否仍在运行
+func (w *worker) alive() bool {
+	if w.cmd == nil {
+		return false
+	}
+	select {
+	case <-w.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// ensureRunning 进程已退出时按退避时间重新启动，宿主停止后返回ErrHostStopped
+func (w *worker) ensureRunning() error {
+	if w.alive() {
+		return nil
+	}
+	if w.host.isStopped() {
+		return fmt.Errorf("plugin %s: %w", w.host.name, ErrHostStopped)
+	}
+	if wait := time.Until(w.retryAt); wait > 0 {
+		return fmt.Errorf("plugin %s worker %d is restarting, retry after %s",
+			w.host.name, w.index, wait.Round(time.Millisecond))
+	}
+
+	w.mu.Lock()
+	if w.cmd != nil {
+		w.restarts++
+	}
+	w.mu.Unlock()
+
+	if err := w.start(); err != nil {
+		w.retryAt = time.Now().Add(w.backoff)
+		w.backoff *= 2
+		if w.backoff > w.host.config.MaxRestartBackoff {
+			w.backoff = w.host.config.MaxRestartBackoff
+		}
+		return err
+	}
+	return nil
+}
+
+// start 启动进程并握手
+func (w *worker) start() error {
+	cfg := w.host.config
+	cmd := exec.Command(cfg.Command, cfg.Args...)
+	cmd.Dir = cfg.Dir
+	cmd.Env = append(os.Environ(), cfg.Env...)
+	cmd.Stderr = &logWriter{prefix: fmt.Sprintf("[plugin %s/%d] ", w.host.name, w.index)}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		w.setError(err)
+		return err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Printf("Plugin %s worker %d exited: %v", w.host.name, w.index, err)
+		}
+		close(exited)
+	}()
+
+	w.cmd = cmd
+	w.stdin = stdin
+	w.stdout = stdout
+	w.mu.Lock()
+	w.exited = exited
+	w.pid = cmd.Process.Pid
+	w.startedAt = time.Now()
+	w.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.StartTimeout)
+	defer cancel()
+
+	var hs HandshakeResult
+	if err := w.call(ctx, MethodHandshake, HandshakeParams{ProtocolVersion: ProtocolVersion}, &hs); err != nil {
+		w.kill()
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+	if hs.ProtocolVersion != ProtocolVersion {
+		w.kill()
+		err := fmt.Errorf("plugin speaks protocol version %d, expected %d", hs.ProtocolVersion, ProtocolVersion)
+		w.setError(err)
+		return err
+	}
+
+	w.host.mu.Lock()
+	w.host.plugin = hs.Name
+	w.host.capabilities = hs.Capabilities
+	w.host.mu.Unlock()
+
+	w.backoff = cfg.RestartBackoff
+	w.setError(nil)
+	return nil
+}
+
+// call 发送请求并等待响应
+// 超时、读写失败或响应不匹配时终止进程，避免后续调用读到错位的响应
+func (w *worker) call(ctx context.Context, method string, params, result interface{}) error {
+	w.nextID++
+	req := Request{ID: w.nextID, Method: method, Params: params}
+
+	type reply struct {
+		resp Response
+		err  error
+	}
+	done := make(chan reply, 1)
+	go func() {
+		if err := WriteFrame(w.stdin, req); err != nil {
+			done <- reply{err: fmt.Errorf("failed to write request: %w", err)}
+			return
+		}
+		var resp Response
+		err := ReadFrame(w.stdout, &resp)
+		done <- reply{resp: resp, err: err}
+	}()
+
+	var r reply
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		w.kill()
+		<-done
+		err := fmt.Errorf("plugin %s call %s timed out", w.host.name, method)
+		w.setError(err)
+		return err
+	}
+
+	if r.err != nil {
+		w.kill()
+		err := fmt.Errorf("plugin %s call %s failed: %w", w.host.name, method, r.err)
+		w.setError(err)
+		return err
+	}
+	if r.resp.ID != req.ID {
+		w.kill()
+		err := fmt.Errorf("plugin %s returned response %d for request %d", w.host.name, r.resp.ID, req.ID)
+		w.setError(err)
+		return err
+	}
+
+	if r.resp.Error != nil {
+		return convertError(r.resp.Error)
+	}
+	if result != nil && len(r.resp.Result) > 0 {
+		if err := json.Unmarshal(r.resp.Result, result); err != nil {
+			return fmt.Errorf("plugin %s returned invalid %s result: %w", w.host.name, method, err)
+		}
+	}
+	return nil
+}
+
+// stop 关闭标准输入，让插件自行退出，超时后强制终止
+func (w *worker) stop() {
+	if !w.alive() {
+		return
+	}
+	w.stdin.Close()
+	select {
+	case <-w.exited:
+	case <-time.After(w.host.config.StartTimeout):
+		w.kill()
+	}
+}
+
+// kill 强制终止进程并等待退出
+func (w *worker) kill() {
+	if !w.alive() {
+		return
+	}
+	w.cmd.Process.Kill()
+	<-w.exited
+}
+
+// setError 记录最近一次错误，nil表示清除
+func (w *worker) setError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err == nil {
+		w.lastError = ""
+		return
+	}
+	w.lastError = err.Error()
+}
+
+// status 返回进程状态
+// running根据exited通道判断，可以在不持有worker时调用
+func (w *worker) status() WorkerStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	s := WorkerStatus{
+		Index:     w.index,
+		Restarts:  w.restarts,
+		StartedAt: w.startedAt,
+		LastError: w.lastError,
+	}
+	if w.pid != 0 && w.exited != nil {
+		select {
+		case <-w.exited:
+		default:
+			s.Running = true
+			s.PID = w.pid
+		}
+	}
+	return s
+}
+
+// convertError 将插件错误码转换为服务器中对应的错误
+func convertError(e *Error) error {
+	switch e.Code {
+	case CodeQuarantined:
+		return fmt.Errorf("%w: %s", models.ErrMessageQuarantined, e.Message)
+	case CodePending:
+		return fmt.Errorf("%w: %s", models.ErrMessagePending, e.Message)
+	default:
+		return e
+	}
+}
+
+// logWriter 将插件的标准错误按行写入日志
+type logWriter struct {
+	prefix string
+	mu     sync.Mutex
+	buf    bytes.Buffer
+}
+
+// Write 实现io.Writer接口
+func (l *logWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf.Write(p)
+	for {
+		line, err := l.buf.ReadString('\n')
+		if err != nil {
+			// 不完整的行留到下次写入
+			l.buf.Reset()
+			l.buf.WriteString(line)
+			break
+		}
+		log.Print(l.prefix + strings.TrimRight(line, "\r\n"))
+	}
+	return len(p), nil
+}
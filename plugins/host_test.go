@@ -0,0 +1,223 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试使用测试二进制本身作为插件进程：设置了PLUGIN_TEST_MODE时，
+// TestHelperPlugin按模式实现插件协议，否则直接返回
+
+// helperPlugin 以指定模式运行测试插件的配置
+func helperPlugin(mode string) Config {
+	return Config{
+		Command:           os.Args[0],
+		Args:              []string{"-test.run=^TestHelperPlugin$"},
+		Env:               []string{"PLUGIN_TEST_MODE=" + mode},
+		PoolSize:          1,
+		CallTimeout:       time.Second,
+		StartTimeout:      time.Second,
+		RestartBackoff:    10 * time.Millisecond,
+		MaxRestartBackoff: 50 * time.Millisecond,
+	}
+}
+
+func TestHelperPlugin(t *testing.T) {
+	mode := os.Getenv("PLUGIN_TEST_MODE")
+	if mode == "" {
+		return
+	}
+	defer os.Exit(0)
+
+	for {
+		var req struct {
+			ID     uint64        `json:"id"`
+			Method string        `json:"method"`
+			Params MessageParams `json:"params"`
+		}
+		if err := ReadFrame(os.Stdin, &req); err != nil {
+			return
+		}
+		resp := map[string]interface{}{"id": req.ID}
+		switch req.Method {
+		case MethodHandshake:
+			switch mode {
+			case "silent":
+				hang()
+			case "old":
+				resp["result"] = HandshakeResult{Name: "helper", ProtocolVersion: ProtocolVersion + 1}
+			default:
+				resp["result"] = HandshakeResult{Name: "helper", ProtocolVersion: ProtocolVersion, Capabilities: []string{CapabilityValidate}}
+			}
+		case MethodValidate:
+			if req.Params.Message == "bad" {
+				resp["error"] = Error{Code: CodeInvalid, Message: "bad message"}
+			} else {
+				resp["result"] = struct{}{}
+			}
+		case MethodProcess:
+			switch req.Params.Message {
+			case "crash":
+				os.Exit(1)
+			case "hang":
+				hang()
+			case "hold":
+				resp["error"] = Error{Code: CodeQuarantined, Message: "held for review"}
+			default:
+				resp["result"] = ProcessResult{Result: strings.ToUpper(req.Params.Message)}
+			}
+		default:
+			resp["result"] = struct{}{}
+		}
+		WriteFrame(os.Stdout, resp)
+	}
+}
+
+// hang 模拟没有响应的插件
+// 插件进程中只有一个协程，select{}会被运行时判定为死锁而退出，因此用Sleep阻塞
+func hang() {
+	time.Sleep(time.Hour)
+}
+
+// startHost 启动测试插件宿主，测试结束时停止
+func startHost(t *testing.T, config Config) *Host {
+	t.Helper()
+	h, err := NewHost("helper", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Stop)
+	return h
+}
+
+// worker0 返回第一个插件进程的状态
+func worker0(h *Host) WorkerStatus {
+	return h.State().(HostState).Workers[0]
+}
+
+func TestHostHandshake(t *testing.T) {
+	tests := []struct {
+		mode string
+		err  string
+	}{
+		{"ok", ""},
+		{"old", "protocol version"},
+		{"silent", "handshake failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			config := helperPlugin(tt.mode)
+			config.StartTimeout = 200 * time.Millisecond
+			h, err := NewHost("helper", config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = h.Start(context.Background())
+			if tt.err == "" {
+				defer h.Stop()
+				if err != nil {
+					t.Fatal(err)
+				}
+				state := h.State().(HostState)
+				if state.Plugin != "helper" || len(state.Capabilities) != 1 || !state.Workers[0].Running {
+					t.Errorf("state %+v", state)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Start: %v, want %q", err, tt.err)
+			}
+			if worker0(h).Running {
+				t.Error("plugin process left running after a failed handshake")
+			}
+		})
+	}
+}
+
+func TestHostProcess(t *testing.T) {
+	h := startHost(t, helperPlugin("ok"))
+
+	if result, err := h.ProcessMessage("hello"); err != nil || result != "HELLO" {
+		t.Errorf("process: %q, %v", result, err)
+	}
+	if err := h.ValidateMessage("bad"); err == nil || !strings.Contains(err.Error(), "bad message") {
+		t.Errorf("validate: %v", err)
+	}
+	if _, err := h.ProcessMessage("hold"); !strings.Contains(err.Error(), "quarantined") {
+		t.Errorf("quarantine error not converted: %v", err)
+	}
+}
+
+func TestHostRestartsCrashedPlugin(t *testing.T) {
+	h := startHost(t, helperPlugin("ok"))
+	pid := worker0(h).PID
+
+	if _, err := h.ProcessMessage("crash"); err == nil {
+		t.Fatal("crashed call succeeded")
+	}
+	if worker0(h).Running {
+		t.Fatal("crashed process reported as running")
+	}
+
+	// 退避时间内不重启，之后的调用重新启动进程
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		result, err := h.ProcessMessage("again")
+		if err == nil {
+			if result != "AGAIN" {
+				t.Errorf("result after restart: %q", result)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("plugin not restarted: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := worker0(h); status.Restarts != 1 || status.PID == pid || !status.Running {
+		t.Errorf("status after restart %+v, old pid %d", status, pid)
+	}
+}
+
+func TestHostKillsTimedOutCall(t *testing.T) {
+	config := helperPlugin("ok")
+	config.CallTimeout = 50 * time.Millisecond
+	h := startHost(t, config)
+
+	start := time.Now()
+	_, err := h.ProcessMessage("hang")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("hung call: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("hung call returned after %s", elapsed)
+	}
+	if status := worker0(h); status.Running || !strings.Contains(status.LastError, "timed out") {
+		t.Errorf("hung process not killed: %+v", status)
+	}
+}
+
+func TestHostRejectsCallsAfterStop(t *testing.T) {
+	h, err := NewHost("helper", helperPlugin("ok"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	h.Stop()
+
+	if _, err := h.ProcessMessage("hello"); !errors.Is(err, ErrHostStopped) {
+		t.Errorf("call after Stop: %v, want ErrHostStopped", err)
+	}
+	if status := worker0(h); status.Running || status.Restarts != 0 {
+		t.Errorf("plugin process started after Stop: %+v", status)
+	}
+}
@@ -0,0 +1,145 @@
+package plugins
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 插件协议
+// 插件是独立的可执行文件，通过标准输入输出与服务器通信，标准错误输出写入服务器日志。
+// 每一帧由4字节大端序长度和JSON正文组成。服务器发送请求，插件按顺序返回响应：
+//
+//	请求 {"id": 1, "method": "process", "params": {"message": "..."}}
+//	响应 {"id": 1, "result": {"result": "...", "metadata": {...}}}
+//	错误 {"id": 1, "error": {"code": "invalid", "message": "..."}}
+//
+// 方法：
+//   - handshake 启动后的第一个调用，参数为{"protocol_version": 1}，
+//     结果为{"name": "...", "protocol_version": 1, "capabilities": ["validate"]}
+//   - health 健康检查，结果为任意对象
+//   - validate 参数为{"message": "..."}，消息有效时结果为空对象，仅在声明validate能力时调用
+//   - process 参数为{"message": "..."}，结果为{"result": "...", "metadata": {...}}
+
+// ProtocolVersion 当前协议版本
+const ProtocolVersion = 1
+
+// MaxFrameSize 单帧正文的大小上限
+const MaxFrameSize = 16 << 20
+
+// 协议方法
+const (
+	MethodHandshake = "handshake"
+	MethodHealth    = "health"
+	MethodValidate  = "validate"
+	MethodProcess   = "process"
+)
+
+// CapabilityValidate 插件实现了validate方法
+const CapabilityValidate = "validate"
+
+// 错误码，插件返回的错误码会转换为服务器中对应的错误
+const (
+	// CodeInvalid 消息无效
+	CodeInvalid = "invalid"
+	// CodeQuarantined 消息需要隔离，对应models.ErrMessageQuarantined
+	CodeQuarantined = "quarantined"
+	// CodePending 消息已接收但尚不能产生结果，对应models.ErrMessagePending
+	CodePending = "pending"
+	// CodeInternal 插件内部错误
+	CodeInternal = "internal"
+)
+
+// Request 请求帧
+type Request struct {
+	ID     uint64      `json:"id"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params,omitempty"`
+}
+
+// Response 响应帧
+type Response struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *Error          `json:"error,omitempty"`
+}
+
+// Error 插件返回的错误
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error 实现error接口
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// HandshakeParams 握手参数
+type HandshakeParams struct {
+	ProtocolVersion int `json:"protocol_version"`
+}
+
+// HandshakeResult 握手结果
+type HandshakeResult struct {
+	Name            string   `json:"name"`
+	ProtocolVersion int      `json:"protocol_version"`
+	Capabilities    []string `json:"capabilities"`
+}
+
+// MessageParams validate和process方法的参数
+type MessageParams struct {
+	Message string `json:"message"`
+}
+
+// ProcessResult process方法的结果
+type ProcessResult struct {
+	Result   string                 `json:"result"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// errFrameTooLarge 帧长度超过上限
+var errFrameTooLarge = errors.New("plugin frame exceeds size limit")
+
+// WriteFrame 写入一帧
+func WriteFrame(w io.Writer, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(body) > MaxFrameSize {
+		return errFrameTooLarge
+	}
+
+	frame := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)))
+	copy(frame[4:], body)
+	_, err = w.Write(frame)
+	return err
+}
+
+// ReadFrame 读取一帧并解码到v
+func ReadFrame(r io.Reader, v interface{}) error {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > MaxFrameSize {
+		return errFrameTooLarge
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("invalid plugin frame: %w", err)
+	}
+	return nil
+}
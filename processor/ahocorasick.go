@@ -0,0 +1,124 @@
+package processor
+
+import "sort"
+
+// Matcher Aho-Corasick多模式匹配器
+// 一次扫描即可找出文本中所有关键词的出现位置，复杂度与文本长度和匹配数量成正比
+// 按字节匹配，ASCII字母不区分大小写，其他UTF-8字符按原样比较
+
+// Match 一次匹配结果
+type Match struct {
+	// Pattern 关键词在构造时传入的下标
+	Pattern int
+	// Start 和 End 是文本中的字节偏移
+	Start int
+	End   int
+}
+
+// acNode 字典树节点
+type acNode struct {
+	next map[byte]int
+	fail int
+	// out 以该节点结尾的关键词（包括沿失败链可达的）
+	out []int
+}
+
+// Matcher 匹配器结构体，构造后只读，可以并发使用
+type Matcher struct {
+	nodes   []acNode
+	lengths []int
+}
+
+// NewMatcher 根据关键词构造匹配器，空关键词会被忽略
+func NewMatcher(patterns []string) *Matcher {
+	m := &Matcher{
+		nodes:   []acNode{{next: make(map[byte]int)}},
+		lengths: make([]int, len(patterns)),
+	}
+
+	// 构建字典树
+	for i, p := range patterns {
+		m.lengths[i] = len(p)
+		if p == "" {
+			continue
+		}
+		cur := 0
+		for j := 0; j < len(p); j++ {
+			c := foldByte(p[j])
+			nxt, ok := m.nodes[cur].next[c]
+			if !ok {
+				nxt = len(m.nodes)
+				m.nodes = append(m.nodes, acNode{next: make(map[byte]int)})
+				m.nodes[cur].next[c] = nxt
+			}
+			cur = nxt
+		}
+		m.nodes[cur].out = append(m.nodes[cur].out, i)
+	}
+
+	// 广度优先计算失败指针
+	queue := make([]int, 0, len(m.nodes))
+	for _, child := range m.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		for c, child := range m.nodes[cur].next {
+			fail := m.nodes[cur].fail
+			for fail != 0 {
+				if _, ok := m.nodes[fail].next[c]; ok {
+					break
+				}
+				fail = m.nodes[fail].fail
+			}
+			if target, ok := m.nodes[fail].next[c]; ok && target != child {
+				m.nodes[child].fail = target
+			}
+			m.nodes[child].out = append(m.nodes[child].out, m.nodes[m.nodes[child].fail].out...)
+			queue = append(queue, child)
+		}
+	}
+
+	return m
+}
+
+// FindAll 返回文本中所有关键词的出现位置，按起始位置排序
+// 重叠的匹配都会返回
+func (m *Matcher) FindAll(text string) []Match {
+	var matches []Match
+	cur := 0
+	for i := 0; i < len(text); i++ {
+		c := foldByte(text[i])
+		for cur != 0 {
+			if _, ok := m.nodes[cur].next[c]; ok {
+				break
+			}
+			cur = m.nodes[cur].fail
+		}
+		if nxt, ok := m.nodes[cur].next[c]; ok {
+			cur = nxt
+		}
+		for _, p := range m.nodes[cur].out {
+			matches = append(matches, Match{
+				Pattern: p,
+				Start:   i + 1 - m.lengths[p],
+				End:     i + 1,
+			})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Start < matches[j].Start
+	})
+	return matches
+}
+
+// foldByte 将ASCII大写字母转换为小写，不改变字节长度
+func foldByte(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatcherFindAll(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		text     string
+		want     []Match
+	}{
+		{
+			name:     "classic overlapping",
+			patterns: []string{"he", "she", "his", "hers"},
+			text:     "ushers",
+			want:     []Match{{Pattern: 1, Start: 1, End: 4}, {Pattern: 0, Start: 2, End: 4}, {Pattern: 3, Start: 2, End: 6}},
+		},
+		{
+			name:     "case insensitive ASCII",
+			patterns: []string{"free"},
+			text:     "FREE Free fReE",
+			want:     []Match{{0, 0, 4}, {0, 5, 9}, {0, 10, 14}},
+		},
+		{
+			name:     "UTF-8 keywords",
+			patterns: []string{"优惠", "免费"},
+			text:     "限时免费，优惠多多",
+			want:     []Match{{1, 6, 12}, {0, 15, 21}},
+		},
+		{
+			name:     "pattern inside pattern via fail link",
+			patterns: []string{"abcd", "bc"},
+			text:     "xabcdx",
+			want:     []Match{{0, 1, 5}, {1, 2, 4}},
+		},
+		{
+			name:     "repeated matches",
+			patterns: []string{"aa"},
+			text:     "aaaa",
+			want:     []Match{{0, 0, 2}, {0, 1, 3}, {0, 2, 4}},
+		},
+		{
+			name:     "empty pattern ignored",
+			patterns: []string{"", "x"},
+			text:     "x",
+			want:     []Match{{1, 0, 1}},
+		},
+		{
+			name:     "no match",
+			patterns: []string{"abc"},
+			text:     "ababab",
+			want:     nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewMatcher(tt.patterns).FindAll(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindAll(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatcherAgreesWithNaiveSearch(t *testing.T) {
+	patterns := []string{"a", "ab", "bab", "bc", "bca", "c", "caa"}
+	text := strings.Repeat("abccab", 5) + "bcaab"
+	m := NewMatcher(patterns)
+
+	// 与逐个关键词的朴素查找比较匹配总数
+	want := 0
+	for _, p := range patterns {
+		for i := 0; i+len(p) <= len(text); i++ {
+			if text[i:i+len(p)] == p {
+				want++
+			}
+		}
+	}
+	got := m.FindAll(text)
+	if len(got) != want {
+		t.Fatalf("got %d matches, want %d", len(got), want)
+	}
+	for _, match := range got {
+		if text[match.Start:match.End] != patterns[match.Pattern] {
+			t.Errorf("match %v covers %q, want %q", match, text[match.Start:match.End], patterns[match.Pattern])
+		}
+	}
+}
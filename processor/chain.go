@@ -0,0 +1,196 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/example/message_processor/models"
+	"github.com/example/message_processor/trace"
+)
+
+// Chain 由多个处理阶段组成的处理器
+// 每个阶段的输出作为下一个阶段的输入，各阶段的元数据合并后一起返回
+
+// Stage 处理阶段，方法集与api.MessageProcessor一致
+type Stage interface {
+	ProcessMessage(msg string) (string, error)
+	ValidateMessage(msg string) error
+}
+
+// metadataStage 能够返回元数据的处理阶段
+type metadataStage interface {
+	ProcessMessageWithMetadata(msg string) (string, map[string]interface{}, error)
+}
+
+// tracingStage 能够记录执行过程的处理阶段
+type tracingStage interface {
+	ProcessMessageWithTrace(msg string, t *trace.Trace) (string, map[string]interface{}, error)
+}
+
+// tracingValidator 能够记录校验规则的处理阶段
+type tracingValidator interface {
+	ValidateMessageWithTrace(msg string, t *trace.Trace) error
+}
+
+// ruleValidator 能够列出所有违反的校验规则的处理阶段
+type ruleValidator interface {
+	ValidateRules(msg string) []models.Violation
+}
+
+// inputRedactor 能够给出可保存形式的处理阶段，如脱敏
+type inputRedactor interface {
+	RedactInput(msg string) string
+}
+
+// externalStage 处理时调用外部系统的处理阶段
+type externalStage interface {
+	External() bool
+}
+
+// NamedStage 带名称的处理阶段，名称用于错误信息和元数据
+type NamedStage struct {
+	Name  string
+	Stage Stage
+}
+
+// Chain 处理链结构体
+type Chain struct {
+	stages []NamedStage
+}
+
+// NewChain 创建新的处理链
+func NewChain(stages ...NamedStage) *Chain {
+	return &Chain{stages: stages}
+}
+
+// Stages 返回处理链中的所有阶段
+func (c *Chain) Stages() []NamedStage {
+	return c.stages
+}
+
+// ValidateMessage 使用第一个阶段验证原始消息
+// 后续阶段在处理时验证各自的输入
+func (c *Chain) ValidateMessage(msg string) error {
+	return c.ValidateMessageWithTrace(msg, nil)
+}
+
+// ValidateMessageWithTrace 使用第一个阶段验证原始消息并记录校验过程
+func (c *Chain) ValidateMessageWithTrace(msg string, t *trace.Trace) error {
+	if len(c.stages) == 0 {
+		if strings.TrimSpace(msg) == "" {
+			return fmt.Errorf("message cannot be empty")
+		}
+		return nil
+	}
+	return validateStage(c.stages[0], msg, t)
+}
+
+// ValidateRules 使用第一个阶段校验原始消息，返回所有违反的规则
+// 第一个阶段不能列出违反的规则时，校验错误作为一条违反项返回
+func (c *Chain) ValidateRules(msg string) []models.Violation {
+	if len(c.stages) == 0 {
+		if strings.TrimSpace(msg) == "" {
+			return []models.Violation{models.NewViolation("not_empty", "message cannot be empty")}
+		}
+		return nil
+	}
+
+	first := c.stages[0].Stage
+	if v, ok := first.(ruleValidator); ok {
+		return v.ValidateRules(msg)
+	}
+	return models.ErrorViolations(msg, first.ValidateMessage(msg))
+}
+
+// RedactInput 依次用实现了遮盖的阶段处理消息，返回可以保存的消息
+// 没有这样的阶段时原样返回
+func (c *Chain) RedactInput(msg string) string {
+	for _, s := range c.stages {
+		if r, ok := s.Stage.(inputRedactor); ok {
+			msg = r.RedactInput(msg)
+		}
+	}
+	return msg
+}
+
+// External 任一阶段调用外部系统时返回true
+func (c *Chain) External() bool {
+	for _, s := range c.stages {
+		if e, ok := s.Stage.(externalStage); ok && e.External() {
+			return true
+		}
+	}
+	return false
+}
+
+// ProcessMessage 依次执行所有阶段
+func (c *Chain) ProcessMessage(msg string) (string, error) {
+	result, _, err := c.ProcessMessageWithMetadata(msg)
+	return result, err
+}
+
+// ProcessMessageWithMetadata 依次执行所有阶段并合并元数据
+// 某个阶段失败时立即返回，已执行阶段的元数据仍然返回
+func (c *Chain) ProcessMessageWithMetadata(msg string) (string, map[string]interface{}, error) {
+	return c.ProcessMessageWithTrace(msg, nil)
+}
+
+// ProcessMessageWithTrace 依次执行所有阶段，记录每个阶段的校验、输入输出和耗时
+func (c *Chain) ProcessMessageWithTrace(msg string, t *trace.Trace) (string, map[string]interface{}, error) {
+	metadata := make(map[string]interface{})
+	names := make([]string, 0, len(c.stages))
+
+	current := msg
+	for i, s := range c.stages {
+		names = append(names, s.Name)
+		metadata["stages"] = names
+
+		step := t.Begin(trace.KindStage, s.Name, current)
+		if i > 0 {
+			if err := validateStage(s, current, t); err != nil {
+				err = fmt.Errorf("stage %s: %w", s.Name, err)
+				step.End("", err)
+				return "", metadata, err
+			}
+		}
+
+		var (
+			next      string
+			stageMeta map[string]interface{}
+			err       error
+		)
+		switch stage := s.Stage.(type) {
+		case tracingStage:
+			next, stageMeta, err = stage.ProcessMessageWithTrace(current, t)
+		case metadataStage:
+			next, stageMeta, err = stage.ProcessMessageWithMetadata(current)
+		default:
+			next, err = s.Stage.ProcessMessage(current)
+		}
+		for k, v := range stageMeta {
+			metadata[k] = v
+			step.Set(k, v)
+		}
+		step.End(next, err)
+		if err != nil {
+			return "", metadata, fmt.Errorf("stage %s: %w", s.Name, err)
+		}
+
+		current = next
+	}
+
+	return current, metadata, nil
+}
+
+// validateStage 校验阶段的输入，开启跟踪时记录校验过程
+func validateStage(s NamedStage, msg string, t *trace.Trace) error {
+	step := t.Begin(trace.KindValidate, s.Name, "")
+	var err error
+	if v, ok := s.Stage.(tracingValidator); ok {
+		err = v.ValidateMessageWithTrace(msg, t)
+	} else {
+		err = s.Stage.ValidateMessage(msg)
+	}
+	step.End("", err)
+	return err
+}
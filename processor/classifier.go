@@ -0,0 +1,369 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/example/message_processor/models"
+	"github.com/example/message_processor/trace"
+)
+
+// Classifier 关键词与垃圾消息分类处理器
+// 使用可配置的关键词词典（Aho-Corasick多模式匹配）和简单的垃圾消息启发式规则
+// （重复度、URL密度、大写字母比例）为消息打标签和评分。
+// 作为处理阶段时原样输出消息，分类结果通过元数据返回；
+// 分数超过阈值的消息会在校验时被拒绝，或在处理时被隔离
+
+// KeywordDictionary 关键词词典
+type KeywordDictionary struct {
+	// Tag 命中时附加的标签
+	Tag string `json:"tag"`
+	// Keywords 关键词列表，ASCII字母不区分大小写
+	Keywords []string `json:"keywords"`
+	// Weight 每次命中累加的分数
+	Weight float64 `json:"weight"`
+}
+
+// ClassifierConfig 分类器配置
+type ClassifierConfig struct {
+	Dictionaries []KeywordDictionary `json:"dictionaries"`
+
+	// CapsRatio 大写字母占字母比例超过该值时触发，0表示禁用
+	CapsRatio float64 `json:"caps_ratio"`
+	// URLDensity URL数量占词数比例超过该值时触发，0表示禁用
+	URLDensity float64 `json:"url_density"`
+	// Repetition 重复词占比超过该值时触发，0表示禁用
+	Repetition float64 `json:"repetition"`
+	// HeuristicWeight 每条启发式规则触发时累加的分数
+	HeuristicWeight float64 `json:"heuristic_weight"`
+
+	// QuarantineThreshold 总分达到该值时隔离消息，0表示禁用
+	QuarantineThreshold float64 `json:"quarantine_threshold"`
+	// RejectThreshold 总分达到该值时拒绝消息，0表示禁用
+	RejectThreshold float64 `json:"reject_threshold"`
+}
+
+// DefaultClassifierConfig 返回默认分类器配置，不包含关键词词典
+func DefaultClassifierConfig() ClassifierConfig {
+	return ClassifierConfig{
+		CapsRatio:       0.7,
+		URLDensity:      0.3,
+		Repetition:      0.5,
+		HeuristicWeight: 1,
+	}
+}
+
+// Verdict 分类结论
+type Verdict string
+
+const (
+	VerdictAccept     Verdict = "accept"
+	VerdictQuarantine Verdict = "quarantine"
+	VerdictReject     Verdict = "reject"
+)
+
+// KeywordMatch 关键词命中位置
+type KeywordMatch struct {
+	Tag   string `json:"tag"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// Classification 分类结果
+type Classification struct {
+	Tags    []string           `json:"tags"`
+	Scores  map[string]float64 `json:"scores"`
+	Score   float64            `json:"score"`
+	Verdict Verdict            `json:"verdict"`
+	Matches []KeywordMatch     `json:"matches,omitempty"`
+}
+
+// urlPattern 用于统计URL密度
+var urlPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// Classifier 分类器结构体
+type Classifier struct {
+	config  ClassifierConfig
+	matcher *Matcher
+	// keywords 匹配器中每个关键词对应的词典
+	keywords []*KeywordDictionary
+}
+
+// NewClassifier 创建新的分类器
+func NewClassifier(config ClassifierConfig) (*Classifier, error) {
+	if config.RejectThreshold > 0 && config.QuarantineThreshold > config.RejectThreshold {
+		return nil, fmt.Errorf("quarantine threshold must not exceed reject threshold")
+	}
+
+	var patterns []string
+	var keywords []*KeywordDictionary
+	for i := range config.Dictionaries {
+		dict := &config.Dictionaries[i]
+		if dict.Tag == "" {
+			return nil, fmt.Errorf("keyword dictionary %d has no tag", i)
+		}
+		for _, kw := range dict.Keywords {
+			patterns = append(patterns, kw)
+			keywords = append(keywords, dict)
+		}
+	}
+
+	return &Classifier{
+		config:   config,
+		matcher:  NewMatcher(patterns),
+		keywords: keywords,
+	}, nil
+}
+
+// Classify 对消息打标签和评分
+func (c *Classifier) Classify(msg string) Classification {
+	result := Classification{
+		Scores:  make(map[string]float64),
+		Verdict: VerdictAccept,
+	}
+	tags := make(map[string]bool)
+
+	// 关键词词典
+	for _, m := range c.matcher.FindAll(msg) {
+		// 以ASCII字母数字开头或结尾的关键词要求整词匹配，避免"free"命中"freedom"
+		if !keywordBoundary(msg, m.Start, m.End) {
+			continue
+		}
+		dict := c.keywords[m.Pattern]
+		tags[dict.Tag] = true
+		result.Scores[dict.Tag] += dict.Weight
+		result.Score += dict.Weight
+		result.Matches = append(result.Matches, KeywordMatch{Tag: dict.Tag, Start: m.Start, End: m.End})
+	}
+
+	// 垃圾消息启发式规则
+	heuristics := []struct {
+		tag       string
+		value     float64
+		threshold float64
+	}{
+		{"spam:caps", capsRatio(msg), c.config.CapsRatio},
+		{"spam:urls", urlDensity(msg), c.config.URLDensity},
+		{"spam:repetition", repetitionRatio(msg), c.config.Repetition},
+	}
+	for _, h := range heuristics {
+		result.Scores[h.tag] = h.value
+		if h.threshold > 0 && h.value >= h.threshold {
+			tags[h.tag] = true
+			result.Score += c.config.HeuristicWeight
+		}
+	}
+
+	for tag := range tags {
+		result.Tags = append(result.Tags, tag)
+	}
+	sort.Strings(result.Tags)
+
+	switch {
+	case c.config.RejectThreshold > 0 && result.Score >= c.config.RejectThreshold:
+		result.Verdict = VerdictReject
+	case c.config.QuarantineThreshold > 0 && result.Score >= c.config.QuarantineThreshold:
+		result.Verdict = VerdictQuarantine
+	}
+
+	return result
+}
+
+// ProcessMessage 原样返回消息，需要隔离时返回models.ErrMessageQuarantined
+func (c *Classifier) ProcessMessage(msg string) (string, error) {
+	result, _, err := c.ProcessMessageWithMetadata(msg)
+	return result, err
+}
+
+// ProcessMessageWithMetadata 原样返回消息和分类结果
+func (c *Classifier) ProcessMessageWithMetadata(msg string) (string, map[string]interface{}, error) {
+	return c.ProcessMessageWithTrace(msg, nil)
+}
+
+// ProcessMessageWithTrace 原样返回消息和分类结果，记录命中的关键词、启发式规则和判定
+func (c *Classifier) ProcessMessageWithTrace(msg string, t *trace.Trace) (string, map[string]interface{}, error) {
+	classification := c.Classify(msg)
+	c.traceClassification(t, classification)
+	metadata := map[string]interface{}{"classification": classification}
+
+	switch classification.Verdict {
+	case VerdictReject:
+		return "", metadata, fmt.Errorf("message rejected by classifier (score %.2f)", classification.Score)
+	case VerdictQuarantine:
+		return "", metadata, models.ErrMessageQuarantined
+	}
+	return msg, metadata, nil
+}
+
+// ValidateMessage 验证消息，分数达到拒绝阈值的消息不予处理
+func (c *Classifier) ValidateMessage(msg string) error {
+	return c.ValidateMessageWithTrace(msg, nil)
+}
+
+// ValidateMessageWithTrace 验证消息并记录校验规则
+func (c *Classifier) ValidateMessageWithTrace(msg string, t *trace.Trace) error {
+	if strings.TrimSpace(msg) == "" {
+		t.Event(trace.KindRule, "not_empty", trace.OutcomeFail, nil)
+		return fmt.Errorf("message cannot be empty")
+	}
+	t.Event(trace.KindRule, "not_empty", trace.OutcomePass, nil)
+
+	if c.config.RejectThreshold > 0 {
+		classification := c.Classify(msg)
+		c.traceClassification(t, classification)
+		if classification.Verdict == VerdictReject {
+			return fmt.Errorf("message rejected as %s (score %.2f)",
+				strings.Join(classification.Tags, ", "), classification.Score)
+		}
+	}
+	return nil
+}
+
+// ValidateRules 校验消息并返回所有违反的规则
+// 消息会被拒绝时，除判定本身外还列出每个命中的关键词和URL的位置，以及触发的其他启发式规则
+func (c *Classifier) ValidateRules(msg string) []models.Violation {
+	if strings.TrimSpace(msg) == "" {
+		return []models.Violation{models.NewViolation("not_empty", "message cannot be empty")}
+	}
+	if c.config.RejectThreshold <= 0 {
+		return nil
+	}
+
+	classification := c.Classify(msg)
+	if classification.Verdict != VerdictReject {
+		return nil
+	}
+	violations := []models.Violation{models.NewViolation("classifier:reject",
+		fmt.Sprintf("message rejected as %s (score %.2f)", strings.Join(classification.Tags, ", "), classification.Score))}
+	for _, m := range classification.Matches {
+		violations = append(violations, models.NewSpanViolation(msg, "keywords:"+m.Tag,
+			fmt.Sprintf("matched %s keyword %q", m.Tag, msg[m.Start:m.End]), m.Start, m.End))
+	}
+	for _, tag := range classification.Tags {
+		switch tag {
+		case "spam:urls":
+			for _, loc := range urlPattern.FindAllStringIndex(msg, -1) {
+				violations = append(violations, models.NewSpanViolation(msg, tag, "too many URLs", loc[0], loc[1]))
+			}
+		case "spam:caps":
+			violations = append(violations, models.NewViolation(tag, fmt.Sprintf(
+				"capital letter ratio %.2f exceeds %.2f", classification.Scores[tag], c.config.CapsRatio)))
+		case "spam:repetition":
+			violations = append(violations, models.NewViolation(tag, fmt.Sprintf(
+				"repeated word ratio %.2f exceeds %.2f", classification.Scores[tag], c.config.Repetition)))
+		}
+	}
+	return violations
+}
+
+// traceClassification 记录分类过程：各词典命中的关键词、启发式规则的取值与阈值，以及最终判定
+func (c *Classifier) traceClassification(t *trace.Trace, result Classification) {
+	if t == nil {
+		return
+	}
+
+	for i := range c.config.Dictionaries {
+		dict := &c.config.Dictionaries[i]
+		var positions [][2]int
+		for _, m := range result.Matches {
+			if m.Tag == dict.Tag {
+				positions = append(positions, [2]int{m.Start, m.End})
+			}
+		}
+		outcome := trace.OutcomeNoMatch
+		if len(positions) > 0 {
+			outcome = trace.OutcomeMatch
+		}
+		t.Event(trace.KindRule, "keywords:"+dict.Tag, outcome, map[string]interface{}{
+			"weight":  dict.Weight,
+			"matches": positions,
+		})
+	}
+
+	for _, h := range []struct {
+		tag       string
+		threshold float64
+	}{
+		{"spam:caps", c.config.CapsRatio},
+		{"spam:urls", c.config.URLDensity},
+		{"spam:repetition", c.config.Repetition},
+	} {
+		value := result.Scores[h.tag]
+		outcome := trace.OutcomeNoMatch
+		switch {
+		case h.threshold <= 0:
+			outcome = trace.OutcomeSkipped
+		case value >= h.threshold:
+			outcome = trace.OutcomeMatch
+		}
+		t.Event(trace.KindRule, h.tag, outcome, map[string]interface{}{
+			"value":     value,
+			"threshold": h.threshold,
+		})
+	}
+
+	t.Event(trace.KindRoute, "verdict", string(result.Verdict), map[string]interface{}{
+		"score":                result.Score,
+		"quarantine_threshold": c.config.QuarantineThreshold,
+		"reject_threshold":     c.config.RejectThreshold,
+	})
+}
+
+// keywordBoundary 关键词两端是ASCII字母数字时，要求文本中相邻位置不是字母数字
+func keywordBoundary(s string, start, end int) bool {
+	if isAlnum(s[start]) && start > 0 && isAlnum(s[start-1]) {
+		return false
+	}
+	if isAlnum(s[end-1]) && end < len(s) && isAlnum(s[end]) {
+		return false
+	}
+	return true
+}
+
+// capsRatio 大写字母占字母的比例，字母太少时返回0
+func capsRatio(s string) float64 {
+	letters, upper := 0, 0
+	for _, r := range s {
+		if !unicode.IsLetter(r) || !unicode.In(r, unicode.Latin, unicode.Greek, unicode.Cyrillic) {
+			continue
+		}
+		letters++
+		if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+	if letters < 10 {
+		return 0
+	}
+	return float64(upper) / float64(letters)
+}
+
+// urlDensity URL数量占词数的比例
+func urlDensity(s string) float64 {
+	words := len(strings.Fields(s))
+	if words == 0 {
+		return 0
+	}
+	urls := len(urlPattern.FindAllStringIndex(s, -1))
+	return float64(urls) / float64(words)
+}
+
+// repetitionRatio 重复出现的词占总词数的比例，词太少时返回0
+func repetitionRatio(s string) float64 {
+	words := strings.Fields(strings.ToLower(s))
+	if len(words) < 5 {
+		return 0
+	}
+	seen := make(map[string]bool, len(words))
+	repeated := 0
+	for _, w := range words {
+		if seen[w] {
+			repeated++
+		}
+		seen[w] = true
+	}
+	return float64(repeated) / float64(len(words))
+}
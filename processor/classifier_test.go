@@ -0,0 +1,90 @@
+package processor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/example/message_processor/models"
+)
+
+func testClassifier(t *testing.T) *Classifier {
+	t.Helper()
+	config := DefaultClassifierConfig()
+	config.Dictionaries = []KeywordDictionary{
+		{Tag: "promo", Keywords: []string{"free", "优惠"}, Weight: 1},
+		{Tag: "scam", Keywords: []string{"wire transfer"}, Weight: 3},
+	}
+	config.QuarantineThreshold = 2
+	config.RejectThreshold = 3
+	c, err := NewClassifier(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClassifyVerdicts(t *testing.T) {
+	c := testClassifier(t)
+
+	tests := []struct {
+		msg   string
+		score float64
+		want  Verdict
+	}{
+		{"hello there", 0, VerdictAccept},
+		{"free stuff", 1, VerdictAccept},
+		// 整词匹配，freedom不命中free
+		{"freedom is free", 1, VerdictAccept},
+		{"free free 优惠", 3, VerdictReject},
+		{"free 优惠", 2, VerdictQuarantine},
+		{"please send a WIRE TRANSFER", 3, VerdictReject},
+	}
+	for _, tt := range tests {
+		got := c.Classify(tt.msg)
+		if got.Score != tt.score || got.Verdict != tt.want {
+			t.Errorf("Classify(%q) = score %v verdict %s, want %v %s", tt.msg, got.Score, got.Verdict, tt.score, tt.want)
+		}
+	}
+}
+
+func TestClassifierProcess(t *testing.T) {
+	c := testClassifier(t)
+
+	if out, _, err := c.ProcessMessageWithMetadata("hello"); err != nil || out != "hello" {
+		t.Errorf("accepted message: got %q, %v", out, err)
+	}
+	if _, _, err := c.ProcessMessageWithMetadata("free 优惠"); !errors.Is(err, models.ErrMessageQuarantined) {
+		t.Errorf("quarantined message: got %v", err)
+	}
+	if err := c.ValidateMessage("wire transfer now"); err == nil {
+		t.Error("rejected message passed validation")
+	}
+}
+
+func TestClassifierHeuristics(t *testing.T) {
+	c := testClassifier(t)
+
+	caps := c.Classify("BUY THIS AMAZING PRODUCT TODAY")
+	if caps.Scores["spam:caps"] != 1 || len(caps.Tags) != 1 || caps.Tags[0] != "spam:caps" {
+		t.Errorf("caps: %+v", caps)
+	}
+	urls := c.Classify("see http://a.example http://b.example")
+	if len(urls.Tags) != 1 || urls.Tags[0] != "spam:urls" {
+		t.Errorf("urls: %+v", urls)
+	}
+}
+
+func TestNewClassifierRejectsBadConfig(t *testing.T) {
+	config := DefaultClassifierConfig()
+	config.QuarantineThreshold = 5
+	config.RejectThreshold = 3
+	if _, err := NewClassifier(config); err == nil {
+		t.Error("quarantine above reject threshold accepted")
+	}
+
+	config = DefaultClassifierConfig()
+	config.Dictionaries = []KeywordDictionary{{Keywords: []string{"x"}}}
+	if _, err := NewClassifier(config); err == nil {
+		t.Error("dictionary without tag accepted")
+	}
+}
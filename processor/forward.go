@@ -0,0 +1,440 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/example/message_processor/circuit"
+	"github.com/example/message_processor/utils"
+)
+
+// Forwarder HTTP转发处理器
+// 将消息发送到配置的上游服务，并以上游的响应作为处理结果。
+// 请求体和处理结果可以用text/template模板映射；每个转发器有独立的连接池、
+// 超时、重试和熔断器，一个上游故障不会影响其他上游
+
+// ForwarderConfig 转发配置
+type ForwarderConfig struct {
+	// URL 上游地址
+	URL string `json:"url"`
+	// Method 请求方法，默认POST
+	Method string `json:"method"`
+	// Headers 附加的请求头
+	Headers map[string]string `json:"headers"`
+	// ContentType 请求体的类型，默认text/plain; charset=utf-8
+	ContentType string `json:"content_type"`
+
+	// RequestTemplate 请求体模板，为空时直接发送消息
+	// 模板数据为RequestData
+	RequestTemplate string `json:"request_template"`
+	// ResponseTemplate 处理结果模板，为空时直接使用响应体
+	// 模板数据为ResponseData
+	ResponseTemplate string `json:"response_template"`
+
+	// Timeout 单次请求的超时时间
+	Timeout time.Duration `json:"timeout"`
+	// Retries 失败后的重试次数，只重试网络错误、429和5xx响应
+	Retries int `json:"retries"`
+	// RetryBackoff 第一次重试前的等待时间，之后每次翻倍
+	RetryBackoff time.Duration `json:"retry_backoff"`
+	// MaxRetryBackoff 重试等待时间的上限，也是Retry-After响应头的上限
+	MaxRetryBackoff time.Duration `json:"max_retry_backoff"`
+
+	// MaxIdleConns 连接池中保持的空闲连接数
+	MaxIdleConns int `json:"max_idle_conns"`
+	// MaxConnsPerHost 到上游的最大连接数，0表示不限制
+	MaxConnsPerHost int `json:"max_conns_per_host"`
+	// IdleConnTimeout 空闲连接的保持时间
+	IdleConnTimeout time.Duration `json:"idle_conn_timeout"`
+
+	// MaxResponseSize 响应体大小上限
+	MaxResponseSize int64 `json:"max_response_size"`
+
+	Breaker circuit.Config `json:"breaker"`
+}
+
+// UnmarshalJSON 自定义JSON反序列化方法
+// 时长字段可以是time.ParseDuration格式的字符串（如"5s"），也可以是纳秒数
+func (c *ForwarderConfig) UnmarshalJSON(data []byte) error {
+	type Alias ForwarderConfig
+	aux := &struct {
+		*Alias
+		Timeout         json.RawMessage `json:"timeout"`
+		RetryBackoff    json.RawMessage `json:"retry_backoff"`
+		MaxRetryBackoff json.RawMessage `json:"max_retry_backoff"`
+		IdleConnTimeout json.RawMessage `json:"idle_conn_timeout"`
+	}{
+		Alias: (*Alias)(c),
+	}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	return utils.SetJSONDurations(
+		utils.DurationField{Name: "timeout", Value: aux.Timeout, Dest: &c.Timeout},
+		utils.DurationField{Name: "retry_backoff", Value: aux.RetryBackoff, Dest: &c.RetryBackoff},
+		utils.DurationField{Name: "max_retry_backoff", Value: aux.MaxRetryBackoff, Dest: &c.MaxRetryBackoff},
+		utils.DurationField{Name: "idle_conn_timeout", Value: aux.IdleConnTimeout, Dest: &c.IdleConnTimeout},
+	)
+}
+
+// DefaultForwarderConfig 返回默认转发配置，URL需要另行设置
+func DefaultForwarderConfig() ForwarderConfig {
+	return ForwarderConfig{
+		Method:          http.MethodPost,
+		ContentType:     "text/plain; charset=utf-8",
+		Timeout:         5 * time.Second,
+		Retries:         2,
+		RetryBackoff:    100 * time.Millisecond,
+		MaxRetryBackoff: 2 * time.Second,
+		MaxIdleConns:    16,
+		MaxConnsPerHost: 64,
+		IdleConnTimeout: 90 * time.Second,
+		MaxResponseSize: 1 << 20,
+		Breaker:         circuit.DefaultConfig(),
+	}
+}
+
+// RequestData 请求模板的数据
+type RequestData struct {
+	// Message 原始消息
+	Message string
+	// JSON 消息解析为JSON后的值，消息不是JSON时为nil
+	JSON interface{}
+}
+
+// ResponseData 结果模板的数据
+type ResponseData struct {
+	Status  int
+	Headers http.Header
+	Body    string
+	// JSON 响应体解析为JSON后的值，响应体不是JSON时为nil
+	JSON interface{}
+}
+
+// UpstreamError 上游返回非2xx状态
+type UpstreamError struct {
+	Status int
+	Body   string
+}
+
+// Error 实现error接口
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("upstream returned status %d", e.Status)
+}
+
+// templateFuncs 模板中可用的函数
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"urlquery": url.QueryEscape,
+}
+
+// Forwarder 转发处理器结构体，可以并发使用
+type Forwarder struct {
+	config   ForwarderConfig
+	client   *http.Client
+	breaker  *circuit.Breaker
+	request  *template.Template
+	response *template.Template
+}
+
+// NewForwarder 创建新的转发处理器，未设置的配置项使用默认值
+func NewForwarder(name string, config ForwarderConfig) (*Forwarder, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream url: %q", config.URL)
+	}
+
+	defaults := DefaultForwarderConfig()
+	if config.Method == "" {
+		config.Method = defaults.Method
+	}
+	if config.ContentType == "" {
+		config.ContentType = defaults.ContentType
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	if config.Retries < 0 {
+		return nil, fmt.Errorf("retries must not be negative")
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = defaults.RetryBackoff
+	}
+	if config.MaxRetryBackoff <= 0 {
+		config.MaxRetryBackoff = defaults.MaxRetryBackoff
+	}
+	if config.MaxIdleConns <= 0 {
+		config.MaxIdleConns = defaults.MaxIdleConns
+	}
+	if config.IdleConnTimeout <= 0 {
+		config.IdleConnTimeout = defaults.IdleConnTimeout
+	}
+	if config.MaxResponseSize <= 0 {
+		config.MaxResponseSize = defaults.MaxResponseSize
+	}
+
+	f := &Forwarder{
+		config:  config,
+		breaker: circuit.NewBreaker(name, config.Breaker),
+	}
+
+	if config.RequestTemplate != "" {
+		f.request, err = template.New("request").Funcs(templateFuncs).Parse(config.RequestTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid request template: %w", err)
+		}
+	}
+	if config.ResponseTemplate != "" {
+		f.response, err = template.New("response").Funcs(templateFuncs).Parse(config.ResponseTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid response template: %w", err)
+		}
+	}
+
+	// 每个转发器使用独立的连接池
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   config.Timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          config.MaxIdleConns,
+		MaxIdleConnsPerHost:   config.MaxIdleConns,
+		MaxConnsPerHost:       config.MaxConnsPerHost,
+		IdleConnTimeout:       config.IdleConnTimeout,
+		TLSHandshakeTimeout:   config.Timeout,
+		ResponseHeaderTimeout: config.Timeout,
+	}
+	f.client = &http.Client{Transport: transport}
+
+	return f, nil
+}
+
+// Breaker 返回转发器的熔断器
+func (f *Forwarder) Breaker() *circuit.Breaker {
+	return f.breaker
+}
+
+// External 转发处理器会把消息发送到上游
+func (f *Forwarder) External() bool {
+	return true
+}
+
+// Close 关闭连接池中的空闲连接
+func (f *Forwarder) Close() {
+	f.client.CloseIdleConnections()
+}
+
+// Forward 将消息转发到上游，返回响应和实际的请求次数
+// 熔断器打开时不发送请求，返回*circuit.OpenError
+func (f *Forwarder) Forward(ctx context.Context, msg string) (*ResponseData, int, error) {
+	body, err := f.renderRequest(msg)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	call, err := f.breaker.Acquire()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var resp *ResponseData
+	attempts := 0
+	backoff := f.config.RetryBackoff
+	for {
+		attempts++
+		var wait time.Duration
+		resp, wait, err = f.attempt(ctx, body)
+		// 调用方已取消或超过截止时间时不再重试
+		if err == nil || attempts > f.config.Retries || ctx.Err() != nil || !retryable(resp, err) {
+			break
+		}
+
+		if wait <= 0 {
+			wait = backoff
+			backoff *= 2
+		}
+		if wait > f.config.MaxRetryBackoff {
+			wait = f.config.MaxRetryBackoff
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			call.Cancel()
+			return nil, attempts, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	// 调用方取消或超过截止时间时无法判断上游是否健康，不计入熔断
+	if err != nil && ctx.Err() != nil {
+		call.Cancel()
+		return resp, attempts, err
+	}
+
+	// 4xx说明上游工作正常，只是拒绝了这条消息，不计入熔断
+	var upstreamErr *UpstreamError
+	healthy := err == nil || (errors.As(err, &upstreamErr) && upstreamErr.Status < 500 && upstreamErr.Status != http.StatusTooManyRequests)
+	call.Done(healthy)
+
+	return resp, attempts, err
+}
+
+// ProcessMessage 转发消息，返回上游的处理结果
+func (f *Forwarder) ProcessMessage(msg string) (string, error) {
+	result, _, err := f.ProcessMessageWithMetadata(msg)
+	return result, err
+}
+
+// ProcessMessageWithMetadata 转发消息，元数据包含上游状态码、请求次数和耗时
+func (f *Forwarder) ProcessMessageWithMetadata(msg string) (string, map[string]interface{}, error) {
+	return f.ProcessMessageContext(context.Background(), msg)
+}
+
+// ProcessMessageContext 在ctx下转发消息，ctx取消或超时后停止请求和重试
+func (f *Forwarder) ProcessMessageContext(ctx context.Context, msg string) (string, map[string]interface{}, error) {
+	start := time.Now()
+	resp, attempts, err := f.Forward(ctx, msg)
+
+	upstream := map[string]interface{}{
+		"attempts":    attempts,
+		"duration_ms": time.Since(start).Milliseconds(),
+		"breaker":     f.breaker.State(),
+	}
+	if resp != nil {
+		upstream["status"] = resp.Status
+	}
+	metadata := map[string]interface{}{"upstream": upstream}
+
+	if err != nil {
+		return "", metadata, err
+	}
+
+	result, err := f.renderResponse(resp)
+	if err != nil {
+		return "", metadata, err
+	}
+	return result, metadata, nil
+}
+
+// ValidateMessage 验证消息，并检查请求模板能否渲染
+func (f *Forwarder) ValidateMessage(msg string) error {
+	if strings.TrimSpace(msg) == "" {
+		return fmt.Errorf("message cannot be empty")
+	}
+	_, err := f.renderRequest(msg)
+	return err
+}
+
+// attempt 发送一次请求
+// 返回的等待时间来自429或503响应的Retry-After头
+func (f *Forwarder) attempt(ctx context.Context, body []byte) (*ResponseData, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, f.config.Method, f.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", f.config.ContentType)
+	for k, v := range f.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("upstream request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.config.MaxResponseSize+1))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read upstream response: %w", err)
+	}
+	if int64(len(data)) > f.config.MaxResponseSize {
+		return nil, 0, fmt.Errorf("upstream response exceeds %d bytes", f.config.MaxResponseSize)
+	}
+
+	result := &ResponseData{
+		Status:  resp.StatusCode,
+		Headers: resp.Header,
+		Body:    string(data),
+	}
+	var parsed interface{}
+	if json.Unmarshal(data, &parsed) == nil {
+		result.JSON = parsed
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return result, retryAfter(resp), &UpstreamError{Status: resp.StatusCode, Body: result.Body}
+	}
+	return result, 0, nil
+}
+
+// renderRequest 生成请求体
+func (f *Forwarder) renderRequest(msg string) ([]byte, error) {
+	if f.request == nil {
+		return []byte(msg), nil
+	}
+
+	data := RequestData{Message: msg}
+	var parsed interface{}
+	if json.Unmarshal([]byte(msg), &parsed) == nil {
+		data.JSON = parsed
+	}
+
+	var buf bytes.Buffer
+	if err := f.request.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render request template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// renderResponse 生成处理结果
+func (f *Forwarder) renderResponse(resp *ResponseData) (string, error) {
+	if f.response == nil {
+		return resp.Body, nil
+	}
+
+	var buf bytes.Buffer
+	if err := f.response.Execute(&buf, resp); err != nil {
+		return "", fmt.Errorf("failed to render response template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// retryable 判断失败的请求是否值得重试
+func retryable(resp *ResponseData, err error) bool {
+	if resp == nil {
+		// 网络错误和超时
+		return true
+	}
+	return resp.Status == http.StatusTooManyRequests || resp.Status >= 500
+}
+
+// retryAfter 解析Retry-After响应头，只支持秒数形式
+func retryAfter(resp *http.Response) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,320 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/example/message_processor/circuit"
+)
+
+// upstream 按请求序号返回状态码的测试上游，序号超出时使用最后一个状态码
+type upstream struct {
+	statuses []int
+	hits     int32
+}
+
+func (u *upstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := int(atomic.AddInt32(&u.hits, 1))
+	status := u.statuses[len(u.statuses)-1]
+	if n <= len(u.statuses) {
+		status = u.statuses[n-1]
+	}
+	w.WriteHeader(status)
+	io.WriteString(w, http.StatusText(status))
+}
+
+func testForwarder(t *testing.T, url string, config ForwarderConfig) *Forwarder {
+	t.Helper()
+	config.URL = url
+	if config.RetryBackoff == 0 {
+		config.RetryBackoff = time.Millisecond
+	}
+	f, err := NewForwarder("test", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(f.Close)
+	return f
+}
+
+func TestForwardRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		retries  int
+		attempts int
+		status   int
+	}{
+		{"success", []int{200}, 2, 1, 200},
+		{"recovers after 5xx", []int{503, 502, 200}, 2, 3, 200},
+		{"retries exhausted", []int{500}, 1, 2, 500},
+		{"429 retried", []int{429, 200}, 1, 2, 200},
+		{"4xx not retried", []int{400, 200}, 2, 1, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &upstream{statuses: tt.statuses}
+			srv := httptest.NewServer(u)
+			defer srv.Close()
+			f := testForwarder(t, srv.URL, ForwarderConfig{Retries: tt.retries})
+
+			resp, attempts, err := f.Forward(context.Background(), "hello")
+			if attempts != tt.attempts || int(atomic.LoadInt32(&u.hits)) != tt.attempts {
+				t.Errorf("attempts %d, upstream hits %d, want %d", attempts, u.hits, tt.attempts)
+			}
+			if resp == nil || resp.Status != tt.status {
+				t.Fatalf("response %+v, want status %d", resp, tt.status)
+			}
+			var upstreamErr *UpstreamError
+			if failed := errors.As(err, &upstreamErr); failed != (tt.status >= 300) {
+				t.Errorf("error %v for status %d", err, tt.status)
+			}
+		})
+	}
+}
+
+func TestForwardRetryAfterCapped(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+	f := testForwarder(t, srv.URL, ForwarderConfig{Retries: 1, MaxRetryBackoff: 10 * time.Millisecond})
+
+	start := time.Now()
+	if _, attempts, err := f.Forward(context.Background(), "hello"); err != nil || attempts != 2 {
+		t.Fatalf("attempts %d, err %v", attempts, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Retry-After not capped by MaxRetryBackoff: waited %s", elapsed)
+	}
+}
+
+func TestForwardTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	f := testForwarder(t, srv.URL, ForwarderConfig{Timeout: 20 * time.Millisecond, Retries: 1})
+
+	start := time.Now()
+	resp, attempts, err := f.Forward(context.Background(), "hello")
+	if err == nil || resp != nil {
+		t.Fatalf("slow upstream: resp %+v, err %v", resp, err)
+	}
+	if attempts != 2 {
+		t.Errorf("timeouts should be retried: attempts %d", attempts)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("per-attempt timeout not applied: took %s", elapsed)
+	}
+}
+
+func TestForwardContextCancel(t *testing.T) {
+	var hits int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	f := testForwarder(t, srv.URL, ForwarderConfig{Timeout: 5 * time.Second, Retries: 5})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err := f.ProcessMessageContext(ctx, "hello")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled call kept running for %s", elapsed)
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("cancelled call retried: %d upstream hits", n)
+	}
+}
+
+func TestForwardCancelNotCountedByBreaker(t *testing.T) {
+	var slow int32 = 1
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&slow) == 1 {
+			<-release
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	defer close(release)
+	f := testForwarder(t, srv.URL, ForwarderConfig{
+		Timeout:         5 * time.Second,
+		Retries:         3,
+		RetryBackoff:    time.Second,
+		MaxRetryBackoff: time.Second,
+		Breaker:         circuit.Config{FailureThreshold: 1, HalfOpenMaxCalls: 1, OpenTimeout: 20 * time.Millisecond},
+	})
+	cancelled := func(slowUpstream int32) {
+		t.Helper()
+		atomic.StoreInt32(&slow, slowUpstream)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, _, err := f.Forward(ctx, "hello"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("error %v, want deadline exceeded", err)
+		}
+	}
+
+	// 请求进行中和重试等待中取消都不计入熔断
+	cancelled(1)
+	cancelled(0)
+	if state := f.Breaker().State(); state != circuit.StateClosed {
+		t.Fatalf("cancelled calls opened the breaker: %s", state)
+	}
+
+	// 半开状态下取消的试探归还名额，下一次调用仍可以试探
+	f.Breaker().Trip()
+	time.Sleep(30 * time.Millisecond)
+	cancelled(1)
+	if _, err := f.Breaker().Acquire(); err != nil {
+		t.Errorf("cancelled probe kept the half-open slot: %v", err)
+	}
+}
+
+func TestForwardBreaker(t *testing.T) {
+	var status int32 = http.StatusInternalServerError
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer srv.Close()
+	f := testForwarder(t, srv.URL, ForwarderConfig{
+		Breaker: circuit.Config{FailureThreshold: 2, OpenTimeout: 50 * time.Millisecond},
+	})
+
+	// 4xx说明上游正常，不计入熔断
+	atomic.StoreInt32(&status, http.StatusBadRequest)
+	for i := 0; i < 3; i++ {
+		f.Forward(context.Background(), "hello")
+	}
+	if state := f.Breaker().State(); state != circuit.StateClosed {
+		t.Fatalf("4xx opened the breaker: %s", state)
+	}
+
+	atomic.StoreInt32(&status, http.StatusInternalServerError)
+	f.Forward(context.Background(), "hello")
+	f.Forward(context.Background(), "hello")
+	if state := f.Breaker().State(); state != circuit.StateOpen {
+		t.Fatalf("state after failures: %s, want open", state)
+	}
+
+	before := atomic.LoadInt32(&hits)
+	_, attempts, err := f.Forward(context.Background(), "hello")
+	if !errors.Is(err, circuit.ErrOpen) || attempts != 0 || atomic.LoadInt32(&hits) != before {
+		t.Fatalf("open breaker sent a request: attempts %d, err %v", attempts, err)
+	}
+
+	// 打开超时后进入半开状态，试探成功即关闭
+	time.Sleep(60 * time.Millisecond)
+	if state := f.Breaker().State(); state != circuit.StateHalfOpen {
+		t.Fatalf("state after open timeout: %s, want half-open", state)
+	}
+	atomic.StoreInt32(&status, http.StatusOK)
+	if _, _, err := f.Forward(context.Background(), "hello"); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if state := f.Breaker().State(); state != circuit.StateClosed {
+		t.Errorf("state after successful probe: %s, want closed", state)
+	}
+}
+
+func TestForwardTemplates(t *testing.T) {
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		if r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Source") != "mp" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"label":"ok","score":0.9}`)
+	}))
+	defer srv.Close()
+	f := testForwarder(t, srv.URL, ForwarderConfig{
+		ContentType:      "application/json",
+		Headers:          map[string]string{"X-Source": "mp"},
+		RequestTemplate:  `{"text":{{json .Message}},"id":{{json .JSON.id}}}`,
+		ResponseTemplate: `{{.Status}} {{.JSON.label}} {{.JSON.score}}`,
+	})
+
+	result, metadata, err := f.ProcessMessageWithMetadata(`{"id":7}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"text":"{\"id\":7}","id":7}`; body != want {
+		t.Errorf("request body %s, want %s", body, want)
+	}
+	if result != "200 ok 0.9" {
+		t.Errorf("result %q", result)
+	}
+	upstream, _ := metadata["upstream"].(map[string]interface{})
+	if upstream["status"] != 200 || upstream["attempts"] != 1 {
+		t.Errorf("metadata %v", metadata)
+	}
+}
+
+func TestNewForwarderRejectsBadConfig(t *testing.T) {
+	for _, config := range []ForwarderConfig{
+		{URL: "ftp://example.com"},
+		{URL: "http://"},
+		{URL: "http://example.com", Retries: -1},
+		{URL: "http://example.com", RequestTemplate: "{{.Message"},
+		{URL: "http://example.com", ResponseTemplate: "{{end}}"},
+	} {
+		if _, err := NewForwarder("bad", config); err == nil {
+			t.Errorf("config accepted: %+v", config)
+		}
+	}
+}
+
+func TestForwarderConfigDurations(t *testing.T) {
+	config := DefaultForwarderConfig()
+	data := `{"url":"http://example.com","timeout":"1.5s","retry_backoff":250000000,
+		"breaker":{"failure_threshold":3,"open_timeout":"1m","slow_call_duration":"200ms"}}`
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Timeout != 1500*time.Millisecond || config.RetryBackoff != 250*time.Millisecond {
+		t.Errorf("timeout %s, retry backoff %s", config.Timeout, config.RetryBackoff)
+	}
+	if config.Breaker.OpenTimeout != time.Minute || config.Breaker.SlowCallDuration != 200*time.Millisecond ||
+		config.Breaker.FailureThreshold != 3 {
+		t.Errorf("breaker %+v", config.Breaker)
+	}
+	// 未出现的字段保持默认值
+	if config.MaxRetryBackoff != DefaultForwarderConfig().MaxRetryBackoff || config.URL != "http://example.com" {
+		t.Errorf("defaults lost: %+v", config)
+	}
+
+	for _, bad := range []string{`{"timeout":"5 seconds"}`, `{"breaker":{"open_timeout":"x"}}`, `{"idle_conn_timeout":true}`} {
+		if err := json.Unmarshal([]byte(bad), &config); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+}
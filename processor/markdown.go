@@ -0,0 +1,590 @@
+package processor
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MarkdownRenderer Markdown渲染处理器
+// 将CommonMark风格的Markdown渲染为HTML，并经过白名单清理后输出，
+// 支持HTML和纯文本两种输出方式。
+// 支持的语法：ATX和Setext标题、段落、强调、行内代码、围栏和缩进代码块、引用、
+// 有序和无序列表、分隔线、链接、图片、自动链接、硬换行和反斜杠转义。
+// 原始HTML按CommonMark的约定透传，由清理器负责删除不安全的内容
+
+// OutputMode 输出方式
+type OutputMode string
+
+const (
+	// OutputHTML 输出清理后的HTML
+	OutputHTML OutputMode = "html"
+	// OutputText 输出纯文本，HTML特殊字符已转义，见Sanitizer.Text
+	OutputText OutputMode = "text"
+)
+
+// MarkdownRenderer 渲染器结构体
+type MarkdownRenderer struct {
+	mode      OutputMode
+	sanitizer *Sanitizer
+}
+
+// NewMarkdownRenderer 创建新的Markdown渲染器
+func NewMarkdownRenderer(mode OutputMode, policy SanitizerPolicy) (*MarkdownRenderer, error) {
+	switch mode {
+	case OutputHTML, OutputText:
+	default:
+		return nil, fmt.Errorf("unknown output mode: %s", mode)
+	}
+	return &MarkdownRenderer{
+		mode:      mode,
+		sanitizer: NewSanitizer(policy),
+	}, nil
+}
+
+// ProcessMessage 渲染并清理消息
+func (m *MarkdownRenderer) ProcessMessage(msg string) (string, error) {
+	safe := m.sanitizer.Sanitize(RenderMarkdown(msg))
+	if m.mode == OutputText {
+		return m.sanitizer.Text(safe), nil
+	}
+	return safe, nil
+}
+
+// ValidateMessage 验证消息
+func (m *MarkdownRenderer) ValidateMessage(msg string) error {
+	if strings.TrimSpace(msg) == "" {
+		return fmt.Errorf("message cannot be empty")
+	}
+	return nil
+}
+
+// RenderMarkdown 将Markdown渲染为HTML，结果未经清理
+func RenderMarkdown(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\t", "    ")
+
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(src, "\n"))
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+var (
+	atxHeading     = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ ]+(.*?))?(?:[ ]+#+)?[ ]*$`)
+	setextH1       = regexp.MustCompile(`^ {0,3}=+[ ]*$`)
+	setextH2       = regexp.MustCompile(`^ {0,3}-+[ ]*$`)
+	thematicBreak  = regexp.MustCompile(`^ {0,3}(?:(?:\*[ ]*){3,}|(?:-[ ]*){3,}|(?:_[ ]*){3,})$`)
+	fenceOpen      = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ ]*([^`\\s]*)")
+	bulletItem     = regexp.MustCompile(`^( {0,3})([-*+])( +|$)`)
+	orderedItem    = regexp.MustCompile(`^( {0,3})(\d{1,9})([.)])( +|$)`)
+	blockquoteLine = regexp.MustCompile(`^ {0,3}> ?`)
+	htmlBlockStart = regexp.MustCompile(`^ {0,3}</?[A-Za-z][A-Za-z0-9-]*(?:\s|/?>|$)`)
+)
+
+// renderBlocks 渲染块级结构
+func renderBlocks(b *strings.Builder, lines []string) {
+	var para []string
+
+	flush := func() {
+		if len(para) > 0 {
+			b.WriteString("<p>")
+			b.WriteString(renderInline(strings.Join(para, "\n")))
+			b.WriteString("</p>\n")
+			para = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		switch {
+		case strings.TrimSpace(line) == "":
+			flush()
+
+		case len(para) > 0 && setextH1.MatchString(line):
+			fmt.Fprintf(b, "<h1>%s</h1>\n", renderInline(strings.Join(para, "\n")))
+			para = nil
+
+		case len(para) > 0 && setextH2.MatchString(line):
+			fmt.Fprintf(b, "<h2>%s</h2>\n", renderInline(strings.Join(para, "\n")))
+			para = nil
+
+		case thematicBreak.MatchString(line):
+			flush()
+			b.WriteString("<hr />\n")
+
+		case atxHeading.MatchString(line):
+			flush()
+			m := atxHeading.FindStringSubmatch(line)
+			level := len(m[1])
+			fmt.Fprintf(b, "<h%d>%s</h%d>\n", level, renderInline(strings.TrimSpace(m[2])), level)
+
+		case fenceOpen.MatchString(line):
+			flush()
+			m := fenceOpen.FindStringSubmatch(line)
+			indent, fence, lang := len(m[1]), m[2], m[3]
+			var code []string
+			for i++; i < len(lines); i++ {
+				trimmed := strings.TrimLeft(lines[i], " ")
+				if strings.HasPrefix(trimmed, fence) && strings.TrimSpace(strings.TrimLeft(trimmed, fence[:1])) == "" {
+					break
+				}
+				code = append(code, trimPrefixSpaces(lines[i], indent))
+			}
+			writeCodeBlock(b, code, lang)
+
+		case len(para) == 0 && strings.HasPrefix(line, "    "):
+			var code []string
+			for ; i < len(lines); i++ {
+				if strings.TrimSpace(lines[i]) != "" && !strings.HasPrefix(lines[i], "    ") {
+					break
+				}
+				code = append(code, strings.TrimPrefix(lines[i], "    "))
+			}
+			i--
+			// 去掉末尾的空行
+			for len(code) > 0 && strings.TrimSpace(code[len(code)-1]) == "" {
+				code = code[:len(code)-1]
+			}
+			writeCodeBlock(b, code, "")
+
+		case blockquoteLine.MatchString(line):
+			flush()
+			var quoted []string
+			for ; i < len(lines); i++ {
+				if !blockquoteLine.MatchString(lines[i]) {
+					// 懒惰续行：非空且不是新块的行仍属于引用中的段落
+					if strings.TrimSpace(lines[i]) == "" || startsBlock(lines[i]) {
+						break
+					}
+					quoted = append(quoted, lines[i])
+					continue
+				}
+				quoted = append(quoted, blockquoteLine.ReplaceAllString(lines[i], ""))
+			}
+			i--
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quoted)
+			b.WriteString("</blockquote>\n")
+
+		case bulletItem.MatchString(line) || orderedItem.MatchString(line):
+			flush()
+			i = renderList(b, lines, i) - 1
+
+		case len(para) == 0 && htmlBlockStart.MatchString(line):
+			// HTML块原样输出直到空行
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+				b.WriteString(lines[i])
+				b.WriteString("\n")
+			}
+			i--
+
+		default:
+			para = append(para, strings.TrimLeft(line, " "))
+		}
+	}
+
+	flush()
+}
+
+// startsBlock 判断一行是否开始新的块级结构
+func startsBlock(line string) bool {
+	return thematicBreak.MatchString(line) || atxHeading.MatchString(line) ||
+		fenceOpen.MatchString(line) || blockquoteLine.MatchString(line) ||
+		bulletItem.MatchString(line) || orderedItem.MatchString(line)
+}
+
+// renderList 渲染从start行开始的列表，返回列表之后的行号
+func renderList(b *strings.Builder, lines []string, start int) int {
+	ordered := orderedItem.MatchString(lines[start])
+	var marker string
+	if ordered {
+		marker = orderedItem.FindStringSubmatch(lines[start])[3]
+	} else {
+		marker = bulletItem.FindStringSubmatch(lines[start])[2]
+	}
+
+	type item struct {
+		lines []string
+	}
+	var items []*item
+	loose := false
+	firstNumber := 1
+
+	i := start
+	for i < len(lines) {
+		var content, indentStr string
+		var width int
+		if ordered {
+			if !sameListItem(lines[i], true, marker) {
+				break
+			}
+			m := orderedItem.FindStringSubmatch(lines[i])
+			if len(items) == 0 {
+				firstNumber, _ = strconv.Atoi(m[2])
+			}
+			indentStr = m[0]
+		} else {
+			if !sameListItem(lines[i], false, marker) {
+				break
+			}
+			indentStr = bulletItem.FindString(lines[i])
+		}
+		width = len(indentStr)
+		content = lines[i][width:]
+
+		it := &item{lines: []string{content}}
+		items = append(items, it)
+		i++
+
+		// 收集续行：缩进不少于标记宽度的行，或段落的懒惰续行
+		blankSeen := false
+		for i < len(lines) {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				blankSeen = true
+				it.lines = append(it.lines, "")
+				i++
+				continue
+			}
+			if leadingSpaces(line) >= width {
+				if blankSeen {
+					loose = true
+				}
+				blankSeen = false
+				it.lines = append(it.lines, trimPrefixSpaces(line, width))
+				i++
+				continue
+			}
+			if !blankSeen && !startsBlock(line) {
+				it.lines = append(it.lines, strings.TrimLeft(line, " "))
+				i++
+				continue
+			}
+			break
+		}
+
+		// 条目之间有空行时列表为松散列表
+		if blankSeen {
+			trimmed := it.lines
+			for len(trimmed) > 0 && trimmed[len(trimmed)-1] == "" {
+				trimmed = trimmed[:len(trimmed)-1]
+			}
+			it.lines = trimmed
+			if i < len(lines) && sameListItem(lines[i], ordered, marker) {
+				loose = true
+			}
+		}
+	}
+
+	if ordered {
+		if firstNumber != 1 {
+			fmt.Fprintf(b, "<ol start=\"%d\">\n", firstNumber)
+		} else {
+			b.WriteString("<ol>\n")
+		}
+	} else {
+		b.WriteString("<ul>\n")
+	}
+
+	for _, it := range items {
+		var inner strings.Builder
+		renderBlocks(&inner, it.lines)
+		body := strings.TrimSuffix(inner.String(), "\n")
+		// 紧凑列表去掉段落标签
+		if !loose {
+			body = strings.ReplaceAll(body, "<p>", "")
+			body = strings.ReplaceAll(body, "</p>", "")
+		}
+		b.WriteString("<li>")
+		b.WriteString(body)
+		b.WriteString("</li>\n")
+	}
+
+	if ordered {
+		b.WriteString("</ol>\n")
+	} else {
+		b.WriteString("</ul>\n")
+	}
+	return i
+}
+
+// sameListItem 判断一行是否是同一列表（类型和标记相同）的新条目
+func sameListItem(line string, ordered bool, marker string) bool {
+	if ordered {
+		m := orderedItem.FindStringSubmatch(line)
+		return m != nil && m[3] == marker
+	}
+	m := bulletItem.FindStringSubmatch(line)
+	return m != nil && m[2] == marker && !thematicBreak.MatchString(line)
+}
+
+// writeCodeBlock 输出代码块，内容全部转义
+func writeCodeBlock(b *strings.Builder, code []string, lang string) {
+	if lang != "" {
+		fmt.Fprintf(b, "<pre><code class=\"language-%s\">", html.EscapeString(lang))
+	} else {
+		b.WriteString("<pre><code>")
+	}
+	for _, line := range code {
+		b.WriteString(html.EscapeString(line))
+		b.WriteString("\n")
+	}
+	b.WriteString("</code></pre>\n")
+}
+
+var (
+	inlineLink       = regexp.MustCompile(`^!?\[((?:[^\[\]\\]|\\.)*)\]\(\s*(<[^>]*>|[^\s()]*(?:\([^\s()]*\)[^\s()]*)*)(?:\s+("[^"]*"|'[^']*'))?\s*\)`)
+	autolink         = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.-]{1,31}:[^\s<>]*)>`)
+	emailAutolink    = regexp.MustCompile(`^<([A-Za-z0-9.!#$%&'*+/=?^_` + "`" + `{|}~-]+@[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?(?:\.[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?)*)>`)
+	entityReference  = regexp.MustCompile(`^&(?:#[0-9]{1,7}|#[xX][0-9A-Fa-f]{1,6}|[A-Za-z][A-Za-z0-9]{1,31});`)
+	inlineHTMLTag    = regexp.MustCompile(`^</?[A-Za-z][A-Za-z0-9-]*(?:\s+[A-Za-z_:][A-Za-z0-9_.:-]*(?:\s*=\s*(?:[^\s"'=<>` + "`" + `]+|'[^']*'|"[^"]*"))?)*\s*/?>`)
+	asciiPunctuation = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+)
+
+// renderInline 渲染行内结构
+func renderInline(text string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(text); {
+		c := text[i]
+		rest := text[i:]
+
+		switch {
+		case c == '\\' && i+1 < len(text) && text[i+1] == '\n':
+			b.WriteString("<br />\n")
+			i += 2
+
+		case c == '\\' && i+1 < len(text) && strings.IndexByte(asciiPunctuation, text[i+1]) >= 0:
+			b.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+
+		case c == '&':
+			// 实体和数字字符引用先解码再转义，无法识别的引用按普通文本输出
+			if m := entityReference.FindString(rest); m != "" && html.UnescapeString(m) != m {
+				b.WriteString(html.EscapeString(html.UnescapeString(m)))
+				i += len(m)
+				continue
+			}
+			b.WriteString("&amp;")
+			i++
+
+		case c == '`':
+			run := countRun(rest, '`')
+			closing := findCodeSpanEnd(text[i+run:], run)
+			if closing < 0 {
+				b.WriteString(strings.Repeat("`", run))
+				i += run
+				continue
+			}
+			code := strings.ReplaceAll(text[i+run:i+run+closing], "\n", " ")
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+				code = code[1 : len(code)-1]
+			}
+			b.WriteString("<code>")
+			b.WriteString(html.EscapeString(code))
+			b.WriteString("</code>")
+			i += run + closing + run
+
+		case c == '!' || c == '[':
+			m := inlineLink.FindStringSubmatch(rest)
+			if m == nil {
+				writeEscapedByte(&b, c)
+				i++
+				continue
+			}
+			label, dest, title := m[1], strings.Trim(m[2], "<>"), m[3]
+			titleAttr := ""
+			if title != "" {
+				titleAttr = fmt.Sprintf(` title="%s"`, html.EscapeString(html.UnescapeString(unescapeMarkdown(title[1:len(title)-1]))))
+			}
+			if c == '!' {
+				fmt.Fprintf(&b, `<img src="%s" alt="%s"%s />`,
+					html.EscapeString(html.UnescapeString(unescapeMarkdown(dest))), html.EscapeString(html.UnescapeString(unescapeMarkdown(label))), titleAttr)
+			} else {
+				fmt.Fprintf(&b, `<a href="%s"%s>%s</a>`,
+					html.EscapeString(html.UnescapeString(unescapeMarkdown(dest))), titleAttr, renderInline(label))
+			}
+			i += len(m[0])
+
+		case c == '<':
+			if m := autolink.FindStringSubmatch(rest); m != nil {
+				fmt.Fprintf(&b, `<a href="%s">%s</a>`, html.EscapeString(m[1]), html.EscapeString(m[1]))
+				i += len(m[0])
+			} else if m := emailAutolink.FindStringSubmatch(rest); m != nil {
+				fmt.Fprintf(&b, `<a href="mailto:%s">%s</a>`, html.EscapeString(m[1]), html.EscapeString(m[1]))
+				i += len(m[0])
+			} else if m := inlineHTMLTag.FindString(rest); m != "" {
+				// 行内HTML原样透传，由清理器处理
+				b.WriteString(m)
+				i += len(m)
+			} else {
+				b.WriteString("&lt;")
+				i++
+			}
+
+		case c == '*' || c == '_' || c == '~':
+			consumed, rendered := renderEmphasis(text, i)
+			if consumed == 0 {
+				run := countRun(rest, c)
+				b.WriteString(rest[:run])
+				i += run
+				continue
+			}
+			b.WriteString(rendered)
+			i += consumed
+
+		case c == '\n':
+			// 行尾两个以上空格为硬换行，其余行尾空格去掉
+			out := b.String()
+			trimmed := strings.TrimRight(out, " ")
+			if len(trimmed) != len(out) {
+				b.Reset()
+				b.WriteString(trimmed)
+			}
+			if len(out)-len(trimmed) >= 2 {
+				b.WriteString("<br />")
+			}
+			b.WriteString("\n")
+			i++
+
+		default:
+			writeEscapedByte(&b, c)
+			i++
+		}
+	}
+
+	return strings.TrimRight(b.String(), " ")
+}
+
+// renderEmphasis 渲染从pos开始的强调，返回消耗的字节数和渲染结果
+// 采用简化的匹配规则：开始分隔符后不能是空白，结束分隔符前不能是空白，
+// 下划线不能出现在单词内部
+func renderEmphasis(text string, pos int) (int, string) {
+	c := text[pos]
+	run := countRun(text[pos:], c)
+
+	var tag string
+	var width int
+	switch {
+	case c == '~' && run == 2:
+		tag, width = "del", 2
+	case c == '~':
+		return 0, ""
+	case run >= 2:
+		tag, width = "strong", 2
+	default:
+		tag, width = "em", 1
+	}
+
+	delim := strings.Repeat(string(c), width)
+	start := pos + width
+	if start >= len(text) || isSpace(text[start]) {
+		return 0, ""
+	}
+	if c == '_' && pos > 0 && isAlnum(text[pos-1]) {
+		return 0, ""
+	}
+
+	// 查找结束分隔符，跳过行内代码
+	for j := start + 1; j+width <= len(text); j++ {
+		if text[j] == '`' {
+			run := countRun(text[j:], '`')
+			if end := findCodeSpanEnd(text[j+run:], run); end >= 0 {
+				j += run + end + run - 1
+			}
+			continue
+		}
+		if text[j:j+width] != delim || isSpace(text[j-1]) {
+			continue
+		}
+		// 单个星号不能是双星号的一部分
+		if width == 1 && j+1 < len(text) && text[j+1] == c {
+			j++
+			continue
+		}
+		if c == '_' && j+width < len(text) && isAlnum(text[j+width]) {
+			continue
+		}
+		inner := renderInline(text[start:j])
+		return j + width - pos, fmt.Sprintf("<%s>%s</%s>", tag, inner, tag)
+	}
+
+	return 0, ""
+}
+
+// findCodeSpanEnd 查找与开始反引号长度相同的结束反引号，返回其在s中的位置
+func findCodeSpanEnd(s string, run int) int {
+	for i := 0; i < len(s); {
+		if s[i] != '`' {
+			i++
+			continue
+		}
+		n := countRun(s[i:], '`')
+		if n == run {
+			return i
+		}
+		i += n
+	}
+	return -1
+}
+
+// unescapeMarkdown 去掉反斜杠转义
+func unescapeMarkdown(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(asciiPunctuation, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// writeEscapedByte 输出单个字节，HTML特殊字符转义
+// 多字节UTF-8字符的各个字节原样输出
+func writeEscapedByte(b *strings.Builder, c byte) {
+	switch c {
+	case '&':
+		b.WriteString("&amp;")
+	case '<':
+		b.WriteString("&lt;")
+	case '>':
+		b.WriteString("&gt;")
+	case '"':
+		b.WriteString("&#34;")
+	case '\'':
+		b.WriteString("&#39;")
+	default:
+		b.WriteByte(c)
+	}
+}
+
+// countRun 统计s开头连续出现c的次数
+func countRun(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+// leadingSpaces 统计行首空格数
+func leadingSpaces(s string) int {
+	return countRun(s, ' ')
+}
+
+// trimPrefixSpaces 去掉最多n个行首空格
+func trimPrefixSpaces(s string, n int) string {
+	i := 0
+	for i < n && i < len(s) && s[i] == ' ' {
+		i++
+	}
+	return s[i:]
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\t'
+}
@@ -0,0 +1,56 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdownEntities(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"&lt;script&gt;", "<p>&lt;script&gt;</p>"},
+		{"AT&amp;T", "<p>AT&amp;T</p>"},
+		{"&copy; 2024 &#169; &#xA9;", "<p>© 2024 © ©</p>"},
+		{"&#60;b&#62;", "<p>&lt;b&gt;</p>"},
+		// 不是实体引用的&按文本转义
+		{"a & b &amp c &nosuch;", "<p>a &amp; b &amp;amp c &amp;nosuch;</p>"},
+		// 反斜杠转义和代码中的实体不解码
+		{`\&amp;`, "<p>&amp;amp;</p>"},
+		{"`&amp;`", "<p><code>&amp;amp;</code></p>"},
+		{`[x](/q?a=1&amp;b=2 "&quot;t&quot;")`, `<p><a href="/q?a=1&amp;b=2" title="&#34;t&#34;">x</a></p>`},
+	}
+	for _, tt := range tests {
+		if got := RenderMarkdown(tt.in); got != tt.want {
+			t.Errorf("RenderMarkdown(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMarkdownTextOutputEscaped(t *testing.T) {
+	renderer, err := NewMarkdownRenderer(OutputText, DefaultSanitizerPolicy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"`<img src=x onerror=alert(1)>`", "&lt;img src=x onerror=alert(1)&gt;"},
+		{"&lt;script&gt;alert(1)&lt;/script&gt;", "&lt;script&gt;alert(1)&lt;/script&gt;"},
+		{"# AT&amp;T", "AT&amp;T"},
+	}
+	for _, tt := range tests {
+		got, err := renderer.ProcessMessage(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("ProcessMessage(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if strings.Contains(got, "<") {
+			t.Errorf("text output of %q contains markup: %q", tt.in, got)
+		}
+	}
+}
@@ -0,0 +1,476 @@
+package processor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// PIIRedactor 个人敏感信息脱敏处理器
+// 识别邮箱、电话号码（含中国大陆格式）、身份证号、信用卡号（Luhn校验）和IP地址，
+// 并按配置的方式遮盖。处理报告只包含类型、数量和位置，不包含原始值
+
+// PIIType 敏感信息类型
+type PIIType string
+
+const (
+	PIIEmail      PIIType = "email"
+	PIIPhone      PIIType = "phone"
+	PIINationalID PIIType = "national_id"
+	PIICreditCard PIIType = "credit_card"
+	PIIIPAddress  PIIType = "ip_address"
+)
+
+// MaskStyle 遮盖方式
+type MaskStyle string
+
+const (
+	// MaskFull 整体替换为类型占位符，如[EMAIL]
+	MaskFull MaskStyle = "full"
+	// MaskPartial 保留部分字符便于人工核对，如138****5678
+	MaskPartial MaskStyle = "partial"
+	// MaskHash 替换为带密钥的哈希令牌，相同的值得到相同的令牌
+	MaskHash MaskStyle = "hash"
+)
+
+// PIIConfig 脱敏配置
+type PIIConfig struct {
+	// Style 默认遮盖方式
+	Style MaskStyle `json:"style"`
+	// Styles 按类型覆盖遮盖方式
+	Styles map[PIIType]MaskStyle `json:"styles"`
+	// Types 启用的类型，为空时启用全部
+	Types []PIIType `json:"types"`
+	// HashKey 哈希令牌使用的HMAC密钥，MaskHash方式必须设置
+	HashKey string `json:"-"`
+}
+
+// PIIFinding 单个识别结果，只记录位置
+type PIIFinding struct {
+	Type  PIIType `json:"type"`
+	Start int     `json:"start"`
+	End   int     `json:"end"`
+}
+
+// RedactionReport 脱敏报告
+type RedactionReport struct {
+	Counts   map[PIIType]int `json:"counts"`
+	Findings []PIIFinding    `json:"findings"`
+}
+
+// Total 返回脱敏的总数量
+func (r RedactionReport) Total() int {
+	return len(r.Findings)
+}
+
+// piiDetector 单一类型的识别规则
+type piiDetector struct {
+	typ     PIIType
+	pattern *regexp.Regexp
+	// bounded 要求匹配前后不能紧接字母或数字，避免截取长串的一部分
+	bounded bool
+	// valid 对候选值做进一步校验，为nil时不校验
+	valid func(s string) bool
+}
+
+// piiDetectors 按优先级排列的识别规则，重叠时靠前的规则优先
+var piiDetectors = []piiDetector{
+	{
+		typ:     PIIEmail,
+		pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	},
+	{
+		typ:     PIINationalID,
+		pattern: regexp.MustCompile(`[1-9]\d{5}(?:18|19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]`),
+		bounded: true,
+		valid:   validChineseID,
+	},
+	{
+		typ:     PIINationalID,
+		pattern: regexp.MustCompile(`\d{3}-\d{2}-\d{4}`),
+		bounded: true,
+	},
+	{
+		typ:     PIIPhone,
+		pattern: regexp.MustCompile(`(?:\+?86[ \-]?)?1[3-9]\d[ \-]?\d{4}[ \-]?\d{4}`),
+		bounded: true,
+	},
+	{
+		typ:     PIIPhone,
+		pattern: regexp.MustCompile(`0\d{2,3}-\d{7,8}`),
+		bounded: true,
+	},
+	{
+		typ:     PIIPhone,
+		pattern: regexp.MustCompile(`\+[1-9]\d{0,2}[ \-]?\d{2,4}[ \-]?\d{3,4}[ \-]?\d{3,4}`),
+		bounded: true,
+	},
+	// 信用卡号只按常见的分组写法匹配：4-4-4-4-3、4-4-4-4、4-6-5/4-6-4和连续的13-19位。
+	// 每种写法单独匹配和校验，卡号后面紧跟其他数字时较长的写法校验失败，仍能按较短的写法识别
+	{
+		typ:     PIICreditCard,
+		pattern: regexp.MustCompile(`\d{4}[ \-]\d{4}[ \-]\d{4}[ \-]\d{4}[ \-]\d{3}`),
+		bounded: true,
+		valid:   luhnValid,
+	},
+	{
+		typ:     PIICreditCard,
+		pattern: regexp.MustCompile(`\d{4}[ \-]\d{4}[ \-]\d{4}[ \-]\d{4}`),
+		bounded: true,
+		valid:   luhnValid,
+	},
+	{
+		typ:     PIICreditCard,
+		pattern: regexp.MustCompile(`\d{4}[ \-]\d{6}[ \-]\d{4,5}`),
+		bounded: true,
+		valid:   luhnValid,
+	},
+	{
+		typ:     PIICreditCard,
+		pattern: regexp.MustCompile(`\d{13,19}`),
+		bounded: true,
+		valid:   luhnValid,
+	},
+	{
+		typ:     PIIIPAddress,
+		pattern: regexp.MustCompile(`\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}`),
+		bounded: true,
+		valid:   validIP,
+	},
+	{
+		typ:     PIIIPAddress,
+		pattern: regexp.MustCompile(`[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}`),
+		bounded: true,
+		valid:   validIPv6,
+	},
+}
+
+// PIIRedactor 脱敏处理器结构体
+type PIIRedactor struct {
+	config  PIIConfig
+	enabled map[PIIType]bool
+}
+
+// NewPIIRedactor 创建新的脱敏处理器
+func NewPIIRedactor(config PIIConfig) (*PIIRedactor, error) {
+	if config.Style == "" {
+		config.Style = MaskFull
+	}
+
+	styles := []MaskStyle{config.Style}
+	for _, style := range config.Styles {
+		styles = append(styles, style)
+	}
+	for _, style := range styles {
+		switch style {
+		case MaskFull, MaskPartial:
+		case MaskHash:
+			if config.HashKey == "" {
+				return nil, fmt.Errorf("hash masking requires a hash key")
+			}
+		default:
+			return nil, fmt.Errorf("unknown mask style: %s", style)
+		}
+	}
+
+	var enabled map[PIIType]bool
+	if len(config.Types) > 0 {
+		enabled = make(map[PIIType]bool, len(config.Types))
+		for _, t := range config.Types {
+			enabled[t] = true
+		}
+	}
+
+	return &PIIRedactor{config: config, enabled: enabled}, nil
+}
+
+// ProcessMessage 返回脱敏后的消息
+func (p *PIIRedactor) ProcessMessage(msg string) (string, error) {
+	redacted, _ := p.Redact(msg)
+	return redacted, nil
+}
+
+// ProcessMessageWithMetadata 返回脱敏后的消息和脱敏报告
+func (p *PIIRedactor) ProcessMessageWithMetadata(msg string) (string, map[string]interface{}, error) {
+	redacted, report := p.Redact(msg)
+	return redacted, map[string]interface{}{"redactions": report}, nil
+}
+
+// RedactInput 返回可以保存的消息，即脱敏后的消息
+func (p *PIIRedactor) RedactInput(msg string) string {
+	redacted, _ := p.Redact(msg)
+	return redacted
+}
+
+// ValidateMessage 验证消息
+func (p *PIIRedactor) ValidateMessage(msg string) error {
+	if strings.TrimSpace(msg) == "" {
+		return fmt.Errorf("message cannot be empty")
+	}
+	return nil
+}
+
+// Redact 识别并遮盖消息中的敏感信息
+// 报告中的位置是原始消息中的字节偏移
+func (p *PIIRedactor) Redact(msg string) (string, RedactionReport) {
+	findings := p.detect(msg)
+
+	report := RedactionReport{
+		Counts:   make(map[PIIType]int),
+		Findings: findings,
+	}
+	if len(findings) == 0 {
+		return msg, report
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, f := range findings {
+		b.WriteString(msg[last:f.Start])
+		b.WriteString(p.mask(f.Type, msg[f.Start:f.End]))
+		last = f.End
+		report.Counts[f.Type]++
+	}
+	b.WriteString(msg[last:])
+
+	return b.String(), report
+}
+
+// detect 找出所有不重叠的敏感信息，按位置排序
+func (p *PIIRedactor) detect(msg string) []PIIFinding {
+	type candidate struct {
+		PIIFinding
+		rank int
+	}
+
+	var candidates []candidate
+	for rank, d := range piiDetectors {
+		if p.enabled != nil && !p.enabled[d.typ] {
+			continue
+		}
+		for _, loc := range d.pattern.FindAllStringIndex(msg, -1) {
+			start, end := loc[0], loc[1]
+			if d.bounded && !wordBoundary(msg, start, end) {
+				continue
+			}
+			if d.valid != nil && !d.valid(msg[start:end]) {
+				continue
+			}
+			candidates = append(candidates, candidate{
+				PIIFinding: PIIFinding{Type: d.typ, Start: start, End: end},
+				rank:       rank,
+			})
+		}
+	}
+
+	// 优先级高的规则先占位，同优先级时更长的匹配优先
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].rank != candidates[j].rank {
+			return candidates[i].rank < candidates[j].rank
+		}
+		return candidates[i].End-candidates[i].Start > candidates[j].End-candidates[j].Start
+	})
+
+	var findings []PIIFinding
+	for _, c := range candidates {
+		overlaps := false
+		for _, f := range findings {
+			if c.Start < f.End && f.Start < c.End {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			findings = append(findings, c.PIIFinding)
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Start < findings[j].Start
+	})
+	return findings
+}
+
+// mask 按配置遮盖单个值
+func (p *PIIRedactor) mask(typ PIIType, value string) string {
+	style := p.config.Style
+	if s, ok := p.config.Styles[typ]; ok {
+		style = s
+	}
+
+	switch style {
+	case MaskPartial:
+		return partialMask(typ, value)
+	case MaskHash:
+		mac := hmac.New(sha256.New, []byte(p.config.HashKey))
+		mac.Write([]byte(normalizePII(typ, value)))
+		return fmt.Sprintf("[%s:%s]", strings.ToUpper(string(typ)), hex.EncodeToString(mac.Sum(nil))[:12])
+	default:
+		return fmt.Sprintf("[%s]", strings.ToUpper(string(typ)))
+	}
+}
+
+// partialMask 保留部分字符的遮盖
+func partialMask(typ PIIType, value string) string {
+	switch typ {
+	case PIIEmail:
+		at := strings.LastIndex(value, "@")
+		local := value[:at]
+		first, _ := utf8.DecodeRuneInString(local)
+		return string(first) + "***" + value[at:]
+
+	case PIIIPAddress:
+		if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+			parts := strings.Split(value, ".")
+			return parts[0] + "." + parts[1] + ".*.*"
+		}
+		return maskDigitsExcept(value, 4, 0, isHexDigit)
+
+	case PIICreditCard:
+		return maskDigitsExcept(value, 0, 4, isDigit)
+
+	case PIINationalID:
+		return maskDigitsExcept(value, 6, 4, isIDChar)
+
+	default:
+		return maskDigitsExcept(value, 3, 4, isDigit)
+	}
+}
+
+// maskDigitsExcept 将value中可遮盖的字符替换为*，保留开头keepHead个和结尾keepTail个
+// 分隔符保持原样；保留的字符过多时优先放弃开头，保证至少遮盖三分之一
+func maskDigitsExcept(value string, keepHead, keepTail int, isMaskable func(byte) bool) string {
+	total := 0
+	for i := 0; i < len(value); i++ {
+		if isMaskable(value[i]) {
+			total++
+		}
+	}
+	if total-keepHead-keepTail < total/3 {
+		keepHead = 0
+	}
+	if total-keepTail < total/3 {
+		keepTail = total - total/3
+	}
+
+	b := []byte(value)
+	seen := 0
+	for i := range b {
+		if !isMaskable(b[i]) {
+			continue
+		}
+		if seen >= keepHead && seen < total-keepTail {
+			b[i] = '*'
+		}
+		seen++
+	}
+	return string(b)
+}
+
+// normalizePII 归一化后再计算哈希，使不同写法的同一号码得到相同令牌
+func normalizePII(typ PIIType, value string) string {
+	switch typ {
+	case PIIEmail, PIIIPAddress:
+		return strings.ToLower(value)
+	case PIINationalID:
+		return strings.ToUpper(value)
+	default:
+		var b strings.Builder
+		for i := 0; i < len(value); i++ {
+			if isDigit(value[i]) {
+				b.WriteByte(value[i])
+			}
+		}
+		return b.String()
+	}
+}
+
+// wordBoundary 检查匹配两侧是否紧接字母数字
+func wordBoundary(s string, start, end int) bool {
+	if start > 0 && isAlnum(s[start-1]) {
+		return false
+	}
+	if end < len(s) && isAlnum(s[end]) {
+		return false
+	}
+	return true
+}
+
+// luhnValid 信用卡号Luhn校验
+func luhnValid(s string) bool {
+	sum := 0
+	digits := 0
+	double := false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if !isDigit(c) {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
+
+// validChineseID 校验18位居民身份证号的校验位（ISO 7064 MOD 11-2）
+func validChineseID(s string) bool {
+	weights := [17]int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+	checks := "10X98765432"
+
+	sum := 0
+	for i := 0; i < 17; i++ {
+		sum += int(s[i]-'0') * weights[i]
+	}
+	return strings.ToUpper(s[17:]) == string(checks[sum%11])
+}
+
+// validIP 校验IPv4地址
+func validIP(s string) bool {
+	ip := net.ParseIP(s)
+	return ip != nil && ip.To4() != nil
+}
+
+// validIPv6 校验IPv6地址，排除时间、"std::"等冒号分隔的误匹配
+func validIPv6(s string) bool {
+	groups := 0
+	for _, g := range strings.Split(s, ":") {
+		if g != "" {
+			groups++
+		}
+	}
+	if groups < 2 {
+		return false
+	}
+	ip := net.ParseIP(s)
+	return ip != nil && ip.To4() == nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isIDChar(c byte) bool {
+	return isDigit(c) || c == 'X' || c == 'x'
+}
+
+func isAlnum(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,151 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLuhnValid(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"4111111111111111", true},
+		{"4111 1111 1111 1111", true},
+		{"4111-1111-1111-1111", true},
+		{"5500005555555559", true},
+		{"4111111111111112", false},
+		{"411111111111", false},         // 位数不足13位
+		{"41111111111111111111", false}, // 超过19位
+	}
+	for _, tt := range tests {
+		if got := luhnValid(tt.in); got != tt.want {
+			t.Errorf("luhnValid(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestValidChineseID(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"11010519491231002X", true},
+		{"11010519491231002x", true},
+		{"110105194912310021", false},
+		{"440524188001010014", true},
+		{"440524188001010015", false},
+	}
+	for _, tt := range tests {
+		if got := validChineseID(tt.in); got != tt.want {
+			t.Errorf("validChineseID(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRedactFull(t *testing.T) {
+	p, err := NewPIIRedactor(PIIConfig{Style: MaskFull})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"mail alice@example.com now", "mail [EMAIL] now"},
+		{"call 13812345678", "call [PHONE]"},
+		{"id 11010519491231002X ok", "id [NATIONAL_ID] ok"},
+		{"card 4111 1111 1111 1111", "card [CREDIT_CARD]"},
+		{"Pay 4111 1111 1111 1111 100 dollars", "Pay [CREDIT_CARD] 100 dollars"},
+		{"Pay 4111-1111-1111-1111-42", "Pay [CREDIT_CARD]-42"},
+		{"amex 3782 822463 10005 on file", "amex [CREDIT_CARD] on file"},
+		{"card 4111111111111111 x2", "card [CREDIT_CARD] x2"},
+		{"cards 4111 1111 1111 1111 5500 0055 5555 5559", "cards [CREDIT_CARD] [CREDIT_CARD]"},
+		{"from 192.168.1.10", "from [IP_ADDRESS]"},
+		{"from 2001:db8::1", "from [IP_ADDRESS]"},
+		// 校验失败的候选值不脱敏
+		{"card 4111 1111 1111 1112", "card 4111 1111 1111 1112"},
+		{"id 110105194912310021", "id 110105194912310021"},
+		{"ip 999.1.1.1", "ip 999.1.1.1"},
+		// 时间和作用域运算符不是IPv6地址
+		{"at 12:30:45 std::string", "at 12:30:45 std::string"},
+		// 长数字串的一部分不匹配
+		{"order 9138123456789012", "order 9138123456789012"},
+	}
+	for _, tt := range tests {
+		got, _ := p.Redact(tt.in)
+		if got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRedactReport(t *testing.T) {
+	p, err := NewPIIRedactor(PIIConfig{Style: MaskFull})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := "a@b.io and c@d.io, phone 13812345678"
+	_, report := p.Redact(msg)
+	if report.Total() != 3 || report.Counts[PIIEmail] != 2 || report.Counts[PIIPhone] != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if f := report.Findings[0]; msg[f.Start:f.End] != "a@b.io" {
+		t.Errorf("first finding covers %q", msg[f.Start:f.End])
+	}
+}
+
+func TestRedactPartial(t *testing.T) {
+	p, err := NewPIIRedactor(PIIConfig{Style: MaskPartial})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"alice@example.com", "a***@example.com"},
+		{"13812345678", "138****5678"},
+		{"4111 1111 1111 1111", "**** **** **** 1111"},
+		{"11010519491231002X", "110105********002X"},
+		{"192.168.1.10", "192.168.*.*"},
+	}
+	for _, tt := range tests {
+		got, _ := p.Redact(tt.in)
+		if got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRedactHashStable(t *testing.T) {
+	p, err := NewPIIRedactor(PIIConfig{Style: MaskHash, HashKey: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 不同写法的同一号码得到相同令牌，不同号码得到不同令牌
+	a, _ := p.Redact("4111 1111 1111 1111")
+	b, _ := p.Redact("4111-1111-1111-1111")
+	c, _ := p.Redact("5500005555555559")
+	if a != b {
+		t.Errorf("same card hashed differently: %q vs %q", a, b)
+	}
+	if a == c {
+		t.Errorf("different cards hashed the same: %q", a)
+	}
+	if !strings.HasPrefix(a, "[CREDIT_CARD:") {
+		t.Errorf("unexpected token %q", a)
+	}
+
+	other, err := NewPIIRedactor(PIIConfig{Style: MaskHash, HashKey: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := other.Redact("4111 1111 1111 1111"); d == a {
+		t.Errorf("token does not depend on the key: %q", d)
+	}
+}
@@ -0,0 +1,309 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/message_processor/models"
+)
+
+// Reassembler 消息重组处理器
+// 接收Splitter产生的分片（JSON格式），分片可以乱序到达。
+// 同一分组的分片全部到齐后输出完整消息；分片未到齐时返回models.ErrMessagePending。
+// 超过等待时间仍未到齐的分组会被丢弃，并交给TimeoutHandler处理
+
+// ReassemblerConfig 重组配置
+type ReassemblerConfig struct {
+	// Timeout 分组从收到第一个分片起的最长等待时间
+	Timeout time.Duration `json:"timeout"`
+	// MaxGroups 同时等待的分组数量上限，0表示不限制
+	MaxGroups int `json:"max_groups"`
+	// MaxChunks 单个分组的分片数量上限，0表示不限制
+	MaxChunks int `json:"max_chunks"`
+}
+
+// DefaultReassemblerConfig 返回默认重组配置
+func DefaultReassemblerConfig() ReassemblerConfig {
+	return ReassemblerConfig{
+		Timeout:   5 * time.Minute,
+		MaxGroups: 10000,
+		MaxChunks: 1000,
+	}
+}
+
+// GroupStatus 分组的接收状态
+type GroupStatus struct {
+	GroupID   string    `json:"group_id"`
+	Received  int       `json:"received"`
+	Total     int       `json:"total"`
+	Missing   []int     `json:"missing,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// TimeoutHandler 分组超时处理函数
+type TimeoutHandler func(status GroupStatus)
+
+// LogTimeoutHandler 只记录日志的超时处理函数
+func LogTimeoutHandler(status GroupStatus) {
+	log.Printf("Chunk group %s timed out with %d of %d chunks received",
+		status.GroupID, status.Received, status.Total)
+}
+
+// chunkGroup 正在重组的分组
+type chunkGroup struct {
+	total     int
+	chunks    map[int]string
+	startedAt time.Time
+}
+
+// status 返回分组的接收状态
+func (g *chunkGroup) status(id string) GroupStatus {
+	s := GroupStatus{
+		GroupID:   id,
+		Received:  len(g.chunks),
+		Total:     g.total,
+		StartedAt: g.startedAt,
+	}
+	for i := 0; i < g.total; i++ {
+		if _, ok := g.chunks[i]; !ok {
+			s.Missing = append(s.Missing, i)
+		}
+	}
+	return s
+}
+
+// Reassembler 重组处理器结构体，可以并发使用
+type Reassembler struct {
+	config    ReassemblerConfig
+	onTimeout TimeoutHandler
+
+	mu     sync.Mutex
+	groups map[string]*chunkGroup
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewReassembler 创建新的重组处理器
+func NewReassembler(config ReassemblerConfig) (*Reassembler, error) {
+	if config.Timeout <= 0 {
+		return nil, fmt.Errorf("timeout must be positive")
+	}
+	return &Reassembler{
+		config:    config,
+		onTimeout: LogTimeoutHandler,
+		groups:    make(map[string]*chunkGroup),
+	}, nil
+}
+
+// SetTimeoutHandler 设置分组超时处理函数，必须在Start之前调用
+func (r *Reassembler) SetTimeoutHandler(handler TimeoutHandler) {
+	r.onTimeout = handler
+}
+
+// Start 启动后台超时检查
+func (r *Reassembler) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.wg.Add(1)
+	go r.run(ctx)
+}
+
+// Stop 停止后台超时检查
+func (r *Reassembler) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+}
+
+// Add 加入一个分片
+// 分组到齐时返回完整消息和true，否则返回空字符串和false
+func (r *Reassembler) Add(chunk Chunk) (string, bool, error) {
+	if err := r.checkChunk(chunk); err != nil {
+		return "", false, err
+	}
+
+	// 单个分片的分组不需要等待
+	if chunk.Total == 1 {
+		return chunk.Content, true, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	group, ok := r.groups[chunk.GroupID]
+	if !ok {
+		if r.config.MaxGroups > 0 && len(r.groups) >= r.config.MaxGroups {
+			return "", false, fmt.Errorf("too many incomplete chunk groups")
+		}
+		group = &chunkGroup{
+			total:     chunk.Total,
+			chunks:    make(map[int]string, chunk.Total),
+			startedAt: time.Now(),
+		}
+		r.groups[chunk.GroupID] = group
+	}
+
+	if chunk.Total != group.total {
+		return "", false, fmt.Errorf("chunk %d of group %s has total %d, expected %d",
+			chunk.Index, chunk.GroupID, chunk.Total, group.total)
+	}
+	// 重复的分片以先到的为准
+	if _, dup := group.chunks[chunk.Index]; !dup {
+		group.chunks[chunk.Index] = chunk.Content
+	}
+	if len(group.chunks) < group.total {
+		return "", false, nil
+	}
+
+	delete(r.groups, chunk.GroupID)
+	var b strings.Builder
+	for i := 0; i < group.total; i++ {
+		b.WriteString(group.chunks[i])
+	}
+	return b.String(), true, nil
+}
+
+// Status 返回分组的接收状态
+func (r *Reassembler) Status(groupID string) (GroupStatus, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	group, ok := r.groups[groupID]
+	if !ok {
+		return GroupStatus{}, false
+	}
+	return group.status(groupID), true
+}
+
+// Pending 返回所有未到齐分组的接收状态，按开始时间排序
+func (r *Reassembler) Pending() []GroupStatus {
+	r.mu.Lock()
+	result := make([]GroupStatus, 0, len(r.groups))
+	for id, group := range r.groups {
+		result = append(result, group.status(id))
+	}
+	r.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt.Before(result[j].StartedAt)
+	})
+	return result
+}
+
+// State 返回所有未到齐分组的接收状态，用于状态查询接口
+func (r *Reassembler) State() interface{} {
+	return r.Pending()
+}
+
+// ExpireGroups 丢弃超过等待时间的分组并返回其状态
+func (r *Reassembler) ExpireGroups(now time.Time) []GroupStatus {
+	r.mu.Lock()
+	var expired []GroupStatus
+	for id, group := range r.groups {
+		if now.Sub(group.startedAt) >= r.config.Timeout {
+			expired = append(expired, group.status(id))
+			delete(r.groups, id)
+		}
+	}
+	r.mu.Unlock()
+
+	if r.onTimeout != nil {
+		for _, status := range expired {
+			r.onTimeout(status)
+		}
+	}
+	return expired
+}
+
+// ProcessMessage 加入一个分片，分组到齐时返回完整消息
+func (r *Reassembler) ProcessMessage(msg string) (string, error) {
+	result, _, err := r.ProcessMessageWithMetadata(msg)
+	return result, err
+}
+
+// ProcessMessageWithMetadata 加入一个分片，元数据包含分组的接收状态
+// 分组未到齐时返回models.ErrMessagePending
+func (r *Reassembler) ProcessMessageWithMetadata(msg string) (string, map[string]interface{}, error) {
+	chunk, err := parseChunk(msg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	result, complete, err := r.Add(chunk)
+	if err != nil {
+		return "", nil, err
+	}
+	if complete {
+		metadata := map[string]interface{}{
+			"reassembly": GroupStatus{GroupID: chunk.GroupID, Received: chunk.Total, Total: chunk.Total},
+		}
+		return result, metadata, nil
+	}
+
+	status, _ := r.Status(chunk.GroupID)
+	return "", map[string]interface{}{"reassembly": status}, models.ErrMessagePending
+}
+
+// ValidateMessage 验证消息是否为合法的分片
+func (r *Reassembler) ValidateMessage(msg string) error {
+	chunk, err := parseChunk(msg)
+	if err != nil {
+		return err
+	}
+	return r.checkChunk(chunk)
+}
+
+// checkChunk 检查分片字段
+func (r *Reassembler) checkChunk(chunk Chunk) error {
+	if chunk.GroupID == "" {
+		return fmt.Errorf("chunk has no group id")
+	}
+	if chunk.Total <= 0 {
+		return fmt.Errorf("chunk total must be positive")
+	}
+	if r.config.MaxChunks > 0 && chunk.Total > r.config.MaxChunks {
+		return fmt.Errorf("chunk group has too many chunks: %d", chunk.Total)
+	}
+	if chunk.Index < 0 || chunk.Index >= chunk.Total {
+		return fmt.Errorf("chunk index %d out of range", chunk.Index)
+	}
+	return nil
+}
+
+// run 超时检查循环
+func (r *Reassembler) run(ctx context.Context) {
+	defer r.wg.Done()
+
+	// 检查间隔取等待时间的十分之一，至少一秒
+	interval := r.config.Timeout / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.ExpireGroups(now)
+		}
+	}
+}
+
+// parseChunk 解析JSON格式的分片
+func parseChunk(msg string) (Chunk, error) {
+	var chunk Chunk
+	if err := json.Unmarshal([]byte(msg), &chunk); err != nil {
+		return Chunk{}, fmt.Errorf("invalid chunk: %w", err)
+	}
+	return chunk, nil
+}
@@ -0,0 +1,147 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/example/message_processor/models"
+)
+
+// chunkMessages 拆分消息并返回各分片的JSON
+func chunkMessages(t *testing.T, msg string, max int) []string {
+	t.Helper()
+	s, err := NewSplitter(SplitterConfig{MaxLength: max})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := s.ProcessMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks []Chunk
+	if err := json.Unmarshal([]byte(result), &chunks); err != nil {
+		t.Fatal(err)
+	}
+	messages := make([]string, len(chunks))
+	for i, c := range chunks {
+		data, _ := json.Marshal(c)
+		messages[i] = string(data)
+	}
+	return messages
+}
+
+func TestReassembleOutOfOrder(t *testing.T) {
+	r, err := NewReassembler(DefaultReassemblerConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := "拆分后乱序到达的消息 should be restored exactly."
+	messages := chunkMessages(t, original, 5)
+	rand.New(rand.NewSource(1)).Shuffle(len(messages), func(i, j int) {
+		messages[i], messages[j] = messages[j], messages[i]
+	})
+
+	for i, msg := range messages {
+		result, metadata, err := r.ProcessMessageWithMetadata(msg)
+		if i < len(messages)-1 {
+			status := metadata["reassembly"].(GroupStatus)
+			if !errors.Is(err, models.ErrMessagePending) || status.Received != i+1 || len(status.Missing) != len(messages)-i-1 {
+				t.Fatalf("chunk %d: err %v, status %+v", i, err, status)
+			}
+			// 重复的分片不改变接收状态
+			if _, _, err := r.ProcessMessageWithMetadata(msg); !errors.Is(err, models.ErrMessagePending) {
+				t.Fatalf("duplicate chunk: %v", err)
+			}
+			continue
+		}
+		if err != nil || result != original {
+			t.Fatalf("reassembled %q, %v", result, err)
+		}
+	}
+	if pending := r.Pending(); len(pending) != 0 {
+		t.Errorf("completed group still pending: %+v", pending)
+	}
+}
+
+func TestReassembleRejectsBadChunks(t *testing.T) {
+	r, _ := NewReassembler(ReassemblerConfig{Timeout: time.Minute, MaxGroups: 1, MaxChunks: 4})
+	if _, _, err := r.Add(Chunk{GroupID: "g1", Index: 0, Total: 2}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		chunk Chunk
+	}{
+		{"no group", Chunk{Index: 0, Total: 2}},
+		{"zero total", Chunk{GroupID: "g1", Total: 0}},
+		{"index out of range", Chunk{GroupID: "g1", Index: 2, Total: 2}},
+		{"too many chunks", Chunk{GroupID: "g1", Index: 0, Total: 5}},
+		{"total changed", Chunk{GroupID: "g1", Index: 1, Total: 3}},
+		{"too many groups", Chunk{GroupID: "g2", Index: 0, Total: 2}},
+	}
+	for _, tt := range tests {
+		if _, _, err := r.Add(tt.chunk); err == nil {
+			t.Errorf("%s: chunk accepted", tt.name)
+		}
+	}
+	if err := r.ValidateMessage("not json"); err == nil {
+		t.Error("invalid JSON accepted")
+	}
+}
+
+func TestReassembleExpireGroups(t *testing.T) {
+	r, _ := NewReassembler(ReassemblerConfig{Timeout: time.Minute})
+	var timedOut []GroupStatus
+	r.SetTimeoutHandler(func(status GroupStatus) {
+		timedOut = append(timedOut, status)
+	})
+	r.Add(Chunk{GroupID: "g1", Index: 1, Total: 3, Content: "b"})
+
+	if expired := r.ExpireGroups(time.Now()); len(expired) != 0 {
+		t.Fatalf("group expired early: %+v", expired)
+	}
+	expired := r.ExpireGroups(time.Now().Add(time.Minute))
+	if len(expired) != 1 || fmt.Sprint(expired[0].Missing) != "[0 2]" || len(timedOut) != 1 {
+		t.Fatalf("expired %+v, handler saw %+v", expired, timedOut)
+	}
+	if _, ok := r.Status("g1"); ok {
+		t.Error("expired group kept")
+	}
+}
+
+func TestReassembleConcurrent(t *testing.T) {
+	r, _ := NewReassembler(DefaultReassemblerConfig())
+	var wg sync.WaitGroup
+	results := make(chan string, 100)
+	for g := 0; g < 10; g++ {
+		original := fmt.Sprintf("group %d message long enough to be split", g)
+		for _, msg := range chunkMessages(t, original, 7) {
+			wg.Add(1)
+			go func(msg string) {
+				defer wg.Done()
+				if result, err := r.ProcessMessage(msg); err == nil {
+					results <- result
+				}
+			}(msg)
+		}
+	}
+	wg.Wait()
+	close(results)
+
+	complete := 0
+	for result := range results {
+		if !strings.HasSuffix(result, "long enough to be split") {
+			t.Errorf("corrupted result %q", result)
+		}
+		complete++
+	}
+	if complete != 10 {
+		t.Errorf("%d of 10 groups completed", complete)
+	}
+}
@@ -0,0 +1,313 @@
+package processor
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Sanitizer 基于白名单的HTML清理器
+// 只保留白名单中的标签和属性，删除脚本、样式及其内容，删除所有事件处理属性，
+// 链接和图片地址只允许安全的协议。输出中的标签保证正确闭合
+
+// SanitizerPolicy 清理策略
+type SanitizerPolicy struct {
+	// Tags 允许的标签及其允许的属性
+	Tags map[string][]string
+	// URLSchemes 链接和图片地址允许的协议，相对地址始终允许
+	URLSchemes []string
+	// DropContent 连同内容一起删除的标签
+	DropContent []string
+}
+
+// DefaultSanitizerPolicy 返回适用于Markdown渲染结果的默认策略
+func DefaultSanitizerPolicy() SanitizerPolicy {
+	return SanitizerPolicy{
+		Tags: map[string][]string{
+			"a":          {"href", "title"},
+			"img":        {"src", "alt", "title"},
+			"p":          nil,
+			"br":         nil,
+			"hr":         nil,
+			"h1":         nil,
+			"h2":         nil,
+			"h3":         nil,
+			"h4":         nil,
+			"h5":         nil,
+			"h6":         nil,
+			"strong":     nil,
+			"b":          nil,
+			"em":         nil,
+			"i":          nil,
+			"del":        nil,
+			"code":       {"class"},
+			"pre":        nil,
+			"blockquote": nil,
+			"ul":         nil,
+			"ol":         {"start"},
+			"li":         nil,
+			"table":      nil,
+			"thead":      nil,
+			"tbody":      nil,
+			"tr":         nil,
+			"th":         nil,
+			"td":         nil,
+		},
+		URLSchemes:  []string{"http", "https", "mailto"},
+		DropContent: []string{"script", "style", "iframe", "object", "embed", "template", "noscript", "textarea", "title"},
+	}
+}
+
+// voidElements 没有结束标签的元素
+var voidElements = map[string]bool{
+	"br": true, "hr": true, "img": true,
+}
+
+// urlAttributes 需要校验协议的属性
+var urlAttributes = map[string]bool{
+	"href": true, "src": true,
+}
+
+// Sanitizer 清理器结构体
+type Sanitizer struct {
+	tags    map[string]map[string]bool
+	schemes map[string]bool
+	drop    map[string]bool
+}
+
+// NewSanitizer 根据策略创建清理器
+func NewSanitizer(policy SanitizerPolicy) *Sanitizer {
+	s := &Sanitizer{
+		tags:    make(map[string]map[string]bool, len(policy.Tags)),
+		schemes: make(map[string]bool, len(policy.URLSchemes)),
+		drop:    make(map[string]bool, len(policy.DropContent)),
+	}
+	for tag, attrs := range policy.Tags {
+		allowed := make(map[string]bool, len(attrs))
+		for _, a := range attrs {
+			allowed[a] = true
+		}
+		s.tags[tag] = allowed
+	}
+	for _, scheme := range policy.URLSchemes {
+		s.schemes[strings.ToLower(scheme)] = true
+	}
+	for _, tag := range policy.DropContent {
+		s.drop[tag] = true
+	}
+	return s
+}
+
+// Sanitize 清理HTML片段
+func (s *Sanitizer) Sanitize(input string) string {
+	var b strings.Builder
+	var open []string
+	dropDepth := 0
+
+	z := html.NewTokenizer(strings.NewReader(input))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		tok := z.Token()
+
+		// 处于需要整体删除的标签内部时，只跟踪嵌套深度
+		if dropDepth > 0 {
+			switch {
+			case tt == html.StartTagToken && s.drop[tok.Data]:
+				dropDepth++
+			case tt == html.EndTagToken && s.drop[tok.Data]:
+				dropDepth--
+			}
+			continue
+		}
+
+		switch tt {
+		case html.TextToken:
+			b.WriteString(html.EscapeString(tok.Data))
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if s.drop[tok.Data] {
+				if tt == html.StartTagToken {
+					dropDepth++
+				}
+				continue
+			}
+			allowed, ok := s.tags[tok.Data]
+			if !ok {
+				continue
+			}
+			s.writeStartTag(&b, tok, allowed)
+			if !voidElements[tok.Data] && tt == html.StartTagToken {
+				open = append(open, tok.Data)
+			}
+
+		case html.EndTagToken:
+			// 只闭合已打开的标签，并顺带闭合其内部未闭合的标签
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != tok.Data {
+					continue
+				}
+				for j := len(open) - 1; j >= i; j-- {
+					fmt.Fprintf(&b, "</%s>", open[j])
+				}
+				open = open[:i]
+				break
+			}
+		}
+		// 注释、DOCTYPE等其他节点全部丢弃
+	}
+
+	for i := len(open) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "</%s>", open[i])
+	}
+	return b.String()
+}
+
+// Text 提取HTML中的纯文本，块级元素之间以换行分隔，连续空行合并为一行
+// 结果中的<、>、&和引号已转义，客户端直接放入HTML时不会被当作标签；按纯文本展示前需要反转义
+func (s *Sanitizer) Text(input string) string {
+	var b strings.Builder
+	dropDepth := 0
+	// afterBullet 列表项标记之后，块级元素不再另起一行
+	afterBullet := false
+
+	newline := func() {
+		out := strings.TrimRight(b.String(), " \t")
+		if !afterBullet && out != "" && !strings.HasSuffix(out, "\n") {
+			b.WriteString("\n")
+		}
+	}
+
+	z := html.NewTokenizer(strings.NewReader(input))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		tok := z.Token()
+
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if s.drop[tok.Data] {
+				if tt == html.StartTagToken {
+					dropDepth++
+				}
+				continue
+			}
+			switch tok.Data {
+			case "br", "p", "div", "h1", "h2", "h3", "h4", "h5", "h6", "pre", "blockquote", "tr", "ul", "ol":
+				newline()
+			case "li":
+				newline()
+				b.WriteString("- ")
+				afterBullet = true
+			case "hr":
+				newline()
+				b.WriteString("---\n")
+			case "img":
+				for _, a := range tok.Attr {
+					if a.Key == "alt" {
+						b.WriteString(a.Val)
+						afterBullet = false
+					}
+				}
+			}
+		case html.EndTagToken:
+			if s.drop[tok.Data] && dropDepth > 0 {
+				dropDepth--
+			}
+		case html.TextToken:
+			if dropDepth > 0 {
+				continue
+			}
+			// 块级元素之间的空白文本不输出，避免产生多余空行
+			text := tok.Data
+			if strings.HasSuffix(b.String(), "\n") || afterBullet {
+				text = strings.TrimLeft(text, "\n")
+			}
+			if strings.TrimSpace(text) != "" || (text == " " && !afterBullet) {
+				b.WriteString(text)
+				afterBullet = false
+			}
+		}
+	}
+
+	// 去掉行尾空白并合并连续空行
+	var lines []string
+	blank := false
+	for _, line := range strings.Split(b.String(), "\n") {
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			if blank {
+				continue
+			}
+			blank = true
+		} else {
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	return html.EscapeString(strings.TrimSpace(strings.Join(lines, "\n")))
+}
+
+// ProcessMessage 清理消息中的HTML，可以作为处理链的阶段使用
+func (s *Sanitizer) ProcessMessage(msg string) (string, error) {
+	return s.Sanitize(msg), nil
+}
+
+// ValidateMessage 验证消息
+func (s *Sanitizer) ValidateMessage(msg string) error {
+	if strings.TrimSpace(msg) == "" {
+		return fmt.Errorf("message cannot be empty")
+	}
+	return nil
+}
+
+// writeStartTag 输出只包含允许属性的开始标签
+func (s *Sanitizer) writeStartTag(b *strings.Builder, tok html.Token, allowed map[string]bool) {
+	b.WriteString("<")
+	b.WriteString(tok.Data)
+	for _, a := range tok.Attr {
+		key := strings.ToLower(a.Key)
+		if a.Namespace != "" || !allowed[key] {
+			continue
+		}
+		if urlAttributes[key] && !s.safeURL(a.Val) {
+			continue
+		}
+		fmt.Fprintf(b, ` %s="%s"`, key, html.EscapeString(a.Val))
+	}
+	if tok.Data == "a" {
+		b.WriteString(` rel="nofollow noopener noreferrer"`)
+	}
+	if voidElements[tok.Data] {
+		b.WriteString(" />")
+		return
+	}
+	b.WriteString(">")
+}
+
+// safeURL 检查地址的协议是否在白名单中
+// 浏览器会忽略协议中的空白和控制字符，因此先去除这些字符再判断
+func (s *Sanitizer) safeURL(raw string) bool {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, raw)
+
+	u, err := url.Parse(cleaned)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "" {
+		// 没有合法协议但第一段中出现冒号，说明协议格式异常，一律拒绝
+		return !strings.Contains(strings.SplitN(cleaned, "/", 2)[0], ":")
+	}
+	return s.schemes[strings.ToLower(u.Scheme)]
+}
@@ -0,0 +1,69 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	s := NewSanitizer(DefaultSanitizerPolicy())
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"event handler dropped", `<p onclick="x()">hi <b>there</b></p>`, `<p>hi <b>there</b></p>`},
+		{"script content dropped", `<script>alert(1)</script>ok`, `ok`},
+		{"nested script dropped", `<svg><script>alert(1)</script></svg>`, ``},
+		{"style and textarea dropped", `<style>p{}</style><textarea>x</textarea>z`, `z`},
+		{"javascript URL", `<a href="javascript:alert(1)">x</a>`, `<a rel="nofollow noopener noreferrer">x</a>`},
+		{"entity encoded scheme", `<a href="JaVaScRiPt&#58;alert(1)">x</a>`, `<a rel="nofollow noopener noreferrer">x</a>`},
+		{"whitespace in scheme", "<a href=\" java\tscript:alert(1)\">x</a>", `<a rel="nofollow noopener noreferrer">x</a>`},
+		{"img onerror", `<img src=x onerror=alert(1)>`, `<img src="x" />`},
+		{"attribute escaping", `<a href="https://e.com/?a=1&b=2" title='t"q'>l</a>`,
+			`<a href="https://e.com/?a=1&amp;b=2" title="t&#34;q" rel="nofollow noopener noreferrer">l</a>`},
+		{"relative URL kept", `<a href="/rel">r</a>`, `<a href="/rel" rel="nofollow noopener noreferrer">r</a>`},
+		{"unknown tags unwrapped", `<div><span>text</span></div>`, `text`},
+		{"unclosed tags closed", `<p>unclosed <em>tag`, `<p>unclosed <em>tag</em></p>`},
+		{"comments dropped", `<!-- c --><p>a</p>`, `<p>a</p>`},
+		{"text escaped", `1 < 2 & 3 > 2`, `1 &lt; 2 &amp; 3 &gt; 2`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.Sanitize(tt.in); got != tt.want {
+				t.Errorf("Sanitize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeIdempotent(t *testing.T) {
+	s := NewSanitizer(DefaultSanitizerPolicy())
+	in := `<p>a <a href="https://e.com" onclick="x">b</a><script>c</script><img src="/i.png" alt="&quot;"></p>`
+	once := s.Sanitize(in)
+	if twice := s.Sanitize(once); twice != once {
+		t.Errorf("sanitizing twice changed the output:\n once: %s\ntwice: %s", once, twice)
+	}
+}
+
+func TestSanitizerText(t *testing.T) {
+	s := NewSanitizer(DefaultSanitizerPolicy())
+	got := s.Text("<h1>T</h1><p>a<br>b</p><script>x</script><ul><li>1</li><li>2</li></ul>")
+	if strings.Contains(got, "x") || strings.Contains(got, "<") {
+		t.Errorf("Text kept markup or dropped content: %q", got)
+	}
+	for _, want := range []string{"T", "a", "b", "1", "2"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Text(%q) lost %q", got, want)
+		}
+	}
+}
+
+func TestSanitizerTextEscapesMarkup(t *testing.T) {
+	s := NewSanitizer(DefaultSanitizerPolicy())
+	// 解码后的标签按文本输出，放入HTML时不能成为标签
+	if got, want := s.Text(`<p>&lt;img src=x onerror=alert(1)&gt; &amp; "q"</p>`), "&lt;img src=x onerror=alert(1)&gt; &amp; &#34;q&#34;"; got != want {
+		t.Errorf("Text = %q, want %q", got, want)
+	}
+}
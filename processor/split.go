@@ -0,0 +1,270 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/example/message_processor/models"
+	"github.com/example/message_processor/utils"
+)
+
+// Splitter 消息拆分处理器
+// 将超过长度限制的消息拆分为有序的分片，每个分片带有分组ID、序号和总数，
+// 接收端使用Reassembler按分组重组。长度按字符（rune）计算，拆分不会截断UTF-8字符
+
+// SplitMode 拆分方式
+type SplitMode string
+
+const (
+	// SplitRunes 按字符拆分，每个分片尽量填满
+	SplitRunes SplitMode = "runes"
+	// SplitSentences 优先在句子边界拆分，单个句子超长时退化为按字符拆分
+	SplitSentences SplitMode = "sentences"
+)
+
+// SplitterConfig 拆分配置
+type SplitterConfig struct {
+	// MaxLength 每个分片的最大字符数
+	MaxLength int `json:"max_length"`
+	// Mode 拆分方式，默认按字符拆分
+	Mode SplitMode `json:"mode"`
+}
+
+// Chunk 消息分片
+type Chunk struct {
+	GroupID string `json:"group_id"`
+	// Index 分片序号，从0开始
+	Index   int    `json:"index"`
+	Total   int    `json:"total"`
+	Content string `json:"content"`
+}
+
+// Splitter 拆分处理器结构体
+type Splitter struct {
+	config SplitterConfig
+}
+
+// NewSplitter 创建新的拆分处理器
+func NewSplitter(config SplitterConfig) (*Splitter, error) {
+	if config.MaxLength <= 0 {
+		return nil, fmt.Errorf("max length must be positive")
+	}
+	switch config.Mode {
+	case "":
+		config.Mode = SplitRunes
+	case SplitRunes, SplitSentences:
+	default:
+		return nil, fmt.Errorf("unknown split mode: %s", config.Mode)
+	}
+	return &Splitter{config: config}, nil
+}
+
+// Split 将消息拆分为分片，未超长的消息也会作为单个分片返回
+func (s *Splitter) Split(msg string) ([]Chunk, error) {
+	groupID, err := utils.GenerateRandomID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate group id: %w", err)
+	}
+
+	var parts []string
+	if s.config.Mode == SplitSentences {
+		parts = splitSentences(msg, s.config.MaxLength)
+	} else {
+		parts = splitRunes(msg, s.config.MaxLength)
+	}
+
+	chunks := make([]Chunk, len(parts))
+	for i, part := range parts {
+		chunks[i] = Chunk{
+			GroupID: groupID,
+			Index:   i,
+			Total:   len(parts),
+			Content: part,
+		}
+	}
+	return chunks, nil
+}
+
+// ProcessMessage 返回JSON数组形式的分片列表
+func (s *Splitter) ProcessMessage(msg string) (string, error) {
+	result, _, err := s.ProcessMessageWithMetadata(msg)
+	return result, err
+}
+
+// ProcessMessageWithMetadata 返回JSON数组形式的分片列表，元数据包含分组ID和分片数
+func (s *Splitter) ProcessMessageWithMetadata(msg string) (string, map[string]interface{}, error) {
+	chunks, err := s.Split(msg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err := json.Marshal(chunks)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encode chunks: %w", err)
+	}
+
+	metadata := map[string]interface{}{
+		"split": map[string]interface{}{
+			"group_id": chunks[0].GroupID,
+			"total":    len(chunks),
+		},
+	}
+	return string(data), metadata, nil
+}
+
+// ValidateMessage 验证消息
+func (s *Splitter) ValidateMessage(msg string) error {
+	if strings.TrimSpace(msg) == "" {
+		return fmt.Errorf("message cannot be empty")
+	}
+	if !utf8.ValidString(msg) {
+		return fmt.Errorf("message is not valid UTF-8")
+	}
+	return nil
+}
+
+// ValidateRules 校验消息并返回所有违反的规则，每个非法的UTF-8字节单独列出
+func (s *Splitter) ValidateRules(msg string) []models.Violation {
+	if strings.TrimSpace(msg) == "" {
+		return []models.Violation{models.NewViolation("not_empty", "message cannot be empty")}
+	}
+	var violations []models.Violation
+	for i := 0; i < len(msg); {
+		r, size := utf8.DecodeRuneInString(msg[i:])
+		if r == utf8.RuneError && size == 1 {
+			violations = append(violations, models.NewSpanViolation(msg, "utf8", "invalid UTF-8 byte", i, i+1))
+		}
+		i += size
+	}
+	return violations
+}
+
+// splitRunes 按字符数拆分
+func splitRunes(s string, max int) []string {
+	var parts []string
+	for s != "" {
+		n, count := 0, 0
+		for n < len(s) && count < max {
+			_, size := utf8.DecodeRuneInString(s[n:])
+			n += size
+			count++
+		}
+		parts = append(parts, s[:n])
+		s = s[n:]
+	}
+	return parts
+}
+
+// splitWords 按字符数拆分，分片末尾尽量落在空白之后，没有空白时按字符截断
+func splitWords(s string, max int) []string {
+	var parts []string
+	for s != "" {
+		n, count, lastSpace := 0, 0, 0
+		for n < len(s) && count < max {
+			r, size := utf8.DecodeRuneInString(s[n:])
+			n += size
+			count++
+			if unicode.IsSpace(r) {
+				lastSpace = n
+			}
+		}
+		if n < len(s) && lastSpace > 0 {
+			n = lastSpace
+		}
+		parts = append(parts, s[:n])
+		s = s[n:]
+	}
+	return parts
+}
+
+// splitSentences 在句子边界拆分，相邻的短句合并到同一分片
+func splitSentences(s string, max int) []string {
+	var parts []string
+	var current strings.Builder
+	currentLen := 0
+
+	flush := func() {
+		if current.Len() > 0 {
+			parts = append(parts, current.String())
+			current.Reset()
+			currentLen = 0
+		}
+	}
+
+	for _, sentence := range sentences(s) {
+		n := utf8.RuneCountInString(sentence)
+		if currentLen+n <= max {
+			current.WriteString(sentence)
+			currentLen += n
+			continue
+		}
+		flush()
+		if n <= max {
+			current.WriteString(sentence)
+			currentLen = n
+			continue
+		}
+		// 单个句子超长，优先在空白处拆分，最后一段继续与后面的句子合并
+		pieces := splitWords(sentence, max)
+		parts = append(parts, pieces[:len(pieces)-1]...)
+		last := pieces[len(pieces)-1]
+		current.WriteString(last)
+		currentLen = utf8.RuneCountInString(last)
+	}
+	flush()
+	return parts
+}
+
+// sentences 将文本切分为句子，句末标点及其后的空白归入前一个句子
+// 拼接所有句子可以得到原文
+func sentences(s string) []string {
+	var result []string
+	start := 0
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		if !sentenceEnd(r) {
+			continue
+		}
+		// 连续的句末标点和右引号、右括号属于同一个句子
+		for i < len(s) {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if !sentenceEnd(r) && !strings.ContainsRune(`"')]”’）」』`, r) {
+				break
+			}
+			i += size
+		}
+		// 西文句号后必须跟空白才算句子结束，避免拆开小数和缩写
+		if r == '.' && i < len(s) {
+			next, _ := utf8.DecodeRuneInString(s[i:])
+			if !unicode.IsSpace(next) {
+				continue
+			}
+		}
+		for i < len(s) {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			i += size
+		}
+		result = append(result, s[start:i])
+		start = i
+	}
+	if start < len(s) {
+		result = append(result, s[start:])
+	}
+	return result
+}
+
+// sentenceEnd 判断字符是否为句末标点
+func sentenceEnd(r rune) bool {
+	switch r {
+	case '.', '!', '?', '。', '！', '？', '…', '\n':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  SplitMode
+		max   int
+		in    string
+		parts []string
+	}{
+		{"short message", SplitRunes, 10, "hello", []string{"hello"}},
+		{"runes", SplitRunes, 4, "abcdefghij", []string{"abcd", "efgh", "ij"}},
+		{"multibyte kept whole", SplitRunes, 2, "你好世界！", []string{"你好", "世界", "！"}},
+		{"sentences merged", SplitSentences, 12, "Hi. Go on. Stop now!", []string{"Hi. Go on. ", "Stop now!"}},
+		{"decimal not a boundary", SplitSentences, 12, "Pi is 3.14 ok. Next", []string{"Pi is 3.14 ", "ok. Next"}},
+		{"chinese sentences", SplitSentences, 4, "你好。再见！谢谢", []string{"你好。", "再见！", "谢谢"}},
+		{"long sentence split at spaces", SplitSentences, 8, "one two three four. x", []string{"one two ", "three ", "four. x"}},
+		{"no spaces falls back to runes", SplitSentences, 3, "abcdefg", []string{"abc", "def", "g"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewSplitter(SplitterConfig{MaxLength: tt.max, Mode: tt.mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			chunks, err := s.Split(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var parts []string
+			for i, c := range chunks {
+				if c.Index != i || c.Total != len(chunks) || c.GroupID != chunks[0].GroupID || c.GroupID == "" {
+					t.Errorf("chunk %d: %+v", i, c)
+				}
+				if n := utf8.RuneCountInString(c.Content); n > tt.max || !utf8.ValidString(c.Content) {
+					t.Errorf("chunk %q has %d runes, max %d", c.Content, n, tt.max)
+				}
+				parts = append(parts, c.Content)
+			}
+			if strings.Join(parts, "|") != strings.Join(tt.parts, "|") {
+				t.Errorf("parts %q, want %q", parts, tt.parts)
+			}
+		})
+	}
+}
+
+func TestNewSplitterRejectsBadConfig(t *testing.T) {
+	for _, config := range []SplitterConfig{{MaxLength: 0}, {MaxLength: 10, Mode: "words"}} {
+		if _, err := NewSplitter(config); err == nil {
+			t.Errorf("config accepted: %+v", config)
+		}
+	}
+}
+
+func TestSplitterValidateRules(t *testing.T) {
+	s, _ := NewSplitter(SplitterConfig{MaxLength: 10})
+	violations := s.ValidateRules("ok\xffthen\xfe")
+	if len(violations) != 2 || violations[0].Rule != "utf8" {
+		t.Errorf("violations %+v", violations)
+	}
+	if err := s.ValidateMessage("ok\xff"); err == nil {
+		t.Error("invalid UTF-8 accepted")
+	}
+}
@@ -0,0 +1,642 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/example/message_processor/utils"
+)
+
+// Aggregator 窗口聚合处理器
+// 输入为JSON对象形式的事件，按键字段分组，在滚动、滑动或会话窗口内计数、求和或收集事件。
+// 窗口按事件时间划分，水位线（watermark）为已见到的最大事件时间减去WatermarkDelay，
+// 也可以随系统时钟推进。水位线越过窗口结束时间加AllowedLateness后窗口关闭，
+// 聚合结果交给EmitHandler。落入已关闭窗口的迟到事件按LatePolicy处理
+
+// WindowType 窗口类型
+type WindowType string
+
+const (
+	// WindowTumbling 固定长度、互不重叠的窗口
+	WindowTumbling WindowType = "tumbling"
+	// WindowSliding 固定长度、按步长滑动的窗口，一个事件可以属于多个窗口
+	WindowSliding WindowType = "sliding"
+	// WindowSession 会话窗口，同一键的事件间隔不超过Gap时属于同一窗口
+	WindowSession WindowType = "session"
+)
+
+// AggregateFunc 聚合方式
+type AggregateFunc string
+
+const (
+	// AggregateCount 只计数
+	AggregateCount AggregateFunc = "count"
+	// AggregateSum 计数并对数值字段求和
+	AggregateSum AggregateFunc = "sum"
+	// AggregateCollect 计数并收集原始事件
+	AggregateCollect AggregateFunc = "collect"
+)
+
+// LatePolicy 迟到事件的处理方式
+type LatePolicy string
+
+const (
+	// LateDrop 丢弃迟到事件，在元数据中标记
+	LateDrop LatePolicy = "drop"
+	// LateReject 拒绝迟到事件，返回错误
+	LateReject LatePolicy = "reject"
+)
+
+// AggregatorConfig 聚合配置
+type AggregatorConfig struct {
+	Window   WindowType    `json:"window"`
+	Function AggregateFunc `json:"function"`
+
+	// Size 滚动窗口和滑动窗口的长度
+	Size time.Duration `json:"size"`
+	// Slide 滑动窗口的步长
+	Slide time.Duration `json:"slide"`
+	// Gap 会话窗口的最大事件间隔
+	Gap time.Duration `json:"gap"`
+
+	// KeyField 分组字段，缺失时归入空键
+	KeyField string `json:"key_field"`
+	// ValueField 求和字段，必须是数值
+	ValueField string `json:"value_field"`
+	// TimeField 事件时间字段（RFC3339），缺失时使用接收时间
+	TimeField string `json:"time_field"`
+
+	// WatermarkDelay 水位线落后于最大事件时间的时长，用于容忍乱序
+	WatermarkDelay time.Duration `json:"watermark_delay"`
+	// AllowedLateness 窗口在水位线越过结束时间后继续接收迟到事件的时长
+	AllowedLateness time.Duration `json:"allowed_lateness"`
+	// MaxSkew 事件时间最多领先系统时钟的时长，超出的事件被拒绝，避免水位线被推到未来
+	MaxSkew time.Duration `json:"max_skew"`
+	// AdvanceWithClock 没有新事件时水位线也随系统时钟推进，保证窗口按时关闭
+	AdvanceWithClock bool `json:"advance_with_clock"`
+	// LatePolicy 迟到事件的处理方式，默认丢弃
+	LatePolicy LatePolicy `json:"late_policy"`
+
+	// MaxCollect 每个窗口最多收集的事件数，0表示不限制
+	MaxCollect int `json:"max_collect"`
+	// History 保留的最近关闭窗口数量，用于状态查询
+	History int `json:"history"`
+}
+
+// UnmarshalJSON 自定义JSON反序列化方法
+// 时长字段可以是time.ParseDuration格式的字符串（如"1m"），也可以是纳秒数
+func (c *AggregatorConfig) UnmarshalJSON(data []byte) error {
+	type Alias AggregatorConfig
+	aux := &struct {
+		*Alias
+		Size            json.RawMessage `json:"size"`
+		Slide           json.RawMessage `json:"slide"`
+		Gap             json.RawMessage `json:"gap"`
+		WatermarkDelay  json.RawMessage `json:"watermark_delay"`
+		AllowedLateness json.RawMessage `json:"allowed_lateness"`
+		MaxSkew         json.RawMessage `json:"max_skew"`
+	}{
+		Alias: (*Alias)(c),
+	}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	return utils.SetJSONDurations(
+		utils.DurationField{Name: "size", Value: aux.Size, Dest: &c.Size},
+		utils.DurationField{Name: "slide", Value: aux.Slide, Dest: &c.Slide},
+		utils.DurationField{Name: "gap", Value: aux.Gap, Dest: &c.Gap},
+		utils.DurationField{Name: "watermark_delay", Value: aux.WatermarkDelay, Dest: &c.WatermarkDelay},
+		utils.DurationField{Name: "allowed_lateness", Value: aux.AllowedLateness, Dest: &c.AllowedLateness},
+		utils.DurationField{Name: "max_skew", Value: aux.MaxSkew, Dest: &c.MaxSkew},
+	)
+}
+
+// DefaultAggregatorConfig 返回按键计数的一分钟滚动窗口配置
+func DefaultAggregatorConfig() AggregatorConfig {
+	return AggregatorConfig{
+		Window:           WindowTumbling,
+		Function:         AggregateCount,
+		Size:             time.Minute,
+		KeyField:         "key",
+		ValueField:       "value",
+		TimeField:        "timestamp",
+		WatermarkDelay:   5 * time.Second,
+		MaxSkew:          time.Minute,
+		AdvanceWithClock: true,
+		LatePolicy:       LateDrop,
+		MaxCollect:       100,
+		History:          100,
+	}
+}
+
+// Aggregate 一个窗口的聚合结果
+type Aggregate struct {
+	Key    string            `json:"key"`
+	Start  time.Time         `json:"start"`
+	End    time.Time         `json:"end"`
+	Count  int               `json:"count"`
+	Sum    float64           `json:"sum,omitempty"`
+	Events []json.RawMessage `json:"events,omitempty"`
+	// Truncated 收集的事件数超过上限后被截断
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// WindowState 聚合器的当前状态
+type WindowState struct {
+	Watermark time.Time   `json:"watermark"`
+	Open      []Aggregate `json:"open"`
+	Recent    []Aggregate `json:"recent"`
+	Late      int64       `json:"late"`
+}
+
+// EmitHandler 窗口关闭时的处理函数
+type EmitHandler func(agg Aggregate)
+
+// LogEmitHandler 只记录日志的处理函数
+func LogEmitHandler(agg Aggregate) {
+	log.Printf("Window [%s, %s) for key %q closed with %d events",
+		agg.Start.Format(time.RFC3339), agg.End.Format(time.RFC3339), agg.Key, agg.Count)
+}
+
+// Aggregator 聚合处理器结构体，可以并发使用
+type Aggregator struct {
+	config AggregatorConfig
+	onEmit EmitHandler
+
+	mu        sync.Mutex
+	windows   map[string][]*Aggregate
+	watermark time.Time
+	recent    []Aggregate
+	late      int64
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewAggregator 创建新的聚合处理器
+func NewAggregator(config AggregatorConfig) (*Aggregator, error) {
+	switch config.Window {
+	case WindowTumbling:
+		if config.Size <= 0 {
+			return nil, fmt.Errorf("tumbling window requires a positive size")
+		}
+	case WindowSliding:
+		if config.Size <= 0 || config.Slide <= 0 {
+			return nil, fmt.Errorf("sliding window requires a positive size and slide")
+		}
+		if config.Slide > config.Size {
+			return nil, fmt.Errorf("slide must not exceed window size")
+		}
+	case WindowSession:
+		if config.Gap <= 0 {
+			return nil, fmt.Errorf("session window requires a positive gap")
+		}
+	default:
+		return nil, fmt.Errorf("unknown window type: %s", config.Window)
+	}
+
+	switch config.Function {
+	case "":
+		config.Function = AggregateCount
+	case AggregateCount, AggregateCollect:
+	case AggregateSum:
+		if config.ValueField == "" {
+			return nil, fmt.Errorf("sum aggregation requires a value field")
+		}
+	default:
+		return nil, fmt.Errorf("unknown aggregate function: %s", config.Function)
+	}
+
+	switch config.LatePolicy {
+	case "":
+		config.LatePolicy = LateDrop
+	case LateDrop, LateReject:
+	default:
+		return nil, fmt.Errorf("unknown late policy: %s", config.LatePolicy)
+	}
+
+	if config.WatermarkDelay < 0 || config.AllowedLateness < 0 || config.MaxSkew < 0 {
+		return nil, fmt.Errorf("watermark delay, allowed lateness and max skew must not be negative")
+	}
+	if config.MaxSkew == 0 {
+		config.MaxSkew = DefaultAggregatorConfig().MaxSkew
+	}
+
+	return &Aggregator{
+		config:  config,
+		onEmit:  LogEmitHandler,
+		windows: make(map[string][]*Aggregate),
+	}, nil
+}
+
+// SetEmitHandler 设置窗口关闭时的处理函数，必须在Start之前调用
+func (a *Aggregator) SetEmitHandler(handler EmitHandler) {
+	a.onEmit = handler
+}
+
+// Start 启动后台水位线推进，仅在AdvanceWithClock启用时有效
+func (a *Aggregator) Start(ctx context.Context) {
+	if !a.config.AdvanceWithClock {
+		return
+	}
+	ctx, a.cancel = context.WithCancel(ctx)
+	a.wg.Add(1)
+	go a.run(ctx)
+}
+
+// Stop 停止后台水位线推进
+func (a *Aggregator) Stop() {
+	if a.cancel != nil {
+		a.cancel()
+	}
+	a.wg.Wait()
+}
+
+// Add 加入一个事件，返回事件所在窗口的当前聚合结果
+// 事件落入的窗口全部已关闭时视为迟到，返回late为true
+// 领先系统时钟超过MaxSkew的事件时间按当前时间加MaxSkew计算
+func (a *Aggregator) Add(key string, ts time.Time, value float64, raw json.RawMessage) (aggs []Aggregate, late bool) {
+	now := time.Now()
+	if limit := now.Add(a.config.MaxSkew); ts.After(limit) {
+		ts = limit
+	}
+
+	a.mu.Lock()
+	var closed []Aggregate
+	defer func() {
+		a.mu.Unlock()
+		a.emit(closed)
+	}()
+
+	if a.config.Window == WindowSession {
+		agg, ok := a.addSession(key, ts)
+		if !ok {
+			a.late++
+			return nil, true
+		}
+		a.accumulate(agg, value, raw)
+		aggs = append(aggs, a.snapshot(agg))
+	} else {
+		for _, start := range a.windowStarts(ts) {
+			end := start.Add(a.config.Size)
+			if a.isClosed(end) {
+				continue
+			}
+			agg := a.window(key, start, end)
+			a.accumulate(agg, value, raw)
+			aggs = append(aggs, a.snapshot(agg))
+		}
+		if len(aggs) == 0 {
+			a.late++
+			return nil, true
+		}
+	}
+
+	// 事件时间推进水位线，但不超过系统时钟，时钟偏快的事件不会关闭当前窗口
+	if ts.After(now) {
+		ts = now
+	}
+	closed = a.advance(ts.Add(-a.config.WatermarkDelay))
+	return aggs, false
+}
+
+// Advance 将水位线推进到指定时间并关闭到期窗口，水位线不会后退
+func (a *Aggregator) Advance(watermark time.Time) []Aggregate {
+	a.mu.Lock()
+	closed := a.advance(watermark)
+	a.mu.Unlock()
+
+	a.emit(closed)
+	return closed
+}
+
+// Flush 关闭所有窗口，通常在停止前调用
+func (a *Aggregator) Flush() []Aggregate {
+	a.mu.Lock()
+	var closed []Aggregate
+	for key, list := range a.windows {
+		for _, agg := range list {
+			closed = append(closed, *agg)
+		}
+		delete(a.windows, key)
+	}
+	a.remember(closed)
+	a.mu.Unlock()
+
+	sortAggregates(closed)
+	a.emit(closed)
+	return closed
+}
+
+// State 返回聚合器的当前状态
+func (a *Aggregator) State() interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	state := WindowState{
+		Watermark: a.watermark,
+		Open:      make([]Aggregate, 0),
+		Recent:    append([]Aggregate(nil), a.recent...),
+		Late:      a.late,
+	}
+	for _, list := range a.windows {
+		for _, agg := range list {
+			state.Open = append(state.Open, a.snapshot(agg))
+		}
+	}
+	sortAggregates(state.Open)
+	return state
+}
+
+// ProcessMessage 加入一个事件，返回JSON格式的当前窗口聚合结果
+func (a *Aggregator) ProcessMessage(msg string) (string, error) {
+	result, _, err := a.ProcessMessageWithMetadata(msg)
+	return result, err
+}
+
+// ProcessMessageWithMetadata 加入一个事件，元数据包含水位线和迟到标记
+func (a *Aggregator) ProcessMessageWithMetadata(msg string) (string, map[string]interface{}, error) {
+	key, ts, value, err := a.parseEvent(msg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	aggs, late := a.Add(key, ts, value, json.RawMessage(msg))
+
+	a.mu.Lock()
+	metadata := map[string]interface{}{
+		"window": map[string]interface{}{
+			"key":        key,
+			"event_time": ts,
+			"watermark":  a.watermark,
+			"late":       late,
+		},
+	}
+	a.mu.Unlock()
+
+	if late && a.config.LatePolicy == LateReject {
+		return "", metadata, fmt.Errorf("event at %s is later than the allowed lateness", ts.Format(time.RFC3339))
+	}
+
+	if aggs == nil {
+		aggs = []Aggregate{}
+	}
+	data, err := json.Marshal(aggs)
+	if err != nil {
+		return "", metadata, fmt.Errorf("failed to encode aggregates: %w", err)
+	}
+	return string(data), metadata, nil
+}
+
+// ValidateMessage 验证消息是否为合法的事件
+func (a *Aggregator) ValidateMessage(msg string) error {
+	_, _, _, err := a.parseEvent(msg)
+	return err
+}
+
+// parseEvent 从JSON对象中取出键、事件时间和数值
+func (a *Aggregator) parseEvent(msg string) (string, time.Time, float64, error) {
+	var event map[string]interface{}
+	if err := json.Unmarshal([]byte(msg), &event); err != nil {
+		return "", time.Time{}, 0, fmt.Errorf("event must be a JSON object: %w", err)
+	}
+
+	var key string
+	if a.config.KeyField != "" {
+		switch v := event[a.config.KeyField].(type) {
+		case nil:
+		case string:
+			key = v
+		case float64:
+			key = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return "", time.Time{}, 0, fmt.Errorf("field %s must be a string or number", a.config.KeyField)
+		}
+	}
+
+	ts := time.Now()
+	if a.config.TimeField != "" {
+		if raw, ok := event[a.config.TimeField]; ok {
+			s, ok := raw.(string)
+			if !ok {
+				return "", time.Time{}, 0, fmt.Errorf("field %s must be an RFC3339 timestamp", a.config.TimeField)
+			}
+			parsed, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return "", time.Time{}, 0, fmt.Errorf("field %s must be an RFC3339 timestamp", a.config.TimeField)
+			}
+			if parsed.After(time.Now().Add(a.config.MaxSkew)) {
+				return "", time.Time{}, 0, fmt.Errorf("field %s is more than %s in the future", a.config.TimeField, a.config.MaxSkew)
+			}
+			ts = parsed
+		}
+	}
+
+	var value float64
+	if a.config.Function == AggregateSum {
+		v, ok := event[a.config.ValueField].(float64)
+		if !ok {
+			return "", time.Time{}, 0, fmt.Errorf("field %s must be a number", a.config.ValueField)
+		}
+		value = v
+	}
+
+	return key, ts, value, nil
+}
+
+// windowStarts 返回事件所属滚动或滑动窗口的开始时间
+func (a *Aggregator) windowStarts(ts time.Time) []time.Time {
+	if a.config.Window == WindowTumbling {
+		return []time.Time{ts.Truncate(a.config.Size)}
+	}
+	var starts []time.Time
+	for start := ts.Truncate(a.config.Slide); start.After(ts.Add(-a.config.Size)); start = start.Add(-a.config.Slide) {
+		starts = append(starts, start)
+	}
+	return starts
+}
+
+// window 查找或创建窗口，调用方必须持有锁
+func (a *Aggregator) window(key string, start, end time.Time) *Aggregate {
+	for _, agg := range a.windows[key] {
+		if agg.Start.Equal(start) {
+			return agg
+		}
+	}
+	agg := &Aggregate{Key: key, Start: start, End: end}
+	a.windows[key] = append(a.windows[key], agg)
+	return agg
+}
+
+// addSession 将事件加入会话窗口，必要时合并被该事件连接起来的会话
+// 调用方必须持有锁
+func (a *Aggregator) addSession(key string, ts time.Time) (*Aggregate, bool) {
+	start, end := ts, ts.Add(a.config.Gap)
+	if a.isClosed(end) {
+		return nil, false
+	}
+
+	var merged *Aggregate
+	kept := a.windows[key][:0]
+	for _, agg := range a.windows[key] {
+		// 与新事件的区间不相交的会话保持不变
+		if agg.End.Before(start) || agg.Start.After(end) {
+			kept = append(kept, agg)
+			continue
+		}
+		if merged == nil {
+			merged = agg
+			kept = append(kept, agg)
+			continue
+		}
+		merged.Count += agg.Count
+		merged.Sum += agg.Sum
+		merged.Events = append(merged.Events, agg.Events...)
+		merged.Truncated = merged.Truncated || agg.Truncated
+		if agg.Start.Before(merged.Start) {
+			merged.Start = agg.Start
+		}
+		if agg.End.After(merged.End) {
+			merged.End = agg.End
+		}
+	}
+
+	if merged == nil {
+		merged = &Aggregate{Key: key, Start: start, End: end}
+		kept = append(kept, merged)
+	}
+	if start.Before(merged.Start) {
+		merged.Start = start
+	}
+	if end.After(merged.End) {
+		merged.End = end
+	}
+	a.windows[key] = kept
+	return merged, true
+}
+
+// accumulate 将事件计入窗口，调用方必须持有锁
+func (a *Aggregator) accumulate(agg *Aggregate, value float64, raw json.RawMessage) {
+	agg.Count++
+	switch a.config.Function {
+	case AggregateSum:
+		agg.Sum += value
+	case AggregateCollect:
+		if a.config.MaxCollect > 0 && len(agg.Events) >= a.config.MaxCollect {
+			agg.Truncated = true
+			return
+		}
+		agg.Events = append(agg.Events, raw)
+	}
+}
+
+// snapshot 复制窗口，避免调用方持有内部状态
+func (a *Aggregator) snapshot(agg *Aggregate) Aggregate {
+	s := *agg
+	s.Events = append([]json.RawMessage(nil), agg.Events...)
+	return s
+}
+
+// isClosed 判断结束时间为end的窗口是否已关闭，调用方必须持有锁
+func (a *Aggregator) isClosed(end time.Time) bool {
+	return !a.watermark.IsZero() && !end.Add(a.config.AllowedLateness).After(a.watermark)
+}
+
+// advance 推进水位线并移除已关闭的窗口，调用方必须持有锁
+func (a *Aggregator) advance(watermark time.Time) []Aggregate {
+	if !watermark.After(a.watermark) {
+		return nil
+	}
+	a.watermark = watermark
+
+	var closed []Aggregate
+	for key, list := range a.windows {
+		kept := list[:0]
+		for _, agg := range list {
+			if a.isClosed(agg.End) {
+				closed = append(closed, *agg)
+				continue
+			}
+			kept = append(kept, agg)
+		}
+		if len(kept) == 0 {
+			delete(a.windows, key)
+		} else {
+			a.windows[key] = kept
+		}
+	}
+
+	sortAggregates(closed)
+	a.remember(closed)
+	return closed
+}
+
+// remember 保留最近关闭的窗口，调用方必须持有锁
+func (a *Aggregator) remember(closed []Aggregate) {
+	if a.config.History <= 0 {
+		return
+	}
+	a.recent = append(a.recent, closed...)
+	if over := len(a.recent) - a.config.History; over > 0 {
+		a.recent = append([]Aggregate(nil), a.recent[over:]...)
+	}
+}
+
+// emit 在锁外调用处理函数
+func (a *Aggregator) emit(closed []Aggregate) {
+	if a.onEmit == nil {
+		return
+	}
+	for _, agg := range closed {
+		a.onEmit(agg)
+	}
+}
+
+// run 水位线推进循环
+func (a *Aggregator) run(ctx context.Context) {
+	defer a.wg.Done()
+
+	// 推进间隔取窗口粒度的十分之一，至少一秒
+	interval := a.config.Size
+	if a.config.Window == WindowSliding {
+		interval = a.config.Slide
+	}
+	if a.config.Window == WindowSession {
+		interval = a.config.Gap
+	}
+	interval /= 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.Advance(now.Add(-a.config.WatermarkDelay))
+		}
+	}
+}
+
+// sortAggregates 按结束时间和键排序
+func sortAggregates(aggs []Aggregate) {
+	sort.Slice(aggs, func(i, j int) bool {
+		if !aggs[i].End.Equal(aggs[j].End) {
+			return aggs[i].End.Before(aggs[j].End)
+		}
+		if aggs[i].Key != aggs[j].Key {
+			return aggs[i].Key < aggs[j].Key
+		}
+		return aggs[i].Start.Before(aggs[j].Start)
+	})
+}
@@ -0,0 +1,145 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// event 生成测试事件的JSON
+func event(key string, ts time.Time, value float64) string {
+	return fmt.Sprintf(`{"key":%q,"timestamp":%q,"value":%v}`, key, ts.Format(time.RFC3339), value)
+}
+
+// windowSummary 将聚合结果格式化为"键@开始-结束=计数/总和"
+func windowSummary(base time.Time, aggs []Aggregate) string {
+	var parts []string
+	for _, agg := range aggs {
+		parts = append(parts, fmt.Sprintf("%s@%v-%v=%d/%v", agg.Key,
+			agg.Start.Sub(base), agg.End.Sub(base), agg.Count, agg.Sum))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestAggregatorWindows(t *testing.T) {
+	base := time.Now().Truncate(time.Hour).Add(-time.Hour)
+	at := func(seconds int) time.Time { return base.Add(time.Duration(seconds) * time.Second) }
+
+	tests := []struct {
+		name   string
+		config AggregatorConfig
+		events []string
+		closed string
+	}{
+		{
+			name:   "tumbling count per key",
+			config: AggregatorConfig{Window: WindowTumbling, Size: 10 * time.Second, KeyField: "key", TimeField: "timestamp"},
+			events: []string{event("a", at(1), 0), event("b", at(2), 0), event("a", at(9), 0), event("a", at(12), 0)},
+			closed: "a@0s-10s=2/0 b@0s-10s=1/0 a@10s-20s=1/0",
+		},
+		{
+			name: "sliding sum",
+			config: AggregatorConfig{Window: WindowSliding, Function: AggregateSum, Size: 10 * time.Second, Slide: 5 * time.Second,
+				KeyField: "key", ValueField: "value", TimeField: "timestamp"},
+			events: []string{event("a", at(6), 1), event("a", at(11), 2)},
+			closed: "a@0s-10s=1/1 a@5s-15s=2/3 a@10s-20s=1/2",
+		},
+		{
+			name: "sessions merge when a gap is bridged",
+			config: AggregatorConfig{Window: WindowSession, Gap: 10 * time.Second, KeyField: "key", TimeField: "timestamp",
+				WatermarkDelay: 20 * time.Second},
+			events: []string{event("a", at(0), 0), event("a", at(18), 0), event("a", at(9), 0), event("a", at(40), 0)},
+			closed: "a@0s-28s=3/0 a@40s-50s=1/0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewAggregator(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var closed []Aggregate
+			a.SetEmitHandler(func(agg Aggregate) { closed = append(closed, agg) })
+			for _, e := range tt.events {
+				if _, err := a.ProcessMessage(e); err != nil {
+					t.Fatal(err)
+				}
+			}
+			a.Flush()
+			if got := windowSummary(base, closed); got != tt.closed {
+				t.Errorf("closed %s, want %s", got, tt.closed)
+			}
+		})
+	}
+}
+
+func TestAggregatorLateEvents(t *testing.T) {
+	base := time.Now().Truncate(time.Hour).Add(-time.Hour)
+	tests := []struct {
+		name    string
+		policy  LatePolicy
+		wantErr bool
+	}{
+		{"drop", LateDrop, false},
+		{"reject", LateReject, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := NewAggregator(AggregatorConfig{Window: WindowTumbling, Size: 10 * time.Second,
+				KeyField: "key", TimeField: "timestamp", WatermarkDelay: 2 * time.Second, LatePolicy: tt.policy, History: 10})
+			a.ProcessMessage(event("a", base.Add(5*time.Second), 0))
+			// 水位线为9秒，乱序但未越过窗口结束时间的事件仍被接受
+			a.ProcessMessage(event("a", base.Add(11*time.Second), 0))
+			if _, metadata, _ := a.ProcessMessageWithMetadata(event("a", base.Add(8*time.Second), 0)); metadata["window"].(map[string]interface{})["late"] != false {
+				t.Errorf("event within the watermark delay marked late")
+			}
+			a.ProcessMessage(event("a", base.Add(25*time.Second), 0))
+
+			_, metadata, err := a.ProcessMessageWithMetadata(event("a", base.Add(9*time.Second), 0))
+			if (err != nil) != tt.wantErr || metadata["window"].(map[string]interface{})["late"] != true {
+				t.Errorf("late event: err %v, metadata %v", err, metadata)
+			}
+			if state := a.State().(WindowState); state.Late != 1 || len(state.Recent) != 2 || state.Recent[0].Count != 2 {
+				t.Errorf("state %+v", state)
+			}
+		})
+	}
+}
+
+func TestAggregatorFutureEvents(t *testing.T) {
+	a, err := NewAggregator(DefaultAggregatorConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	future := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := a.ProcessMessage(event("a", future, 0)); err == nil {
+		t.Fatal("event in 2100 accepted")
+	}
+
+	// 直接调用Add时事件时间被截断，水位线不会超过系统时钟
+	a.Add("a", future, 0, nil)
+	if state := a.State().(WindowState); state.Watermark.After(time.Now()) || len(state.Open) != 1 || state.Open[0].End.After(time.Now().Add(2*time.Minute)) {
+		t.Errorf("state after a future event: %+v", state)
+	}
+	if _, late := a.Add("a", time.Now(), 0, nil); late {
+		t.Error("current event is late after a future event")
+	}
+}
+
+func TestNewAggregatorRejectsBadConfig(t *testing.T) {
+	tests := []AggregatorConfig{
+		{Window: WindowTumbling},
+		{Window: WindowSliding, Size: time.Second, Slide: time.Minute},
+		{Window: WindowSession},
+		{Window: "hopping", Size: time.Second},
+		{Window: WindowTumbling, Size: time.Second, Function: AggregateSum},
+		{Window: WindowTumbling, Size: time.Second, LatePolicy: "keep"},
+		{Window: WindowTumbling, Size: time.Second, MaxSkew: -time.Second},
+	}
+	for _, config := range tests {
+		if _, err := NewAggregator(config); err == nil {
+			t.Errorf("config accepted: %+v", config)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package queue
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/example/message_processor/models"
+)
+
+// 消息有效期处理
+// 过期消息在处理前被跳过并标记为expired，可以交给ExpiryHandler做后续处理
+// Sweeper在后台定期清理过期记录
+
+// ExpiryHandler 过期消息处理接口
+type ExpiryHandler interface {
+	HandleExpired(ctx context.Context, msg *models.Message)
+}
+
+// ExpiryHandlerFunc 函数形式的ExpiryHandler
+type ExpiryHandlerFunc func(ctx context.Context, msg *models.Message)
+
+// HandleExpired 调用函数本身
+func (f ExpiryHandlerFunc) HandleExpired(ctx context.Context, msg *models.Message) {
+	f(ctx, msg)
+}
+
+// LogExpiryHandler 只记录日志的过期处理器
+var LogExpiryHandler = ExpiryHandlerFunc(func(ctx context.Context, msg *models.Message) {
+	log.Printf("Message %s expired at %s without being processed",
+		msg.ID, msg.ExpiresAt.Format(time.RFC3339))
+})
+
+// markExpired 如果消息已过期，将其标记为expired并通知处理器
+// 返回true表示消息不应再被处理
+func markExpired(ctx context.Context, msg *models.Message, handler ExpiryHandler) bool {
+	if !msg.IsExpired(time.Now()) {
+		return false
+	}
+
+	msg.Status = models.MessageStatusExpired
+	if handler != nil {
+		handler.HandleExpired(ctx, msg)
+	}
+	return true
+}
+
+// PurgeStore 清理器依赖的存储接口
+type PurgeStore interface {
+	PurgeExpiredMessages(ctx context.Context, before time.Time) (int64, error)
+}
+
+// SweeperConfig 清理器配置
+type SweeperConfig struct {
+	// Interval 两次清理之间的间隔
+	Interval time.Duration
+	// Retention 过期记录在删除前保留的时长，便于排查问题
+	Retention time.Duration
+}
+
+// DefaultSweeperConfig 返回默认清理器配置
+func DefaultSweeperConfig() SweeperConfig {
+	return SweeperConfig{
+		Interval:  time.Minute,
+		Retention: 24 * time.Hour,
+	}
+}
+
+// Sweeper 过期记录清理器
+type Sweeper struct {
+	store  PurgeStore
+	config SweeperConfig
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewSweeper 创建新的清理器
+func NewSweeper(store PurgeStore, config SweeperConfig) *Sweeper {
+	return &Sweeper{
+		store:  store,
+		config: config,
+	}
+}
+
+// Start 启动后台清理
+func (s *Sweeper) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.wg.Add(1)
+	go s.run(ctx)
+}
+
+// Stop 停止后台清理
+func (s *Sweeper) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
+
+// Sweep 立即执行一次清理
+func (s *Sweeper) Sweep(ctx context.Context) (int64, error) {
+	return s.store.PurgeExpiredMessages(ctx, time.Now().Add(-s.config.Retention))
+}
+
+// run 清理循环
+func (s *Sweeper) run(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := s.Sweep(ctx)
+			if err != nil {
+				log.Printf("Failed to purge expired messages: %v", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("Purged %d expired messages", purged)
+			}
+		}
+	}
+}
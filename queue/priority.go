@@ -0,0 +1,271 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/example/message_processor/models"
+)
+
+// Queue 按优先级分道的消息处理队列
+// 每个优先级有独立的FIFO通道，工作协程使用平滑加权轮询在非空通道间选择，
+// 保证批量消息不会饿死关键消息，同时低优先级消息也总能得到处理
+
+// ErrQueueFull 对应优先级的通道已满
+var ErrQueueFull = errors.New("queue is full")
+
+// ErrQueueClosed 队列已停止
+var ErrQueueClosed = errors.New("queue is closed")
+
+// ErrExpired 消息在等待处理期间过期
+var ErrExpired = errors.New("message expired")
+
+// QueueConfig 队列配置
+type QueueConfig struct {
+	// Workers 工作协程数量
+	Workers int
+	// Capacity 每个优先级通道的最大排队数量
+	Capacity int
+	// Weights 各优先级的调度权重，缺省的优先级权重为1
+	Weights map[models.Priority]int
+}
+
+// DefaultQueueConfig 返回默认队列配置
+func DefaultQueueConfig() QueueConfig {
+	return QueueConfig{
+		Workers:  8,
+		Capacity: 1000,
+		Weights: map[models.Priority]int{
+			models.PriorityCritical: 10,
+			models.PriorityNormal:   5,
+			models.PriorityBulk:     1,
+		},
+	}
+}
+
+// QueueStats 队列状态
+type QueueStats struct {
+	Depth    map[models.Priority]int `json:"depth"`
+	InFlight int                     `json:"in_flight"`
+	Workers  int                     `json:"workers"`
+}
+
+// TotalDepth 返回所有优先级的排队总数
+func (s QueueStats) TotalDepth() int {
+	total := 0
+	for _, n := range s.Depth {
+		total += n
+	}
+	return total
+}
+
+// Queue 队列结构体
+type Queue struct {
+	config   QueueConfig
+	dispatch DispatchFunc
+	onExpire ExpiryHandler
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	lanes    []*lane
+	byPrio   map[models.Priority]*lane
+	inFlight int
+	closed   bool
+
+	wg sync.WaitGroup
+}
+
+// lane 单个优先级的通道
+type lane struct {
+	priority models.Priority
+	weight   int
+	current  int
+	jobs     []*job
+}
+
+// job 排队中的消息
+type job struct {
+	ctx  context.Context
+	msg  *models.Message
+	done chan jobResult
+}
+
+// jobResult 处理结果
+type jobResult struct {
+	result string
+	err    error
+}
+
+// NewQueue 创建新的优先级队列
+func NewQueue(config QueueConfig, dispatch DispatchFunc) *Queue {
+	q := &Queue{
+		config:   config,
+		dispatch: dispatch,
+		byPrio:   make(map[models.Priority]*lane),
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	for _, p := range models.Priorities {
+		weight := config.Weights[p]
+		if weight <= 0 {
+			weight = 1
+		}
+		l := &lane{priority: p, weight: weight}
+		q.lanes = append(q.lanes, l)
+		q.byPrio[p] = l
+	}
+
+	return q
+}
+
+// SetExpiryHandler 设置过期消息处理器，必须在Start之前调用
+func (q *Queue) SetExpiryHandler(handler ExpiryHandler) {
+	q.onExpire = handler
+}
+
+// Start 启动工作协程
+func (q *Queue) Start() {
+	workers := q.config.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+}
+
+// Stop 停止接收新消息，处理完已排队的消息后返回
+func (q *Queue) Stop() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+	q.wg.Wait()
+}
+
+// Submit 将消息加入对应优先级的通道并等待处理结果
+// 签名与DispatchFunc一致，可以直接作为调度器的投递函数
+func (q *Queue) Submit(ctx context.Context, msg *models.Message) (string, error) {
+	j := &job{
+		ctx:  ctx,
+		msg:  msg,
+		done: make(chan jobResult, 1),
+	}
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return "", ErrQueueClosed
+	}
+	l, ok := q.byPrio[msg.Priority]
+	if !ok {
+		l = q.byPrio[models.PriorityNormal]
+	}
+	if q.config.Capacity > 0 && len(l.jobs) >= q.config.Capacity {
+		q.mu.Unlock()
+		return "", ErrQueueFull
+	}
+	l.jobs = append(l.jobs, j)
+	q.mu.Unlock()
+	q.cond.Signal()
+
+	select {
+	case res := <-j.done:
+		return res.result, res.err
+	case <-ctx.Done():
+		// 工作协程取到该消息时会发现上下文已取消并跳过
+		return "", ctx.Err()
+	}
+}
+
+// Stats 返回队列当前状态
+func (q *Queue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := QueueStats{
+		Depth:    make(map[models.Priority]int, len(q.lanes)),
+		InFlight: q.inFlight,
+		Workers:  q.config.Workers,
+	}
+	for _, l := range q.lanes {
+		stats.Depth[l.priority] = len(l.jobs)
+	}
+	return stats
+}
+
+// worker 工作协程，循环取出并处理消息
+func (q *Queue) worker() {
+	defer q.wg.Done()
+
+	for {
+		q.mu.Lock()
+		for !q.closed && q.empty() {
+			q.cond.Wait()
+		}
+		if q.closed && q.empty() {
+			q.mu.Unlock()
+			return
+		}
+		j := q.next()
+		q.inFlight++
+		q.mu.Unlock()
+
+		j.done <- q.run(j)
+
+		q.mu.Lock()
+		q.inFlight--
+		q.mu.Unlock()
+	}
+}
+
+// run 处理单条消息
+func (q *Queue) run(j *job) jobResult {
+	if err := j.ctx.Err(); err != nil {
+		return jobResult{err: err}
+	}
+	if markExpired(j.ctx, j.msg, q.onExpire) {
+		return jobResult{err: ErrExpired}
+	}
+
+	result, err := q.dispatch(j.ctx, j.msg)
+	return jobResult{result: result, err: err}
+}
+
+// empty 判断所有通道是否为空，调用方必须持有锁
+func (q *Queue) empty() bool {
+	for _, l := range q.lanes {
+		if len(l.jobs) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// next 使用平滑加权轮询选出下一条消息，调用方必须持有锁且队列非空
+func (q *Queue) next() *job {
+	var chosen *lane
+	total := 0
+	for _, l := range q.lanes {
+		if len(l.jobs) == 0 {
+			continue
+		}
+		l.current += l.weight
+		total += l.weight
+		if chosen == nil || l.current > chosen.current {
+			chosen = l
+		}
+	}
+	chosen.current -= total
+
+	j := chosen.jobs[0]
+	chosen.jobs[0] = nil
+	chosen.jobs = chosen.jobs[1:]
+	if len(chosen.jobs) == 0 {
+		// 通道清空后不保留累积的权重，避免再次入队时突发抢占
+		chosen.current = 0
+	}
+	return j
+}
@@ -0,0 +1,109 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/example/message_processor/models"
+)
+
+// fill 直接向通道加入n条消息，不启动工作协程
+func fill(q *Queue, p models.Priority, n int) {
+	for i := 0; i < n; i++ {
+		l := q.byPrio[p]
+		l.jobs = append(l.jobs, &job{
+			ctx:  context.Background(),
+			msg:  &models.Message{Priority: p},
+			done: make(chan jobResult, 1),
+		})
+	}
+}
+
+func TestNextWeightedShares(t *testing.T) {
+	q := NewQueue(DefaultQueueConfig(), nil)
+	fill(q, models.PriorityCritical, 100)
+	fill(q, models.PriorityNormal, 100)
+	fill(q, models.PriorityBulk, 100)
+
+	// 所有通道都有积压时，每16次调度按10:5:1分配
+	counts := make(map[models.Priority]int)
+	for i := 0; i < 32; i++ {
+		counts[q.next().msg.Priority]++
+	}
+	want := map[models.Priority]int{
+		models.PriorityCritical: 20,
+		models.PriorityNormal:   10,
+		models.PriorityBulk:     2,
+	}
+	for p, n := range want {
+		if counts[p] != n {
+			t.Errorf("priority %s: got %d of 32, want %d", p, counts[p], n)
+		}
+	}
+}
+
+func TestNextSmoothInterleaving(t *testing.T) {
+	q := NewQueue(DefaultQueueConfig(), nil)
+	fill(q, models.PriorityCritical, 20)
+	fill(q, models.PriorityBulk, 20)
+
+	// 平滑加权轮询不会连续调度同一通道超过其权重份额，低优先级在每轮中都能得到处理
+	var seq []models.Priority
+	for i := 0; i < 11; i++ {
+		seq = append(seq, q.next().msg.Priority)
+	}
+	bulk := 0
+	for _, p := range seq {
+		if p == models.PriorityBulk {
+			bulk++
+		}
+	}
+	if bulk != 1 {
+		t.Errorf("bulk scheduled %d times in one round of 11, want 1: %v", bulk, seq)
+	}
+}
+
+func TestNextResetsDrainedLane(t *testing.T) {
+	q := NewQueue(DefaultQueueConfig(), nil)
+	fill(q, models.PriorityBulk, 1)
+	q.next()
+	if q.byPrio[models.PriorityBulk].current != 0 {
+		t.Errorf("drained lane kept weight %d", q.byPrio[models.PriorityBulk].current)
+	}
+}
+
+func TestSubmitFullAndClosed(t *testing.T) {
+	config := DefaultQueueConfig()
+	config.Capacity = 1
+	q := NewQueue(config, func(ctx context.Context, msg *models.Message) (string, error) {
+		return "ok", nil
+	})
+	fill(q, models.PriorityNormal, 1)
+
+	if _, err := q.Submit(context.Background(), &models.Message{Priority: models.PriorityNormal}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Submit to a full lane: got %v, want ErrQueueFull", err)
+	}
+
+	q.Start()
+	q.Stop()
+	if _, err := q.Submit(context.Background(), &models.Message{}); !errors.Is(err, ErrQueueClosed) {
+		t.Fatalf("Submit after Stop: got %v, want ErrQueueClosed", err)
+	}
+}
+
+func TestSubmitDispatches(t *testing.T) {
+	q := NewQueue(DefaultQueueConfig(), func(ctx context.Context, msg *models.Message) (string, error) {
+		return "processed " + msg.Content, nil
+	})
+	q.Start()
+	defer q.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result, err := q.Submit(ctx, &models.Message{Content: "hi"})
+	if err != nil || result != "processed hi" {
+		t.Fatalf("Submit: got %q, %v", result, err)
+	}
+}
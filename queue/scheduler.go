@@ -0,0 +1,357 @@
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/example/message_processor/models"
+)
+
+// Scheduler 定时消息调度器
+// 将设置了投递时间的消息保存到存储中，到期后交给DispatchFunc处理
+// 服务重启后会从存储中恢复尚未投递的消息
+// 到期时已超过有效期的消息不会被处理，而是标记为expired
+// 队列已满、已停止或处理器熔断等暂时性错误不改变消息状态，按退避时间（有建议的重试时间时使用建议值）重新调度
+
+// Store 调度器依赖的消息存储接口
+type Store interface {
+	CreateMessage(ctx context.Context, msg *models.Message) error
+	UpdateMessage(ctx context.Context, msg *models.Message) error
+	ListMessagesByStatus(ctx context.Context, status models.MessageStatus) ([]*models.Message, error)
+}
+
+// DispatchFunc 处理到期的消息，返回处理结果
+type DispatchFunc func(ctx context.Context, msg *models.Message) (string, error)
+
+// ErrNotScheduled 消息不在调度队列中（不存在或已投递）
+var ErrNotScheduled = errors.New("message is not scheduled")
+
+// ErrExpiresBeforeDelivery 消息在投递时间之前就会过期
+var ErrExpiresBeforeDelivery = errors.New("message would expire before delivery")
+
+// retryAfterError 带有建议重试时间的错误，如熔断器打开、隔离舱已满
+type retryAfterError interface {
+	error
+	RetryAfter() time.Duration
+}
+
+// Scheduler 调度器结构体
+type Scheduler struct {
+	store    Store
+	dispatch DispatchFunc
+	onExpire ExpiryHandler
+
+	// retryBackoff和maxRetryBackoff 暂时性错误后重新投递的初始和最大等待时间
+	retryBackoff    time.Duration
+	maxRetryBackoff time.Duration
+
+	mu      sync.Mutex
+	pending messageHeap
+	index   map[string]*scheduledItem
+	wake    chan struct{}
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewScheduler 创建新的调度器
+func NewScheduler(store Store, dispatch DispatchFunc) *Scheduler {
+	return &Scheduler{
+		store:           store,
+		dispatch:        dispatch,
+		retryBackoff:    time.Second,
+		maxRetryBackoff: time.Minute,
+		index:           make(map[string]*scheduledItem),
+		wake:            make(chan struct{}, 1),
+	}
+}
+
+// SetExpiryHandler 设置过期消息处理器，必须在Start之前调用
+func (s *Scheduler) SetExpiryHandler(handler ExpiryHandler) {
+	s.onExpire = handler
+}
+
+// Start 从存储中恢复待投递消息并启动调度循环
+func (s *Scheduler) Start(ctx context.Context) error {
+	messages, err := s.store.ListMessagesByStatus(ctx, models.MessageStatusScheduled)
+	if err != nil {
+		return fmt.Errorf("failed to restore scheduled messages: %w", err)
+	}
+
+	s.mu.Lock()
+	for _, msg := range messages {
+		s.push(&scheduledItem{msg: msg, due: msg.DeliverAt})
+	}
+	s.mu.Unlock()
+
+	if len(messages) > 0 {
+		log.Printf("Restored %d scheduled messages", len(messages))
+	}
+
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.wg.Add(1)
+	go s.run(ctx)
+
+	return nil
+}
+
+// Stop 停止调度循环并等待正在投递的消息完成
+func (s *Scheduler) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
+
+// Schedule 持久化消息并加入调度队列
+func (s *Scheduler) Schedule(ctx context.Context, msg *models.Message) error {
+	if msg.DeliverAt.IsZero() {
+		return fmt.Errorf("message has no delivery time")
+	}
+	if msg.IsExpired(msg.DeliverAt) {
+		return ErrExpiresBeforeDelivery
+	}
+
+	msg.Status = models.MessageStatusScheduled
+	if err := s.store.CreateMessage(ctx, msg); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.push(&scheduledItem{msg: msg, due: msg.DeliverAt})
+	s.mu.Unlock()
+
+	s.notify()
+	return nil
+}
+
+// Cancel 取消用户owner尚未投递的消息
+// 消息属于其他用户时与消息不存在一样返回ErrNotScheduled，不暴露其他用户的消息ID
+func (s *Scheduler) Cancel(ctx context.Context, id, owner string) (*models.Message, error) {
+	s.mu.Lock()
+	item, ok := s.index[id]
+	if !ok || item.msg.User != owner {
+		s.mu.Unlock()
+		return nil, ErrNotScheduled
+	}
+	heap.Remove(&s.pending, item.index)
+	delete(s.index, id)
+	s.mu.Unlock()
+
+	msg := *item.msg
+	msg.Status = models.MessageStatusCancelled
+	if err := s.store.UpdateMessage(ctx, &msg); err != nil {
+		// 存储失败时恢复调度，避免消息丢失
+		s.mu.Lock()
+		s.push(item)
+		s.mu.Unlock()
+		s.notify()
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+// List 返回用户owner所有待投递消息的快照，按投递时间排序
+func (s *Scheduler) List(owner string) []*models.Message {
+	s.mu.Lock()
+	messages := make([]*models.Message, 0)
+	for _, item := range s.pending {
+		if item.msg.User != owner {
+			continue
+		}
+		msg := *item.msg
+		messages = append(messages, &msg)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].DeliverAt.Before(messages[j].DeliverAt)
+	})
+	return messages
+}
+
+// run 调度循环，在最早的投递时间到达时唤醒
+func (s *Scheduler) run(ctx context.Context) {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		wait := time.Hour
+		if len(s.pending) > 0 {
+			wait = time.Until(s.pending[0].due)
+		}
+		s.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-timer.C:
+			s.deliverDue(ctx)
+		}
+	}
+}
+
+// deliverDue 取出所有已到期的消息并异步投递
+func (s *Scheduler) deliverDue(ctx context.Context) {
+	now := time.Now()
+
+	s.mu.Lock()
+	var due []*scheduledItem
+	for len(s.pending) > 0 && !s.pending[0].due.After(now) {
+		item := heap.Pop(&s.pending).(*scheduledItem)
+		delete(s.index, item.msg.ID)
+		due = append(due, item)
+	}
+	s.mu.Unlock()
+
+	for _, item := range due {
+		s.wg.Add(1)
+		go s.deliver(ctx, item)
+	}
+}
+
+// deliver 投递单条消息并记录处理结果
+// 如果结果未能写回存储，消息保持scheduled状态，重启后会再次投递
+func (s *Scheduler) deliver(ctx context.Context, item *scheduledItem) {
+	defer s.wg.Done()
+	msg := item.msg
+
+	if markExpired(ctx, msg, s.onExpire) {
+		if err := s.store.UpdateMessage(ctx, msg); err != nil {
+			log.Printf("Failed to record expiry of message %s: %v", msg.ID, err)
+		}
+		return
+	}
+
+	result, err := s.dispatch(ctx, msg)
+	if err != nil && ctx.Err() != nil {
+		// 调度器停止时中断的消息保持scheduled状态，重启后再次投递
+		log.Printf("Delivery of message %s interrupted: %v", msg.ID, err)
+		return
+	}
+	if delay, ok := s.retryDelay(item.attempts, err); ok {
+		// 暂时无法处理，消息保持scheduled状态，稍后再次投递
+		log.Printf("Delivery of message %s deferred for %s: %v", msg.ID, delay, err)
+		item.attempts++
+		item.due = time.Now().Add(delay)
+		s.mu.Lock()
+		s.push(item)
+		s.mu.Unlock()
+		s.notify()
+		return
+	}
+	ApplyResult(msg, result, err)
+
+	if err := s.store.UpdateMessage(ctx, msg); err != nil {
+		log.Printf("Failed to record delivery of message %s: %v", msg.ID, err)
+	}
+}
+
+// retryDelay 判断err是否为暂时性错误，是则返回重新投递前的等待时间
+// 有建议的重试时间时使用建议值，否则从retryBackoff开始按尝试次数翻倍，不超过maxRetryBackoff
+func (s *Scheduler) retryDelay(attempts int, err error) (time.Duration, bool) {
+	var retry retryAfterError
+	switch {
+	case errors.As(err, &retry) && retry.RetryAfter() > 0:
+		return min(retry.RetryAfter(), s.maxRetryBackoff), true
+	case errors.As(err, &retry), errors.Is(err, ErrQueueFull), errors.Is(err, ErrQueueClosed):
+		delay := s.retryBackoff
+		for i := 0; i < attempts && delay < s.maxRetryBackoff; i++ {
+			delay *= 2
+		}
+		return min(delay, s.maxRetryBackoff), true
+	default:
+		return 0, false
+	}
+}
+
+// ApplyResult 根据处理结果设置消息的状态、结果和错误信息
+func ApplyResult(msg *models.Message, result string, err error) {
+	switch {
+	case errors.Is(err, ErrExpired):
+		msg.Status = models.MessageStatusExpired
+	case errors.Is(err, models.ErrMessageQuarantined):
+		msg.Status = models.MessageStatusQuarantined
+	case errors.Is(err, models.ErrMessagePending):
+		msg.Status = models.MessageStatusPending
+	case err != nil:
+		msg.Status = models.MessageStatusFailed
+		msg.Error = err.Error()
+	default:
+		msg.Status = models.MessageStatusProcessed
+		msg.Result = result
+	}
+}
+
+// push 将消息加入堆，调用方必须持有锁
+func (s *Scheduler) push(item *scheduledItem) {
+	heap.Push(&s.pending, item)
+	s.index[item.msg.ID] = item
+}
+
+// notify 唤醒调度循环重新计算等待时间
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// scheduledItem 堆中的元素
+type scheduledItem struct {
+	msg *models.Message
+	// due 下一次投递的时间，首次为消息的投递时间，暂时性错误后推迟
+	due time.Time
+	// attempts 因暂时性错误推迟的次数
+	attempts int
+	index    int
+}
+
+// messageHeap 按投递时间排序的最小堆
+type messageHeap []*scheduledItem
+
+func (h messageHeap) Len() int { return len(h) }
+
+func (h messageHeap) Less(i, j int) bool {
+	return h[i].due.Before(h[j].due)
+}
+
+func (h messageHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *messageHeap) Push(x interface{}) {
+	item := x.(*scheduledItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *messageHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
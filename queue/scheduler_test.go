@@ -0,0 +1,383 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/example/message_processor/models"
+)
+
+// memoryStore 在内存中保存消息的测试存储，每次更新都发送到updates
+type memoryStore struct {
+	mu       sync.Mutex
+	messages map[string]models.Message
+	updates  chan models.Message
+}
+
+func newMemoryStore(messages ...*models.Message) *memoryStore {
+	s := &memoryStore{messages: make(map[string]models.Message), updates: make(chan models.Message, 16)}
+	for _, msg := range messages {
+		s.messages[msg.ID] = *msg
+	}
+	return s
+}
+
+func (s *memoryStore) CreateMessage(ctx context.Context, msg *models.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages[msg.ID] = *msg
+	return nil
+}
+
+func (s *memoryStore) UpdateMessage(ctx context.Context, msg *models.Message) error {
+	s.mu.Lock()
+	s.messages[msg.ID] = *msg
+	s.mu.Unlock()
+	s.updates <- *msg
+	return nil
+}
+
+func (s *memoryStore) ListMessagesByStatus(ctx context.Context, status models.MessageStatus) ([]*models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []*models.Message
+	for _, msg := range s.messages {
+		if msg.Status == status {
+			msg := msg
+			messages = append(messages, &msg)
+		}
+	}
+	return messages, nil
+}
+
+// get 返回存储中的消息
+func (s *memoryStore) get(id string) models.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.messages[id]
+}
+
+// next 等待下一次更新
+func (s *memoryStore) next(t *testing.T) models.Message {
+	t.Helper()
+	select {
+	case msg := <-s.updates:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message update")
+		return models.Message{}
+	}
+}
+
+// echo 原样返回消息内容并统计调用次数的DispatchFunc
+func echo(calls *int32) DispatchFunc {
+	return func(ctx context.Context, msg *models.Message) (string, error) {
+		atomic.AddInt32(calls, 1)
+		return msg.Content, nil
+	}
+}
+
+func startScheduler(t *testing.T, store Store, dispatch DispatchFunc) *Scheduler {
+	t.Helper()
+	s := NewScheduler(store, dispatch)
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Stop)
+	return s
+}
+
+func TestSchedulerDelivers(t *testing.T) {
+	var calls int32
+	store := newMemoryStore()
+	s := startScheduler(t, store, echo(&calls))
+
+	deliverAt := time.Now().Add(20 * time.Millisecond)
+	if err := s.Schedule(context.Background(), &models.Message{ID: "m1", Content: "hello", DeliverAt: deliverAt}); err != nil {
+		t.Fatal(err)
+	}
+	if stored := store.get("m1"); stored.Status != models.MessageStatusScheduled {
+		t.Errorf("stored status %s, want scheduled", stored.Status)
+	}
+
+	delivered := store.next(t)
+	if delivered.Status != models.MessageStatusProcessed || delivered.Result != "hello" {
+		t.Errorf("delivered %+v", delivered)
+	}
+	if time.Now().Before(deliverAt) {
+		t.Error("delivered before deliver_at")
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("dispatched %d times", n)
+	}
+}
+
+func TestSchedulerRejectsBadMessages(t *testing.T) {
+	s := NewScheduler(newMemoryStore(), echo(new(int32)))
+	now := time.Now()
+	tests := []struct {
+		name string
+		msg  *models.Message
+		want error
+	}{
+		{"no delivery time", &models.Message{ID: "a"}, nil},
+		{"expires first", &models.Message{ID: "b", DeliverAt: now.Add(time.Hour), ExpiresAt: now.Add(time.Minute)}, ErrExpiresBeforeDelivery},
+	}
+	for _, tt := range tests {
+		err := s.Schedule(context.Background(), tt.msg)
+		if err == nil || (tt.want != nil && !errors.Is(err, tt.want)) {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+}
+
+func TestSchedulerRestoresAndExpires(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	store := newMemoryStore(
+		&models.Message{ID: "due", Content: "due", Status: models.MessageStatusScheduled, DeliverAt: past},
+		&models.Message{ID: "expired", Status: models.MessageStatusScheduled, DeliverAt: past, ExpiresAt: past.Add(time.Second)},
+		&models.Message{ID: "done", Status: models.MessageStatusProcessed, DeliverAt: past},
+	)
+	var calls int32
+	var expired []string
+	s := NewScheduler(store, echo(&calls))
+	s.SetExpiryHandler(ExpiryHandlerFunc(func(ctx context.Context, msg *models.Message) {
+		expired = append(expired, msg.ID)
+	}))
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := make(map[string]models.MessageStatus)
+	for i := 0; i < 2; i++ {
+		msg := store.next(t)
+		statuses[msg.ID] = msg.Status
+	}
+	s.Stop()
+
+	if statuses["due"] != models.MessageStatusProcessed || statuses["expired"] != models.MessageStatusExpired {
+		t.Errorf("statuses %v", statuses)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("dispatched %d times, want only the unexpired message", n)
+	}
+	if len(expired) != 1 || expired[0] != "expired" {
+		t.Errorf("expiry handler called for %v", expired)
+	}
+}
+
+func TestSchedulerListAndCancelByOwner(t *testing.T) {
+	store := newMemoryStore()
+	s := startScheduler(t, store, echo(new(int32)))
+	later := time.Now().Add(time.Hour)
+	for _, msg := range []*models.Message{
+		{ID: "a2", User: "alice", DeliverAt: later.Add(time.Minute)},
+		{ID: "a1", User: "alice", DeliverAt: later},
+		{ID: "b1", User: "bob", DeliverAt: later},
+	} {
+		if err := s.Schedule(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := s.List("alice")
+	if len(list) != 2 || list[0].ID != "a1" || list[1].ID != "a2" {
+		t.Errorf("alice sees %v", list)
+	}
+	if list := s.List("mallory"); len(list) != 0 {
+		t.Errorf("mallory sees %d messages", len(list))
+	}
+
+	if _, err := s.Cancel(context.Background(), "b1", "alice"); !errors.Is(err, ErrNotScheduled) {
+		t.Errorf("cancelled another user's message: %v", err)
+	}
+	msg, err := s.Cancel(context.Background(), "b1", "bob")
+	if err != nil || msg.Status != models.MessageStatusCancelled {
+		t.Fatalf("owner cancel: %+v, %v", msg, err)
+	}
+	if _, err := s.Cancel(context.Background(), "b1", "bob"); !errors.Is(err, ErrNotScheduled) {
+		t.Errorf("second cancel: %v", err)
+	}
+	if stored := store.get("b1"); stored.Status != models.MessageStatusCancelled {
+		t.Errorf("stored status %s", stored.Status)
+	}
+}
+
+func TestSchedulerStopKeepsInterruptedMessage(t *testing.T) {
+	started := make(chan struct{})
+	store := newMemoryStore()
+	s := NewScheduler(store, func(ctx context.Context, msg *models.Message) (string, error) {
+		close(started)
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Schedule(context.Background(), &models.Message{ID: "m1", DeliverAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	s.Stop()
+
+	select {
+	case msg := <-store.updates:
+		t.Errorf("interrupted delivery recorded as %s", msg.Status)
+	default:
+	}
+	if stored := store.get("m1"); stored.Status != models.MessageStatusScheduled {
+		t.Errorf("stored status %s, want scheduled", stored.Status)
+	}
+}
+
+// purgeStore 记录每次清理的截止时间
+type purgeStore struct {
+	mu      sync.Mutex
+	befores []time.Time
+}
+
+func (s *purgeStore) PurgeExpiredMessages(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.befores = append(s.befores, before)
+	return 1, nil
+}
+
+func (s *purgeStore) calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.befores)
+}
+
+func TestSweeper(t *testing.T) {
+	store := &purgeStore{}
+	sweeper := NewSweeper(store, SweeperConfig{Interval: 5 * time.Millisecond, Retention: time.Hour})
+
+	if n, err := sweeper.Sweep(context.Background()); n != 1 || err != nil {
+		t.Fatalf("sweep: %d, %v", n, err)
+	}
+	if age := time.Since(store.befores[0]); age < time.Hour || age > time.Hour+time.Second {
+		t.Errorf("purged records older than %s, want the retention period", age)
+	}
+
+	sweeper.Start(context.Background())
+	deadline := time.Now().Add(2 * time.Second)
+	for store.calls() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	sweeper.Stop()
+	if store.calls() < 3 {
+		t.Fatalf("sweeper ran %d times", store.calls())
+	}
+	stopped := store.calls()
+	time.Sleep(20 * time.Millisecond)
+	if store.calls() != stopped {
+		t.Error("sweeper kept running after Stop")
+	}
+}
+
+// busyError 带有建议重试时间的测试错误
+type busyError struct {
+	after time.Duration
+}
+
+func (e busyError) Error() string { return "busy" }
+
+func (e busyError) RetryAfter() time.Duration { return e.after }
+
+func TestSchedulerRetryDelay(t *testing.T) {
+	s := NewScheduler(newMemoryStore(), nil)
+	tests := []struct {
+		name     string
+		attempts int
+		err      error
+		delay    time.Duration
+		retry    bool
+	}{
+		{"queue full", 0, ErrQueueFull, time.Second, true},
+		{"queue closed doubles", 2, fmt.Errorf("submit: %w", ErrQueueClosed), 4 * time.Second, true},
+		{"backoff capped", 20, ErrQueueFull, time.Minute, true},
+		{"retry-after hint", 5, busyError{after: 3 * time.Second}, 3 * time.Second, true},
+		{"hint capped", 0, busyError{after: time.Hour}, time.Minute, true},
+		{"hint missing", 1, busyError{}, 2 * time.Second, true},
+		{"processor error", 0, errors.New("boom"), 0, false},
+		{"expired", 0, ErrExpired, 0, false},
+	}
+	for _, tt := range tests {
+		delay, retry := s.retryDelay(tt.attempts, tt.err)
+		if delay != tt.delay || retry != tt.retry {
+			t.Errorf("%s: %s, %v, want %s, %v", tt.name, delay, retry, tt.delay, tt.retry)
+		}
+	}
+}
+
+func TestSchedulerRetriesTransientErrors(t *testing.T) {
+	var calls int32
+	store := newMemoryStore()
+	s := NewScheduler(store, func(ctx context.Context, msg *models.Message) (string, error) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			return "", ErrQueueFull
+		case 2:
+			return "", busyError{after: 20 * time.Millisecond}
+		default:
+			return "done", nil
+		}
+	})
+	s.retryBackoff = 5 * time.Millisecond
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	start := time.Now()
+	if err := s.Schedule(context.Background(), &models.Message{ID: "m1", User: "alice", DeliverAt: start}); err != nil {
+		t.Fatal(err)
+	}
+	// 暂时性错误不写回存储，第一次记录的就是最终结果
+	msg := store.next(t)
+	if msg.Status != models.MessageStatusProcessed || msg.Result != "done" {
+		t.Fatalf("first recorded state %+v, want processed after retries", msg)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("dispatched %d times, want 3", n)
+	}
+	if elapsed := time.Since(start); elapsed < 25*time.Millisecond {
+		t.Errorf("retried after %s, ignoring backoff and Retry-After", elapsed)
+	}
+}
+
+func TestSchedulerDeferredMessageCanBeCancelled(t *testing.T) {
+	store := newMemoryStore()
+	deferred := make(chan struct{}, 1)
+	s := NewScheduler(store, func(ctx context.Context, msg *models.Message) (string, error) {
+		deferred <- struct{}{}
+		return "", ErrQueueFull
+	})
+	s.retryBackoff = time.Hour
+	s.maxRetryBackoff = time.Hour
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	if err := s.Schedule(context.Background(), &models.Message{ID: "m1", User: "alice", DeliverAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	<-deferred
+	deadline := time.Now().Add(2 * time.Second)
+	for len(s.List("alice")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := s.Cancel(context.Background(), "m1", "alice"); err != nil {
+		t.Fatalf("cancel deferred message: %v", err)
+	}
+	if msg := store.next(t); msg.Status != models.MessageStatusCancelled {
+		t.Errorf("recorded %s, want cancelled", msg.Status)
+	}
+}
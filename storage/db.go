@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
@@ -26,6 +27,25 @@ type DB interface {
 	UpdateUser(ctx context.Context, user *models.User) error
 	DeleteUser(ctx context.Context, id int) error
 
+	// 消息相关操作
+	CreateMessage(ctx context.Context, msg *models.Message) error
+	GetMessage(ctx context.Context, id string) (*models.Message, error)
+	UpdateMessage(ctx context.Context, msg *models.Message) error
+	SaveMessage(ctx context.Context, msg *models.Message) error
+	ListMessagesByStatus(ctx context.Context, status models.MessageStatus) ([]*models.Message, error)
+	QueryMessages(ctx context.Context, q MessageQuery) (*MessagePage, error)
+	SearchMessages(ctx context.Context, q SearchQuery) (*SearchPage, error)
+	PurgeExpiredMessages(ctx context.Context, before time.Time) (int64, error)
+
+	// 导入导出
+	ExportMessages(ctx context.Context, w io.Writer, q MessageQuery) (int, error)
+	ExportUsers(ctx context.Context, w io.Writer) (int, error)
+	ImportMessages(ctx context.Context, r io.Reader, policy ConflictPolicy) (ImportStats, error)
+	ImportUsers(ctx context.Context, r io.Reader, policy ConflictPolicy) (ImportStats, error)
+
+	// 数据库结构管理
+	Migrate(ctx context.Context) error
+
 	// 事务管理
 	BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error)
 }
@@ -54,6 +74,25 @@ type DBConfig struct {
 	Password string
 	DBName   string
 	SSLMode  string
+	// 连接池参数，为0时使用默认值
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// NewDBConfig 根据应用配置创建数据库配置
+func NewDBConfig(c models.DatabaseConfig) DBConfig {
+	return DBConfig{
+		Host:            c.Host,
+		Port:            c.Port,
+		User:            c.User,
+		Password:        c.Password,
+		DBName:          c.DBName,
+		SSLMode:         c.SSLMode,
+		MaxOpenConns:    c.MaxOpenConns,
+		MaxIdleConns:    c.MaxIdleConns,
+		ConnMaxLifetime: c.ConnMaxLifetime,
+	}
 }
 
 // NewPostgresDB 创建新的PostgreSQL数据库实例
@@ -77,9 +116,13 @@ func (p *PostgresDB) Connect(ctx context.Context) error {
 	}
 
 	// 设置连接池参数
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(5)
-	db.SetConnMaxLifetime(5 * time.Minute)
+	db.SetMaxOpenConns(orDefault(p.config.MaxOpenConns, 25))
+	db.SetMaxIdleConns(orDefault(p.config.MaxIdleConns, 5))
+	lifetime := p.config.ConnMaxLifetime
+	if lifetime <= 0 {
+		lifetime = 5 * time.Minute
+	}
+	db.SetConnMaxLifetime(lifetime)
 
 	// 测试连接
 	if err := db.PingContext(ctx); err != nil {
@@ -90,6 +133,14 @@ func (p *PostgresDB) Connect(ctx context.Context) error {
 	return nil
 }
 
+// orDefault value不大于0时返回def
+func orDefault(value, def int) int {
+	if value <= 0 {
+		return def
+	}
+	return value
+}
+
 // Disconnect 断开数据库连接
 func (p *PostgresDB) Disconnect(ctx context.Context) error {
 	if p.db != nil {
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/example/message_processor/models"
+)
+
+// 消息历史查询
+// 按创建时间倒序分页，游标记录上一页最后一条消息的创建时间和ID，
+// 翻页期间写入的新消息不会导致重复或遗漏
+
+// DefaultQueryLimit 未指定每页数量时的默认值
+const DefaultQueryLimit = 50
+
+// MaxQueryLimit 每页数量的上限
+const MaxQueryLimit = 500
+
+// ErrInvalidCursor 分页游标无法解析
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// MessageQuery 消息历史查询条件，零值字段表示不过滤
+type MessageQuery struct {
+	// From 创建时间下限（含）
+	From time.Time
+	// To 创建时间上限（不含）
+	To        time.Time
+	Processor string
+	Status    models.MessageStatus
+	User      string
+	// Cursor 上一页返回的NextCursor，为空时从最新的消息开始
+	Cursor string
+	Limit  int
+}
+
+// MessagePage 一页查询结果
+type MessagePage struct {
+	Messages []*models.Message `json:"messages"`
+	// NextCursor 下一页的游标，没有更多消息时为空
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// QueryMessages 按条件查询消息历史
+func (p *PostgresDB) QueryMessages(ctx context.Context, q MessageQuery) (*MessagePage, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	if limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+
+	var where whereClause
+	where.filter(q)
+	if q.Cursor != "" {
+		createdAt, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		where.add("(created_at, id) < (?, ?)", createdAt, id)
+	}
+
+	// 多取一条用于判断是否还有下一页
+	query := `SELECT ` + messageColumns + ` FROM messages` + where.String() +
+		` ORDER BY created_at DESC, id DESC LIMIT ` + where.bind(limit+1)
+
+	rows, err := p.db.QueryContext(ctx, query, where.args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer rows.Close()
+
+	page := &MessagePage{Messages: []*models.Message{}}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		page.Messages = append(page.Messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+
+	if len(page.Messages) > limit {
+		page.Messages = page.Messages[:limit]
+		last := page.Messages[limit-1]
+		page.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	return page, nil
+}
+
+// whereClause 逐步构造查询条件和参数
+type whereClause struct {
+	conditions []string
+	args       []interface{}
+}
+
+// bind 添加一个参数，返回其$n占位符
+func (w *whereClause) bind(value interface{}) string {
+	w.args = append(w.args, value)
+	return fmt.Sprintf("$%d", len(w.args))
+}
+
+// add 添加一个条件，条件中的?按顺序替换为$n占位符
+func (w *whereClause) add(condition string, values ...interface{}) {
+	for _, v := range values {
+		condition = strings.Replace(condition, "?", w.bind(v), 1)
+	}
+	w.conditions = append(w.conditions, condition)
+}
+
+// filter 添加查询条件中的过滤字段，不包括游标
+func (w *whereClause) filter(q MessageQuery) {
+	if !q.From.IsZero() {
+		w.add("created_at >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		w.add("created_at < ?", q.To)
+	}
+	if q.Processor != "" {
+		w.add("processor = ?", q.Processor)
+	}
+	if q.Status != "" {
+		w.add("status = ?", q.Status)
+	}
+	if q.User != "" {
+		w.add("submitted_by = ?", q.User)
+	}
+}
+
+// String 返回WHERE子句，没有条件时返回空字符串
+func (w *whereClause) String() string {
+	if len(w.conditions) == 0 {
+		return ""
+	}
+	return ` WHERE ` + strings.Join(w.conditions, " AND ")
+}
+
+// encodeCursor 将创建时间和ID编码为游标
+func encodeCursor(createdAt time.Time, id string) string {
+	raw := createdAt.UTC().Format(time.RFC3339Nano) + "|" + id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor 解析游标
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return createdAt, id, nil
+}
@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/example/message_processor/models"
+)
+
+// ErrMessageNotFound 消息不存在
+var ErrMessageNotFound = errors.New("message not found")
+
+// messageColumns 消息表查询列，顺序与scanMessage保持一致
+const messageColumns = `id, content, processor, submitted_by, status, priority, result, error, metadata, latency_us, deliver_at, expires_at, created_at, updated_at`
+
+// rowScanner 抽象sql.Row和sql.Rows的Scan方法
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanMessage 从查询结果中读取一条消息
+func scanMessage(row rowScanner) (*models.Message, error) {
+	var msg models.Message
+	var deliverAt, expiresAt sql.NullTime
+	var metadata []byte
+	var latencyUS int64
+
+	err := row.Scan(
+		&msg.ID, &msg.Content, &msg.Processor, &msg.User, &msg.Status, &msg.Priority, &msg.Result, &msg.Error,
+		&metadata, &latencyUS, &deliverAt, &expiresAt, &msg.CreatedAt, &msg.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(metadata) > 0 {
+		if err := json.Unmarshal(metadata, &msg.Metadata); err != nil {
+			return nil, fmt.Errorf("invalid metadata of message %s: %w", msg.ID, err)
+		}
+	}
+	msg.Latency = time.Duration(latencyUS) * time.Microsecond
+
+	if deliverAt.Valid {
+		msg.DeliverAt = deliverAt.Time
+	}
+	if expiresAt.Valid {
+		msg.ExpiresAt = expiresAt.Time
+	}
+	return &msg, nil
+}
+
+// nullTime 将零值时间转换为数据库NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// nullJSON 将元数据编码为JSON，空元数据转换为数据库NULL
+func nullJSON(metadata map[string]interface{}) ([]byte, error) {
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	return data, nil
+}
+
+// CreateMessage 创建消息
+func (p *PostgresDB) CreateMessage(ctx context.Context, msg *models.Message) error {
+	query := `
+		INSERT INTO messages (` + messageColumns + `, search_grams)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+	`
+
+	now := time.Now()
+	msg.CreatedAt = now
+	msg.UpdatedAt = now
+	if msg.Priority == "" {
+		msg.Priority = models.PriorityNormal
+	}
+
+	metadata, err := nullJSON(msg.Metadata)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.db.ExecContext(ctx, query,
+		msg.ID, msg.Content, msg.Processor, msg.User, msg.Status, msg.Priority, msg.Result, msg.Error,
+		metadata, msg.Latency.Microseconds(), nullTime(msg.DeliverAt), nullTime(msg.ExpiresAt),
+		msg.CreatedAt, msg.UpdatedAt, searchGrams(msg))
+	if err != nil {
+		return fmt.Errorf("failed to create message: %w", err)
+	}
+
+	return nil
+}
+
+// SaveMessage 保存处理完成的消息，消息已存在时更新内容、状态和处理结果
+// 立即处理的消息只在处理完成后写入一次，定时消息在创建时已经写入。
+// 内容和search_grams一起更新，search_vector与search_grams总是由同一份（已遮盖的）文本生成
+func (p *PostgresDB) SaveMessage(ctx context.Context, msg *models.Message) error {
+	query := `
+		INSERT INTO messages (` + messageColumns + `, search_grams)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		ON CONFLICT (id) DO UPDATE
+		SET content = EXCLUDED.content, status = EXCLUDED.status, result = EXCLUDED.result, error = EXCLUDED.error,
+			metadata = EXCLUDED.metadata, latency_us = EXCLUDED.latency_us, updated_at = EXCLUDED.updated_at,
+			search_grams = EXCLUDED.search_grams
+	`
+
+	now := time.Now()
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = now
+	}
+	msg.UpdatedAt = now
+	if msg.Priority == "" {
+		msg.Priority = models.PriorityNormal
+	}
+
+	metadata, err := nullJSON(msg.Metadata)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.db.ExecContext(ctx, query,
+		msg.ID, msg.Content, msg.Processor, msg.User, msg.Status, msg.Priority, msg.Result, msg.Error,
+		metadata, msg.Latency.Microseconds(), nullTime(msg.DeliverAt), nullTime(msg.ExpiresAt),
+		msg.CreatedAt, msg.UpdatedAt, searchGrams(msg))
+	if err != nil {
+		return fmt.Errorf("failed to save message: %w", err)
+	}
+
+	return nil
+}
+
+// GetMessage 根据ID获取消息
+func (p *PostgresDB) GetMessage(ctx context.Context, id string) (*models.Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE id = $1`
+
+	msg, err := scanMessage(p.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMessageNotFound
+		}
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	return msg, nil
+}
+
+// UpdateMessage 更新消息的内容、状态、处理结果、元数据和耗时
+// 内容和search_grams一起更新，search_vector与search_grams总是由同一份（已遮盖的）文本生成
+func (p *PostgresDB) UpdateMessage(ctx context.Context, msg *models.Message) error {
+	query := `
+		UPDATE messages
+		SET content = $1, status = $2, result = $3, error = $4, metadata = $5, latency_us = $6, updated_at = $7,
+			search_grams = $8
+		WHERE id = $9
+	`
+
+	msg.UpdatedAt = time.Now()
+
+	metadata, err := nullJSON(msg.Metadata)
+	if err != nil {
+		return err
+	}
+
+	result, err := p.db.ExecContext(ctx, query,
+		msg.Content, msg.Status, msg.Result, msg.Error, metadata, msg.Latency.Microseconds(), msg.UpdatedAt,
+		searchGrams(msg), msg.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update message: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrMessageNotFound
+	}
+
+	return nil
+}
+
+// ListMessagesByStatus 获取指定状态的所有消息，按投递时间排序
+func (p *PostgresDB) ListMessagesByStatus(ctx context.Context, status models.MessageStatus) ([]*models.Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE status = $1 ORDER BY deliver_at, created_at`
+
+	rows, err := p.db.QueryContext(ctx, query, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []*models.Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// PurgeExpiredMessages 删除有效期早于before且状态为expired的消息，返回删除数量
+// 仍在等待投递的消息由调度器标记为expired后再清理；已处理和处理失败的消息属于处理历史，不会被删除
+func (p *PostgresDB) PurgeExpiredMessages(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM messages
+		WHERE expires_at IS NOT NULL AND expires_at < $1 AND status = $2
+	`
+
+	result, err := p.db.ExecContext(ctx, query, before, models.MessageStatusExpired)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired messages: %w", err)
+	}
+
+	return result.RowsAffected()
+}
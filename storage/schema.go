@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// schemaStatements 数据库结构定义
+// 所有语句都必须是幂等的，服务启动时按顺序执行
+var schemaStatements = []string{
+	`CREATE TABLE IF NOT EXISTS messages (
+		id         TEXT PRIMARY KEY,
+		content    TEXT NOT NULL,
+		status     TEXT NOT NULL,
+		result     TEXT NOT NULL DEFAULT '',
+		error      TEXT NOT NULL DEFAULT '',
+		deliver_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_messages_status_deliver_at
+		ON messages (status, deliver_at)`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ`,
+	`CREATE INDEX IF NOT EXISTS idx_messages_expires_at
+		ON messages (expires_at) WHERE expires_at IS NOT NULL`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS priority TEXT NOT NULL DEFAULT 'normal'`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS processor TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS submitted_by TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS latency_us BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS metadata JSONB`,
+	`CREATE INDEX IF NOT EXISTS idx_messages_created_at
+		ON messages (created_at DESC, id DESC)`,
+	`CREATE INDEX IF NOT EXISTS idx_messages_processor_created_at
+		ON messages (processor, created_at DESC)`,
+	`CREATE INDEX IF NOT EXISTS idx_messages_submitted_by_created_at
+		ON messages (submitted_by, created_at DESC)`,
+	// search_grams保存中日韩文本的n-gram，由应用写入；该列加入之前的消息需要重新保存才能按中文检索
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_grams TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('english', content), 'A') ||
+			setweight(to_tsvector('english', result), 'B') ||
+			setweight(to_tsvector('simple', search_grams), 'C')
+		) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_messages_search_vector
+		ON messages USING GIN (search_vector)`,
+	`CREATE TABLE IF NOT EXISTS users (
+		id         SERIAL PRIMARY KEY,
+		username   TEXT NOT NULL UNIQUE,
+		email      TEXT NOT NULL DEFAULT '',
+		password   TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	)`,
+}
+
+// Migrate 创建或更新数据库结构
+func (p *PostgresDB) Migrate(ctx context.Context) error {
+	for _, stmt := range schemaStatements {
+		if _, err := p.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to migrate schema: %w", err)
+		}
+	}
+	return nil
+}
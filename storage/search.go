@@ -0,0 +1,325 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/example/message_processor/models"
+)
+
+// 消息全文检索
+// 输入和输出按english配置建立tsvector（输入权重高于输出）。english解析器不会切分中日韩文本，
+// 这部分文本由应用切分为单字和二元组写入search_grams列，按simple配置加入同一个tsvector；
+// 查询中的中日韩片段同样切分为n-gram，要求全部命中。
+// 摘要在应用中生成，命中的词用<mark></mark>包围，其余文本经过HTML转义。
+// 索引只由content、result列和同一份文本切分出的search_grams生成；脱敏处理器的消息在保存前
+// 已经遮盖（见api.InputRedactor），原始的敏感信息既不落库也不会进入索引
+
+// ErrEmptySearch 检索词为空
+var ErrEmptySearch = errors.New("search query is empty")
+
+// snippetRunes 摘要的最大长度
+const snippetRunes = 160
+
+// snippetLead 摘要中第一个命中位置之前保留的字符数
+const snippetLead = 40
+
+// SearchQuery 全文检索条件
+// MessageQuery中的过滤字段和Limit同样生效，Cursor不使用，结果按相关度排序后按Offset分页
+type SearchQuery struct {
+	Text string
+	MessageQuery
+	Offset int
+}
+
+// SearchResult 一条检索结果
+type SearchResult struct {
+	Message *models.Message `json:"message"`
+	Rank    float64         `json:"rank"`
+	// Snippets 命中字段（content、result）的高亮摘要
+	Snippets map[string]string `json:"snippets"`
+}
+
+// SearchPage 一页检索结果
+type SearchPage struct {
+	Results []SearchResult `json:"results"`
+	// NextOffset 下一页的offset，没有更多结果时为0
+	NextOffset int `json:"next_offset,omitempty"`
+}
+
+// SearchMessages 按内容检索消息，结果按相关度从高到低排序
+func (p *PostgresDB) SearchMessages(ctx context.Context, q SearchQuery) (*SearchPage, error) {
+	words, runs := splitSearchText(q.Text)
+	if strings.TrimSpace(words) == "" && len(runs) == 0 {
+		return nil, ErrEmptySearch
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	if limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+	offset := q.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	var where whereClause
+	var parts []string
+	if strings.TrimSpace(words) != "" {
+		parts = append(parts, "websearch_to_tsquery('english', "+where.bind(words)+")")
+	}
+	for _, run := range runs {
+		parts = append(parts, "to_tsquery('simple', "+where.bind(strings.Join(ngrams(run), " & "))+")")
+	}
+	tsquery := strings.Join(parts, " && ")
+
+	where.add("search_vector @@ q.query")
+	where.filter(q.MessageQuery)
+
+	query := `SELECT ` + messageColumns + `, ts_rank_cd(search_vector, q.query) AS rank
+		FROM messages CROSS JOIN (SELECT ` + tsquery + ` AS query) q` + where.String() +
+		fmt.Sprintf(` ORDER BY rank DESC, created_at DESC, id DESC LIMIT %s OFFSET %s`,
+			where.bind(limit+1), where.bind(offset))
+
+	rows, err := p.db.QueryContext(ctx, query, where.args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+
+	terms := highlightTerms(words, runs)
+	page := &SearchPage{Results: []SearchResult{}}
+	for rows.Next() {
+		var rank float64
+		msg, err := scanMessage(rankScanner{rows, &rank})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		page.Results = append(page.Results, SearchResult{
+			Message:  msg,
+			Rank:     rank,
+			Snippets: snippets(msg, terms),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+
+	if len(page.Results) > limit {
+		page.Results = page.Results[:limit]
+		page.NextOffset = offset + limit
+	}
+	return page, nil
+}
+
+// rankScanner 在消息列之后额外读取相关度
+type rankScanner struct {
+	row  rowScanner
+	rank *float64
+}
+
+// Scan 实现rowScanner接口
+func (s rankScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.rank)...)
+}
+
+// isCJK 判断字符是否属于中日韩文字
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+// splitSearchText 将文本拆分为非中日韩部分和连续的中日韩片段
+// 中日韩片段在非中日韩部分中替换为空格
+func splitSearchText(text string) (string, []string) {
+	var words strings.Builder
+	var runs []string
+	var run []rune
+	flush := func() {
+		if len(run) > 0 {
+			runs = append(runs, string(run))
+			run = run[:0]
+		}
+	}
+
+	for _, r := range text {
+		if isCJK(r) {
+			run = append(run, r)
+			continue
+		}
+		if len(run) > 0 {
+			flush()
+			words.WriteRune(' ')
+		}
+		words.WriteRune(r)
+	}
+	flush()
+	return words.String(), runs
+}
+
+// ngrams 返回片段的单字和相邻二元组，单字片段只返回单字
+func ngrams(run string) []string {
+	runes := []rune(run)
+	if len(runes) == 1 {
+		return []string{run}
+	}
+	grams := make([]string, 0, len(runes)-1)
+	for i := 0; i+1 < len(runes); i++ {
+		grams = append(grams, string(runes[i:i+2]))
+	}
+	return grams
+}
+
+// searchGrams 返回写入search_grams列的文本，包含输入和输出中所有中日韩片段的单字和二元组
+// 必须与同一次写入的content、result取自同一个msg，保证n-gram不包含未写入的原始文本
+func searchGrams(msg *models.Message) string {
+	var grams []string
+	for _, text := range []string{msg.Content, msg.Result} {
+		_, runs := splitSearchText(text)
+		for _, run := range runs {
+			for _, r := range run {
+				grams = append(grams, string(r))
+			}
+			if len([]rune(run)) > 1 {
+				grams = append(grams, ngrams(run)...)
+			}
+		}
+	}
+	return strings.Join(grams, " ")
+}
+
+// highlightTerms 返回用于生成摘要的小写检索词
+// 英文词去掉常见词尾，以便匹配词形变化
+func highlightTerms(words string, runs []string) []string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(words), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if word == "or" {
+			continue
+		}
+		terms = append(terms, stem(word))
+	}
+	for _, run := range runs {
+		terms = append(terms, strings.ToLower(run))
+	}
+	return terms
+}
+
+// stem 去掉英文词的常见词尾，剩余部分至少保留3个字符
+func stem(word string) string {
+	for _, suffix := range []string{"ing", "ed", "es", "s"} {
+		if strings.HasSuffix(word, suffix) && len(word)-len(suffix) >= 3 {
+			return strings.TrimSuffix(word, suffix)
+		}
+	}
+	return word
+}
+
+// snippets 为命中的字段生成摘要，都没有命中时返回输入开头的摘要
+func snippets(msg *models.Message, terms []string) map[string]string {
+	result := make(map[string]string)
+	for field, text := range map[string]string{"content": msg.Content, "result": msg.Result} {
+		if s, ok := highlight(text, terms); ok {
+			result[field] = s
+		}
+	}
+	if len(result) == 0 {
+		s, _ := highlight(msg.Content, nil)
+		result["content"] = s
+	}
+	return result
+}
+
+// highlight 截取第一个命中位置附近的文本并标记所有命中的检索词
+// 英文检索词匹配到所在单词的结尾，返回是否有命中
+func highlight(text string, terms []string) (string, bool) {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	// marked[i]表示第i个字符位于命中范围内
+	marked := make([]bool, len(runes))
+	first := -1
+	for _, term := range terms {
+		t := []rune(term)
+		if len(t) == 0 {
+			continue
+		}
+		for i := 0; i+len(t) <= len(lower); i++ {
+			if !hasPrefixAt(lower, t, i) {
+				continue
+			}
+			end := i + len(t)
+			if !isCJK(t[0]) {
+				// 英文词只从单词开头匹配，并延伸到单词结尾
+				if i > 0 && isWordRune(lower[i-1]) {
+					continue
+				}
+				for end < len(lower) && isWordRune(lower[end]) {
+					end++
+				}
+			}
+			for j := i; j < end; j++ {
+				marked[j] = true
+			}
+			if first < 0 || i < first {
+				first = i
+			}
+		}
+	}
+
+	start := 0
+	if first > snippetLead {
+		start = first - snippetLead
+	}
+	end := start + snippetRunes
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; {
+		j := i
+		for j < end && marked[j] == marked[i] {
+			j++
+		}
+		segment := html.EscapeString(string(runes[i:j]))
+		if marked[i] {
+			segment = "<mark>" + segment + "</mark>"
+		}
+		b.WriteString(segment)
+		i = j
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String(), first >= 0
+}
+
+// hasPrefixAt 判断s从第i个字符开始是否以prefix开头
+func hasPrefixAt(s, prefix []rune, i int) bool {
+	for j, r := range prefix {
+		if s[i+j] != r {
+			return false
+		}
+	}
+	return true
+}
+
+// isWordRune 判断字符是否属于英文单词
+func isWordRune(r rune) bool {
+	return (unicode.IsLetter(r) || unicode.IsDigit(r)) && !isCJK(r)
+}
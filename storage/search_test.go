@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/example/message_processor/models"
+)
+
+func TestSplitSearchText(t *testing.T) {
+	tests := []struct {
+		text  string
+		words string
+		runs  []string
+	}{
+		{"plain english", "plain english", nil},
+		{"订单已发货", "", []string{"订单已发货"}},
+		{"order 订单 shipped 已发货!", "order   shipped  !", []string{"订单", "已发货"}},
+		{"カタカナとひらがな mixed", "  mixed", []string{"カタカナとひらがな"}},
+		{"한국어test", " test", []string{"한국어"}},
+	}
+	for _, tt := range tests {
+		words, runs := splitSearchText(tt.text)
+		if words != tt.words || strings.Join(runs, "|") != strings.Join(tt.runs, "|") {
+			t.Errorf("splitSearchText(%q) = %q, %q; want %q, %q", tt.text, words, runs, tt.words, tt.runs)
+		}
+	}
+}
+
+func TestNgrams(t *testing.T) {
+	tests := []struct {
+		run   string
+		grams []string
+	}{
+		{"中", []string{"中"}},
+		{"中文", []string{"中文"}},
+		{"订单发货", []string{"订单", "单发", "发货"}},
+	}
+	for _, tt := range tests {
+		if grams := ngrams(tt.run); strings.Join(grams, "|") != strings.Join(tt.grams, "|") {
+			t.Errorf("ngrams(%q) = %q, want %q", tt.run, grams, tt.grams)
+		}
+	}
+
+	msg := &models.Message{Content: "订单 ok", Result: "发货"}
+	if grams := searchGrams(msg); grams != "订 单 订单 发 货 发货" {
+		t.Errorf("searchGrams = %q", grams)
+	}
+}
+
+func TestHighlightTerms(t *testing.T) {
+	words, runs := splitSearchText("Shipping OR orders 发货")
+	if terms := highlightTerms(words, runs); strings.Join(terms, " ") != "shipp order 发货" {
+		t.Errorf("terms %q", terms)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	long := strings.Repeat("filler ", 20)
+	tests := []struct {
+		name  string
+		text  string
+		terms []string
+		want  string
+		hit   bool
+	}{
+		{"word extended to its end", "Orders shipped today", []string{"ship"}, "Orders <mark>shipped</mark> today", true},
+		{"word start only", "reship the order", []string{"ship", "order"}, "reship the <mark>order</mark>", true},
+		{"case insensitive", "ORDER ready", []string{"order"}, "<mark>ORDER</mark> ready", true},
+		{"cjk substring", "您的订单已发货", []string{"发货"}, "您的订单已<mark>发货</mark>", true},
+		{"adjacent hits merged", "订单发货", []string{"订单", "发货"}, "<mark>订单发货</mark>", true},
+		{"markup escaped", "<b>order</b> & co", []string{"order"}, "&lt;b&gt;<mark>order</mark>&lt;/b&gt; &amp; co", true},
+		{"no hit", "nothing here", []string{"order"}, "nothing here", false},
+		{"cut before first hit", long + "order", []string{"order"}, "…" + long[len(long)-40:] + "<mark>order</mark>", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, hit := highlight(tt.text, tt.terms)
+			if got != tt.want || hit != tt.hit {
+				t.Errorf("highlight = %q, %v; want %q, %v", got, hit, tt.want, tt.hit)
+			}
+		})
+	}
+}
+
+func TestHighlightTruncatesLongText(t *testing.T) {
+	text := strings.Repeat("word ", 100)
+	got, hit := highlight(text, nil)
+	if hit || !strings.HasSuffix(got, "…") || len([]rune(got)) != snippetRunes+1 {
+		t.Errorf("snippet of %d runes: %q", len([]rune(got)), got)
+	}
+
+	s := snippets(&models.Message{Content: "order placed", Result: "ORDER SHIPPED"}, []string{"ship"})
+	if len(s) != 1 || s["result"] != "ORDER <mark>SHIPPED</mark>" {
+		t.Errorf("snippets %v", s)
+	}
+}
@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/example/message_processor/models"
+)
+
+// 消息和用户的JSONL导入导出
+// 每行一条记录，格式与API返回的JSON相同（models.Message和models.User的JSON序列化）。
+// 导出逐行写出查询结果，不会把全部记录读入内存；导入在一个事务中完成，出错时全部回滚。
+// 用户的密码不参与序列化，导入的新用户没有密码，需要重置后才能登录；
+// 覆盖已有用户时保留其原来的密码。用户按用户名判断冲突，消息按ID判断冲突
+
+// ConflictPolicy 导入记录已存在时的处理方式
+type ConflictPolicy string
+
+const (
+	// ConflictSkip 保留已有记录，跳过导入的记录
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictOverwrite 用导入的记录覆盖已有记录
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictFail 遇到已有记录时中止导入并回滚
+	ConflictFail ConflictPolicy = "fail"
+)
+
+// ErrConflict 导入的记录已存在，仅在ConflictFail策略下返回
+var ErrConflict = errors.New("record already exists")
+
+// ErrInvalidRecord 导入的记录无法解析或缺少必要字段
+var ErrInvalidRecord = errors.New("invalid record")
+
+// ParseConflictPolicy 解析冲突处理方式，空字符串返回ConflictSkip
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch ConflictPolicy(s) {
+	case "":
+		return ConflictSkip, nil
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+		return ConflictPolicy(s), nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy: %s", s)
+	}
+}
+
+// ImportStats 导入结果
+type ImportStats struct {
+	Inserted    int `json:"inserted"`
+	Overwritten int `json:"overwritten"`
+	Skipped     int `json:"skipped"`
+}
+
+// userColumns 导出用户的列，不包括密码
+const userColumns = `id, username, email, created_at, updated_at`
+
+// ExportMessages 按条件将消息逐行写为JSONL，返回写出的数量
+// q.Limit大于0时限制导出数量，Cursor不使用
+func (p *PostgresDB) ExportMessages(ctx context.Context, w io.Writer, q MessageQuery) (int, error) {
+	var where whereClause
+	where.filter(q)
+	query := `SELECT ` + messageColumns + ` FROM messages` + where.String() + ` ORDER BY created_at, id`
+	if q.Limit > 0 {
+		query += ` LIMIT ` + where.bind(q.Limit)
+	}
+
+	rows, err := p.db.QueryContext(ctx, query, where.args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to export messages: %w", err)
+	}
+	defer rows.Close()
+
+	enc := json.NewEncoder(w)
+	count := 0
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return count, fmt.Errorf("failed to scan message: %w", err)
+		}
+		if err := enc.Encode(msg); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, fmt.Errorf("failed to export messages: %w", err)
+	}
+	return count, nil
+}
+
+// ExportUsers 将所有用户逐行写为JSONL，返回写出的数量
+func (p *PostgresDB) ExportUsers(ctx context.Context, w io.Writer) (int, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT `+userColumns+` FROM users ORDER BY id`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to export users: %w", err)
+	}
+	defer rows.Close()
+
+	enc := json.NewEncoder(w)
+	count := 0
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt, &user.UpdatedAt); err != nil {
+			return count, fmt.Errorf("failed to scan user: %w", err)
+		}
+		if err := enc.Encode(user); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, fmt.Errorf("failed to export users: %w", err)
+	}
+	return count, nil
+}
+
+// ImportMessages 从JSONL导入消息
+func (p *PostgresDB) ImportMessages(ctx context.Context, r io.Reader, policy ConflictPolicy) (ImportStats, error) {
+	return p.importJSONL(ctx, r, policy, func(tx *sql.Tx, dec *json.Decoder) (bool, bool, error) {
+		var msg models.Message
+		if err := dec.Decode(&msg); err != nil {
+			return false, false, fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+		}
+		if msg.ID == "" {
+			return false, false, fmt.Errorf("%w: message id is required", ErrInvalidRecord)
+		}
+		if msg.Priority == "" {
+			msg.Priority = models.PriorityNormal
+		}
+		if msg.CreatedAt.IsZero() {
+			msg.CreatedAt = time.Now()
+		}
+		if msg.UpdatedAt.IsZero() {
+			msg.UpdatedAt = msg.CreatedAt
+		}
+		metadata, err := nullJSON(msg.Metadata)
+		if err != nil {
+			return false, false, err
+		}
+
+		query := `
+			INSERT INTO messages (` + messageColumns + `, search_grams)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		` + conflictClause(policy, "id", `
+			content = EXCLUDED.content, processor = EXCLUDED.processor, submitted_by = EXCLUDED.submitted_by,
+			status = EXCLUDED.status, priority = EXCLUDED.priority, result = EXCLUDED.result,
+			error = EXCLUDED.error, metadata = EXCLUDED.metadata, latency_us = EXCLUDED.latency_us,
+			deliver_at = EXCLUDED.deliver_at, expires_at = EXCLUDED.expires_at,
+			created_at = EXCLUDED.created_at, updated_at = EXCLUDED.updated_at,
+			search_grams = EXCLUDED.search_grams`)
+
+		return insertReturning(ctx, tx, query,
+			msg.ID, msg.Content, msg.Processor, msg.User, msg.Status, msg.Priority, msg.Result, msg.Error,
+			metadata, msg.Latency.Microseconds(), nullTime(msg.DeliverAt), nullTime(msg.ExpiresAt),
+			msg.CreatedAt, msg.UpdatedAt, searchGrams(&msg))
+	})
+}
+
+// ImportUsers 从JSONL导入用户，导入记录中的ID被忽略，新用户由数据库分配ID
+func (p *PostgresDB) ImportUsers(ctx context.Context, r io.Reader, policy ConflictPolicy) (ImportStats, error) {
+	return p.importJSONL(ctx, r, policy, func(tx *sql.Tx, dec *json.Decoder) (bool, bool, error) {
+		var user models.User
+		if err := dec.Decode(&user); err != nil {
+			return false, false, fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+		}
+		if user.Username == "" {
+			return false, false, fmt.Errorf("%w: username is required", ErrInvalidRecord)
+		}
+
+		query := `
+			INSERT INTO users (username, email, password, created_at, updated_at)
+			VALUES ($1, $2, '', $3, $4)
+		` + conflictClause(policy, "username", `
+			email = EXCLUDED.email, created_at = EXCLUDED.created_at, updated_at = EXCLUDED.updated_at`)
+
+		return insertReturning(ctx, tx, query, user.Username, user.Email, user.CreatedAt, user.UpdatedAt)
+	})
+}
+
+// importJSONL 在事务中逐条导入JSONL记录
+// insert读取并写入一条记录，返回是否写入以及写入的是否为新记录
+func (p *PostgresDB) importJSONL(ctx context.Context, r io.Reader, policy ConflictPolicy,
+	insert func(tx *sql.Tx, dec *json.Decoder) (written, inserted bool, err error)) (ImportStats, error) {
+	var stats ImportStats
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return stats, fmt.Errorf("failed to begin import: %w", err)
+	}
+	defer tx.Rollback()
+
+	// json.Decoder.More在读取出错时同样返回false，读取错误单独记录，避免提交不完整的导入
+	src := &importReader{r: r}
+	dec := json.NewDecoder(src)
+	for record := 1; dec.More(); record++ {
+		written, inserted, err := insert(tx, dec)
+		if src.err != nil {
+			return ImportStats{}, fmt.Errorf("failed to read import: %w", src.err)
+		}
+		if err != nil {
+			return ImportStats{}, fmt.Errorf("record %d: %w", record, err)
+		}
+		switch {
+		case !written && policy == ConflictFail:
+			return ImportStats{}, fmt.Errorf("record %d: %w", record, ErrConflict)
+		case !written:
+			stats.Skipped++
+		case inserted:
+			stats.Inserted++
+		default:
+			stats.Overwritten++
+		}
+	}
+
+	if src.err != nil {
+		return ImportStats{}, fmt.Errorf("failed to read import: %w", src.err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ImportStats{}, fmt.Errorf("failed to commit import: %w", err)
+	}
+	return stats, nil
+}
+
+// importReader 记录读取导入数据时遇到的错误，io.EOF除外
+type importReader struct {
+	r   io.Reader
+	err error
+}
+
+// Read 实现io.Reader接口
+func (r *importReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// conflictClause 按冲突处理方式生成ON CONFLICT子句
+// 返回的插入语句在写入时返回一行，RETURNING的值表示是否为新记录
+func conflictClause(policy ConflictPolicy, key, set string) string {
+	if policy == ConflictOverwrite {
+		return ` ON CONFLICT (` + key + `) DO UPDATE SET ` + set + ` RETURNING (xmax = 0)`
+	}
+	return ` ON CONFLICT (` + key + `) DO NOTHING RETURNING true`
+}
+
+// insertReturning 执行conflictClause生成的插入语句
+func insertReturning(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (written, inserted bool, err error) {
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&inserted)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	return true, inserted, nil
+}
@@ -0,0 +1,197 @@
+package trace
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/example/message_processor/utils"
+)
+
+// Trace 记录一次处理的执行过程
+// 包括校验规则、处理阶段、路由决策等步骤，每个步骤记录输入输出、结果和耗时。
+// 所有方法都可以在nil上调用，未开启跟踪时不产生任何开销，处理器无需判断是否开启
+
+// 步骤类型
+const (
+	KindValidate = "validate"
+	KindRule     = "rule"
+	KindRoute    = "route"
+	KindProcess  = "process"
+	KindStage    = "stage"
+)
+
+// 步骤结果
+const (
+	OutcomePass     = "pass"
+	OutcomeFail     = "fail"
+	OutcomeMatch    = "match"
+	OutcomeNoMatch  = "no_match"
+	OutcomeSelected = "selected"
+	OutcomeSkipped  = "skipped"
+)
+
+// maxTextLength 步骤中记录的输入输出的最大长度
+const maxTextLength = 2000
+
+// Step 一个执行步骤
+type Step struct {
+	Kind    string                 `json:"kind"`
+	Name    string                 `json:"name"`
+	Outcome string                 `json:"outcome,omitempty"`
+	Input   string                 `json:"input,omitempty"`
+	Output  string                 `json:"output,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+	Detail  map[string]interface{} `json:"detail,omitempty"`
+	// Duration 步骤耗时，单位毫秒；事件类步骤没有耗时
+	Duration float64 `json:"duration_ms,omitempty"`
+	Steps    []*Step `json:"steps,omitempty"`
+
+	trace *Trace
+	start time.Time
+}
+
+// Trace 跟踪记录，可以并发使用
+type Trace struct {
+	mu    sync.Mutex
+	start time.Time
+	steps []*Step
+	// open 尚未结束的步骤，之后添加的步骤嵌套在最内层的步骤中
+	open []*Step
+}
+
+// New 创建新的跟踪记录
+func New() *Trace {
+	return &Trace{start: time.Now()}
+}
+
+// Begin 开始一个有耗时的步骤，必须调用返回步骤的End结束
+func (t *Trace) Begin(kind, name, input string) *Step {
+	if t == nil {
+		return nil
+	}
+	s := &Step{Kind: kind, Name: name, Input: truncate(input), trace: t, start: time.Now()}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.append(s)
+	t.open = append(t.open, s)
+	return s
+}
+
+// Event 记录一个没有耗时的步骤，如规则判断和路由决策
+func (t *Trace) Event(kind, name, outcome string, detail map[string]interface{}) {
+	if t == nil {
+		return
+	}
+	s := &Step{Kind: kind, Name: name, Outcome: outcome, Detail: detail}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.append(s)
+}
+
+// Steps 返回顶层步骤
+func (t *Trace) Steps() []*Step {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.steps
+}
+
+// MarshalJSON 序列化为步骤列表和总耗时
+func (t *Trace) MarshalJSON() ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return utils.JSONMarshal(map[string]interface{}{
+		"steps":       t.steps,
+		"duration_ms": milliseconds(time.Since(t.start)),
+	})
+}
+
+// append 将步骤加入当前打开的步骤或顶层，调用方必须持有锁
+func (t *Trace) append(s *Step) {
+	if n := len(t.open); n > 0 {
+		parent := t.open[n-1]
+		parent.Steps = append(parent.Steps, s)
+		return
+	}
+	t.steps = append(t.steps, s)
+}
+
+// Set 设置步骤的附加信息
+func (s *Step) Set(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+	if s.Detail == nil {
+		s.Detail = make(map[string]interface{})
+	}
+	s.Detail[key] = value
+}
+
+// SetOutcome 设置步骤的结果，没有错误时End保留该结果
+func (s *Step) SetOutcome(outcome string) {
+	if s == nil {
+		return
+	}
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+	s.Outcome = outcome
+}
+
+// End 结束步骤，记录输出、错误和耗时
+// 有错误时结果为fail，否则保留SetOutcome设置的结果，未设置时为pass
+func (s *Step) End(output string, err error) {
+	if s == nil {
+		return
+	}
+	t := s.trace
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s.Duration = milliseconds(time.Since(s.start))
+	s.Output = truncate(output)
+	if err != nil {
+		s.Outcome = OutcomeFail
+		s.Error = err.Error()
+	} else if s.Outcome == "" {
+		s.Outcome = OutcomePass
+	}
+
+	// 同时结束在该步骤内部开始但没有结束的步骤
+	for i := len(t.open) - 1; i >= 0; i-- {
+		if t.open[i] == s {
+			t.open = t.open[:i]
+			break
+		}
+	}
+}
+
+// traceKey 上下文中存放跟踪记录的键
+type traceKey struct{}
+
+// WithTrace 返回带有跟踪记录的上下文
+func WithTrace(ctx context.Context, t *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+// FromContext 从上下文中取出跟踪记录，未开启跟踪时返回nil
+func FromContext(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+// milliseconds 将时长转换为毫秒
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// truncate 截断过长的文本
+func truncate(s string) string {
+	return utils.TruncateString(s, maxTextLength)
+}
@@ -0,0 +1,145 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTraceNesting(t *testing.T) {
+	tr := New()
+	validate := tr.Begin(KindValidate, "schema", "input")
+	tr.Event(KindRule, "length", OutcomePass, map[string]interface{}{"max": 10})
+	validate.End("", nil)
+
+	process := tr.Begin(KindProcess, "default", "hello")
+	stage := tr.Begin(KindStage, "upper", "hello")
+	tr.Event(KindRoute, "canary", OutcomeSelected, nil)
+	// 结束外层步骤时同时结束内部未结束的步骤，之后的步骤回到顶层
+	process.End("", errors.New("boom"))
+	tr.Event(KindRoute, "after", OutcomeSkipped, nil)
+
+	steps := tr.Steps()
+	if len(steps) != 3 {
+		t.Fatalf("top level steps: %d, want 3", len(steps))
+	}
+	if len(steps[0].Steps) != 1 || steps[0].Steps[0].Name != "length" || steps[0].Outcome != OutcomePass {
+		t.Errorf("validate step %+v", steps[0])
+	}
+	if steps[1].Outcome != OutcomeFail || steps[1].Error != "boom" || len(steps[1].Steps) != 1 {
+		t.Errorf("process step %+v", steps[1])
+	}
+	if inner := stage.Steps; len(inner) != 1 || inner[0].Name != "canary" || stage.Outcome != "" {
+		t.Errorf("stage step %+v", stage)
+	}
+	if steps[2].Name != "after" {
+		t.Errorf("step after End nested: %+v", steps[2])
+	}
+}
+
+func TestStepOutcome(t *testing.T) {
+	tests := []struct {
+		name    string
+		outcome string
+		err     error
+		want    string
+	}{
+		{"default pass", "", nil, OutcomePass},
+		{"outcome kept", OutcomeNoMatch, nil, OutcomeNoMatch},
+		{"error overrides outcome", OutcomeMatch, errors.New("failed"), OutcomeFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New().Begin(KindRule, tt.name, "")
+			if tt.outcome != "" {
+				s.SetOutcome(tt.outcome)
+			}
+			s.Set("key", "value")
+			s.End("output", tt.err)
+			if s.Outcome != tt.want || s.Output != "output" || s.Detail["key"] != "value" {
+				t.Errorf("step %+v", s)
+			}
+		})
+	}
+}
+
+func TestTraceTruncatesText(t *testing.T) {
+	long := strings.Repeat("x", maxTextLength+10)
+	s := New().Begin(KindProcess, "long", long)
+	s.End(long, nil)
+	if len(s.Input) != maxTextLength+3 || len(s.Output) != maxTextLength+3 || !strings.HasSuffix(s.Output, "...") {
+		t.Errorf("input %d, output %d bytes", len(s.Input), len(s.Output))
+	}
+}
+
+func TestNilTrace(t *testing.T) {
+	// 未开启跟踪时所有方法都可以调用
+	tr := FromContext(context.Background())
+	if tr != nil {
+		t.Fatalf("trace in empty context: %v", tr)
+	}
+	s := tr.Begin(KindProcess, "default", "hello")
+	s.Set("key", "value")
+	s.SetOutcome(OutcomeMatch)
+	s.End("", nil)
+	tr.Event(KindRule, "length", OutcomePass, nil)
+	if steps := tr.Steps(); steps != nil {
+		t.Errorf("steps %v", steps)
+	}
+}
+
+func TestTraceContextAndJSON(t *testing.T) {
+	tr := New()
+	ctx := WithTrace(context.Background(), tr)
+	if FromContext(ctx) != tr {
+		t.Fatal("trace not found in context")
+	}
+	FromContext(ctx).Event(KindRoute, "default", OutcomeSelected, map[string]interface{}{"reason": "fallback"})
+	FromContext(ctx).Begin(KindProcess, "default", "hi").End("HI", nil)
+
+	data, err := json.Marshal(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Steps []struct {
+			Kind     string                 `json:"kind"`
+			Outcome  string                 `json:"outcome"`
+			Output   string                 `json:"output"`
+			Detail   map[string]interface{} `json:"detail"`
+			Duration *float64               `json:"duration_ms"`
+		} `json:"steps"`
+		Duration *float64 `json:"duration_ms"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Duration == nil || len(out.Steps) != 2 {
+		t.Fatalf("trace json %s", data)
+	}
+	if route := out.Steps[0]; route.Kind != KindRoute || route.Detail["reason"] != "fallback" || route.Duration != nil {
+		t.Errorf("route step %s", data)
+	}
+	if process := out.Steps[1]; process.Outcome != OutcomePass || process.Output != "HI" {
+		t.Errorf("process step %s", data)
+	}
+}
+
+func TestTraceConcurrent(t *testing.T) {
+	tr := New()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tr.Event(KindRule, "rule", OutcomeMatch, nil)
+		}()
+	}
+	wg.Wait()
+	if steps := tr.Steps(); len(steps) != 20 {
+		t.Errorf("steps %d, want 20", len(steps))
+	}
+}
@@ -0,0 +1,68 @@
+package utils
+
+import "strings"
+
+// DiffUtils 文本差异比较工具
+
+// maxDiffCells 逐行比较的最大计算量（旧行数×新行数），超出时按整体替换输出
+const maxDiffCells = 1 << 20
+
+// DiffLines 逐行比较两段文本，返回统一格式的差异行
+// 未变化的行以空格开头，删除的行以"-"开头，新增的行以"+"开头；两段文本相同时返回nil
+func DiffLines(old, new string) []string {
+	if old == new {
+		return nil
+	}
+	a := strings.Split(old, "\n")
+	b := strings.Split(new, "\n")
+
+	if len(a)*len(b) > maxDiffCells {
+		diff := make([]string, 0, len(a)+len(b))
+		for _, line := range a {
+			diff = append(diff, "-"+line)
+		}
+		for _, line := range b {
+			diff = append(diff, "+"+line)
+		}
+		return diff
+	}
+
+	// lcs[i][j] 为a[i:]和b[j:]的最长公共子序列长度
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = Max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "-"+a[i])
+			i++
+		default:
+			diff = append(diff, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, "+"+b[j])
+	}
+	return diff
+}
@@ -48,6 +48,11 @@ func CamelToSnake(s string) string {
 
 // TimeUtils 时间处理工具
 
+// Now 返回当前时间
+func Now() time.Time {
+	return time.Now()
+}
+
 // FormatTime 将时间格式化为标准格式
 func FormatTime(t time.Time) string {
 	return t.Format("2006-01-02 15:04:05")
@@ -1,9 +1,11 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 )
 
 // JSONUtils 提供JSON处理的工具函数集合
@@ -91,4 +93,47 @@ func JSONToMap(jsonStr string) (map[string]interface{}, error) {
 // MapToJSON 将map转换为JSON字符串
 func MapToJSON(m map[string]interface{}) (string, error) {
 	return JSONMarshalToString(m)
-}
\ No newline at end of file
+}
+
+// DurationField JSON中的一个时长字段
+type DurationField struct {
+	// Name 字段名，用于错误信息
+	Name string
+	// Value 字段的JSON原始值，字段未出现时为nil
+	Value json.RawMessage
+	Dest  *time.Duration
+}
+
+// SetJSONDurations 解析JSON中的时长字段并写入Dest，字段未出现、为null或为空字符串时保持原值
+// 时长可以是time.ParseDuration格式的字符串（如"5s"），也可以是纳秒数
+func SetJSONDurations(fields ...DurationField) error {
+	for _, f := range fields {
+		value := bytes.TrimSpace(f.Value)
+		if len(value) == 0 || string(value) == "null" {
+			continue
+		}
+
+		if value[0] != '"' {
+			var n int64
+			if err := json.Unmarshal(value, &n); err != nil {
+				return fmt.Errorf("invalid %s: must be a duration string such as \"5s\" or nanoseconds", f.Name)
+			}
+			*f.Dest = time.Duration(n)
+			continue
+		}
+
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			return fmt.Errorf("invalid %s: %w", f.Name, err)
+		}
+		if s == "" {
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", f.Name, err)
+		}
+		*f.Dest = d
+	}
+	return nil
+}